@@ -16,11 +16,15 @@ func newApplyCmd(cmd *barrelman.ApplyCmd) *cobra.Command {
 		the current Kubernetes cluster state.
 
 		For the given manifest each chart is installed on the Kubernetes cluster.
+
+		A manifest with a barrelman/Targets/v1 document, or given --contexts, is applied to each
+		of its clusters in turn, or all at once with --strategy parallel.
 	`))
 
 	shortDesc := `Apply the given manifest to the cluster.`
 
-	examples := `barrelman apply lamp-stack.yaml`
+	examples := `barrelman apply lamp-stack.yaml
+barrelman apply --contexts us-east,us-west --strategy parallel lamp-stack.yaml`
 
 	cobraCmd := &cobra.Command{
 		Use:           "apply [manifest.yaml]",
@@ -36,9 +40,21 @@ func newApplyCmd(cmd *barrelman.ApplyCmd) *cobra.Command {
 
 			cobraCmd.SilenceUsage = true
 			cobraCmd.SilenceErrors = true
-			session := cluster.NewSession(
-				cmd.Options.KubeContext,
-				cmd.Options.KubeConfigFile)
+			targets, err := cmd.Targets()
+			if err != nil {
+				return err
+			}
+			if targets != nil {
+				return cmd.RunTargets(targets, func(kubeContext string) (cluster.Sessioner, error) {
+					options := *cmd.Options
+					options.KubeContext = kubeContext
+					return newSession(&options)
+				})
+			}
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
 			if err := cmd.Run(session); err != nil {
 				return err
 			}
@@ -76,6 +92,36 @@ func newApplyCmd(cmd *barrelman.ApplyCmd) *cobra.Command {
 		"install-retry",
 		Default().InstallRetry,
 		"retry install (n) times")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Options.RepairDrift,
+		"repair-drift",
+		false,
+		"upgrade releases whose cluster objects have drifted from the manifest")
+	cobraCmd.Flags().DurationVar(
+		&cmd.Options.SyncTimeout,
+		"sync-timeout",
+		0,
+		"time allowed to download charts, within --timeout")
+	cobraCmd.Flags().DurationVar(
+		&cmd.Options.PlanTimeout,
+		"plan-timeout",
+		0,
+		"time allowed to dry run and diff the releases, within --timeout")
+	cobraCmd.Flags().DurationVar(
+		&cmd.Options.ApplyTimeout,
+		"apply-timeout",
+		0,
+		"time allowed to change the releases before the transaction is canceled, within --timeout")
+	cobraCmd.Flags().StringSliceVar(
+		&cmd.Options.Contexts,
+		"contexts",
+		nil,
+		"apply the manifest to each kube context, selecting targets of the manifest by name or context")
+	cobraCmd.Flags().StringVar(
+		&cmd.Options.Strategy,
+		"strategy",
+		"",
+		"rollout strategy across clusters [ sequential | parallel ], sequential halts on the first failure")
 
 	return cobraCmd
 }
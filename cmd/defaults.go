@@ -10,12 +10,17 @@ type Defaults struct {
 	ManifestFile   string
 	KubeConfigFile string
 	KubeContext    string
+	Backend        string
 	DataDir        string
 	ConfigFile     string
 	InstallRetry   int
 	Force          *[]string
 	LogLevel       string
 	LogFormat      string
+	// TillerHost, TillerNamespace and HelmHome follow the environment Helm passes to plugins
+	TillerHost      string
+	TillerNamespace string
+	HelmHome        string
 }
 
 func Default() *Defaults {
@@ -31,10 +36,20 @@ func Default() *Defaults {
 		d.KubeConfigFile = fmt.Sprintf("%v/.kube/config", usr.HomeDir)
 	}
 	d.KubeContext = os.Getenv("KUBE_CONTEXT")
+	d.Backend = os.Getenv("BARRELMAN_BACKEND")
+	if d.Backend == "" {
+		d.Backend = "helm2"
+	}
 	d.DataDir = fmt.Sprintf("%v/.barrelman/data", usr.HomeDir)
 	d.InstallRetry = int(3)
 	d.Force = &[]string{}
 	d.LogLevel = "info"
 	d.LogFormat = "text"
+	d.TillerHost = os.Getenv("HELM_HOST")
+	if d.TillerHost == "" {
+		d.TillerHost = os.Getenv("TILLER_HOST")
+	}
+	d.TillerNamespace = os.Getenv("TILLER_NAMESPACE")
+	d.HelmHome = os.Getenv("HELM_HOME")
 	return d
 }
@@ -7,7 +7,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/charter-oss/barrelman/pkg/barrelman"
-	"github.com/charter-oss/barrelman/pkg/cluster"
 )
 
 func newDeleteCmd(cmd *barrelman.DeleteCmd) *cobra.Command {
@@ -15,13 +14,20 @@ func newDeleteCmd(cmd *barrelman.DeleteCmd) *cobra.Command {
 	longDesc := strings.TrimSpace(dedent.Dedent(`
 		The delete command deletes all releases in a manifest.
 
-		All releases currently deployed in the matching manifest will be deleted, 
-		as will all releases currently configured in the supplied manifest file.
+		Only releases owned by the manifest are deleted, releases of the same name installed
+		by another manifest are left alone. Releases are deleted in reverse chart group order,
+		releases no longer configured in the manifest file first. The teardown is recorded as
+		a new version of the manifest.
+
+		Releases are deleted within a transaction. When a delete fails or is interrupted the
+		releases deleted so far are rolled back to their last revision. --purge removes the
+		history of the releases only once every release was deleted.
 	`))
 
 	shortDesc := `Delete all releases configured in the manifest.`
 
-	examples := `barrelman delete lamp-stack.yaml`
+	examples := `barrelman delete lamp-stack.yaml
+barrelman delete --purge --wait lamp-stack.yaml`
 
 	cobraCmd := &cobra.Command{
 		Use:           "delete [manifest.yaml]",
@@ -37,9 +43,10 @@ func newDeleteCmd(cmd *barrelman.DeleteCmd) *cobra.Command {
 			}
 			cobraCmd.SilenceUsage = true
 			cobraCmd.SilenceErrors = true
-			session := cluster.NewSession(
-				cmd.Options.KubeContext,
-				cmd.Options.KubeConfigFile)
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
 			if err := cmd.Run(session); err != nil {
 				return err
 			}
@@ -58,5 +65,20 @@ func newDeleteCmd(cmd *barrelman.DeleteCmd) *cobra.Command {
 		"nosync",
 		false,
 		"disable remote sync")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Options.DryRun,
+		"dry-run",
+		false,
+		"list the releases that would be deleted")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Options.Purge,
+		"purge",
+		false,
+		"remove the release history so the release names can be reused")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Options.Wait,
+		"wait",
+		false,
+		"wait until the objects of each release are removed")
 	return cobraCmd
 }
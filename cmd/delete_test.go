@@ -62,23 +62,24 @@ func TestDeleteRun(t *testing.T) {
 			}
 			session := &mocks.Sessioner{}
 			session.On("Init").Return(nil).Once()
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(c.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return("").Maybe()
-			session.On("ListReleases").Return([]*cluster.Release{
-				&cluster.Release{
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(map[string]*cluster.ReleaseMeta{
+				"storage-minio": &cluster.ReleaseMeta{
 					ReleaseName: "storage-minio",
 					Namespace:   "scratch",
-					Chart: &cluster.Chart{
+					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
 							Name: "storage-minio",
 						},
 					},
 				},
-			}, errors.New("simulated ListReleases failure")).Once()
+			}, errors.New("simulated ReleasesByManifest failure")).Once()
 
 			err := c.Run(session)
 			So(err, ShouldNotBeNil)
-			So(err.Error(), ShouldContainSubstring, "simulated ListReleases failure")
+			So(err.Error(), ShouldContainSubstring, "simulated ReleasesByManifest failure")
 			session.AssertExpectations(t)
 		})
 
@@ -94,26 +95,31 @@ func TestDeleteRun(t *testing.T) {
 				},
 			}
 			session := &mocks.Sessioner{}
+			transaction := newTestTransaction()
 			session.On("Init").Return(nil).Once()
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(c.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return("").Maybe()
-			session.On("ListReleases").Return([]*cluster.Release{
-				&cluster.Release{
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(map[string]*cluster.ReleaseMeta{
+				"storage-minio": &cluster.ReleaseMeta{
 					ReleaseName: "storage-minio",
 					Namespace:   "scratch",
-					Chart: &cluster.Chart{
+					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
 							Name: "storage-minio",
 						},
 					},
 				},
 			}, nil).Once()
-			session.On("DeleteRelease", mock.Anything).Return(errors.New("simulated delete failure")).Once()
+			session.On("NewTransaction", mock.Anything).Return(transaction, nil).Once()
+			session.On("DeleteRelease", mock.Anything, mock.Anything).Return(errors.New("simulated delete failure")).Once()
+			transaction.On("Cancel").Return(nil).Once()
 
 			err := c.Run(session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated delete failure")
 			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
 		})
 
 		Convey("Can succeed", func() {
@@ -128,25 +134,47 @@ func TestDeleteRun(t *testing.T) {
 				},
 			}
 			session := &mocks.Sessioner{}
+			transaction := newTestTransaction()
 			session.On("Init").Return(nil).Once()
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(c.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return("").Maybe()
-			session.On("ListReleases").Return([]*cluster.Release{
-				&cluster.Release{
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(map[string]*cluster.ReleaseMeta{
+				"storage-minio": &cluster.ReleaseMeta{
 					ReleaseName: "storage-minio",
 					Namespace:   "scratch",
-					Chart: &cluster.Chart{
+					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
 							Name: "storage-minio",
 						},
 					},
 				},
 			}, nil).Once()
-			session.On("DeleteRelease", mock.Anything).Return(nil).Once()
+			session.On("NewTransaction", mock.Anything).Return(transaction, nil).Once()
+			session.On("DeleteRelease", mock.Anything, mock.Anything).Return(nil).Once()
+			transaction.On("SetChanged").Return().Once()
+			transaction.On("Complete").Return(nil).Once()
 
 			err := c.Run(session)
 			So(err, ShouldBeNil)
+			So(transaction.Versions().Deleted, ShouldBeTrue)
 			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
 		})
 	})
 }
+
+func newTestTransaction() *mocks.Transactioner {
+	transaction := &mocks.Transactioner{}
+	versions := cluster.NewVersions("unit-test-manifest")
+	transaction.On("Checkpoint").Return(nil).Maybe()
+	transaction.On("Versions").Return(versions).Maybe()
+	return transaction
+}
+
+func newTestUnlocker() *mocks.Unlocker {
+	unlocker := &mocks.Unlocker{}
+	unlocker.On("Unlock").Return(nil)
+	unlocker.On("Lost").Return((<-chan struct{})(nil)).Maybe()
+	return unlocker
+}
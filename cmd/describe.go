@@ -6,7 +6,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/charter-oss/barrelman/pkg/barrelman"
-	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/cirrocloud/structured/errors"
 )
 
@@ -36,9 +35,10 @@ func newDescribeCmd(cmd *barrelman.DescribeCmd) *cobra.Command {
 			}
 			cmd.ManifestVersion = int32(verTmp)
 
-			session := cluster.NewSession(
-				cmd.Options.KubeContext,
-				cmd.Options.KubeConfigFile)
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
 			if err := cmd.Run(session); err != nil {
 				return err
 			}
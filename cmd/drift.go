@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+)
+
+func newDriftCmd(cmd *barrelman.DriftCmd) *cobra.Command {
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		The drift command compares the objects running in the Kubernetes cluster with the
+		desired state rendered from the supplied manifest.
+
+		Objects that were modified, removed or added outside of Barrelman are reported.
+		Use "barrelman apply --repair-drift" to upgrade the drifted releases.
+	`))
+
+	shortDesc := `Report cluster objects that differ from the given manifest.`
+
+	examples := `barrelman drift lamp-stack.yaml`
+
+	cobraCmd := &cobra.Command{
+		Use:           "drift [manifest.yaml]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+
+			cmd.Options.ManifestFile = args[0]
+
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(session); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.Flags().BoolVar(
+		&cmd.Options.NoSync,
+		"nosync",
+		false,
+		"disable remote sync")
+
+	return cobraCmd
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+)
+
+func newGetCmd(cmd *barrelman.GetManifestCmd) *cobra.Command {
+
+	shortDesc := `Display information recorded with Barrelman manifest versions.`
+
+	cobraCmd := &cobra.Command{
+		Use:           "get",
+		Short:         shortDesc,
+		Long:          shortDesc,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cobraCmd.AddCommand(newGetManifestCmd(cmd))
+	return cobraCmd
+}
+
+func newGetManifestCmd(cmd *barrelman.GetManifestCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Print the manifest recorded with a Barrelman manifest version.
+		
+		Each version written by apply records the manifest YAML as applied, the git commit or chart
+		version each chart was archived from, the Barrelman version and the invoking user and host.
+		The source is printed as comments ahead of the manifest. Without --version the latest version
+		is printed.
+	`))
+
+	shortDesc := `Print the manifest recorded with a manifest version.`
+
+	examples := `barrelman get manifest lamp-stack
+barrelman get manifest lamp-stack --version 5 > lamp-stack.yaml`
+
+	cobraCmd := &cobra.Command{
+		Use:           "manifest [manifest name]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+
+			cmd.ManifestName = args[0]
+
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(session); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.Flags().Int32Var(
+		&cmd.ManifestVersion,
+		"version",
+		0,
+		"manifest version to print, the latest when unset")
+	return cobraCmd
+}
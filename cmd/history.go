@@ -1,13 +1,14 @@
 package cmd
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/lithammer/dedent"
 	"github.com/spf13/cobra"
 
 	"github.com/charter-oss/barrelman/pkg/barrelman"
-	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/cirrocloud/structured/errors"
 )
 
 func newHistoryCmd(cmd *barrelman.HistoryCmd) *cobra.Command {
@@ -20,7 +21,9 @@ func newHistoryCmd(cmd *barrelman.HistoryCmd) *cobra.Command {
 
 	shortDesc := `List manifest revision history.`
 
-	examples := `barrelman history lamp-stack`
+	examples := `barrelman history lamp-stack
+barrelman history diff lamp-stack 4 5
+barrelman history prune lamp-stack --keep 20`
 
 	cobraCmd := &cobra.Command{
 		Use:           "history [manifest name]",
@@ -34,9 +37,136 @@ func newHistoryCmd(cmd *barrelman.HistoryCmd) *cobra.Command {
 
 			cmd.ManifestName = args[0]
 
-			session := cluster.NewSession(
-				cmd.Options.KubeContext,
-				cmd.Options.KubeConfigFile)
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(session); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.AddCommand(newHistoryDiffCmd(&barrelman.HistoryDiffCmd{
+		Options: cmd.Options,
+		Config:  cmd.Config,
+	}))
+	cobraCmd.AddCommand(newHistoryPruneCmd(&barrelman.HistoryPruneCmd{
+		Options: cmd.Options,
+		Config:  cmd.Config,
+	}))
+	return cobraCmd
+}
+
+func newHistoryPruneCmd(cmd *barrelman.HistoryPruneCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Remove the oldest Barrelman manifest versions.
+		
+		The newest versions are kept. The latest version, the one deployed, and versions carrying a TAG
+		label are never removed. The --history-max flag, the history_max manifest setting or the
+		history_max config setting prune versions the same way each time a version is written.
+	`))
+
+	shortDesc := `Remove old manifest versions.`
+
+	examples := `barrelman history prune lamp-stack --keep 20
+barrelman history prune lamp-stack --keep 5 --dry-run`
+
+	cobraCmd := &cobra.Command{
+		Use:           "prune [manifest name]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+
+			cmd.ManifestName = args[0]
+			if cmd.Keep < 1 {
+				return errors.WithFields(errors.Fields{
+					"Keep": cmd.Keep,
+				}).New("--keep must be at least 1")
+			}
+
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(session); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+	cobraCmd.Flags().IntVar(
+		&cmd.Keep,
+		"keep",
+		20,
+		"number of newest versions to keep")
+	cobraCmd.Flags().BoolVar(
+		&cmd.DryRun,
+		"dry-run",
+		false,
+		"list the versions that would be removed")
+	return cobraCmd
+}
+
+func newHistoryDiffCmd(cmd *barrelman.HistoryDiffCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Compare two Barrelman manifest versions.
+		
+		Lists the releases added, removed or moved to another revision between the versions. For each
+		release found at another revision the chart version change, the values diff and the rendered
+		manifest diff are shown.
+	`))
+
+	shortDesc := `Show the release changes between two manifest versions.`
+
+	examples := `barrelman history diff lamp-stack 4 5`
+
+	cobraCmd := &cobra.Command{
+		Use:           "diff [manifest name] [from version] [to version]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(3),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+
+			cmd.ManifestName = args[0]
+
+			from, err := strconv.Atoi(args[1])
+			if err != nil {
+				return errors.Wrap(err, "Failed to parse version from second argument")
+			}
+			to, err := strconv.Atoi(args[2])
+			if err != nil {
+				return errors.Wrap(err, "Failed to parse version from third argument")
+			}
+			cmd.FromVersion = int32(from)
+			cmd.ToVersion = int32(to)
+
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
 			if err := cmd.Run(session); err != nil {
 				return err
 			}
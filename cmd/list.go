@@ -7,7 +7,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/charter-oss/barrelman/pkg/barrelman"
-	"github.com/charter-oss/barrelman/pkg/cluster"
 )
 
 func newListCmd(cmd *barrelman.ListCmd) *cobra.Command {
@@ -35,9 +34,10 @@ func newListCmd(cmd *barrelman.ListCmd) *cobra.Command {
 			}
 			cobraCmd.SilenceUsage = true
 			cobraCmd.SilenceErrors = true
-			session := cluster.NewSession(
-				cmd.Options.KubeContext,
-				cmd.Options.KubeConfigFile)
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
 			if err := cmd.Run(session); err != nil {
 				return err
 			}
@@ -76,7 +76,7 @@ func TestListRun(t *testing.T) {
 			session.On("Init").Return(nil).Once()
 			session.On("GetKubeConfig").Return(c.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return("").Maybe()
-			session.On("ReleasesByManifest", mock.Anything).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					ReleaseName: "storage-minio",
 				},
@@ -103,7 +103,7 @@ func TestListRun(t *testing.T) {
 			session.On("Init").Return(nil).Once()
 			session.On("GetKubeConfig").Return(c.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return("").Maybe()
-			session.On("ReleasesByManifest", mock.Anything).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					ReleaseName: "storage-minio",
 				},
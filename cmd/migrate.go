@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/cirrocloud/structured/errors"
+)
+
+func newMigrateCmd(cmd *barrelman.MigrateCmd) *cobra.Command {
+
+	shortDesc := `Migrate Barrelman releases between storage backends.`
+
+	cobraCmd := &cobra.Command{
+		Use:           "migrate",
+		Short:         shortDesc,
+		Long:          shortDesc,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cobraCmd.AddCommand(newMigrateHelm3Cmd(cmd))
+	cobraCmd.AddCommand(newMigrateVersionsCmd(&barrelman.MigrateVersionsCmd{
+		Options: cmd.Options,
+		Config:  cmd.Config,
+	}))
+	return cobraCmd
+}
+
+func newMigrateVersionsCmd(cmd *barrelman.MigrateVersionsCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Copy every Barrelman manifest version stored as a ConfigMap into the versions storage.
+
+		The versions storage is selected by --versions-storage and --versions-namespace. Versions are read
+		from the ConfigMaps of --from-namespace, the versions namespace when empty. Each version keeps its
+		name and labels, so tagged versions stay tagged. Versions already present are skipped, so the
+		command may be repeated. With --cleanup the version ConfigMaps are removed once copied.
+	`))
+
+	shortDesc := `Migrate manifest versions from ConfigMaps to the versions storage.`
+
+	examples := `barrelman migrate versions --versions-storage secret --dry-run
+barrelman migrate versions --versions-storage secret --versions-namespace barrelman --from-namespace kube-system --cleanup`
+
+	cobraCmd := &cobra.Command{
+		Use:           "versions",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			migrator, ok := session.(cluster.VersionMigrator)
+			if !ok {
+				return errors.WithFields(errors.Fields{
+					"Backend": cmd.Options.Backend,
+				}).New("the backend cannot migrate versions")
+			}
+			if err := cmd.Run(migrator); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.Flags().StringVar(
+		&cmd.FromNamespace,
+		"from-namespace",
+		"",
+		"namespace holding the version ConfigMaps, defaults to the versions namespace")
+	cobraCmd.Flags().BoolVar(
+		&cmd.DryRun,
+		"dry-run",
+		false,
+		"report the versions that would be migrated")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Cleanup,
+		"cleanup",
+		false,
+		"remove the version ConfigMaps after migration")
+	return cobraCmd
+}
+
+func newMigrateHelm3Cmd(cmd *barrelman.MigrateCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Copy every release owned by a Barrelman manifest from Tiller storage to Helm 3 release Secrets.
+
+		Each revision recorded by Tiller is converted, and the Barrelman manifest versions are carried over
+		so rollback keeps working with "--backend helm3". Revisions already present in Helm 3 storage are
+		skipped, so the command may be repeated. With --cleanup the Tiller release ConfigMaps are removed
+		once a release has been migrated.
+	`))
+
+	shortDesc := `Migrate manifest releases from Tiller to Helm 3 storage.`
+
+	examples := `barrelman migrate helm3 lamp-stack --dry-run`
+
+	cobraCmd := &cobra.Command{
+		Use:           "helm3 [manifest name]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+
+			cmd.ManifestName = args[0]
+
+			source, err := newTillerSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(source, newHelm3Session(cmd.Options)); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.Flags().BoolVar(
+		&cmd.DryRun,
+		"dry-run",
+		false,
+		"report the releases that would be migrated")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Cleanup,
+		"cleanup",
+		false,
+		"remove Tiller release ConfigMaps after migration")
+	return cobraCmd
+}
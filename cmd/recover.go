@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+)
+
+func newRecoverCmd(cmd *barrelman.RecoverCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Recover finishes a transaction left behind by an interrupted apply or rollback.
+
+		Barrelman records each release it changes in a journal in the cluster, an unfinished
+		journal blocks further changes to the manifest. Without options the releases changed
+		by the interrupted transaction are reported. --rollback reverts them to their previous
+		revisions, --forward applies the given manifest and records the releases changed by
+		both transactions as a new manifest version.`))
+
+	shortDesc := `Roll an interrupted transaction back or forward.`
+
+	examples := `barrelman recover lamp-stack
+barrelman recover lamp-stack --rollback
+barrelman recover lamp-stack --forward lamp-stack.yaml`
+
+	cobraCmd := &cobra.Command{
+		Use:           "recover [manifest name]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			cmd.ManifestName = args[0]
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(session); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.Flags().BoolVar(
+		&cmd.Rollback,
+		"rollback",
+		false,
+		"revert the releases changed by the interrupted transaction")
+	cobraCmd.Flags().StringVar(
+		&cmd.ManifestFile,
+		"forward",
+		"",
+		"resume the interrupted transaction by applying this manifest")
+	cobraCmd.Flags().BoolVar(
+		&cmd.Options.NoSync,
+		"nosync",
+		false,
+		"disable remote sync")
+	cobraCmd.Flags().IntVar(
+		&cmd.Options.InstallRetry,
+		"install-retry",
+		Default().InstallRetry,
+		"retry install (n) times")
+	return cobraCmd
+}
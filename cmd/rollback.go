@@ -9,7 +9,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/charter-oss/barrelman/pkg/barrelman"
-	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/structured/log"
 )
@@ -20,33 +19,45 @@ func newRollbackCmd(cmd *barrelman.RollbackCmd) *cobra.Command {
 		Rollback sets release versions to those recorded in a Barrelman rollback manifest.
 		
 		Rollback manifests are saved in the cluster when barrelman sucessfully commits a change
-		to the manifest release group.`))
+		to the manifest release group. Without a manifest version the version before the latest
+		is used. Releases purged since the version are reinstalled from the chart stored with it.
+		
+		The --chart and --group flags limit the rollback to the releases of those charts or chart
+		groups. The other releases are left untouched and keep their current revisions in the new
+		manifest version.`))
 
 	shortDesc := `Set release versions to a previous Barrelman save state.`
 
-	examples := `barrelman rollback lamp-stack 5`
+	examples := `barrelman rollback lamp-stack
+barrelman rollback lamp-stack 5
+barrelman rollback lamp-stack --diff
+barrelman rollback lamp-stack 5 --chart api --chart worker
+barrelman rollback lamp-stack --group backend`
 
 	cobraCmd := &cobra.Command{
 		Use:           "rollback [manifest name] [manifest version]",
 		Short:         shortDesc,
 		Long:          longDesc,
 		Example:       examples,
-		Args:          cobra.ExactArgs(2),
+		Args:          cobra.RangeArgs(1, 2),
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			cmd.ManifestName = args[0]
 
-			verTmp, err := strconv.Atoi(args[1])
-			if err != nil {
-				log.Error(errors.Wrap(err, "Failed to parse version from second arguement"))
-				os.Exit(1)
+			if len(args) > 1 {
+				verTmp, err := strconv.Atoi(args[1])
+				if err != nil {
+					log.Error(errors.Wrap(err, "Failed to parse version from second arguement"))
+					os.Exit(1)
+				}
+				cmd.ManifestVersion = int32(verTmp)
 			}
-			cmd.ManifestVersion = int32(verTmp)
 
-			session := cluster.NewSession(
-				cmd.Options.KubeContext,
-				cmd.Options.KubeConfigFile)
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
 			if err := cmd.Run(session); err != nil {
 				return err
 			}
@@ -69,6 +80,16 @@ func newRollbackCmd(cmd *barrelman.RollbackCmd) *cobra.Command {
 		&cmd.Options.Diff,
 		"diff",
 		false,
-		"Display differences")
+		"Display the rollback plan and differences without changing releases")
+	cobraCmd.Flags().StringSliceVar(
+		&cmd.Charts,
+		"chart",
+		nil,
+		"roll back only the releases of these charts")
+	cobraCmd.Flags().StringSliceVar(
+		&cmd.ChartGroups,
+		"group",
+		nil,
+		"roll back only the releases of these chart groups")
 	return cobraCmd
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/charter-oss/barrelman/pkg/barrelman"
+	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/cirrocloud/structured/log"
 )
 
@@ -88,6 +89,78 @@ func newRootCmd(args []string) (*cobra.Command, *RootOpts) {
 		Default().KubeContext,
 		"use alternate kube context")
 
+	flags.StringVar(
+		&options.Backend,
+		"backend",
+		Default().Backend,
+		"release storage backend. [ helm2 | helm3 ]")
+
+	flags.StringVar(
+		&options.Simulate,
+		"simulate",
+		"",
+		"run against an in-memory cluster persisted to the given state file")
+
+	flags.DurationVar(
+		&options.Timeout,
+		"timeout",
+		0,
+		"abort the operation and cancel its transaction after this duration (e.g. 30m), 0 waits indefinitely")
+
+	flags.IntVar(
+		&options.HistoryMax,
+		"history-max",
+		0,
+		"limit the manifest versions kept in the cluster, 0 defers to the manifest or config")
+
+	flags.StringVar(
+		&options.VersionsNamespace,
+		"versions-namespace",
+		"",
+		"namespace holding Barrelman versions, locks and journals, defaults to the Tiller namespace or kube-system")
+
+	flags.StringVar(
+		&options.VersionsStorage,
+		"versions-storage",
+		cluster.VersionsStorageConfigMap,
+		"Barrelman versions storage. [ configmap | secret ]")
+
+	flags.StringVar(
+		&options.TillerHost,
+		"tiller-host",
+		Default().TillerHost,
+		"connect directly to Tiller at host:port instead of port forwarding, overrides $HELM_HOST")
+
+	flags.StringVar(
+		&options.TillerNamespace,
+		"tiller-namespace",
+		Default().TillerNamespace,
+		"namespace of Tiller, overrides $TILLER_NAMESPACE (default kube-system)")
+
+	flags.StringVar(
+		&options.TillerSelector,
+		"tiller-selector",
+		"",
+		"label selector of the Tiller pod (default app=helm,name=tiller)")
+
+	flags.DurationVar(
+		&options.TillerConnectionTimeout,
+		"tiller-connection-timeout",
+		0,
+		"duration to wait for a connection to Tiller (default 5s)")
+
+	flags.StringVar(
+		&options.HelmHome,
+		"home",
+		Default().HelmHome,
+		"location of the Helm TLS files, overrides $HELM_HOME")
+
+	flags.BoolVar(
+		&options.IgnoreClusterGuard,
+		"ignore-cluster-guard",
+		false,
+		"do not verify the cluster identity declared in the manifest")
+
 	cobraCmd.AddCommand(newDeleteCmd(&barrelman.DeleteCmd{
 		Options: options,
 		Config:  config,
@@ -98,6 +171,11 @@ func newRootCmd(args []string) (*cobra.Command, *RootOpts) {
 		Config:  config,
 	}))
 
+	cobraCmd.AddCommand(newDriftCmd(&barrelman.DriftCmd{
+		Options: options,
+		Config:  config,
+	}))
+
 	cobraCmd.AddCommand(newListCmd(&barrelman.ListCmd{
 		Options: options,
 		Config:  config,
@@ -113,6 +191,11 @@ func newRootCmd(args []string) (*cobra.Command, *RootOpts) {
 		Config:  config,
 	}))
 
+	cobraCmd.AddCommand(newGetCmd(&barrelman.GetManifestCmd{
+		Options: options,
+		Config:  config,
+	}))
+
 	cobraCmd.AddCommand(newDescribeCmd(&barrelman.DescribeCmd{
 		Options: options,
 		Config:  config,
@@ -123,6 +206,20 @@ func newRootCmd(args []string) (*cobra.Command, *RootOpts) {
 		Config:  config,
 	}))
 
+	cobraCmd.AddCommand(newMigrateCmd(&barrelman.MigrateCmd{
+		Options: options,
+		Config:  config,
+	}))
+
+	cobraCmd.AddCommand(newRecoverCmd(&barrelman.RecoverCmd{
+		Options: options,
+		Config:  config,
+	}))
+
+	cobraCmd.AddCommand(newUnlockCmd(&barrelman.UnlockCmd{
+		Options: options,
+	}))
+
 	cobraCmd.AddCommand(newVersionCmd(&barrelman.VersionCmd{}))
 
 	flags.Parse(args)
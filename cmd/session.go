@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/cirrocloud/structured/errors"
+)
+
+// newSession returns the cluster.Sessioner selected by --backend, or a simulated cluster with --simulate
+// a Tiller session is located by the --tiller flags, falling back to the tiller entries of the config file
+func newSession(options *barrelman.CmdOptions) (cluster.Sessioner, error) {
+	if options.Simulate != "" {
+		session := cluster.NewSimSession(options.Simulate)
+		if options.VersionsNamespace != "" {
+			session.VersionsNamespace = options.VersionsNamespace
+		}
+		session.VersionsStorage = options.VersionsStorage
+		return session, nil
+	}
+	switch options.Backend {
+	case "", "helm2", "tiller":
+		return newTillerSession(options)
+	case "helm3":
+		return newHelm3Session(options), nil
+	}
+	return nil, errors.WithFields(errors.Fields{
+		"Backend": options.Backend,
+	}).New("unknown backend, expected one of [ helm2 | helm3 ]")
+}
+
+// newTillerSession returns a Helm 2 session for the Tiller located by the --tiller flags or the config file
+func newTillerSession(options *barrelman.CmdOptions) (*cluster.Session, error) {
+	config, err := barrelman.GetConfigFromFile(options.ConfigFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "got error while loading config")
+	}
+	tiller := options.Tiller(config)
+	session := cluster.NewSession(
+		options.KubeContext,
+		options.KubeConfigFile)
+	session.TillerHost = tiller.Host
+	session.TillerNamespace = tiller.Namespace
+	session.TillerSelector = tiller.Selector
+	session.TillerConnectionTimeout = tiller.ConnectionTimeout
+	session.HelmHome = options.HelmHome
+	session.VersionsNamespace = options.VersionsNamespace
+	session.VersionsStorage = options.VersionsStorage
+	return session, nil
+}
+
+// newHelm3Session returns a Helm 3 session, keeping its default versions namespace unless one is given
+func newHelm3Session(options *barrelman.CmdOptions) *cluster.Helm3Session {
+	session := cluster.NewHelm3Session(
+		options.KubeContext,
+		options.KubeConfigFile)
+	if options.VersionsNamespace != "" {
+		session.VersionsNamespace = options.VersionsNamespace
+	}
+	session.VersionsStorage = options.VersionsStorage
+	return session
+}
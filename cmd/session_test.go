@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewTillerSession(t *testing.T) {
+	Convey("newTillerSession", t, func() {
+		Convey("Locates Tiller with the tiller flags", func() {
+			session, err := newTillerSession(&barrelman.CmdOptions{
+				ConfigFile:              "testdata/missing.yaml",
+				TillerNamespace:         "tiller-apps",
+				TillerSelector:          "app=helm,name=tiller",
+				TillerConnectionTimeout: 5 * time.Second,
+				HelmHome:                "/tmp/helm",
+				VersionsNamespace:       "versions",
+			})
+			So(err, ShouldBeNil)
+			So(session.TillerNamespace, ShouldEqual, "tiller-apps")
+			So(session.TillerSelector, ShouldEqual, "app=helm,name=tiller")
+			So(session.TillerConnectionTimeout, ShouldEqual, 5*time.Second)
+			So(session.HelmHome, ShouldEqual, "/tmp/helm")
+			So(session.VersionsNamespace, ShouldEqual, "versions")
+		})
+	})
+}
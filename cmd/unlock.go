@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+)
+
+func newUnlockCmd(cmd *barrelman.UnlockCmd) *cobra.Command {
+
+	longDesc := strings.TrimSpace(dedent.Dedent(`
+		Unlock removes the cluster lock held on a manifest.
+
+		Apply, rollback and delete lock the manifest while they run. A lock left behind
+		by an interrupted process expires once it is no longer renewed, unlock removes it
+		earlier. A lock that is still being renewed is only removed with --force.`))
+
+	shortDesc := `Remove the cluster lock of a manifest.`
+
+	examples := `barrelman unlock lamp-stack --force`
+
+	cobraCmd := &cobra.Command{
+		Use:           "unlock [manifest name]",
+		Short:         shortDesc,
+		Long:          longDesc,
+		Example:       examples,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			cmd.ManifestName = args[0]
+			session, err := newSession(cmd.Options)
+			if err != nil {
+				return err
+			}
+			if err := cmd.Run(session); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.LogOptions = cobraCmd.Flags().StringSliceP(
+		"log",
+		"l",
+		nil,
+		"log options (e.g. --log=debug,JSON")
+
+	cobraCmd.Flags().BoolVar(
+		&cmd.Force,
+		"force",
+		false,
+		"remove the lock even when it is held by a running operation")
+	return cobraCmd
+}
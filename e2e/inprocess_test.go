@@ -0,0 +1,136 @@
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/tiller/environment"
+
+	"github.com/charter-oss/barrelman/pkg/barrelman"
+	"github.com/charter-oss/barrelman/pkg/cluster/tillertest"
+	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
+)
+
+// failingKubeClient fails to create objects in one namespace
+type failingKubeClient struct {
+	environment.PrintingKubeClient
+	namespace string
+}
+
+func (c *failingKubeClient) Create(namespace string, reader io.Reader, timeout int64, shouldWait bool) error {
+	if namespace == c.namespace {
+		return fmt.Errorf("simulated failure creating objects in %s", namespace)
+	}
+	return c.PrintingKubeClient.Create(namespace, reader, timeout, shouldWait)
+}
+
+func TestInProcessTiller(t *testing.T) {
+	const dataDir = "testdata/datadir"
+	options := func(manifestFile string) *barrelman.CmdOptions {
+		return &barrelman.CmdOptions{
+			Force:        &[]string{},
+			InstallRetry: 1,
+			DataDir:      dataDir,
+			ConfigFile:   "testdata/noconfig",
+			ManifestFile: manifestFile,
+		}
+	}
+
+	Convey("Given an in-process Tiller", t, func() {
+		ts, err := tillertest.NewServer()
+		So(err, ShouldBeNil)
+		Reset(func() {
+			ts.Stop()
+			chartsync.Reset()
+			os.RemoveAll(dataDir)
+		})
+		apply := func(manifestFile string) error {
+			cmd := &barrelman.ApplyCmd{Options: options(manifestFile)}
+			return cmd.Run(ts.Session())
+		}
+		replicaCount := func(releaseName string) string {
+			rls, err := ts.Env.Releases.Deployed(releaseName)
+			So(err, ShouldBeNil)
+			return rls.Config.Raw
+		}
+
+		Convey("When a manifest is applied", func() {
+			So(apply("testdata/inprocess_manifest.yaml"), ShouldBeNil)
+			So(replicaCount("inprocess-app"), ShouldContainSubstring, "replicaCount: 1")
+
+			Convey("An updated manifest upgrades the release", func() {
+				So(apply("testdata/inprocess_manifest_update.yaml"), ShouldBeNil)
+				So(replicaCount("inprocess-app"), ShouldContainSubstring, "replicaCount: 3")
+				s := ts.Session()
+				So(s.Init(), ShouldBeNil)
+				versions, err := s.GetVersions("inprocess")
+				So(err, ShouldBeNil)
+				So(versions.Data, ShouldHaveLength, 2)
+
+				Convey("Rollback restores the first manifest version", func() {
+					cmd := &barrelman.RollbackCmd{
+						Options:         options(""),
+						ManifestName:    "inprocess",
+						ManifestVersion: 1,
+					}
+					So(cmd.Run(ts.Session()), ShouldBeNil)
+					So(replicaCount("inprocess-app"), ShouldContainSubstring, "replicaCount: 1")
+				})
+			})
+
+			Convey("A failed apply cancels the transaction", func() {
+				ts.Env.KubeClient = &failingKubeClient{
+					PrintingKubeClient: environment.PrintingKubeClient{Out: ioutil.Discard},
+					namespace:          "inprocess-broken",
+				}
+				err := apply("testdata/inprocess_manifest_broken.yaml")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "simulated failure")
+
+				// The upgraded release is rolled back and the failed release is not running
+				So(replicaCount("inprocess-app"), ShouldContainSubstring, "replicaCount: 1")
+				_, err = ts.Env.Releases.Deployed("inprocess-extra")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Drift repair restores the objects of an unchanged release", func() {
+				repair := func() error {
+					cmd := &barrelman.ApplyCmd{Options: options("testdata/inprocess_manifest.yaml")}
+					cmd.Options.RepairDrift = true
+					return cmd.Run(ts.Session())
+				}
+				configMaps := ts.Clientset.CoreV1().ConfigMaps("inprocess")
+
+				// Tiller creates no objects here, so the object of the release is missing
+				So(repair(), ShouldBeNil)
+				settings, err := configMaps.Get("inprocess-app-settings", metav1.GetOptions{})
+				So(err, ShouldBeNil)
+				So(settings.Data["replicaCount"], ShouldEqual, "1")
+
+				// an object edited in the cluster is replaced, without upgrading the release
+				settings.Data["replicaCount"] = "5"
+				_, err = configMaps.Update(settings)
+				So(err, ShouldBeNil)
+				So(repair(), ShouldBeNil)
+				settings, err = configMaps.Get("inprocess-app-settings", metav1.GetOptions{})
+				So(err, ShouldBeNil)
+				So(settings.Data["replicaCount"], ShouldEqual, "1")
+				rls, err := ts.Env.Releases.Deployed("inprocess-app")
+				So(err, ShouldBeNil)
+				So(rls.Version, ShouldEqual, 1)
+			})
+
+			Convey("Delete removes the releases", func() {
+				cmd := &barrelman.DeleteCmd{Options: options("testdata/inprocess_manifest.yaml")}
+				So(cmd.Run(ts.Session()), ShouldBeNil)
+				_, err := ts.Env.Releases.Deployed("inprocess-app")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -1,20 +1,24 @@
 package barrelman
 
 import (
+	"context"
 	"fmt"
-	"time"
+	"strings"
 
 	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/manifest"
 	"github.com/charter-oss/barrelman/pkg/version"
 	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/structured/log"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 )
 
 type ApplyCmd struct {
 	Options    *CmdOptions
 	Config     *Config
 	LogOptions *[]string
+	// Resume adopts the journal of an interrupted transaction instead of failing on it
+	Resume bool
 }
 
 type ReleaseTarget struct {
@@ -23,13 +27,22 @@ type ReleaseTarget struct {
 	TransitionState TransitionState
 	Diff            []byte
 	Changed         bool
-	ReleaseVersion  *cluster.Version
+	Drift           *cluster.ReleaseDrift
+	// Repair applies the desired state of the drifted objects once the release is upgraded or left unchanged
+	Repair         bool
+	ReleaseVersion *cluster.Version
+	// Retry holds the retry limits set for the chart in the manifest
+	Retry *cluster.RetryPolicy
+	// Source is where the chart was archived from, recorded with the manifest version
+	Source *cluster.ChartSource
 }
 
 type ReleaseTargets struct {
 	ManifestName string
+	DiffOptions  *cluster.DiffOptions
 	session      cluster.Sessioner
 	transaction  cluster.Transactioner
+	interrupt    *interrupt
 	Data         []*ReleaseTarget
 }
 
@@ -52,63 +65,161 @@ func (cmd *ApplyCmd) Run(session cluster.Sessioner) error {
 		return errors.Wrap(err, "failed to create new cluster session")
 	}
 
-	archives, manifestName, err := processManifest(&manifest.Config{
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+	return cmd.apply(ctx, session)
+}
+
+// apply commits the manifest to the cluster through an initialized session
+// the sync, plan and apply phases are each bounded by their timeout option within ctx
+func (cmd *ApplyCmd) apply(ctx context.Context, session cluster.Sessioner) error {
+	syncCtx, cancelSync := phaseContext(ctx, cmd.Options.SyncTimeout)
+	archives, mfest, err := processManifest(syncCtx, &manifest.Config{
 		DataDir:      cmd.Options.DataDir,
 		ManifestFile: cmd.Options.ManifestFile,
 		AccountTable: cmd.Config.Account,
 	}, cmd.Options.NoSync)
+	err = timeoutError(syncCtx, "sync", err)
+	cancelSync()
 	if err != nil {
 		return errors.Wrap(err, "apply failed")
 	}
+	return cmd.applyArchives(ctx, session, mfest, archives, mfest.Data.Cluster)
+}
 
-	transaction, err := session.NewTransaction(manifestName)
-	if err != nil {
-		return errors.Wrap(err, "failed to create new transaction durring apply")
+// applyArchives commits the archives of a synced manifest to the cluster through an initialized session
+// the cluster is verified against guard before anything is planned
+func (cmd *ApplyCmd) applyArchives(
+	ctx context.Context,
+	session cluster.Sessioner,
+	mfest *manifest.Manifest,
+	archives *manifest.ArchiveFiles,
+	guard *manifest.ClusterGuard) error {
+	if err := guardCluster(session, guard, cmd.Options.IgnoreClusterGuard); err != nil {
+		return err
 	}
+	manifestName := mfest.Name
 
-	releases, err := session.ReleasesByManifest(manifestName)
+	ctx, unlock, err := lockManifest(ctx, session, manifestName)
 	if err != nil {
-		return errors.Wrap(err, "failed to get current releases")
+		return err
 	}
+	defer unlock()
 
-	rt, err := cmd.ComputeReleases(session, transaction, manifestName, archives, releases)
+	journal, err := unfinishedJournal(session, manifestName, cmd.Resume || cmd.Options.DryRun || cmd.Options.Diff)
 	if err != nil {
 		return err
 	}
 
-	if err := rt.dryRun(session); err != nil {
-		return err
+	transaction, err := session.NewTransaction(manifestName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create new transaction durring apply")
 	}
-	if cmd.Options.DryRun {
-		log.Info("No errors")
-		return nil
+	if journal != nil && cmd.Resume {
+		transaction.Resume(journal)
 	}
 
-	_, err = rt.Diff(session)
+	planCtx, cancelPlan := phaseContext(ctx, cmd.Options.PlanTimeout)
+	defer cancelPlan()
+	rt, err := cmd.plan(planCtx, session, transaction, mfest, archives)
 	if err != nil {
-		return err
+		return timeoutError(planCtx, "plan", err)
+	}
+	if cmd.Options.DryRun {
+		log.Info("No errors")
+		return nil
 	}
 	if cmd.Options.Diff {
 		rt.LogDiff()
 		return nil
 	}
 
-	err = rt.Apply(cmd.Options)
+	// The version records the manifest and the chart sources it was applied from
+	// it is checked to fit its storage before any release is changed
+	source := newVersionSource()
+	source.Manifest = mfest.Source()
+	for _, target := range rt.Data {
+		source.Charts = append(source.Charts, target.Source)
+	}
+	transaction.Versions().Source = source
+	transaction.Versions().HistoryMax = cmd.Options.HistoryLimit(cmd.Config, mfest)
+	if err := session.CheckVersionSize(rt.plannedVersions(transaction.Versions())); err != nil {
+		return errors.Wrap(err, "apply failed")
+	}
+
+	applyCtx, cancelApply := phaseContext(ctx, cmd.Options.ApplyTimeout)
+	defer cancelApply()
+	rt.interrupt = watchInterrupt(manifestName)
+	defer rt.interrupt.Close()
+	err = rt.Apply(applyCtx, cmd.Options)
+	if err != nil && lockLost(ctx) {
+		return lockLostError(manifestName, err)
+	}
 	if err != nil {
+		err = timeoutError(applyCtx, "apply", err)
 		if innerErr := transaction.Cancel(); innerErr != nil {
 			err = errors.WithFields(errors.Fields{
 				"TransactionError": innerErr.Error(),
 			}).Wrap(err, "transaction error while Canceling")
+		} else if rt.interrupt.Stopped() {
+			log.WithFields(log.Fields{
+				"ManifestName": manifestName,
+			}).Warn("Transaction canceled after interrupt")
+		} else if applyCtx.Err() == context.DeadlineExceeded {
+			log.WithFields(log.Fields{
+				"ManifestName": manifestName,
+			}).Warn("Transaction canceled after timeout")
 		}
 		return errors.Wrap(err, "Manifest upgrade failed")
 	}
-
 	return transaction.Complete()
 }
 
+// plan computes the release targets of the manifest and verifies them with a dry run and diff
+func (cmd *ApplyCmd) plan(
+	ctx context.Context,
+	session cluster.Sessioner,
+	transaction cluster.Transactioner,
+	mfest *manifest.Manifest,
+	archives *manifest.ArchiveFiles) (*ReleaseTargets, error) {
+	if err := useTillers(session, archives.TillerNamespaces()); err != nil {
+		return nil, err
+	}
+	releases, err := session.ReleasesByManifest(ctx, mfest.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get current releases")
+	}
+
+	rt, err := cmd.ComputeReleases(session, transaction, mfest.Name, archives, releases)
+	if err != nil {
+		return nil, err
+	}
+	rt.DiffOptions = diffOptions(cmd.Config, mfest)
+
+	if err := rt.dryRun(ctx, session); err != nil {
+		return nil, err
+	}
+	if cmd.Options.DryRun {
+		return rt, nil
+	}
+
+	if _, err := rt.Diff(ctx, session); err != nil {
+		return nil, err
+	}
+	if cmd.Options.RepairDrift {
+		if err := rt.RepairDrift(ctx, session); err != nil {
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
 //IsReplaceable checks a release against the --force flag values to see if an existing release should be replaced via delete
 func (cmd *ApplyCmd) isInForce(rel *cluster.ReleaseMeta) bool {
 	//Checks for releases configured for Force by cmdline
+	if cmd.Options.Force == nil {
+		return false
+	}
 	for _, r := range *cmd.Options.Force {
 		if r == rel.MetaName || r == rel.ChartName || r == rel.ReleaseName {
 			return true
@@ -119,6 +230,7 @@ func (cmd *ApplyCmd) isInForce(rel *cluster.ReleaseMeta) bool {
 
 //ComputeReleases configures each potential release with a current state
 //states may be one of 'Installable', 'Upgradeable', 'Replaceable', 'NoChange'
+//transaction is nil when the targets are only inspected, as by drift
 func (cmd *ApplyCmd) ComputeReleases(
 	session cluster.Sessioner,
 	transaction cluster.Transactioner,
@@ -142,50 +254,64 @@ func (cmd *ApplyCmd) ComputeReleases(
 		rt := &ReleaseTarget{
 			TransitionState: NoChange, //Unless modified below
 			ReleaseMeta: &cluster.ReleaseMeta{
-				Chart:          inChart,
-				ReleaseName:    v.ReleaseName,
-				Namespace:      v.Namespace,
-				ValueOverrides: v.Overrides,
-				InstallWait:    v.InstallWait,
+				Chart:           inChart,
+				ReleaseName:     v.ReleaseName,
+				Namespace:       v.Namespace,
+				ValueOverrides:  v.Overrides,
+				InstallWait:     v.InstallWait,
+				TillerNamespace: v.TillerNamespace,
 			},
+			Retry: chartRetryPolicy(v.Retry),
 		}
 
 		//Evaluate archive vs current releases
-		for _, rel := range currentReleases {
-			if rel.ReleaseName == v.ReleaseName {
-				rt.ReleaseVersion = &cluster.Version{
-					Name:             rel.ReleaseName,
-					Namespace:        rel.Namespace,
-					Revision:         rel.Revision,
-					PreviousRevision: rel.Revision,
-				}
-				releaseExists = true
-				if rel.Status == cluster.Status_DELETED {
-					// Current release has been deleted, a state that is resisitant to Upgrade/Install
-					// Rollback to the current revision, then Upgrade
-					rt.TransitionState = Undeletable
-				} else if cmd.isInForce(rel) || rel.Status == cluster.Status_FAILED {
-					// Current release is in FAILED state AND force is enabled for this release
-					// setup for delete and install
-					rt.TransitionState = Replaceable
-				} else {
-					// All other cases use Upgrade
-					rt.TransitionState = Upgradable
-				}
+		if rel := cluster.FindRelease(currentReleases, v.Namespace, v.ReleaseName); rel != nil {
+			rt.ReleaseVersion = &cluster.Version{
+				Name:             rel.ReleaseName,
+				Namespace:        rel.Namespace,
+				Revision:         rel.Revision,
+				PreviousRevision: rel.Revision,
+				TillerNamespace:  rel.TillerNamespace,
+			}
+			// An existing release stays with the Tiller holding it, it is upgraded in its namespace
+			rt.ReleaseMeta.TillerNamespace = rel.TillerNamespace
+			releaseExists = true
+			if rel.Status == cluster.Status_DELETED {
+				// Current release has been deleted, a state that is resisitant to Upgrade/Install
+				// Rollback to the current revision, then Upgrade
+				rt.TransitionState = Undeletable
+				rt.ReleaseMeta.Namespace = rel.Namespace
+				rt.ReleaseVersion.Prior = rel.Prior()
+			} else if cmd.isInForce(rel) || rel.Status == cluster.Status_FAILED {
+				// Current release is in FAILED state AND force is enabled for this release
+				// setup for delete and install
+				rt.TransitionState = Replaceable
+				rt.ReleaseVersion.Prior = rel.Prior()
+			} else {
+				// All other cases use Upgrade
+				rt.TransitionState = Upgradable
+				rt.ReleaseMeta.Namespace = rel.Namespace
 			}
 		}
 		if !releaseExists {
 			//There is no existing releases, just Install
 			rt.TransitionState = Installable
 			rt.ReleaseVersion = &cluster.Version{
-				Name:      v.ReleaseName,
-				Namespace: v.Namespace,
+				Name:            v.ReleaseName,
+				Namespace:       v.Namespace,
+				TillerNamespace: v.TillerNamespace,
 			}
 		}
+		// The chart is stored with the manifest version once the release changes, a rollback can reinstall the release from it
+		rt.ReleaseVersion.Chart = inChart
+		rt.ReleaseVersion.Config = &chart.Config{Raw: string(v.Overrides)}
+		rt.ReleaseVersion.MetaName = v.MetaName
+		rt.ReleaseVersion.ChartGroup = v.ChartGroup
+		rt.Source = chartSource(v, inChart)
 		rts.Data = append(rts.Data, rt)
 
 		// Add this release target to the transaction
-		rts.transaction.Versions().AddReleaseVersion(rt.ReleaseVersion)
+		rts.addReleaseVersion(rt.ReleaseVersion)
 	}
 
 	// iterate current releases, any that do not exist in rollbackReleaseList set to delete
@@ -208,23 +334,41 @@ func (cmd *ApplyCmd) ComputeReleases(
 			continue
 		}
 
+		// The deleted release is kept in the transaction so a cancel can restore it
 		rv := &cluster.Version{
-			Name:      rel.ReleaseName,
-			Namespace: rel.Namespace,
+			Name:             rel.ReleaseName,
+			Namespace:        rel.Namespace,
+			Revision:         rel.Revision,
+			PreviousRevision: rel.Revision,
+			TillerNamespace:  rel.TillerNamespace,
+			Prior:            rel.Prior(),
+			Removed:          true,
 		}
 		rts.Data = append(rts.Data, &ReleaseTarget{
 			ReleaseMeta: &cluster.ReleaseMeta{
-				ReleaseName: rel.ReleaseName,
-				Namespace:   rel.Namespace,
+				ReleaseName:     rel.ReleaseName,
+				Namespace:       rel.Namespace,
+				TillerNamespace: rel.TillerNamespace,
 			},
 			TransitionState: Deletable,
-			ReleaseVersion:  &cluster.Version{},
+			ReleaseVersion:  rv,
 		})
-		rv.SetModified()
+		rts.addReleaseVersion(rv)
 	}
 	return rts, nil
 }
 
+// useTillers connects the session to the Tillers named in the manifest, a manifest naming none keeps the primary Tiller
+func useTillers(session cluster.Sessioner, namespaces []string) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	if err := session.UseTillers(namespaces); err != nil {
+		return errors.Wrap(err, "failed to connect to the Tillers of the manifest")
+	}
+	return nil
+}
+
 func (rt *ReleaseTargets) HasRelease(releaseName string) bool {
 	for _, v := range rt.Data {
 		if v.ReleaseMeta.ReleaseName == releaseName {
@@ -234,17 +378,17 @@ func (rt *ReleaseTargets) HasRelease(releaseName string) bool {
 	return false
 }
 
-func (rt *ReleaseTargets) dryRun(session cluster.Sessioner) error {
+func (rt *ReleaseTargets) dryRun(ctx context.Context, session cluster.Sessioner) error {
 	for _, v := range rt.Data {
 		v.ReleaseMeta.DryRun = true
 		switch v.TransitionState {
 		case Installable:
-			_, err := session.InstallRelease(v.ReleaseMeta, rt.ManifestName)
+			_, err := session.InstallRelease(ctx, v.ReleaseMeta, rt.ManifestName)
 			if err != nil {
 				return err
 			}
 		case Upgradable:
-			_, err := session.UpgradeRelease(v.ReleaseMeta, rt.ManifestName)
+			_, err := session.UpgradeRelease(ctx, v.ReleaseMeta, rt.ManifestName)
 			if err != nil {
 				return err
 			}
@@ -253,13 +397,14 @@ func (rt *ReleaseTargets) dryRun(session cluster.Sessioner) error {
 	return nil
 }
 
-func (rt *ReleaseTargets) Diff(session cluster.Sessioner) (*ReleaseTargets, error) {
+func (rt *ReleaseTargets) Diff(ctx context.Context, session cluster.Sessioner) (*ReleaseTargets, error) {
 	var err error
 	for _, v := range rt.Data {
 		v.ReleaseMeta.DryRun = true
+		v.ReleaseMeta.DiffOptions = rt.DiffOptions
 		switch v.TransitionState {
 		case Upgradable:
-			v.Changed, v.Diff, err = session.DiffRelease(v.ReleaseMeta)
+			v.Changed, v.Diff, err = session.DiffRelease(ctx, v.ReleaseMeta)
 			if err != nil {
 				return nil, err
 			}
@@ -268,6 +413,59 @@ func (rt *ReleaseTargets) Diff(session cluster.Sessioner) (*ReleaseTargets, erro
 	return rt, nil
 }
 
+//RepairDrift marks releases with drifted objects for repair, the diff of an unchanged release reports its drift
+//an upgrade does not patch live objects back to the desired state, Apply repairs them through the session
+func (rt *ReleaseTargets) RepairDrift(ctx context.Context, session cluster.Sessioner) error {
+	if _, err := rt.DetectDrift(ctx, session); err != nil {
+		return err
+	}
+	for _, v := range rt.Data {
+		if !v.Drifted() {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"Name":    v.ReleaseMeta.ReleaseName,
+			"Objects": len(v.Drift.Objects),
+		}).Info("Drift will be repaired")
+		v.Repair = true
+		if !v.Changed {
+			v.Diff = driftDiff(v.Drift)
+		}
+	}
+	return nil
+}
+
+// repairDrift applies the desired state of the drifted objects of v
+func (rt *ReleaseTargets) repairDrift(ctx context.Context, policy *cluster.RetryPolicy, v *ReleaseTarget) error {
+	log.WithFields(log.Fields{
+		"Name":      v.ReleaseMeta.ReleaseName,
+		"Namespace": v.ReleaseMeta.Namespace,
+		"Objects":   len(v.Drift.Objects),
+	}).Info("Repairing drift")
+	err := policy.Do(ctx, "repair", func() error {
+		return rt.session.RepairDrift(ctx, v.Drift)
+	})
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"Name":      v.ReleaseMeta.ReleaseName,
+			"Namespace": v.ReleaseMeta.Namespace,
+		}).Wrap(err, "error while repairing drift")
+	}
+	return nil
+}
+
+func driftDiff(rd *cluster.ReleaseDrift) []byte {
+	ret := []byte{}
+	for _, od := range rd.Objects {
+		if len(od.Diff) > 0 {
+			ret = append(ret, od.Diff...)
+			continue
+		}
+		ret = append(ret, []byte(fmt.Sprintf("%s is %s\n", od.Name, strings.ToLower(od.State.String())))...)
+	}
+	return ret
+}
+
 func (rt *ReleaseTargets) LogDiff() {
 	for _, v := range rt.Data {
 		switch v.TransitionState {
@@ -277,7 +475,7 @@ func (rt *ReleaseTargets) LogDiff() {
 				"Namespace": v.ReleaseMeta.Namespace,
 			}).Info("Would install")
 		case Upgradable:
-			if v.Changed {
+			if v.Changed || v.Repair {
 				log.WithFields(log.Fields{
 					"Name": v.ReleaseMeta.ReleaseName,
 				}).Info("Diff")
@@ -292,93 +490,118 @@ func (rt *ReleaseTargets) LogDiff() {
 	}
 }
 
-func (rt *ReleaseTargets) Apply(opt *CmdOptions) error {
+// Apply changes each release target, a done ctx stops it before the next release
+// transient errors are retried within the retry limits of each release
+func (rt *ReleaseTargets) Apply(ctx context.Context, opt *CmdOptions) error {
+	if err := rt.checkpoint(); err != nil {
+		return err
+	}
 
 	for _, v := range rt.Data {
+		if err := rt.interrupt.Err(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		v.ReleaseMeta.DryRun = false
 		v.ReleaseMeta.InstallTimeout = 120
+		policy := v.retryPolicy(opt)
 		switch v.TransitionState {
 		case Installable, Replaceable:
 			if err := func() error {
 				//This closure removes a "break OUT"
-				var innerErr error
 				if v.TransitionState == Replaceable {
-					//The release exists, it needs to be deleted
+					//The release exists in its own namespace, it needs to be deleted
 					dm := &cluster.DeleteMeta{
-						ReleaseName:   v.ReleaseMeta.ReleaseName,
-						Namespace:     v.ReleaseMeta.Namespace,
-						DeleteTimeout: v.ReleaseMeta.InstallTimeout,
+						ReleaseName:     v.ReleaseMeta.ReleaseName,
+						Namespace:       v.ReleaseVersion.Namespace,
+						DeleteTimeout:   v.ReleaseMeta.InstallTimeout,
+						TillerNamespace: v.ReleaseMeta.TillerNamespace,
 					}
 					log.WithFields(log.Fields{
 						"Name":        v.ReleaseMeta.ReleaseName,
 						"Namespace":   v.ReleaseMeta.Namespace,
 						"InstallWait": v.ReleaseMeta.InstallWait,
 					}).Info("Deleting (force install)")
-					if err := rt.session.DeleteRelease(dm); err != nil {
+					if err := policy.Do(ctx, "delete", func() error {
+						return rt.session.DeleteRelease(ctx, dm)
+					}); err != nil {
 						return errors.Wrap(err, "error deleting release before install (forced)")
 					}
 					v.ReleaseVersion.SetModified()
+					if err := rt.checkpoint(); err != nil {
+						return err
+					}
 				}
 				log.WithFields(log.Fields{
 					"Name":        v.ReleaseMeta.ReleaseName,
 					"Namespace":   v.ReleaseMeta.Namespace,
 					"InstallWait": v.ReleaseMeta.InstallWait,
 				}).Info("Installing")
-				for i := 0; i < opt.InstallRetry; i++ {
-					installResponse, err := rt.session.InstallRelease(v.ReleaseMeta, rt.ManifestName)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"Name":        v.ReleaseMeta.ReleaseName,
-							"Namespace":   v.ReleaseMeta.Namespace,
-							"InstallWait": v.ReleaseMeta.InstallWait,
-							"Error":       err.Error(),
-						}).Debug("Install reported error")
-						innerErr = err
-						//The state has changed underneath us, but the release needs installed anyhow
+				var installResponse *cluster.InstallReleaseResponse
+				failed := false
+				err := policy.Do(ctx, "install", func() error {
+					if failed {
+						//The failed attempt may have left the release behind, but the release needs installed anyhow
 						//So delete and try again
 						dm := &cluster.DeleteMeta{
-							ReleaseName:   v.ReleaseMeta.ReleaseName,
-							Namespace:     v.ReleaseMeta.Namespace,
-							DeleteTimeout: v.ReleaseMeta.InstallTimeout,
+							ReleaseName:     v.ReleaseMeta.ReleaseName,
+							Namespace:       v.ReleaseMeta.Namespace,
+							DeleteTimeout:   v.ReleaseMeta.InstallTimeout,
+							TillerNamespace: v.ReleaseMeta.TillerNamespace,
 						}
 						log.WithFields(log.Fields{
 							"Name":        v.ReleaseMeta.ReleaseName,
 							"Namespace":   v.ReleaseMeta.Namespace,
 							"InstallWait": v.ReleaseMeta.InstallWait,
 						}).Info("Deleting (state change)")
-						if err := rt.session.DeleteRelease(dm); err != nil {
+						if err := rt.session.DeleteRelease(ctx, dm); err != nil {
 							//deleting kube-proxy or other connection issues can trigger this, don't abort the retry
 							log.Debug(err, "error deleting release before install (forced)")
 						}
-						/////
-						select {
-						default:
-							_ = <-time.After(1 * time.Second)
-						}
-						continue
 					}
-					log.WithFields(log.Fields{
+					var err error
+					installResponse, err = rt.session.InstallRelease(ctx, v.ReleaseMeta, rt.ManifestName)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"Name":        v.ReleaseMeta.ReleaseName,
+							"Namespace":   v.ReleaseMeta.Namespace,
+							"InstallWait": v.ReleaseMeta.InstallWait,
+							"Error":       err.Error(),
+						}).Debug("Install reported error")
+						failed = true
+					}
+					return err
+				})
+				if err != nil {
+					return errors.WithFields(errors.Fields{
 						"Name":        v.ReleaseMeta.ReleaseName,
 						"Namespace":   v.ReleaseMeta.Namespace,
 						"InstallWait": v.ReleaseMeta.InstallWait,
-						"Release":     installResponse.ReleaseName,
-						"Version":     installResponse.ReleaseVersion,
-					}).Info(installResponse.Description)
-					v.ReleaseVersion.SetRevision(installResponse.ReleaseVersion)
-					innerErr = nil
-					return nil
+					}).Wrap(err, "Error while installing release")
 				}
-				return errors.WithFields(errors.Fields{
+				log.WithFields(log.Fields{
 					"Name":        v.ReleaseMeta.ReleaseName,
 					"Namespace":   v.ReleaseMeta.Namespace,
 					"InstallWait": v.ReleaseMeta.InstallWait,
-				}).Wrap(innerErr, "Error while installing release")
+					"Release":     installResponse.ReleaseName,
+					"Version":     installResponse.ReleaseVersion,
+				}).Info(installResponse.Description)
+				v.ReleaseVersion.SetRevision(installResponse.ReleaseVersion)
+				return rt.checkpoint()
 			}(); err != nil {
 				return err
 			}
 
 		case Upgradable, Undeletable:
 			if !v.Changed && v.TransitionState != Undeletable {
+				if v.Repair {
+					if err := rt.repairDrift(ctx, policy, v); err != nil {
+						return err
+					}
+					continue
+				}
 				log.WithFields(log.Fields{
 					"Name":      v.ReleaseMeta.ReleaseName,
 					"Namespace": v.ReleaseMeta.Namespace,
@@ -392,19 +615,32 @@ func (rt *ReleaseTargets) Apply(opt *CmdOptions) error {
 					"Namespace": v.ReleaseMeta.Namespace,
 					"Revision":  v.ReleaseMeta.Revision,
 				}).Info("Rollback before Upgrade (undelete)")
-				_, err := rt.session.RollbackRelease(&cluster.RollbackMeta{
-					ReleaseName: v.ReleaseVersion.Name,
-					Revision:    v.ReleaseVersion.Revision,
+				err := policy.Do(ctx, "rollback", func() error {
+					_, err := rt.session.RollbackRelease(ctx, &cluster.RollbackMeta{
+						ReleaseName:     v.ReleaseVersion.Name,
+						Revision:        v.ReleaseVersion.Revision,
+						TillerNamespace: v.ReleaseVersion.TillerNamespace,
+					})
+					return err
 				})
 				if err != nil {
 					return errors.Wrap(err, "Rollback of release failed")
 				}
+				v.ReleaseVersion.SetModified()
+				if err := rt.checkpoint(); err != nil {
+					return err
+				}
 			}
 			log.WithFields(log.Fields{
 				"Name":      v.ReleaseMeta.ReleaseName,
 				"Namespace": v.ReleaseMeta.Namespace,
 			}).Info("Upgrading")
-			upgradeResponse, err := rt.session.UpgradeRelease(v.ReleaseMeta, rt.ManifestName)
+			var upgradeResponse *cluster.UpgradeReleaseResponse
+			err := policy.Do(ctx, "upgrade", func() error {
+				var err error
+				upgradeResponse, err = rt.session.UpgradeRelease(ctx, v.ReleaseMeta, rt.ManifestName)
+				return err
+			})
 			if err != nil {
 				return errors.WithFields(errors.Fields{
 					"Name":      v.ReleaseMeta.ReleaseName,
@@ -417,22 +653,36 @@ func (rt *ReleaseTargets) Apply(opt *CmdOptions) error {
 				"Version":   upgradeResponse.ReleaseVersion,
 			}).Info(upgradeResponse.Description)
 			v.ReleaseVersion.SetRevision(upgradeResponse.ReleaseVersion)
+			if err := rt.checkpoint(); err != nil {
+				return err
+			}
+			if v.Repair {
+				if err := rt.repairDrift(ctx, policy, v); err != nil {
+					return err
+				}
+			}
 		case Deletable:
 			//The release exists, it needs to be deleted
 			dm := &cluster.DeleteMeta{
-				ReleaseName:   v.ReleaseMeta.ReleaseName,
-				Namespace:     v.ReleaseMeta.Namespace,
-				DeleteTimeout: v.ReleaseMeta.InstallTimeout,
+				ReleaseName:     v.ReleaseMeta.ReleaseName,
+				Namespace:       v.ReleaseMeta.Namespace,
+				DeleteTimeout:   v.ReleaseMeta.InstallTimeout,
+				TillerNamespace: v.ReleaseMeta.TillerNamespace,
 			}
 			log.WithFields(log.Fields{
 				"Name":        v.ReleaseMeta.ReleaseName,
 				"Namespace":   v.ReleaseMeta.Namespace,
 				"InstallWait": v.ReleaseMeta.InstallWait,
 			}).Info("Deleting (removed from manifest)")
-			if err := rt.session.DeleteRelease(dm); err != nil {
+			if err := policy.Do(ctx, "delete", func() error {
+				return rt.session.DeleteRelease(ctx, dm)
+			}); err != nil {
 				return errors.Wrap(err, "error deleting release before install (forced)")
 			}
 			v.ReleaseVersion.SetModified()
+			if err := rt.checkpoint(); err != nil {
+				return err
+			}
 
 		default:
 			log.WithFields(log.Fields{
@@ -443,3 +693,43 @@ func (rt *ReleaseTargets) Apply(opt *CmdOptions) error {
 	}
 	return nil
 }
+
+// plannedVersions returns a copy of versions with the releases the targets change marked modified
+// as they are once applied, the version written then stores their charts and values
+func (rts *ReleaseTargets) plannedVersions(versions *cluster.Versions) *cluster.Versions {
+	changes := make(map[*cluster.Version]bool)
+	for _, v := range rts.Data {
+		if v.TransitionState != Upgradable || v.Changed {
+			changes[v.ReleaseVersion] = true
+		}
+	}
+	planned := *versions
+	planned.Data = []*cluster.Version{}
+	for _, v := range versions.Data {
+		version := *v
+		if changes[v] {
+			version.Modified = true
+		}
+		planned.Data = append(planned.Data, &version)
+	}
+	return &planned
+}
+
+// addReleaseVersion adds the version of a release target to the transaction, if there is one
+func (rts *ReleaseTargets) addReleaseVersion(version *cluster.Version) {
+	if rts.transaction == nil {
+		return
+	}
+	rts.transaction.Versions().AddReleaseVersion(version)
+}
+
+// checkpoint records the release changes made so far in the transaction journal, if there is one
+func (rt *ReleaseTargets) checkpoint() error {
+	if rt.transaction == nil {
+		return nil
+	}
+	if err := rt.transaction.Checkpoint(); err != nil {
+		return errors.Wrap(err, "failed to record transaction journal")
+	}
+	return nil
+}
@@ -2,10 +2,12 @@ package barrelman
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
@@ -40,6 +42,7 @@ func TestApplyRun(t *testing.T) {
 
 		session := &mocks.Sessioner{}
 		transaction := &mocks.Transactioner{}
+		transaction.On("Checkpoint").Return(nil).Maybe()
 
 		Convey("Should error on session.Init()", func() {
 			session.On("Init").Return(errors.New("simulated Init error"))
@@ -51,6 +54,8 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should error on manifest file not found", func() {
 			applyCmd.Options.ManifestFile = "testdata/nofile"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
 			err := applyCmd.Run(session)
@@ -61,6 +66,8 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should successfuly error during sync", func() {
 			applyCmd.Options.ManifestFile = "testdata/repo-not-exist.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
 			err := applyCmd.Run(session)
@@ -72,9 +79,11 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should successfuly handle error in ListReleases()", func() {
 			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.AnythingOfType("string")).Return(nil, errors.New("simulated"))
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(nil, errors.New("simulated"))
 			session.On("NewTransaction", mock.AnythingOfType("string")).Return(transaction, nil)
 			err := applyCmd.Run(session)
 			So(err, ShouldNotBeNil)
@@ -82,13 +91,27 @@ func TestApplyRun(t *testing.T) {
 			session.AssertExpectations(t)
 		})
 
+		Convey("Should error when the manifest is locked", func() {
+			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
+			session.On("Init").Return(nil)
+			session.On("Lock", "scratch-manifest").Return(nil, errors.New("manifest is locked by another operation"))
+			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
+			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
+			err := applyCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "locked")
+			session.AssertExpectations(t)
+		})
+
 		Convey("Should return nil on diff", func() {
 			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
 			applyCmd.Options.Diff = true
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
@@ -119,7 +142,7 @@ func TestApplyRun(t *testing.T) {
 				},
 			})
 
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string")).Return(&cluster.InstallReleaseResponse{}, nil)
 
@@ -131,9 +154,11 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should handle failure on Releases()", func() {
 			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
@@ -152,9 +177,11 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should handle failure on ChartFromArchive()", func() {
 			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
@@ -181,9 +208,11 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should handle failure to install release", func() {
 			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
@@ -201,7 +230,7 @@ func TestApplyRun(t *testing.T) {
 						Name: "storage-minio",
 					},
 				}, nil)
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.InstallReleaseResponse{}, errors.New("simulated"))
@@ -228,9 +257,11 @@ func TestApplyRun(t *testing.T) {
 		Convey("Should succeed", func() {
 			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{
 				"storage-minio": &cluster.ReleaseMeta{
 					Chart: &chart.Chart{
 						Metadata: &chart.Metadata{
@@ -253,6 +284,7 @@ func TestApplyRun(t *testing.T) {
 				},
 			})
 			transaction.On("Complete").Return(nil)
+			session.On("CheckVersionSize", mock.Anything).Return(nil)
 
 			session.On("ChartFromArchive", mock.MatchedBy(func(crm *bytes.Buffer) bool {
 				return true
@@ -262,12 +294,12 @@ func TestApplyRun(t *testing.T) {
 						Name: "storage-minio",
 					},
 				}, nil)
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.InstallReleaseResponse{}, nil)
 
-			session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(nil)
@@ -277,6 +309,83 @@ func TestApplyRun(t *testing.T) {
 			session.AssertExpectations(t)
 		})
 
+		Convey("Should leave the transaction to recover when the lock is lost", func() {
+			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
+			lost := make(chan struct{})
+			unlocker := &mocks.Unlocker{}
+			unlocker.On("Unlock").Return(nil)
+			unlocker.On("Lost").Return((<-chan struct{})(lost))
+			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(unlocker, nil)
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
+			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
+			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{}, nil)
+			session.On("NewTransaction", mock.AnythingOfType("string")).Return(transaction, nil)
+			transaction.On("Versions").Return(cluster.NewVersions("someVersionsName"))
+			session.On("CheckVersionSize", mock.Anything).Return(nil)
+			session.On("ChartFromArchive", mock.Anything).Return(&chart.Chart{
+				Metadata: &chart.Metadata{
+					Name: "storage-minio",
+				},
+			}, nil)
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+				return crm.DryRun
+			}), mock.AnythingOfType("string"),
+			).Return(&cluster.InstallReleaseResponse{}, nil)
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+				return !crm.DryRun
+			}), mock.AnythingOfType("string"),
+			).Run(func(args mock.Arguments) {
+				// the lease is taken over while the release is installed
+				close(lost)
+				<-args.Get(0).(context.Context).Done()
+			}).Return(&cluster.InstallReleaseResponse{}, context.Canceled).Once()
+
+			err := applyCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "barrelman recover")
+			transaction.AssertNotCalled(t, "Cancel")
+		})
+
+		Convey("Should fail before changing releases when the version is too large to store", func() {
+			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
+			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
+			session.On("GetJournal", mock.AnythingOfType("string")).Return(nil, nil).Maybe()
+			session.On("GetKubeConfig").Return(applyCmd.Options.KubeConfigFile).Maybe()
+			session.On("GetKubeContext").Return(applyCmd.Options.KubeContext).Maybe()
+			session.On("ReleasesByManifest", mock.Anything, mock.AnythingOfType("string")).Return(map[string]*cluster.ReleaseMeta{}, nil)
+			session.On("NewTransaction", mock.AnythingOfType("string")).Return(transaction, nil)
+			transaction.On("Versions").Return(cluster.NewVersions("someVersionsName"))
+			session.On("CheckVersionSize", mock.MatchedBy(func(versions *cluster.Versions) bool {
+				for _, v := range versions.Data {
+					if !v.Modified {
+						return false
+					}
+				}
+				return len(versions.Data) > 0
+			})).Return(errors.New("manifest version is too large to be stored"))
+			session.On("ChartFromArchive", mock.Anything).Return(&chart.Chart{
+				Metadata: &chart.Metadata{
+					Name: "storage-minio",
+				},
+			}, nil)
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+				return crm.DryRun
+			}), mock.AnythingOfType("string"),
+			).Return(&cluster.InstallReleaseResponse{}, nil)
+
+			err := applyCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "too large")
+			session.AssertNotCalled(t, "InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+				return !crm.DryRun
+			}), mock.Anything)
+			transaction.AssertNotCalled(t, "Cancel")
+			transaction.AssertNotCalled(t, "Complete")
+		})
+
 		Reset(func() {
 			chartsync.Reset()
 			session = &mocks.Sessioner{}
@@ -306,6 +415,7 @@ func TestComputeReleases(t *testing.T) {
 		}
 		session := &mocks.Sessioner{}
 		transaction := &mocks.Transactioner{}
+		transaction.On("Checkpoint").Return(nil).Maybe()
 		releases := map[string]*cluster.ReleaseMeta{
 			releaseMatch: &cluster.ReleaseMeta{
 				ReleaseName: releaseMatch,
@@ -369,7 +479,7 @@ func TestComputeReleases(t *testing.T) {
 				},
 			})
 
-			// session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+			// session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
 			// 	return true
 			// })).Return(nil)
 
@@ -388,6 +498,9 @@ func TestComputeReleases(t *testing.T) {
 						Reader:      chartReader,
 						Namespace:   "default",
 						Overrides:   []byte{},
+						SourceType:  "git",
+						Source:      &chartsync.Source{Location: "https://example.com/charts", Reference: "master"},
+						Commit:      "0123abcd",
 					},
 				},
 			}
@@ -395,7 +508,7 @@ func TestComputeReleases(t *testing.T) {
 			session.On("ChartFromArchive", mock.MatchedBy(func(r io.Reader) bool {
 				return true
 			}),
-			).Return(&cluster.Chart{}, nil)
+			).Return(&cluster.Chart{Metadata: &chart.Metadata{Name: "testChart", Version: "1.0.0"}}, nil)
 
 			transaction.On("Versions").Return(&cluster.Versions{
 				Name: "someVersionsName",
@@ -413,6 +526,14 @@ func TestComputeReleases(t *testing.T) {
 			releaseTargets, err := applyCmd.ComputeReleases(session, transaction, manifestName, archives, releases)
 			So(err, ShouldBeNil)
 			So(releaseTargets.Data[0].TransitionState, ShouldEqual, Upgradable)
+			So(releaseTargets.Data[0].Source, ShouldResemble, &cluster.ChartSource{
+				Name:         "test",
+				Type:         "git",
+				Location:     "https://example.com/charts",
+				Reference:    "master",
+				Commit:       "0123abcd",
+				ChartVersion: "1.0.0",
+			})
 			session.AssertExpectations(t)
 		})
 		Convey("Should result in Installable", func() {
@@ -452,6 +573,98 @@ func TestComputeReleases(t *testing.T) {
 			So(releaseTargets.Data[0].TransitionState, ShouldEqual, Installable)
 			session.AssertExpectations(t)
 		})
+		Convey("Should carry the Tiller namespace of the chart", func() {
+			archives := &manifest.ArchiveFiles{
+				List: []*manifest.ArchiveSpec{
+					&manifest.ArchiveSpec{
+						ReleaseName:     releaseDontMatch,
+						MetaName:        "test",
+						ChartName:       "testChart",
+						Reader:          chartReader,
+						Namespace:       "default",
+						Overrides:       []byte{},
+						TillerNamespace: "tenant-a",
+					},
+				},
+			}
+
+			session.On("ChartFromArchive", mock.Anything).Return(&cluster.Chart{}, nil)
+			transaction.On("Versions").Return(cluster.NewVersions(manifestName))
+
+			releaseTargets, err := applyCmd.ComputeReleases(session, transaction, manifestName, archives, releases)
+			So(err, ShouldBeNil)
+			So(releaseTargets.Data[0].ReleaseMeta.TillerNamespace, ShouldEqual, "tenant-a")
+			So(releaseTargets.Data[0].ReleaseVersion.TillerNamespace, ShouldEqual, "tenant-a")
+		})
+		Convey("Should compute releases without a transaction", func() {
+			archives := &manifest.ArchiveFiles{
+				List: []*manifest.ArchiveSpec{
+					&manifest.ArchiveSpec{
+						ReleaseName: releaseDontMatch,
+						MetaName:    "test",
+						ChartName:   "testChart",
+						Reader:      chartReader,
+						Namespace:   "default",
+						Overrides:   []byte{},
+					},
+				},
+			}
+
+			session.On("ChartFromArchive", mock.Anything).Return(&cluster.Chart{}, nil)
+
+			releaseTargets, err := applyCmd.ComputeReleases(session, nil, manifestName, archives, releases)
+			So(err, ShouldBeNil)
+			So(releaseTargets.Data, ShouldHaveLength, 2)
+		})
+		Convey("Should capture the prior release of each transition", func() {
+			applyCmd.Options.Force = &[]string{"replaced"}
+			prior := &chart.Chart{Metadata: &chart.Metadata{Name: "prior"}}
+			current := map[string]*cluster.ReleaseMeta{
+				"upgraded":  &cluster.ReleaseMeta{ReleaseName: "upgraded", Revision: 2, Status: cluster.Status_DEPLOYED, Chart: prior},
+				"replaced":  &cluster.ReleaseMeta{ReleaseName: "replaced", Revision: 3, Status: cluster.Status_DEPLOYED, Chart: prior},
+				"undeleted": &cluster.ReleaseMeta{ReleaseName: "undeleted", Revision: 4, Status: cluster.Status_DELETED, Chart: prior},
+				"removed":   &cluster.ReleaseMeta{ReleaseName: "removed", Namespace: "old", Revision: 5, Status: cluster.Status_DEPLOYED, Chart: prior},
+				"gone":      &cluster.ReleaseMeta{ReleaseName: "gone", Revision: 6, Status: cluster.Status_DELETED, Chart: prior},
+			}
+			archives := &manifest.ArchiveFiles{List: []*manifest.ArchiveSpec{}}
+			for _, name := range []string{"installed", "upgraded", "replaced", "undeleted"} {
+				archives.List = append(archives.List, &manifest.ArchiveSpec{
+					ReleaseName: name,
+					MetaName:    name,
+					Reader:      bytes.NewReader([]byte{}),
+					Namespace:   "default",
+				})
+			}
+			versions := cluster.NewVersions(manifestName)
+			session.On("ChartFromArchive", mock.Anything).Return(&cluster.Chart{}, nil)
+			transaction.On("Versions").Return(versions)
+
+			releaseTargets, err := applyCmd.ComputeReleases(session, transaction, manifestName, archives, current)
+			So(err, ShouldBeNil)
+			states := map[string]TransitionState{}
+			for _, rt := range releaseTargets.Data {
+				states[rt.ReleaseMeta.ReleaseName] = rt.TransitionState
+			}
+			So(states, ShouldResemble, map[string]TransitionState{
+				"installed": Installable,
+				"upgraded":  Upgradable,
+				"replaced":  Replaceable,
+				"undeleted": Undeletable,
+				"removed":   Deletable,
+			})
+			So(versions.Data, ShouldHaveLength, 5)
+			So(versions.Lookup("installed").Prior, ShouldBeNil)
+			So(versions.Lookup("upgraded").Prior, ShouldBeNil)
+			So(versions.Lookup("replaced").Prior.Chart, ShouldEqual, prior)
+			So(versions.Lookup("undeleted").Prior.Status, ShouldEqual, cluster.Status_DELETED)
+			removed := versions.Lookup("removed")
+			So(removed.Removed, ShouldBeTrue)
+			So(removed.Modified, ShouldBeFalse)
+			So(removed.PreviousRevision, ShouldEqual, 5)
+			So(removed.Namespace, ShouldEqual, "old")
+			So(removed.Prior.Chart, ShouldEqual, prior)
+			So(versions.Lookup("gone"), ShouldBeNil)
+		})
 		Reset(func() {
 			applyCmd.Options.Force = &[]string{}
 		})
@@ -471,19 +684,24 @@ func TestApply(t *testing.T) {
 					},
 					TransitionState: Replaceable,
 					ReleaseVersion:  &cluster.Version{},
+					Retry: &cluster.RetryPolicy{
+						InitialInterval: time.Millisecond,
+						MaxInterval:     time.Millisecond,
+					},
 				},
 			},
 		}
+		transient := errors.New("rpc error: code = Unavailable desc = transport is closing")
 		opt := &CmdOptions{
 			Force:        &[]string{},
 			InstallRetry: 3,
 		}
 		Convey("Should handle DeleteRelease failure with state Replaceable", func() {
-			session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(errors.New("simulated fail in DeleteRelease"))
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
@@ -491,75 +709,100 @@ func TestApply(t *testing.T) {
 		Convey("Should handle InstallRelease failure with state Installable, then fallthrough to UpgradeRelease", func() {
 			rt.Data[0].TransitionState = Installable
 
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
-			).Return(&cluster.InstallReleaseResponse{}, errors.New("simulated fail in InstallRelease")).Times(3)
+			).Return(&cluster.InstallReleaseResponse{}, errors.New("simulated fail in InstallRelease: "+transient.Error())).Times(3)
 
-			session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(nil)
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "simulated")
+			session.AssertExpectations(t)
+			session.AssertNumberOfCalls(t, "InstallRelease", 3)
+		})
+		Convey("Should not retry a permanent InstallRelease failure", func() {
+			rt.Data[0].TransitionState = Installable
+			session.On("InstallRelease", mock.Anything, mock.Anything, mock.AnythingOfType("string"),
+			).Return(&cluster.InstallReleaseResponse{}, errors.New("simulated render error")).Once()
+
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
+			session.AssertNotCalled(t, "DeleteRelease", mock.Anything, mock.Anything)
 		})
 		Convey("Should succeed in InstallRelease", func() {
 			rt.Data[0].TransitionState = Installable
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.InstallReleaseResponse{}, nil)
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
 		Convey("Should handle InstallRelease failure with state Installable, then succeed", func() {
 			rt.Data[0].TransitionState = Installable
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
-			).Return(&cluster.InstallReleaseResponse{}, errors.New("simulated fail in InstallRelease")).Once()
+			).Return(&cluster.InstallReleaseResponse{}, transient).Once()
 
-			session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(nil)
 
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.InstallReleaseResponse{}, nil).Once()
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
 		Convey("Should fail in UpgradeRelease with state Upgradable", func() {
 			rt.Data[0].TransitionState = Upgradable
 			rt.Data[0].Changed = true
-			session.On("UpgradeRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("UpgradeRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.UpgradeReleaseResponse{}, errors.New("simulated fail in UpgradeRelease")).Times(1)
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
 		})
+		Convey("Should retry a transient UpgradeRelease failure", func() {
+			rt.Data[0].TransitionState = Upgradable
+			rt.Data[0].Changed = true
+			session.On("UpgradeRelease", mock.Anything, mock.Anything, mock.AnythingOfType("string"),
+			).Return(&cluster.UpgradeReleaseResponse{}, transient).Once()
+			session.On("UpgradeRelease", mock.Anything, mock.Anything, mock.AnythingOfType("string"),
+			).Return(&cluster.UpgradeReleaseResponse{ReleaseVersion: 2}, nil).Once()
+
+			err := rt.Apply(context.Background(), opt)
+			So(err, ShouldBeNil)
+			So(rt.Data[0].ReleaseVersion.Revision, ShouldEqual, 2)
+			session.AssertExpectations(t)
+		})
 		Convey("Should succeed in UpgradeRelease with state Upgradable", func() {
 			rt.Data[0].TransitionState = Upgradable
 			rt.Data[0].Changed = true
-			session.On("UpgradeRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("UpgradeRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.UpgradeReleaseResponse{}, nil).Times(1)
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
@@ -567,14 +810,36 @@ func TestApply(t *testing.T) {
 			rt.Data[0].TransitionState = Upgradable
 			rt.Data[0].Changed = false
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
+		Convey("Should mark an undeleted release modified when the upgrade fails", func() {
+			rt.Data[0].TransitionState = Undeletable
+			session.On("RollbackRelease", mock.Anything, mock.Anything).Return(int32(3), nil).Once()
+			session.On("UpgradeRelease", mock.Anything, mock.Anything, mock.AnythingOfType("string"),
+			).Return(&cluster.UpgradeReleaseResponse{}, errors.New("simulated fail in UpgradeRelease")).Once()
+
+			err := rt.Apply(context.Background(), opt)
+			So(err, ShouldNotBeNil)
+			So(rt.Data[0].ReleaseVersion.Modified, ShouldBeTrue)
+			session.AssertExpectations(t)
+		})
+		Convey("Should mark a removed release modified once deleted", func() {
+			rt.Data[0].TransitionState = Deletable
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(dm *cluster.DeleteMeta) bool {
+				return !dm.Purge
+			})).Return(nil).Once()
+
+			err := rt.Apply(context.Background(), opt)
+			So(err, ShouldBeNil)
+			So(rt.Data[0].ReleaseVersion.Modified, ShouldBeTrue)
+			session.AssertExpectations(t)
+		})
 		Convey("Should skip unhandled state", func() {
 			rt.Data[0].TransitionState = -1
 
-			err := rt.Apply(opt)
+			err := rt.Apply(context.Background(), opt)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
@@ -602,11 +867,11 @@ func TestDiff(t *testing.T) {
 				},
 			}
 
-			session.On("DiffRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("DiffRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}),
 			).Return(true, []byte{}, errors.New("simulated fail in DiffRelease"))
-			_, err := rt.Diff(session)
+			_, err := rt.Diff(context.Background(), session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
@@ -626,11 +891,11 @@ func TestDiff(t *testing.T) {
 				},
 			}
 
-			session.On("DiffRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("DiffRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}),
 			).Return(true, []byte{}, nil)
-			_, err := rt.Diff(session)
+			_, err := rt.Diff(context.Background(), session)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
@@ -651,20 +916,20 @@ func TestDryRun(t *testing.T) {
 			},
 		}
 		Convey("Should handle Upgradable, no error", func() {
-			session.On("UpgradeRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("UpgradeRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.UpgradeReleaseResponse{}, nil)
-			err := rt.dryRun(session)
+			err := rt.dryRun(context.Background(), session)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
 		Convey("Should handle Upgradable, with error", func() {
-			session.On("UpgradeRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("UpgradeRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.UpgradeReleaseResponse{}, errors.New("simulated error"))
-			err := rt.dryRun(session)
+			err := rt.dryRun(context.Background(), session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
@@ -672,20 +937,20 @@ func TestDryRun(t *testing.T) {
 
 		rt.Data[0].TransitionState = Installable
 		Convey("Should handle Installable, no error", func() {
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.InstallReleaseResponse{}, nil)
-			err := rt.dryRun(session)
+			err := rt.dryRun(context.Background(), session)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
 		})
 		Convey("Should handle Installable, with error", func() {
-			session.On("InstallRelease", mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.ReleaseMeta) bool {
 				return true
 			}), mock.AnythingOfType("string"),
 			).Return(&cluster.InstallReleaseResponse{}, errors.New("simulated error"))
-			err := rt.dryRun(session)
+			err := rt.dryRun(context.Background(), session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
@@ -693,3 +958,53 @@ func TestDryRun(t *testing.T) {
 	})
 
 }
+
+func TestRepairDrift(t *testing.T) {
+	Convey("RepairDrift", t, func() {
+		session := &mocks.Sessioner{}
+		rt := &ReleaseTargets{
+			ManifestName: "test",
+			Data: []*ReleaseTarget{
+				&ReleaseTarget{
+					TransitionState: Upgradable,
+					ReleaseMeta:     &cluster.ReleaseMeta{ReleaseName: "drifted"},
+				},
+				&ReleaseTarget{
+					TransitionState: Upgradable,
+					ReleaseMeta:     &cluster.ReleaseMeta{ReleaseName: "clean"},
+				},
+				&ReleaseTarget{
+					TransitionState: Installable,
+					ReleaseMeta:     &cluster.ReleaseMeta{ReleaseName: "new"},
+				},
+			},
+		}
+		Convey("Marks only drifted releases for repair", func() {
+			session.On("DetectDrift", mock.Anything, mock.MatchedBy(func(rm *cluster.ReleaseMeta) bool {
+				return rm.ReleaseName == "drifted"
+			})).Return(&cluster.ReleaseDrift{
+				ReleaseName: "drifted",
+				Objects: []*cluster.ObjectDrift{
+					&cluster.ObjectDrift{Name: "default, app, ConfigMap (v1)", State: cluster.DriftMissing},
+				},
+			}, nil)
+			session.On("DetectDrift", mock.Anything, mock.MatchedBy(func(rm *cluster.ReleaseMeta) bool {
+				return rm.ReleaseName == "clean"
+			})).Return(&cluster.ReleaseDrift{ReleaseName: "clean"}, nil)
+			err := rt.RepairDrift(context.Background(), session)
+			So(err, ShouldBeNil)
+			So(rt.Data[0].Repair, ShouldBeTrue)
+			So(rt.Data[0].Changed, ShouldBeFalse)
+			So(string(rt.Data[0].Diff), ShouldContainSubstring, "missing")
+			So(rt.Data[1].Repair, ShouldBeFalse)
+			So(rt.Data[2].Repair, ShouldBeFalse)
+			session.AssertExpectations(t)
+		})
+		Convey("Returns detection errors", func() {
+			session.On("DetectDrift", mock.Anything, mock.Anything).Return(nil, errors.New("simulated"))
+			err := rt.RepairDrift(context.Background(), session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "simulated")
+		})
+	})
+}
@@ -8,12 +8,14 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	helm_env "k8s.io/helm/pkg/helm/environment"
 
+	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
 	"github.com/cirrocloud/structured/errors"
 )
@@ -30,6 +32,18 @@ var (
 
 type Config struct {
 	Account chartsync.AccountTable
+	Diff    *cluster.DiffOptions
+	Tiller  *TillerConfig
+	// HistoryMax limits the manifest versions kept in the cluster, zero keeps them all
+	HistoryMax int
+}
+
+// TillerConfig locates and connects to Tiller, empty fields use the cluster defaults
+type TillerConfig struct {
+	Host              string
+	Namespace         string
+	Selector          string
+	ConnectionTimeout time.Duration
 }
 
 type BarrelmanConfig struct {
@@ -64,7 +78,13 @@ func GetConfigFromFile(s string) (*Config, error) {
 		return nil, err
 	}
 
-	return config.LoadAcc(b)
+	if _, err := config.LoadAcc(b); err != nil {
+		return nil, err
+	}
+	if _, err := config.LoadDiff(b).LoadTiller(b); err != nil {
+		return nil, err
+	}
+	return config.LoadHistory(b), nil
 }
 
 //LoadAcc populates *config.Account from *BarrelmanConfig
@@ -118,6 +138,57 @@ func (config *Config) LoadAcc(b *BarrelmanConfig) (*Config, error) {
 	return config, nil
 }
 
+//LoadDiff populates *config.Diff from *BarrelmanConfig
+// This block supports the YAML format :
+//	diff:
+//	  suppressed_kinds: [ ConfigMap ]
+//	  redacted_kinds: [ Secret ]
+//	  redacted_paths: [ spec.password ]
+//	  redacted_keys: [ "(?i)apikey" ]
+func (config *Config) LoadDiff(b *BarrelmanConfig) *Config {
+	config.Diff = &cluster.DiffOptions{
+		SuppressedKinds: b.Viper.GetStringSlice("diff.suppressed_kinds"),
+		RedactedKinds:   b.Viper.GetStringSlice("diff.redacted_kinds"),
+		RedactedPaths:   b.Viper.GetStringSlice("diff.redacted_paths"),
+		RedactedKeys:    b.Viper.GetStringSlice("diff.redacted_keys"),
+	}
+	return config
+}
+
+//LoadTiller populates *config.Tiller from *BarrelmanConfig
+// This block supports the YAML format :
+//	tiller:
+//	  host: tiller-deploy.kube-system:44134
+//	  namespace: tiller
+//	  selector: app=helm,name=tiller
+//	  connection_timeout: 10s
+func (config *Config) LoadTiller(b *BarrelmanConfig) (*Config, error) {
+	config.Tiller = &TillerConfig{
+		Host:      b.Viper.GetString("tiller.host"),
+		Namespace: b.Viper.GetString("tiller.namespace"),
+		Selector:  b.Viper.GetString("tiller.selector"),
+	}
+	if timeout := b.Viper.GetString("tiller.connection_timeout"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"File":              b.FilePath,
+				"ConnectionTimeout": timeout,
+			}).Wrap(err, "failed to parse tiller.connection_timeout in config")
+		}
+		config.Tiller.ConnectionTimeout = d
+	}
+	return config, nil
+}
+
+//LoadHistory populates *config.HistoryMax from *BarrelmanConfig
+// This block supports the YAML format :
+//	history_max: 20
+func (config *Config) LoadHistory(b *BarrelmanConfig) *Config {
+	config.HistoryMax = b.Viper.GetInt("history_max")
+	return config
+}
+
 func toBarrelmanConfig(s string, r io.Reader) (*BarrelmanConfig, error) {
 	barrelConfig := &BarrelmanConfig{FilePath: s}
 
@@ -6,9 +6,11 @@ import (
 	"path"
 	"runtime"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
+	"github.com/charter-oss/barrelman/pkg/manifest"
 	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
 )
 
@@ -19,6 +21,49 @@ func TestConfig(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(c, ShouldNotBeNil)
 			So(c.Account, ShouldContainKey, "github.com")
+			So(c.Diff.SuppressedKinds, ShouldContain, "ConfigMap")
+			So(c.Diff.RedactedKeys, ShouldContain, "(?i)apikey")
+			So(c.Tiller.Namespace, ShouldEqual, "tiller")
+			So(c.Tiller.Selector, ShouldEqual, "app=tiller")
+			So(c.Tiller.ConnectionTimeout, ShouldEqual, 10*time.Second)
+		})
+		Convey("Can fail to parse the Tiller connection timeout", func() {
+			r := bytes.NewBufferString("tiller:\n  connection_timeout: soon\n")
+			bc, err := toBarrelmanConfig("/pretend/path", r)
+			So(err, ShouldBeNil)
+			_, err = GetEmptyConfig().LoadTiller(bc)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "connection_timeout")
+		})
+		Convey("Tiller options take precedence over the config", func() {
+			config := &Config{Tiller: &TillerConfig{
+				Namespace:         "tiller",
+				Selector:          "app=tiller",
+				ConnectionTimeout: 10 * time.Second,
+			}}
+			opt := &CmdOptions{TillerNamespace: "other", TillerHost: "tiller:44134"}
+			tiller := opt.Tiller(config)
+			So(tiller.Host, ShouldEqual, "tiller:44134")
+			So(tiller.Namespace, ShouldEqual, "other")
+			So(tiller.Selector, ShouldEqual, "app=tiller")
+			So(tiller.ConnectionTimeout, ShouldEqual, 10*time.Second)
+			So(config.Tiller.Namespace, ShouldEqual, "tiller")
+			So(opt.Tiller(nil).Namespace, ShouldEqual, "other")
+		})
+		Convey("History limit is read from the config", func() {
+			r := bytes.NewBufferString("history_max: 20\n")
+			bc, err := toBarrelmanConfig("/pretend/path", r)
+			So(err, ShouldBeNil)
+			config := GetEmptyConfig().LoadHistory(bc)
+			So(config.HistoryMax, ShouldEqual, 20)
+		})
+		Convey("History limit options take precedence over the manifest and config", func() {
+			config := &Config{HistoryMax: 20}
+			mfest := &manifest.Manifest{Data: &manifest.ManifestData{HistoryMax: 10}}
+			So((&CmdOptions{HistoryMax: 5}).HistoryLimit(config, mfest), ShouldEqual, 5)
+			So((&CmdOptions{}).HistoryLimit(config, mfest), ShouldEqual, 10)
+			So((&CmdOptions{}).HistoryLimit(config, nil), ShouldEqual, 20)
+			So((&CmdOptions{}).HistoryLimit(nil, nil), ShouldEqual, 0)
 		})
 		Convey("Can fail from file", func() {
 			_, err := GetConfigFromFile(getTestDataDir() + "/unit-test-manifest.yaml")
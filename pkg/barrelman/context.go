@@ -0,0 +1,35 @@
+package barrelman
+
+import (
+	"context"
+	"time"
+
+	"github.com/cirrocloud/structured/errors"
+)
+
+// Context returns the context of a whole operation, bounded by the Timeout option when set
+func (opt *CmdOptions) Context() (context.Context, context.CancelFunc) {
+	if opt == nil || opt.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), opt.Timeout)
+}
+
+// phaseContext bounds one phase of an operation by timeout, a zero timeout leaves ctx unchanged
+// the phase never outlives the operation deadline of ctx
+func phaseContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError names the phase when err was caused by the expiry of ctx
+func timeoutError(ctx context.Context, phase string, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	return errors.WithFields(errors.Fields{
+		"Phase": phase,
+	}).Wrap(err, "operation timed out")
+}
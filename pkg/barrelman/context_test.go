@@ -0,0 +1,83 @@
+package barrelman
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+)
+
+func TestOperationContext(t *testing.T) {
+	Convey("Options context", t, func() {
+		Convey("Has no deadline without a timeout", func() {
+			ctx, cancel := (&CmdOptions{}).Context()
+			defer cancel()
+			_, ok := ctx.Deadline()
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Is bounded by the timeout", func() {
+			ctx, cancel := (&CmdOptions{Timeout: time.Minute}).Context()
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			So(ok, ShouldBeTrue)
+			So(deadline, ShouldHappenWithin, time.Minute, time.Now())
+		})
+	})
+
+	Convey("phaseContext", t, func() {
+		parent, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		parentDeadline, _ := parent.Deadline()
+
+		Convey("Keeps the operation deadline without a phase timeout", func() {
+			ctx, cancelPhase := phaseContext(parent, 0)
+			defer cancelPhase()
+			deadline, _ := ctx.Deadline()
+			So(deadline, ShouldEqual, parentDeadline)
+		})
+		Convey("Never outlives the operation deadline", func() {
+			ctx, cancelPhase := phaseContext(parent, time.Hour)
+			defer cancelPhase()
+			deadline, _ := ctx.Deadline()
+			So(deadline, ShouldEqual, parentDeadline)
+		})
+	})
+
+	Convey("timeoutError", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		So(timeoutError(ctx, "plan", nil), ShouldBeNil)
+		<-ctx.Done()
+		err := timeoutError(ctx, "plan", errors.New("simulated"))
+		So(err.Error(), ShouldContainSubstring, "operation timed out")
+
+		canceled, cancelNow := context.WithCancel(context.Background())
+		cancelNow()
+		So(timeoutError(canceled, "plan", errors.New("simulated")).Error(), ShouldEqual, "simulated")
+	})
+
+	Convey("No release is changed after the deadline", t, func() {
+		session := &mocks.Sessioner{}
+		rt := &ReleaseTargets{
+			session: session,
+			Data: []*ReleaseTarget{
+				&ReleaseTarget{
+					ReleaseMeta:     &cluster.ReleaseMeta{ReleaseName: "storage-minio"},
+					TransitionState: Installable,
+					ReleaseVersion:  &cluster.Version{},
+				},
+			},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		err := rt.Apply(ctx, &CmdOptions{InstallRetry: 1})
+		So(err, ShouldResemble, context.DeadlineExceeded)
+		session.AssertExpectations(t)
+	})
+}
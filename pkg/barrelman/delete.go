@@ -1,6 +1,9 @@
 package barrelman
 
 import (
+	"context"
+	"sort"
+
 	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/manifest"
 	"github.com/charter-oss/barrelman/pkg/version"
@@ -33,6 +36,9 @@ func (cmd *DeleteCmd) Run(session cluster.Sessioner) error {
 		return errors.Wrap(err, "failed to create new cluster session")
 	}
 
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
 	// Open and initialize the manifest
 	mfest, err := manifest.New(&manifest.Config{
 		DataDir:      cmd.Options.DataDir,
@@ -43,56 +49,223 @@ func (cmd *DeleteCmd) Run(session cluster.Sessioner) error {
 		return errors.Wrap(err, "error while initializing manifest")
 	}
 
+	if err := guardCluster(session, mfest.Data.Cluster, cmd.Options.IgnoreClusterGuard); err != nil {
+		return err
+	}
+
 	if !cmd.Options.NoSync {
-		if err := mfest.Sync(); err != nil {
-			return errors.Wrap(err, "error while downloading charts")
+		if err := mfest.Sync(ctx); err != nil {
+			return errors.Wrap(timeoutError(ctx, "sync", err), "error while downloading charts")
 		}
 	}
 
-	if err := DeleteByManifest(mfest, session); err != nil {
-		return errors.Wrap(err, "failed to delete by manifest")
+	ctx, unlock, err := lockManifest(ctx, session, mfest.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	opt := *cmd.Options
+	opt.HistoryMax = cmd.Options.HistoryLimit(cmd.Config, mfest)
+	if err := DeleteByManifest(ctx, mfest, session, &opt); err != nil {
+		return errors.Wrap(timeoutError(ctx, "delete", err), "failed to delete by manifest")
 	}
 	return nil
 }
 
-func DeleteByManifest(bm *manifest.Manifest, session cluster.Sessioner) error {
-	deleteList := make(map[string]*cluster.DeleteMeta)
-	groups, err := bm.GetChartGroups()
+// DeleteByManifest deletes the releases owned by the manifest, releases of the same name owned by
+// another manifest are left alone. Releases are deleted in reverse chart group order, those no longer
+// part of the manifest first. The releases are deleted within a transaction, a failure rolls the
+// releases deleted so far back to their last revision. Once every release is deleted a teardown
+// version of the manifest is recorded, releases are only purged after that. The versions kept are
+// limited by opt.HistoryMax alone.
+func DeleteByManifest(ctx context.Context, bm *manifest.Manifest, session cluster.Sessioner, opt *CmdOptions) error {
+	plan, err := deletePlan(ctx, bm, session)
 	if err != nil {
-		return errors.Wrap(err, "error resolving chart groups")
+		return err
 	}
 
-	releases, err := session.ListReleases()
+	if opt.DryRun {
+		for _, rel := range plan {
+			if rel.Status == cluster.Status_DELETED && !opt.Purge {
+				continue
+			}
+			log.WithFields(log.Fields{
+				"Release":   rel.ReleaseName,
+				"Namespace": rel.Namespace,
+				"Purge":     opt.Purge,
+			}).Info("Release would be deleted")
+		}
+		return nil
+	}
+
+	transaction, err := session.NewTransaction(bm.Name)
 	if err != nil {
-		return errors.Wrap(err, "failed to list releases")
+		return errors.Wrap(err, "failed to create new transaction durring delete")
 	}
 
-	for _, v := range releases {
-		deleteList[v.ReleaseName] = &cluster.DeleteMeta{
-			ReleaseName: v.ReleaseName,
-			Namespace:   "",
+	in := watchInterrupt(bm.Name)
+	defer in.Close()
+	if err := deleteReleases(ctx, session, transaction, plan, opt, in); err != nil {
+		if lockLost(ctx) {
+			return lockLostError(bm.Name, err)
+		}
+		if innerErr := transaction.Cancel(); innerErr != nil {
+			err = errors.WithFields(errors.Fields{
+				"TransactionError": innerErr.Error(),
+			}).Wrap(err, "transaction error while Canceling")
+		} else {
+			log.WithFields(log.Fields{
+				"ManifestName": bm.Name,
+			}).Warn("Transaction canceled, deleted releases were restored")
 		}
+		return errors.Wrap(err, "Manifest delete failed")
 	}
 
-	for _, cg := range groups {
-		charts, err := bm.GetChartsByChartName(cg.Data.ChartGroup)
+	transaction.Versions().Deleted = true
+	transaction.Versions().Source = newVersionSource()
+	transaction.Versions().HistoryMax = opt.HistoryMax
+	transaction.SetChanged()
+	if err := transaction.Complete(); err != nil {
+		return errors.Wrap(err, "failed to record the deleted manifest version")
+	}
+
+	if opt.Purge {
+		return purgeReleases(ctx, session, plan)
+	}
+	return nil
+}
+
+// deleteReleases deletes the releases of plan that are not deleted yet without purging them
+// each release is recorded in the transaction before it is deleted so a cancel rolls it back to its last revision
+func deleteReleases(
+	ctx context.Context,
+	session cluster.Sessioner,
+	transaction cluster.Transactioner,
+	plan []*cluster.ReleaseMeta,
+	opt *CmdOptions,
+	in *interrupt) error {
+	if err := transaction.Checkpoint(); err != nil {
+		return errors.Wrap(err, "failed to record transaction journal")
+	}
+	for _, rel := range plan {
+		if rel.Status == cluster.Status_DELETED {
+			continue
+		}
+		if err := in.Err(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// The release is marked before it is deleted, a delete that fails while waiting has still removed it
+		version := &cluster.Version{
+			Name:             rel.ReleaseName,
+			Namespace:        rel.Namespace,
+			Revision:         rel.Revision,
+			PreviousRevision: rel.Revision,
+			TillerNamespace:  rel.TillerNamespace,
+			Prior:            rel.Prior(),
+			Removed:          true,
+		}
+		version.SetModified()
+		transaction.Versions().AddReleaseVersion(version)
+		if err := transaction.Checkpoint(); err != nil {
+			return errors.Wrap(err, "failed to record transaction journal")
+		}
+
+		log.WithFields(log.Fields{
+			"Release":   rel.ReleaseName,
+			"Namespace": rel.Namespace,
+			"Revision":  rel.Revision,
+		}).Info("deleting release")
+		if err := session.DeleteRelease(ctx, &cluster.DeleteMeta{
+			ReleaseName:     rel.ReleaseName,
+			Namespace:       rel.Namespace,
+			TillerNamespace: rel.TillerNamespace,
+			Wait:            opt.Wait,
+		}); err != nil {
+			return errors.WithFields(errors.Fields{
+				"Release": rel.ReleaseName,
+			}).Wrap(err, "error deleting release")
+		}
+	}
+	return nil
+}
+
+// purgeReleases removes the history of every release of plan, it cannot be undone
+func purgeReleases(ctx context.Context, session cluster.Sessioner, plan []*cluster.ReleaseMeta) error {
+	for _, rel := range plan {
+		log.WithFields(log.Fields{
+			"Release":   rel.ReleaseName,
+			"Namespace": rel.Namespace,
+		}).Info("purging release")
+		if err := session.DeleteRelease(ctx, &cluster.DeleteMeta{
+			ReleaseName:     rel.ReleaseName,
+			Namespace:       rel.Namespace,
+			TillerNamespace: rel.TillerNamespace,
+			Purge:           true,
+		}); err != nil {
+			return errors.WithFields(errors.Fields{
+				"Release": rel.ReleaseName,
+			}).Wrap(err, "error purging release")
+		}
+	}
+	return nil
+}
+
+// deletePlan returns the releases owned by the manifest in the order they are deleted
+// already deleted releases are included, they are only purged
+func deletePlan(ctx context.Context, bm *manifest.Manifest, session cluster.Sessioner) ([]*cluster.ReleaseMeta, error) {
+	groups, err := bm.GetChartGroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "error resolving chart groups")
+	}
+
+	tillerNamespaces, err := bm.TillerNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	if err := useTillers(session, tillerNamespaces); err != nil {
+		return nil, err
+	}
+
+	owned, err := session.ReleasesByManifest(ctx, bm.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases")
+	}
+
+	ordered := []*cluster.ReleaseMeta{}
+	inManifest := make(map[string]bool)
+	for i := len(groups) - 1; i >= 0; i-- {
+		charts, err := bm.GetChartsByChartName(groups[i].Data.ChartGroup)
 		if err != nil {
-			return errors.Wrap(err, "error resolving charts")
+			return nil, errors.Wrap(err, "error resolving charts")
 		}
-		for _, v := range charts {
-			for _, rel := range deleteList {
-				if rel.ReleaseName == v.Data.ReleaseName {
-					//if dm, exists := deleteList[v.Data.ReleaseName]; exists {
-					log.WithFields(log.Fields{
-						"Name":    v.Metadata.Name,
-						"Release": rel.ReleaseName,
-					}).Info("deleting release")
-					if err := session.DeleteRelease(rel); err != nil {
-						return errors.Wrap(err, "error deleting list")
-					}
-				}
+		for j := len(charts) - 1; j >= 0; j-- {
+			releaseName := charts[j].Data.ReleaseName
+			inManifest[releaseName] = true
+			rel := cluster.FindRelease(owned, charts[j].Data.Namespace, releaseName)
+			if rel == nil {
+				log.WithFields(log.Fields{
+					"Name":    charts[j].Metadata.Name,
+					"Release": releaseName,
+				}).Debug("release not owned by manifest, skipping")
+				continue
 			}
+			ordered = append(ordered, rel)
 		}
 	}
-	return nil
+
+	orphans := []*cluster.ReleaseMeta{}
+	for _, rel := range owned {
+		if !inManifest[rel.ReleaseName] {
+			orphans = append(orphans, rel)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].ReleaseName < orphans[j].ReleaseName
+	})
+	return append(orphans, ordered...), nil
 }
@@ -1,6 +1,7 @@
 package barrelman
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -9,6 +10,7 @@ import (
 
 	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+	"github.com/charter-oss/barrelman/pkg/manifest"
 	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
 	"github.com/cirrocloud/structured/errors"
 )
@@ -47,6 +49,7 @@ func TestDeleteRun(t *testing.T) {
 		Convey("Should error on manifest file not found", func() {
 			delCmd.Options.ManifestFile = "testdata/nofile"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(delCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(delCmd.Options.KubeContext).Maybe()
 			err := delCmd.Run(session)
@@ -58,6 +61,7 @@ func TestDeleteRun(t *testing.T) {
 		Convey("Should successfuly error during sync", func() {
 			delCmd.Options.ManifestFile = "testdata/repo-not-exist.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(delCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(delCmd.Options.KubeContext).Maybe()
 			err := delCmd.Run(session)
@@ -69,9 +73,10 @@ func TestDeleteRun(t *testing.T) {
 		Convey("Should successfuly handle error in ListReleases()", func() {
 			delCmd.Options.ManifestFile = "testdata/file-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(delCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(delCmd.Options.KubeContext).Maybe()
-			session.On("ListReleases").Return([]*cluster.Release{}, errors.New("simulated"))
+			session.On("ReleasesByManifest", mock.Anything, "scratch-manifest").Return(nil, errors.New("simulated"))
 			err := delCmd.Run(session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
@@ -80,34 +85,49 @@ func TestDeleteRun(t *testing.T) {
 
 		Convey("Should successfuly handle error in DeleteByManifest()", func() {
 			delCmd.Options.ManifestFile = "testdata/file-test-manifest.yaml"
+			transaction, versions := newTestDeleteTransaction()
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(delCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(delCmd.Options.KubeContext).Maybe()
-			session.On("ListReleases").Return([]*cluster.Release{&cluster.Release{
-				ReleaseName: "storage-minio",
-			}}, nil)
-			session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+			session.On("ReleasesByManifest", mock.Anything, "scratch-manifest").Return(map[string]*cluster.ReleaseMeta{
+				"storage-minio": &cluster.ReleaseMeta{ReleaseName: "storage-minio", Revision: 4},
+			}, nil)
+			session.On("NewTransaction", "scratch-manifest").Return(transaction, nil)
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
 				return true
 			})).Return(errors.New("simulated"))
+			transaction.On("Cancel").Return(nil).Once()
 			err := delCmd.Run(session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
+			So(versions.Data, ShouldHaveLength, 1)
+			So(versions.Data[0].Modified, ShouldBeTrue)
+			So(versions.Data[0].PreviousRevision, ShouldEqual, 4)
 			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
 		})
 		Convey("Should complete without error", func() {
 			delCmd.Options.ManifestFile = "testdata/file-test-manifest.yaml"
 			session.On("Init").Return(nil)
+			session.On("Lock", mock.AnythingOfType("string")).Return(newTestUnlocker(), nil).Maybe()
 			session.On("GetKubeConfig").Return(delCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(delCmd.Options.KubeContext).Maybe()
-			session.On("ListReleases").Return([]*cluster.Release{&cluster.Release{
-				ReleaseName: "storage-minio",
-			}}, nil)
-			session.On("DeleteRelease", mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
-				return true
+			transaction, versions := newTestDeleteTransaction()
+			session.On("ReleasesByManifest", mock.Anything, "scratch-manifest").Return(map[string]*cluster.ReleaseMeta{
+				"storage-minio": &cluster.ReleaseMeta{ReleaseName: "storage-minio", Revision: 4},
+			}, nil)
+			session.On("NewTransaction", "scratch-manifest").Return(transaction, nil)
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(crm *cluster.DeleteMeta) bool {
+				return !crm.Purge
 			})).Return(nil)
+			transaction.On("SetChanged").Return().Once()
+			transaction.On("Complete").Return(nil).Once()
 			err := delCmd.Run(session)
 			So(err, ShouldBeNil)
+			So(versions.Deleted, ShouldBeTrue)
 			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
 		})
 
 		Reset(func() {
@@ -118,3 +138,87 @@ func TestDeleteRun(t *testing.T) {
 		})
 	})
 }
+
+func TestDeletePlan(t *testing.T) {
+	Convey("deletePlan", t, func() {
+		mfest, err := manifest.New(&manifest.Config{
+			DataDir:      "testdata/datadir",
+			ManifestFile: "testdata/file-test-manifest.yaml",
+			AccountTable: make(chartsync.AccountTable),
+		})
+		So(err, ShouldBeNil)
+		session := &mocks.Sessioner{}
+		session.On("ReleasesByManifest", mock.Anything, "scratch-manifest").Return(map[string]*cluster.ReleaseMeta{
+			"storage-minio":     &cluster.ReleaseMeta{ReleaseName: "storage-minio", Namespace: "scratch"},
+			"kubernetes-common": &cluster.ReleaseMeta{ReleaseName: "kubernetes-common", Status: cluster.Status_DELETED},
+			"old-cache":         &cluster.ReleaseMeta{ReleaseName: "old-cache", Revision: 2},
+		}, nil)
+
+		Convey("Deletes owned releases, those no longer in the manifest first", func() {
+			plan, err := deletePlan(context.Background(), mfest, session)
+			So(err, ShouldBeNil)
+			So(plan, ShouldHaveLength, 3)
+			So(plan[0].ReleaseName, ShouldEqual, "kubernetes-common")
+			So(plan[1].ReleaseName, ShouldEqual, "old-cache")
+			So(plan[2].ReleaseName, ShouldEqual, "storage-minio")
+			So(plan[2].Namespace, ShouldEqual, "scratch")
+		})
+		Convey("Changes nothing on a dry run", func() {
+			err := DeleteByManifest(context.Background(), mfest, session, &CmdOptions{DryRun: true})
+			So(err, ShouldBeNil)
+			session.AssertNotCalled(t, "DeleteRelease", mock.Anything, mock.Anything)
+			session.AssertNotCalled(t, "WriteVersions", mock.Anything)
+		})
+		Convey("Deletes with wait and purges once the teardown is recorded", func() {
+			transaction, versions := newTestDeleteTransaction()
+			session.On("NewTransaction", "scratch-manifest").Return(transaction, nil)
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(dm *cluster.DeleteMeta) bool {
+				return !dm.Purge && dm.Wait
+			})).Return(nil).Times(2)
+			transaction.On("SetChanged").Return()
+			transaction.On("Complete").Return(nil).Once()
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(dm *cluster.DeleteMeta) bool {
+				return dm.Purge
+			})).Return(nil).Times(3)
+			err := DeleteByManifest(context.Background(), mfest, session, &CmdOptions{Purge: true, Wait: true})
+			So(err, ShouldBeNil)
+			So(versions.Data, ShouldHaveLength, 2)
+			So(versions.Deleted, ShouldBeTrue)
+			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
+		})
+		Convey("Cancels and purges nothing when a delete fails", func() {
+			transaction, versions := newTestDeleteTransaction()
+			session.On("NewTransaction", "scratch-manifest").Return(transaction, nil)
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(dm *cluster.DeleteMeta) bool {
+				return dm.ReleaseName == "old-cache"
+			})).Return(nil).Once()
+			session.On("DeleteRelease", mock.Anything, mock.MatchedBy(func(dm *cluster.DeleteMeta) bool {
+				return dm.ReleaseName == "storage-minio"
+			})).Return(errors.New("simulated delete failure")).Once()
+			transaction.On("Cancel").Return(nil).Once()
+			err := DeleteByManifest(context.Background(), mfest, session, &CmdOptions{Purge: true})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "simulated delete failure")
+			So(versions.Data, ShouldHaveLength, 2)
+			So(versions.Data[0].Name, ShouldEqual, "old-cache")
+			So(versions.Data[0].PreviousRevision, ShouldEqual, 2)
+			transaction.AssertNotCalled(t, "Complete")
+			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
+		})
+		Reset(func() {
+			chartsync.Reset()
+			os.RemoveAll("testdata/datadir")
+		})
+	})
+}
+
+// newTestDeleteTransaction returns a transaction recording the deleted releases in versions
+func newTestDeleteTransaction() (*mocks.Transactioner, *cluster.Versions) {
+	versions := cluster.NewVersions("scratch-manifest")
+	transaction := &mocks.Transactioner{}
+	transaction.On("Checkpoint").Return(nil).Maybe()
+	transaction.On("Versions").Return(versions).Maybe()
+	return transaction, versions
+}
@@ -0,0 +1,130 @@
+package barrelman
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/manifest"
+	"github.com/charter-oss/barrelman/pkg/version"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+type DriftCmd struct {
+	Options    *CmdOptions
+	Config     *Config
+	LogOptions *[]string
+}
+
+// Run compares each release of a manifest with the objects running in the cluster
+func (cmd *DriftCmd) Run(session cluster.Sessioner) error {
+	var err error
+
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	if err := ensureWorkDir(cmd.Options.DataDir); err != nil {
+		return errors.Wrap(err, "failed to create working directory")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	archives, mfest, err := processManifest(ctx, &manifest.Config{
+		DataDir:      cmd.Options.DataDir,
+		ManifestFile: cmd.Options.ManifestFile,
+		AccountTable: cmd.Config.Account,
+	}, cmd.Options.NoSync)
+	if err != nil {
+		return errors.Wrap(timeoutError(ctx, "sync", err), "drift failed")
+	}
+	if err := guardCluster(session, mfest.Data.Cluster, cmd.Options.IgnoreClusterGuard); err != nil {
+		return err
+	}
+	manifestName := mfest.Name
+
+	if err := useTillers(session, archives.TillerNamespaces()); err != nil {
+		return err
+	}
+	releases, err := session.ReleasesByManifest(ctx, manifestName)
+	if err != nil {
+		return errors.Wrap(err, "failed to get current releases")
+	}
+
+	applyCmd := &ApplyCmd{Options: cmd.Options, Config: cmd.Config}
+	rt, err := applyCmd.ComputeReleases(session, nil, manifestName, archives, releases)
+	if err != nil {
+		return err
+	}
+	rt.DiffOptions = diffOptions(cmd.Config, mfest)
+
+	if _, err := rt.DetectDrift(ctx, session); err != nil {
+		return timeoutError(ctx, "drift", err)
+	}
+	rt.LogDrift()
+	return nil
+}
+
+// DetectDrift compares each upgradable release with the live cluster objects
+func (rt *ReleaseTargets) DetectDrift(ctx context.Context, session cluster.Sessioner) (*ReleaseTargets, error) {
+	for _, v := range rt.Data {
+		if v.TransitionState != Upgradable {
+			continue
+		}
+		v.ReleaseMeta.DiffOptions = rt.DiffOptions
+		drift, err := session.DetectDrift(ctx, v.ReleaseMeta)
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"Name": v.ReleaseMeta.ReleaseName,
+			}).Wrap(err, "failed to detect drift")
+		}
+		v.Drift = drift
+	}
+	return rt, nil
+}
+
+// Drifted reports whether the release has objects which differ from the desired state
+func (rt *ReleaseTarget) Drifted() bool {
+	return rt.Drift != nil && rt.Drift.Drifted()
+}
+
+func (rt *ReleaseTargets) LogDrift() {
+	for _, v := range rt.Data {
+		switch v.TransitionState {
+		case Installable:
+			log.WithFields(log.Fields{
+				"Name":      v.ReleaseMeta.ReleaseName,
+				"Namespace": v.ReleaseMeta.Namespace,
+			}).Info("Not installed")
+		case Upgradable:
+			if !v.Drifted() {
+				log.WithFields(log.Fields{
+					"Name": v.ReleaseMeta.ReleaseName,
+				}).Info("No drift")
+				continue
+			}
+			for _, od := range v.Drift.Objects {
+				log.WithFields(log.Fields{
+					"Name":      v.ReleaseMeta.ReleaseName,
+					"Object":    od.Name,
+					"Kind":      od.Kind,
+					"Namespace": od.Namespace,
+				}).Info(od.State.String())
+				if len(od.Diff) > 0 {
+					//Print the byte content and keep formatting, its fancy
+					fmt.Printf("----%v\n%v_____\n", od.Name, string(od.Diff))
+				}
+			}
+		}
+	}
+}
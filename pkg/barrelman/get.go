@@ -0,0 +1,110 @@
+package barrelman
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/manifest"
+	"github.com/charter-oss/barrelman/pkg/version"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/timeconv"
+)
+
+// GetManifestCmd prints the manifest recorded with a manifest version
+type GetManifestCmd struct {
+	Options         *CmdOptions
+	Config          *Config
+	ManifestName    string
+	ManifestVersion int32
+	LogOptions      *[]string
+}
+
+func (cmd *GetManifestCmd) Run(session cluster.Sessioner) error {
+	var err error
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	versions, err := session.GetVersions(cmd.ManifestName)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get versions")
+	}
+	target := versions.Latest()
+	if cmd.ManifestVersion != 0 {
+		target = versions.Table().Data[cmd.ManifestVersion]
+	}
+	if target == nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestVersion": cmd.ManifestVersion,
+			"ManifestName":    cmd.ManifestName,
+		}).New("Failed to get manifest, No such version")
+	}
+	return WriteManifest(os.Stdout, target)
+}
+
+// WriteManifest writes the manifest recorded with version, preceded by comments describing its source
+func WriteManifest(w io.Writer, v *cluster.Version) error {
+	source, err := v.Source()
+	if err != nil {
+		return err
+	}
+	if source == nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestName":    v.Name,
+			"ManifestVersion": v.Revision,
+		}).New("the version holds no manifest, it was written before manifests were recorded")
+	}
+	fmt.Fprintf(w, "# Manifest: %s\n", v.Name)
+	fmt.Fprintf(w, "# Version: %d\n", v.Revision)
+	if v.Info != nil && v.Info.LastDeployed != nil {
+		fmt.Fprintf(w, "# Deployed: %s\n", timeconv.String(v.Info.LastDeployed))
+	}
+	fmt.Fprintf(w, "# Barrelman: %s %s\n", source.BarrelmanVersion, source.BarrelmanCommit)
+	fmt.Fprintf(w, "# User: %s\n", source.User)
+	fmt.Fprintf(w, "# Host: %s\n", source.Host)
+	for _, cs := range source.Charts {
+		fmt.Fprintf(w, "# Chart %s: %s\n", cs.Name, cs.String())
+	}
+	if len(source.Manifest) == 0 {
+		_, err := fmt.Fprintln(w, "# The version holds no manifest")
+		return err
+	}
+	_, err = w.Write(source.Manifest)
+	return err
+}
+
+// newVersionSource returns the source of a version written by this invocation of Barrelman
+func newVersionSource() *cluster.VersionSource {
+	ver := version.Get()
+	return cluster.NewVersionSource(ver.Version, ver.Commit)
+}
+
+// chartSource records where the chart of an archive came from, with the version of the archived chart
+func chartSource(as *manifest.ArchiveSpec, ch *chart.Chart) *cluster.ChartSource {
+	cs := &cluster.ChartSource{
+		Name:   as.MetaName,
+		Type:   as.SourceType,
+		Commit: as.Commit,
+	}
+	if as.Source != nil {
+		cs.Location = as.Source.Location
+		cs.SubPath = as.Source.SubPath
+		cs.Reference = as.Source.Reference
+	}
+	if ch != nil && ch.Metadata != nil {
+		cs.ChartVersion = ch.Metadata.Version
+	}
+	return cs
+}
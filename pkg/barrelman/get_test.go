@@ -0,0 +1,60 @@
+package barrelman
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/manifest"
+	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
+)
+
+func TestWriteManifest(t *testing.T) {
+	Convey("WriteManifest", t, func() {
+		versions := cluster.NewVersions("testManifest")
+		source := cluster.NewVersionSource("1.2.3", "abc123")
+		source.User = "ci"
+		source.Host = "build-1"
+		source.Manifest = []byte("---\nschema: barrelman/Manifest/v1\n")
+		source.Charts = []*cluster.ChartSource{
+			chartSource(&manifest.ArchiveSpec{
+				MetaName:   "api",
+				SourceType: "git",
+				Source:     &chartsync.Source{Location: "https://example.com/charts", SubPath: "api", Reference: "master"},
+				Commit:     "0123abcd",
+			}, &chart.Chart{Metadata: &chart.Metadata{Name: "api", Version: "1.0.0"}}),
+		}
+		versions.Source = source
+
+		Convey("Prints the source ahead of the recorded manifest", func() {
+			v := newTestSourceVersion(versions)
+			out := &bytes.Buffer{}
+			So(WriteManifest(out, v), ShouldBeNil)
+			So(out.String(), ShouldContainSubstring, "# Version: 3\n")
+			So(out.String(), ShouldContainSubstring, "# Barrelman: 1.2.3 abc123\n")
+			So(out.String(), ShouldContainSubstring, "# User: ci\n# Host: build-1\n")
+			So(out.String(), ShouldContainSubstring, "# Chart api: git https://example.com/charts/api@0123abcd\n")
+			So(out.String(), ShouldEndWith, "---\nschema: barrelman/Manifest/v1\n")
+		})
+		Convey("Fails for versions written before manifests were recorded", func() {
+			versions.Source = nil
+			err := WriteManifest(&bytes.Buffer{}, newTestSourceVersion(versions))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "holds no manifest")
+		})
+	})
+}
+
+// newTestSourceVersion returns a manifest version holding the files written for versions
+func newTestSourceVersion(versions *cluster.Versions) *cluster.Version {
+	v := newTestVersion(3)
+	if versions.Source != nil {
+		files, err := versions.Source.Files()
+		So(err, ShouldBeNil)
+		v.Chart.Files = files
+	}
+	return v
+}
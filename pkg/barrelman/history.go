@@ -1,12 +1,20 @@
 package barrelman
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strconv"
+	"text/tabwriter"
 
 	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/version"
 	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/structured/log"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/timeconv"
 )
 
@@ -18,6 +26,47 @@ type HistoryCmd struct {
 	LogOptions      *[]string
 }
 
+// HistoryDiffCmd compares the releases recorded in two versions of a manifest
+type HistoryDiffCmd struct {
+	Options      *CmdOptions
+	Config       *Config
+	ManifestName string
+	FromVersion  int32
+	ToVersion    int32
+	LogOptions   *[]string
+}
+
+// HistoryPruneCmd removes the oldest versions of a manifest
+// the latest version, the one deployed, and tagged versions are always kept
+type HistoryPruneCmd struct {
+	Options      *CmdOptions
+	Config       *Config
+	ManifestName string
+	Keep         int
+	DryRun       bool
+	LogOptions   *[]string
+}
+
+// VersionDiff holds the releases added, removed or moved to another revision between two manifest versions
+type VersionDiff struct {
+	ManifestName string
+	FromVersion  int32
+	ToVersion    int32
+	Changes      []*ReleaseChange
+}
+
+// ReleaseChange is the change of one release between two manifest versions
+// FromRevision is 0 for an added release and ToRevision is 0 for a removed one
+type ReleaseChange struct {
+	ReleaseName  string
+	FromRevision int32
+	ToRevision   int32
+	FromChart    string
+	ToChart      string
+	Diff         []byte
+	Changed      bool
+}
+
 type HistoryTarget struct {
 	ReleaseMeta *cluster.ReleaseMeta
 	Chart       *cluster.Chart
@@ -63,15 +112,271 @@ func (cmd *HistoryCmd) Run(session cluster.Sessioner) error {
 
 	cluster.By(revisionNumber).Sort(versions.Data)
 	for _, v := range versions.Data {
-		log.WithFields(log.Fields{
+		fields := log.Fields{
 			"ReleaseName":  v.Name,
 			"Revision":     strconv.Itoa(int(v.Revision)),
 			"LastDeployed": timeconv.String(v.Info.GetLastDeployed()),
-		}).Info("history")
+		}
+		// a teardown version records the deletion of the manifest
+		if status := v.Info.GetStatus(); status != nil {
+			fields["Status"] = status.GetCode().String()
+		}
+		if v.Tag != "" {
+			fields["Tag"] = v.Tag
+		}
+		log.WithFields(fields).Info("history")
+	}
+	return nil
+}
+
+func (cmd *HistoryPruneCmd) Run(session cluster.Sessioner) error {
+	var err error
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	if !cmd.DryRun {
+		_, unlock, err := lockManifest(context.Background(), session, cmd.ManifestName)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	pruned, err := session.PruneVersions(cmd.ManifestName, cmd.Keep, cmd.DryRun)
+	if err != nil {
+		return errors.Wrap(err, "Failed to prune versions")
+	}
+	message := "Pruned version"
+	if cmd.DryRun {
+		message = "Version would be pruned"
+	}
+	for _, v := range pruned {
+		log.WithFields(log.Fields{
+			"ManifestName": v.Name,
+			"Revision":     v.Revision,
+		}).Info(message)
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": cmd.ManifestName,
+		"Pruned":       len(pruned),
+		"DryRun":       cmd.DryRun,
+	}).Info("Prune complete")
+	return nil
+}
+
+func (cmd *HistoryDiffCmd) Run(session cluster.Sessioner) error {
+	var err error
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	versions, err := session.GetVersions(cmd.ManifestName)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get versions")
+	}
+	table := versions.Table()
+	from, ok := table.Data[cmd.FromVersion]
+	if !ok {
+		return errors.WithFields(errors.Fields{
+			"ManifestVersion": cmd.FromVersion,
+			"ManifestName":    cmd.ManifestName,
+		}).New("Failed to diff versions, No such version")
+	}
+	to, ok := table.Data[cmd.ToVersion]
+	if !ok {
+		return errors.WithFields(errors.Fields{
+			"ManifestVersion": cmd.ToVersion,
+			"ManifestName":    cmd.ManifestName,
+		}).New("Failed to diff versions, No such version")
+	}
+
+	diff, err := DiffVersions(ctx, session, from, to, diffOptions(cmd.Config, nil))
+	if err != nil {
+		return errors.Wrap(timeoutError(ctx, "diff", err), "failed to diff versions")
+	}
+	diff.ManifestName = cmd.ManifestName
+	return diff.Write(os.Stdout)
+}
+
+// DiffVersions compares the release tables of two manifest versions
+// each release found at another revision is fetched at both revisions to diff its chart, values and manifest
+func DiffVersions(ctx context.Context, session cluster.Sessioner, from, to *cluster.Version, opts *cluster.DiffOptions) (*VersionDiff, error) {
+	fromTable, err := from.ReleaseTable()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get release table of version")
+	}
+	toTable, err := to.ReleaseTable()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get release table of version")
+	}
+	fromRevisions, err := tableRevisions(fromTable)
+	if err != nil {
+		return nil, err
+	}
+	toRevisions, err := tableRevisions(toTable)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range fromRevisions {
+		names = append(names, name)
+	}
+	for name := range toRevisions {
+		if _, ok := fromRevisions[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	vd := &VersionDiff{
+		FromVersion: from.Revision,
+		ToVersion:   to.Revision,
+	}
+	for _, name := range names {
+		change := &ReleaseChange{
+			ReleaseName:  name,
+			FromRevision: fromRevisions[name],
+			ToRevision:   toRevisions[name],
+		}
+		if change.FromRevision == change.ToRevision {
+			continue
+		}
+		vd.Changes = append(vd.Changes, change)
+		if change.FromRevision == 0 || change.ToRevision == 0 {
+			continue
+		}
+		if err := change.diff(ctx, session, opts); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			// a purged release has no history left to diff
+			log.WithFields(log.Fields{
+				"ReleaseName": name,
+				"Error":       err.Error(),
+			}).Warn("Release revisions are not available for diff")
+		}
+	}
+	return vd, nil
+}
+
+// diff fetches both revisions of the release and compares their charts, values and manifests
+func (rc *ReleaseChange) diff(ctx context.Context, session cluster.Sessioner, opts *cluster.DiffOptions) error {
+	fromRel, err := session.GetRelease(ctx, rc.ReleaseName, rc.FromRevision)
+	if err != nil {
+		return err
+	}
+	toRel, err := session.GetRelease(ctx, rc.ReleaseName, rc.ToRevision)
+	if err != nil {
+		return err
+	}
+	rc.FromChart = chartVersion(fromRel.Chart)
+	rc.ToChart = chartVersion(toRel.Chart)
+
+	buf := &bytes.Buffer{}
+	if rc.FromChart != rc.ToChart {
+		fmt.Fprintf(buf, "Chart has changed: %s -> %s\n", rc.FromChart, rc.ToChart)
+	}
+	manifestsChanged := cluster.DiffManifests(
+		cluster.RedactMappings(cluster.Parse(fromRel.Manifest, fromRel.Namespace), opts),
+		cluster.RedactMappings(cluster.Parse(toRel.Manifest, toRel.Namespace), opts),
+		opts.SuppressedKinds,
+		opts.Context,
+		buf)
+	valuesChanged := cluster.DiffOverrides(configRaw(fromRel.Config), configRaw(toRel.Config), opts, buf)
+	rc.Changed = manifestsChanged || valuesChanged || rc.FromChart != rc.ToChart
+	rc.Diff = buf.Bytes()
+	return nil
+}
+
+// Change names how the release differs between the versions
+func (rc *ReleaseChange) Change() string {
+	switch {
+	case rc.FromRevision == 0:
+		return "added"
+	case rc.ToRevision == 0:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// Write writes a table of the changed releases followed by the diff of each release
+func (vd *VersionDiff) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RELEASE\tFROM REVISION\tTO REVISION\tCHANGE")
+	for _, rc := range vd.Changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			rc.ReleaseName,
+			planRevision(rc.FromRevision),
+			planRevision(rc.ToRevision),
+			rc.Change())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, rc := range vd.Changes {
+		if !rc.Changed {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "----%v\n%v_____\n", rc.ReleaseName, string(rc.Diff)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// tableRevisions returns the revision of each release in a version release table
+func tableRevisions(table map[string]*chart.Value) (map[string]int32, error) {
+	revisions := make(map[string]int32)
+	for name, v := range table {
+		revision, err := strconv.Atoi(v.Value)
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"ReleaseName": name,
+			}).Wrap(err, "Failed to extract release version from version data")
+		}
+		revisions[name] = int32(revision)
+	}
+	return revisions, nil
+}
+
+// chartVersion names a chart by its name and version
+func chartVersion(ch *chart.Chart) string {
+	if ch == nil || ch.Metadata == nil {
+		return ""
+	}
+	return ch.Metadata.Name + "-" + ch.Metadata.Version
+}
+
+// configRaw returns the raw values of config, empty when there are none
+func configRaw(config *chart.Config) string {
+	if config == nil {
+		return ""
+	}
+	return config.Raw
+}
+
 // revisionNumber is a sorting algorythm for sorting []*cluster.Version
 func revisionNumber(r1, r2 *cluster.Version) bool {
 	return r1.Revision < r2.Revision
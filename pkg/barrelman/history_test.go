@@ -0,0 +1,84 @@
+package barrelman
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+	"github.com/cirrocloud/structured/errors"
+)
+
+func TestDiffVersions(t *testing.T) {
+	Convey("DiffVersions", t, func() {
+		session := &mocks.Sessioner{}
+		from := newTestVersion(4,
+			&cluster.Version{Name: "app", Revision: 2},
+			&cluster.Version{Name: "cache", Revision: 1},
+			&cluster.Version{Name: "old", Revision: 3},
+		)
+		to := newTestVersion(5,
+			&cluster.Version{Name: "app", Revision: 3},
+			&cluster.Version{Name: "cache", Revision: 1},
+			&cluster.Version{Name: "worker", Revision: 1},
+		)
+		appRelease := func(revision int32, chartVersion, setting string) *cluster.ReleaseMeta {
+			return &cluster.ReleaseMeta{
+				ReleaseName: "app",
+				Namespace:   "apps",
+				Revision:    revision,
+				Chart:       &chart.Chart{Metadata: &chart.Metadata{Name: "app", Version: chartVersion}},
+				Config:      &chart.Config{Raw: "setting: " + setting + "\n"},
+				Manifest:    "\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app-settings\ndata:\n  setting: " + setting + "\n",
+			}
+		}
+
+		Convey("Lists added, removed and changed releases with the diff of each change", func() {
+			session.On("GetRelease", mock.Anything, "app", int32(2)).Return(appRelease(2, "0.1.0", "one"), nil).Once()
+			session.On("GetRelease", mock.Anything, "app", int32(3)).Return(appRelease(3, "0.2.0", "two"), nil).Once()
+
+			vd, err := DiffVersions(context.Background(), session, from, to, cluster.NewDiffOptions())
+			So(err, ShouldBeNil)
+			So(vd.Changes, ShouldHaveLength, 3)
+			changes := map[string]string{}
+			for _, rc := range vd.Changes {
+				changes[rc.ReleaseName] = rc.Change()
+			}
+			So(changes, ShouldResemble, map[string]string{"app": "changed", "old": "removed", "worker": "added"})
+
+			app := vd.Changes[0]
+			So(app.ReleaseName, ShouldEqual, "app")
+			So(app.Changed, ShouldBeTrue)
+			So(app.FromChart, ShouldEqual, "app-0.1.0")
+			So(app.ToChart, ShouldEqual, "app-0.2.0")
+			So(string(app.Diff), ShouldContainSubstring, "app-0.1.0 -> app-0.2.0")
+			So(string(app.Diff), ShouldContainSubstring, "has changed")
+			So(string(app.Diff), ShouldContainSubstring, "Override Values has changed")
+			session.AssertExpectations(t)
+
+			out := &bytes.Buffer{}
+			So(vd.Write(out), ShouldBeNil)
+			lines := strings.Split(out.String(), "\n")
+			So(strings.Fields(lines[0]), ShouldResemble, []string{"RELEASE", "FROM", "REVISION", "TO", "REVISION", "CHANGE"})
+			So(strings.Fields(lines[1]), ShouldResemble, []string{"app", "2", "3", "changed"})
+			So(strings.Fields(lines[2]), ShouldResemble, []string{"old", "3", "-", "removed"})
+			So(strings.Fields(lines[3]), ShouldResemble, []string{"worker", "-", "1", "added"})
+			So(out.String(), ShouldContainSubstring, "----app")
+		})
+
+		Convey("Keeps the changes of releases whose history is gone", func() {
+			session.On("GetRelease", mock.Anything, "app", mock.Anything).Return(nil, errors.New("simulated purged release"))
+
+			vd, err := DiffVersions(context.Background(), session, from, to, cluster.NewDiffOptions())
+			So(err, ShouldBeNil)
+			So(vd.Changes, ShouldHaveLength, 3)
+			So(vd.Changes[0].Changed, ShouldBeFalse)
+		})
+	})
+}
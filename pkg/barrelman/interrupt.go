@@ -0,0 +1,86 @@
+package barrelman
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// interrupt watches for SIGINT and SIGTERM during an operation
+// the first signal stops the operation after the current release so its transaction can be canceled,
+// the second exits immediately leaving the transaction journal for barrelman recover
+type interrupt struct {
+	manifestName string
+	signals      chan os.Signal
+	stopped      chan struct{}
+	done         chan struct{}
+	exit         func(int)
+}
+
+// watchInterrupt starts watching for signals until Close is called
+func watchInterrupt(manifestName string) *interrupt {
+	in := &interrupt{
+		manifestName: manifestName,
+		signals:      make(chan os.Signal, 2),
+		stopped:      make(chan struct{}),
+		done:         make(chan struct{}),
+		exit:         os.Exit,
+	}
+	signal.Notify(in.signals, os.Interrupt, syscall.SIGTERM)
+	go in.watch()
+	return in
+}
+
+func (in *interrupt) watch() {
+	select {
+	case sig := <-in.signals:
+		log.WithFields(log.Fields{
+			"ManifestName": in.manifestName,
+			"Signal":       sig.String(),
+		}).Warn("Interrupted, canceling after the current release. Interrupt again to abort immediately")
+		close(in.stopped)
+	case <-in.done:
+		return
+	}
+	select {
+	case sig := <-in.signals:
+		log.WithFields(log.Fields{
+			"ManifestName": in.manifestName,
+			"Signal":       sig.String(),
+		}).Error("Aborted, run barrelman recover to finish the transaction")
+		in.exit(130)
+	case <-in.done:
+	}
+}
+
+// Stopped returns true once the operation has been interrupted
+func (in *interrupt) Stopped() bool {
+	if in == nil {
+		return false
+	}
+	select {
+	case <-in.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err returns an error when the operation has been interrupted
+func (in *interrupt) Err() error {
+	if !in.Stopped() {
+		return nil
+	}
+	return errors.WithFields(errors.Fields{
+		"ManifestName": in.manifestName,
+	}).New("interrupted, remaining releases were not changed")
+}
+
+// Close stops watching for signals
+func (in *interrupt) Close() {
+	signal.Stop(in.signals)
+	close(in.done)
+}
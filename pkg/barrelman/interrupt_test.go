@@ -0,0 +1,63 @@
+package barrelman
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+)
+
+func TestInterrupt(t *testing.T) {
+	Convey("interrupt", t, func() {
+		exited := make(chan int, 1)
+		in := watchInterrupt("testManifest")
+		in.exit = func(code int) { exited <- code }
+		defer in.Close()
+
+		So(in.Stopped(), ShouldBeFalse)
+		So(in.Err(), ShouldBeNil)
+
+		Convey("The first signal stops the operation", func() {
+			in.signals <- os.Interrupt
+			<-in.stopped
+			So(in.Stopped(), ShouldBeTrue)
+			So(in.Err().Error(), ShouldContainSubstring, "interrupted")
+
+			Convey("The second signal exits", func() {
+				in.signals <- os.Interrupt
+				So(<-exited, ShouldEqual, 130)
+			})
+		})
+
+		Convey("No release is changed once stopped", func() {
+			session := &mocks.Sessioner{}
+			rt := &ReleaseTargets{
+				session:   session,
+				interrupt: in,
+				Data: []*ReleaseTarget{
+					&ReleaseTarget{
+						ReleaseMeta:     &cluster.ReleaseMeta{ReleaseName: "storage-minio"},
+						TransitionState: Installable,
+						ReleaseVersion:  &cluster.Version{},
+					},
+				},
+			}
+			in.signals <- os.Interrupt
+			<-in.stopped
+			err := rt.Apply(context.Background(), &CmdOptions{InstallRetry: 1})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "interrupted")
+			session.AssertExpectations(t)
+		})
+	})
+
+	Convey("A nil interrupt is never stopped", t, func() {
+		var in *interrupt
+		So(in.Stopped(), ShouldBeFalse)
+		So(in.Err(), ShouldBeNil)
+	})
+}
@@ -43,7 +43,9 @@ func (cmd *ListCmd) Run(session cluster.Sessioner) error {
 	}
 
 	// Name was supplied, list the releases
-	list, err := session.ReleasesByManifest(cmd.ManifestName)
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+	list, err := session.ReleasesByManifest(ctx, cmd.ManifestName)
 	if err != nil {
 		return errors.Wrap(err, "Failed to get releases")
 	}
@@ -46,7 +46,7 @@ func TestListCmd(t *testing.T) {
 			session.On("Init").Return(nil)
 			session.On("GetKubeConfig").Return(listCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(listCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.Anything).Return(releases, errors.New("simulated"))
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(releases, errors.New("simulated"))
 			err := listCmd.Run(session)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "simulated")
@@ -61,7 +61,7 @@ func TestListCmd(t *testing.T) {
 			session.On("Init").Return(nil)
 			session.On("GetKubeConfig").Return(listCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(listCmd.Options.KubeContext).Maybe()
-			session.On("ReleasesByManifest", mock.Anything).Return(releases, nil)
+			session.On("ReleasesByManifest", mock.Anything, mock.Anything).Return(releases, nil)
 			err := listCmd.Run(session)
 			So(err, ShouldBeNil)
 			session.AssertExpectations(t)
@@ -1,33 +1,46 @@
 package barrelman
 
 import (
+	"context"
+
 	"github.com/cirrocloud/yamlpack"
 
+	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/manifest"
 	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
 )
 
-func processManifest(config *manifest.Config, noSync bool) (*manifest.ArchiveFiles, string, error) {
+func processManifest(ctx context.Context, config *manifest.Config, noSync bool) (*manifest.ArchiveFiles, *manifest.Manifest, error) {
+	mfest, err := syncManifest(ctx, config, noSync)
+	if err != nil {
+		return nil, nil, err
+	}
+	//Build/update chart archives from manifest
+	archives, err := mfest.CreateArchives()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create archives")
+	}
+	return archives, mfest, err
+}
+
+//syncManifest opens the manifest and downloads its charts unless noSync is set
+func syncManifest(ctx context.Context, config *manifest.Config, noSync bool) (*manifest.Manifest, error) {
 	// Open and initialize the manifest
 	mfest, err := manifest.New(config)
 	if err != nil {
-		return nil, "", errors.Wrap(err, "error while initializing manifest")
+		return nil, errors.Wrap(err, "error while initializing manifest")
 	}
 
 	if !noSync {
-		if err := mfest.Sync(); err != nil {
-			return nil, "", errors.Wrap(err, "error while downloading charts")
+		if err := mfest.Sync(ctx); err != nil {
+			return nil, errors.Wrap(err, "error while downloading charts")
 		}
 	}
-	//Build/update chart archives from manifest
-	archives, err := mfest.CreateArchives()
-	if err != nil {
-		return nil, "", errors.Wrap(err, "failed to create archives")
-	}
-	return archives, mfest.Name, err
+	return mfest, nil
 }
 
-func processManifestSections(config *manifest.Config, ys []*yamlpack.YamlSection, noSync bool) (*manifest.ArchiveFiles, error) {
+func processManifestSections(ctx context.Context, config *manifest.Config, ys []*yamlpack.YamlSection, noSync bool) (*manifest.ArchiveFiles, error) {
 	// Open and initialize the manifest
 	mfest, err := manifest.NewFromSections(config, ys)
 	if err != nil {
@@ -35,7 +48,7 @@ func processManifestSections(config *manifest.Config, ys []*yamlpack.YamlSection
 	}
 
 	if !noSync {
-		if err := mfest.Sync(); err != nil {
+		if err := mfest.Sync(ctx); err != nil {
 			return nil, errors.Wrap(err, "error while downloading charts")
 		}
 	}
@@ -47,3 +60,65 @@ func processManifestSections(config *manifest.Config, ys []*yamlpack.YamlSection
 	}
 	return archives, err
 }
+
+//guardCluster verifies the session is connected to the cluster declared by guard, unless ignore is set
+func guardCluster(session cluster.Sessioner, guard *manifest.ClusterGuard, ignore bool) error {
+	if guard == nil {
+		return nil
+	}
+	if ignore {
+		log.WithFields(log.Fields{
+			"KubeContext": session.GetKubeContext(),
+		}).Warn("Cluster guard of the manifest ignored")
+		return nil
+	}
+	if err := session.VerifyCluster(&cluster.ClusterGuard{
+		KubeSystemUID: guard.KubeSystemUID,
+		APIServer:     guard.APIServer,
+		Labels:        guard.Labels,
+	}); err != nil {
+		return errors.Wrap(err, "cluster guard failed")
+	}
+	return nil
+}
+
+// guardVersions verifies the cluster against the guard of the manifest recorded with the latest version
+// commands working from stored versions have no manifest file to read the guard from
+func guardVersions(session cluster.Sessioner, versions *cluster.Versions, ignore bool) error {
+	latest := versions.Latest()
+	if latest == nil {
+		return nil
+	}
+	source, err := latest.Source()
+	if err != nil {
+		return err
+	}
+	if source == nil || len(source.Manifest) == 0 {
+		return nil
+	}
+	guard, err := manifest.GuardFromSource(source.Manifest)
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": versions.Name,
+			"Revision":     latest.Revision,
+		}).Wrap(err, "failed to read the cluster guard of the latest version")
+	}
+	return guardCluster(session, guard, ignore)
+}
+
+//diffOptions combines the default diff options with those set in the config file and manifest
+func diffOptions(config *Config, mfest *manifest.Manifest) *cluster.DiffOptions {
+	opts := cluster.NewDiffOptions()
+	if config != nil {
+		opts = opts.Merge(config.Diff)
+	}
+	if mfest != nil && mfest.Data != nil && mfest.Data.Diff != nil {
+		opts = opts.Merge(&cluster.DiffOptions{
+			SuppressedKinds: mfest.Data.Diff.SuppressedKinds,
+			RedactedKinds:   mfest.Data.Diff.RedactedKinds,
+			RedactedPaths:   mfest.Data.Diff.RedactedPaths,
+			RedactedKeys:    mfest.Data.Diff.RedactedKeys,
+		})
+	}
+	return opts
+}
@@ -2,14 +2,19 @@ package barrelman
 
 import (
 	"bytes"
+	"context"
 	"os"
 	"testing"
 
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
 	"github.com/charter-oss/barrelman/pkg/manifest"
 	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
+	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/yamlpack"
 	"github.com/lithammer/dedent"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestMFest(t *testing.T) {
@@ -24,13 +29,36 @@ func TestMFest(t *testing.T) {
 		Convey("can create archives from yaml sections", func() {
 			sections, err := sectionsFromBytes(twoGood())
 			So(err, ShouldBeNil)
-			archives, err := processManifestSections(config, sections, true)
+			archives, err := processManifestSections(context.Background(), config, sections, true)
 			So(err, ShouldBeNil)
 			So(archives.List, ShouldHaveLength, 1)
 		})
 	})
 }
 
+func TestGuardCluster(t *testing.T) {
+	Convey("guardCluster", t, func() {
+		session := &mocks.Sessioner{}
+		session.On("GetKubeContext").Return("staging").Maybe()
+		guard := &manifest.ClusterGuard{KubeSystemUID: "1234-abcd"}
+
+		Convey("Skips a manifest without a guard", func() {
+			So(guardCluster(session, nil, false), ShouldBeNil)
+			session.AssertNotCalled(t, "VerifyCluster", mock.Anything)
+		})
+		Convey("Fails on a cluster mismatch", func() {
+			session.On("VerifyCluster", &cluster.ClusterGuard{KubeSystemUID: "1234-abcd"}).Return(errors.New("cluster does not match"))
+			err := guardCluster(session, guard, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cluster guard failed")
+		})
+		Convey("Is skipped with --ignore-cluster-guard", func() {
+			So(guardCluster(session, guard, true), ShouldBeNil)
+			session.AssertNotCalled(t, "VerifyCluster", mock.Anything)
+		})
+	})
+}
+
 func sectionsFromBytes(name string, bytesIn []byte) ([]*yamlpack.YamlSection, error) {
 	yp := yamlpack.New()
 	err := yp.Import(name, bytes.NewReader(bytesIn))
@@ -0,0 +1,123 @@
+package barrelman
+
+import (
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/version"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+type MigrateCmd struct {
+	Options      *CmdOptions
+	Config       *Config
+	ManifestName string
+	DryRun       bool
+	Cleanup      bool
+	LogOptions   *[]string
+}
+
+// MigrateVersionsCmd moves the Barrelman versions stored as ConfigMaps into the versions storage
+type MigrateVersionsCmd struct {
+	Options       *CmdOptions
+	Config        *Config
+	FromNamespace string
+	DryRun        bool
+	Cleanup       bool
+	LogOptions    *[]string
+}
+
+// Run copies the releases and versions of a manifest from Tiller storage into Helm 3 storage
+func (cmd *MigrateCmd) Run(source cluster.Helm3Migrator, target *cluster.Helm3Session) error {
+	var err error
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = source.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+	if err = target.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new Helm 3 session")
+	}
+
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	results, err := source.MigrateManifest(ctx, cmd.ManifestName, target, &cluster.MigrateOptions{
+		DryRun:  cmd.DryRun,
+		Cleanup: cmd.Cleanup,
+		Progress: func(result *cluster.MigrateResult) {
+			msg := "Migrated release"
+			if cmd.DryRun {
+				msg = "Would migrate release"
+			}
+			log.WithFields(log.Fields{
+				"ReleaseName": result.ReleaseName,
+				"Namespace":   result.Namespace,
+				"Revisions":   result.Migrated,
+				"Skipped":     result.Skipped,
+				"CleanedUp":   result.CleanedUp,
+			}).Info(msg)
+		},
+	})
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": cmd.ManifestName,
+			"Completed":    len(results),
+		}).Wrap(err, "migration failed")
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": cmd.ManifestName,
+		"Releases":     len(results),
+		"DryRun":       cmd.DryRun,
+	}).Info("Migration complete")
+	return nil
+}
+
+// Run copies every version ConfigMap into the versions storage of session
+func (cmd *MigrateVersionsCmd) Run(session cluster.VersionMigrator) error {
+	var err error
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	results, err := session.MigrateVersions(&cluster.VersionMigrateOptions{
+		FromNamespace: cmd.FromNamespace,
+		DryRun:        cmd.DryRun,
+		Cleanup:       cmd.Cleanup,
+	})
+	msg := "Migrated versions"
+	if cmd.DryRun {
+		msg = "Would migrate versions"
+	}
+	for _, result := range results {
+		log.WithFields(log.Fields{
+			"ManifestName": result.ManifestName,
+			"Versions":     result.Migrated,
+			"Skipped":      result.Skipped,
+			"CleanedUp":    result.CleanedUp,
+		}).Info(msg)
+	}
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"Completed": len(results),
+		}).Wrap(err, "version migration failed")
+	}
+	log.WithFields(log.Fields{
+		"Manifests": len(results),
+		"DryRun":    cmd.DryRun,
+	}).Info("Version migration complete")
+	return nil
+}
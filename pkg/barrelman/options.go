@@ -1,10 +1,18 @@
 package barrelman
 
+import (
+	"time"
+
+	"github.com/charter-oss/barrelman/pkg/manifest"
+)
+
 type CmdOptions struct {
 	ManifestFile   string
 	ConfigFile     string
 	KubeConfigFile string
 	KubeContext    string
+	Backend        string
+	Simulate       string
 	DataDir        string
 	LogLevel       string
 	DryRun         bool
@@ -14,4 +22,67 @@ type CmdOptions struct {
 	Force          *[]string
 	InstallRetry   int
 	InstallWait    bool
+	RepairDrift    bool
+	// Timeout bounds the whole operation, zero waits indefinitely
+	Timeout time.Duration
+	// SyncTimeout, PlanTimeout and ApplyTimeout bound the phases of apply within Timeout
+	SyncTimeout  time.Duration
+	PlanTimeout  time.Duration
+	ApplyTimeout time.Duration
+	// TillerHost, TillerNamespace, TillerSelector and TillerConnectionTimeout override the tiller config entries
+	TillerHost              string
+	TillerNamespace         string
+	TillerSelector          string
+	TillerConnectionTimeout time.Duration
+	// HelmHome locates the Helm TLS files
+	HelmHome string
+	// Contexts applies the manifest to each kube context, Strategy selects sequential or parallel rollout
+	Contexts []string
+	Strategy string
+	// IgnoreClusterGuard skips verifying the cluster identity declared in the manifest
+	IgnoreClusterGuard bool
+	// Purge removes the release history on delete, Wait returns once the deleted objects are gone
+	Purge bool
+	Wait  bool
+	// HistoryMax limits the manifest versions kept, zero leaves the limit to the manifest or config
+	HistoryMax int
+	// VersionsNamespace holds Barrelman versions, locks and journals, VersionsStorage selects ConfigMaps or Secrets
+	VersionsNamespace string
+	VersionsStorage   string
+}
+
+// HistoryLimit returns the number of manifest versions to keep, zero keeps them all
+// --history-max takes precedence over the manifest, which takes precedence over the config
+func (opt *CmdOptions) HistoryLimit(config *Config, mfest *manifest.Manifest) int {
+	if opt.HistoryMax > 0 {
+		return opt.HistoryMax
+	}
+	if mfest != nil && mfest.Data != nil && mfest.Data.HistoryMax > 0 {
+		return mfest.Data.HistoryMax
+	}
+	if config != nil {
+		return config.HistoryMax
+	}
+	return 0
+}
+
+// Tiller returns the Tiller settings of config with those set in the options taking precedence
+func (opt *CmdOptions) Tiller(config *Config) *TillerConfig {
+	tiller := &TillerConfig{}
+	if config != nil && config.Tiller != nil {
+		*tiller = *config.Tiller
+	}
+	if opt.TillerHost != "" {
+		tiller.Host = opt.TillerHost
+	}
+	if opt.TillerNamespace != "" {
+		tiller.Namespace = opt.TillerNamespace
+	}
+	if opt.TillerSelector != "" {
+		tiller.Selector = opt.TillerSelector
+	}
+	if opt.TillerConnectionTimeout > 0 {
+		tiller.ConnectionTimeout = opt.TillerConnectionTimeout
+	}
+	return tiller
 }
@@ -0,0 +1,169 @@
+package barrelman
+
+import (
+	"context"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/version"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+type RecoverCmd struct {
+	Options      *CmdOptions
+	Config       *Config
+	ManifestName string
+	// Rollback reverts the releases modified by the interrupted transaction
+	Rollback bool
+	// ManifestFile resumes the interrupted transaction by applying the manifest
+	ManifestFile string
+	LogOptions   *[]string
+}
+
+// Run reports the unfinished transaction of a manifest, then rolls it back or forward
+func (cmd *RecoverCmd) Run(session cluster.Sessioner) error {
+	var err error
+	log.Rep(version.Get()).Info("Barrelman")
+
+	if cmd.Rollback && cmd.ManifestFile != "" {
+		return errors.New("rollback and forward recovery cannot be combined")
+	}
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	if err := ensureWorkDir(cmd.Options.DataDir); err != nil {
+		return errors.Wrap(err, "failed to create working directory")
+	}
+
+	log.Debug("connecting to cluster")
+	if err = session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	journal, err := cmd.recover(ctx, session)
+	if err != nil || journal == nil || cmd.ManifestFile == "" {
+		return err
+	}
+
+	cmd.Options.ManifestFile = cmd.ManifestFile
+	applyCmd := &ApplyCmd{
+		Options:    cmd.Options,
+		Config:     cmd.Config,
+		LogOptions: cmd.LogOptions,
+		Resume:     true,
+	}
+	if err := applyCmd.apply(ctx, session); err != nil {
+		return errors.Wrap(err, "forward recovery failed")
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": cmd.ManifestName,
+	}).Info("Recovered by applying manifest")
+	return nil
+}
+
+// recover reports the journal of the manifest and performs a rollback recovery
+// the returned journal is nil when the manifest has no unfinished transaction
+// the cluster is verified against the guard of the manifest recorded with the latest version first
+func (cmd *RecoverCmd) recover(ctx context.Context, session cluster.Sessioner) (*cluster.Journal, error) {
+	ctx, unlock, err := lockManifest(ctx, session, cmd.ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	journal, err := session.GetJournal(cmd.ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	if journal == nil {
+		log.WithFields(log.Fields{
+			"ManifestName": cmd.ManifestName,
+		}).Info("No unfinished transaction")
+		return nil, nil
+	}
+
+	versions, err := session.GetVersions(cmd.ManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get versions")
+	}
+	if err := guardVersions(session, versions, cmd.Options.IgnoreClusterGuard); err != nil {
+		return nil, err
+	}
+
+	releases, err := session.ReleasesByManifest(ctx, cmd.ManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get current releases")
+	}
+	if reconcileJournal(journal, releases) {
+		if err := session.WriteJournal(journal); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Rep(journal).Info("Unfinished transaction")
+	for _, entry := range journal.Modified() {
+		log.Rep(entry).Info("Modified release")
+	}
+
+	if !cmd.Rollback {
+		if cmd.ManifestFile == "" {
+			log.Info("Use --rollback to revert the modified releases or --forward with the manifest file to resume")
+		}
+		return journal, nil
+	}
+
+	transaction, err := session.NewTransaction(cmd.ManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new transaction during recover")
+	}
+	transaction.Resume(journal)
+	if err := transaction.Cancel(); err != nil {
+		return nil, errors.Wrap(err, "rollback recovery failed")
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": cmd.ManifestName,
+	}).Info("Recovered by rolling back")
+	return journal, nil
+}
+
+// reconcileJournal marks releases changed after the last checkpoint as modified
+// this covers a process interrupted during a release change, returns true when journal was changed
+func reconcileJournal(journal *cluster.Journal, releases map[string]*cluster.ReleaseMeta) bool {
+	changed := false
+	for _, entry := range journal.Releases {
+		if entry.Modified {
+			continue
+		}
+		for _, rel := range releases {
+			if rel.ReleaseName != entry.Name || rel.Revision == entry.Revision {
+				continue
+			}
+			entry.Revision = rel.Revision
+			entry.Modified = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// unfinishedJournal returns the journal of an interrupted transaction on manifestName
+// it fails when there is one, unless allowed
+func unfinishedJournal(session cluster.Sessioner, manifestName string, allowed bool) (*cluster.Journal, error) {
+	journal, err := session.GetJournal(manifestName)
+	if err != nil {
+		return nil, err
+	}
+	if journal != nil && !allowed {
+		return nil, errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+			"Started":      journal.Started,
+		}).New("manifest has an unfinished transaction, run barrelman recover")
+	}
+	return journal, nil
+}
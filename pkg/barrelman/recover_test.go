@@ -0,0 +1,120 @@
+package barrelman
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+)
+
+func TestRecoverCmd(t *testing.T) {
+	newJournal := func() *cluster.Journal {
+		return &cluster.Journal{
+			ManifestName: "testManifest",
+			Releases: []*cluster.JournalEntry{
+				{Name: "upgraded", Revision: 3, PreviousRevision: 2, Modified: true},
+				{Name: "interrupted", Revision: 4, PreviousRevision: 4},
+				{Name: "untouched", Revision: 1, PreviousRevision: 1},
+			},
+		}
+	}
+	releases := map[string]*cluster.ReleaseMeta{
+		"upgraded":    {ReleaseName: "upgraded", Revision: 3},
+		"interrupted": {ReleaseName: "interrupted", Revision: 5},
+		"untouched":   {ReleaseName: "untouched", Revision: 1},
+	}
+
+	Convey("reconcileJournal marks releases changed after the last checkpoint", t, func() {
+		journal := newJournal()
+		So(reconcileJournal(journal, releases), ShouldBeTrue)
+		So(journal.Lookup("interrupted").Modified, ShouldBeTrue)
+		So(journal.Lookup("interrupted").Revision, ShouldEqual, 5)
+		So(journal.Lookup("untouched").Modified, ShouldBeFalse)
+		So(reconcileJournal(journal, releases), ShouldBeFalse)
+	})
+
+	Convey("Recover Command", t, func() {
+		recoverCmd := &RecoverCmd{
+			ManifestName: "testManifest",
+			Options: &CmdOptions{
+				Force:      &[]string{},
+				DataDir:    "testdata/datadir",
+				ConfigFile: "testdata/config",
+			},
+		}
+		session := &mocks.Sessioner{}
+		session.On("Init").Return(nil)
+		session.On("Lock", "testManifest").Return(newTestUnlocker(), nil)
+		session.On("GetVersions", "testManifest").Return(cluster.NewVersions("testManifest"), nil).Maybe()
+
+		Convey("Should succeed without an unfinished transaction", func() {
+			session.On("GetJournal", "testManifest").Return(nil, nil)
+			So(recoverCmd.Run(session), ShouldBeNil)
+			session.AssertExpectations(t)
+		})
+		Convey("Should report an unfinished transaction without changing releases", func() {
+			session.On("GetJournal", "testManifest").Return(newJournal(), nil)
+			session.On("ReleasesByManifest", mock.Anything, "testManifest").Return(releases, nil)
+			session.On("WriteJournal", mock.AnythingOfType("*cluster.Journal")).Return(nil)
+			So(recoverCmd.Run(session), ShouldBeNil)
+			session.AssertExpectations(t)
+		})
+		Convey("Should cancel the unfinished transaction on rollback", func() {
+			recoverCmd.Rollback = true
+			transaction := &mocks.Transactioner{}
+			session.On("GetJournal", "testManifest").Return(newJournal(), nil)
+			session.On("ReleasesByManifest", mock.Anything, "testManifest").Return(releases, nil)
+			session.On("WriteJournal", mock.AnythingOfType("*cluster.Journal")).Return(nil)
+			session.On("NewTransaction", "testManifest").Return(transaction, nil)
+			transaction.On("Resume", mock.MatchedBy(func(j *cluster.Journal) bool {
+				return len(j.Modified()) == 2
+			})).Return()
+			transaction.On("Cancel").Return(nil)
+			So(recoverCmd.Run(session), ShouldBeNil)
+			session.AssertExpectations(t)
+			transaction.AssertExpectations(t)
+		})
+		Convey("Should verify the cluster guard of the latest version", func() {
+			recoverCmd.Rollback = true
+			versions := cluster.NewVersions("testManifest")
+			versions.Data = append(versions.Data, withManifestSource(newTestVersion(2, &cluster.Version{Name: "upgraded", Revision: 3}), guardedManifest))
+			session := &mocks.Sessioner{}
+			session.On("Init").Return(nil)
+			session.On("Lock", "testManifest").Return(newTestUnlocker(), nil)
+			session.On("GetJournal", "testManifest").Return(newJournal(), nil)
+			session.On("GetVersions", "testManifest").Return(versions, nil)
+			session.On("VerifyCluster", mock.Anything).Return(errors.New("cluster label env is staging"))
+			err := recoverCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cluster guard failed")
+			session.AssertNotCalled(t, "NewTransaction", mock.Anything)
+		})
+		Convey("Should refuse rollback and forward recovery together", func() {
+			recoverCmd.Rollback = true
+			recoverCmd.ManifestFile = "testdata/manifest.yaml"
+			err := recoverCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cannot be combined")
+		})
+	})
+
+	Convey("unfinishedJournal", t, func() {
+		session := &mocks.Sessioner{}
+		session.On("GetJournal", "testManifest").Return(newJournal(), nil)
+
+		Convey("Should fail on an unfinished transaction", func() {
+			_, err := unfinishedJournal(session, "testManifest", false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "barrelman recover")
+		})
+		Convey("Should return the journal when allowed", func() {
+			journal, err := unfinishedJournal(session, "testManifest", true)
+			So(err, ShouldBeNil)
+			So(journal, ShouldNotBeNil)
+		})
+	})
+}
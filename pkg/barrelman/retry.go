@@ -0,0 +1,34 @@
+package barrelman
+
+import (
+	"time"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/manifest"
+)
+
+// chartRetryPolicy converts the retry limits of a chart, nil when the chart sets none
+func chartRetryPolicy(retry *manifest.ChartDataRetry) *cluster.RetryPolicy {
+	if retry == nil {
+		return nil
+	}
+	return &cluster.RetryPolicy{
+		Attempts:        retry.Attempts,
+		InitialInterval: time.Duration(retry.InitialInterval * float64(time.Second)),
+		MaxInterval:     time.Duration(retry.MaxInterval * float64(time.Second)),
+	}
+}
+
+// defaultRetryPolicy returns the retry limits of releases without their own, --install-retry sets the attempts
+func defaultRetryPolicy(opt *CmdOptions) *cluster.RetryPolicy {
+	policy := cluster.NewRetryPolicy()
+	if opt != nil && opt.InstallRetry > 0 {
+		policy.Attempts = opt.InstallRetry
+	}
+	return policy
+}
+
+// retryPolicy returns the retry limits of the release, the chart limits replace the defaults
+func (rt *ReleaseTarget) retryPolicy(opt *CmdOptions) *cluster.RetryPolicy {
+	return defaultRetryPolicy(opt).Merge(rt.Retry)
+}
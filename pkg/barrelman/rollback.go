@@ -1,8 +1,15 @@
 package barrelman
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/charter-oss/barrelman/pkg/cluster"
 	"github.com/charter-oss/barrelman/pkg/version"
@@ -11,12 +18,22 @@ import (
 	"k8s.io/helm/pkg/proto/hapi/chart"
 )
 
+// reinstallTimeout bounds reinstalling a purged release from the chart stored in a version
+const reinstallTimeout = 2 * time.Minute
+
 type RollbackCmd struct {
 	Options         *CmdOptions
 	Config          *Config
 	ManifestName    string
 	ManifestVersion int32
-	LogOptions      *[]string
+	// Charts and ChartGroups select the releases of a partial rollback, all releases are rolled back when empty
+	Charts      []string
+	ChartGroups []string
+	LogOptions  *[]string
+	// latest is the latest manifest version, it names the chart and group of the current releases
+	latest *cluster.Version
+	// versions are the manifest versions, the chart of a purged release is looked up in them
+	versions *cluster.Versions
 }
 
 type RollbackTarget struct {
@@ -32,6 +49,8 @@ type RollbackTargets struct {
 	ManifestName string
 	session      cluster.Sessioner
 	transaction  cluster.Transactioner
+	interrupt    *interrupt
+	retry        *cluster.RetryPolicy
 	Data         []*RollbackTarget
 }
 
@@ -53,6 +72,19 @@ func (cmd *RollbackCmd) Run(session cluster.Sessioner) error {
 		return errors.Wrap(err, "failed to create new cluster session")
 	}
 
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	ctx, unlock, err := lockManifest(ctx, session, cmd.ManifestName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := unfinishedJournal(session, cmd.ManifestName, cmd.Options.Diff); err != nil {
+		return err
+	}
+
 	versions, err := session.GetVersions(cmd.ManifestName)
 	if err != nil {
 		return errors.Wrap(err, "Failed to get versions")
@@ -60,72 +92,158 @@ func (cmd *RollbackCmd) Run(session cluster.Sessioner) error {
 	for _, v := range versions.Data {
 		log.Rep(v).Debug("Rollback manifest")
 	}
+	if err := guardVersions(session, versions, cmd.Options.IgnoreClusterGuard); err != nil {
+		return err
+	}
 
 	// Rollback supports transactions
 	transaction, err := session.NewTransaction(cmd.ManifestName)
 	if err != nil {
 		return errors.Wrap(err, "failed to create new transaction durring apply")
 	}
-	defer transaction.Cancel()
-
-	versionTable := versions.Table()
-	if cmd.ManifestVersion != 0 {
-		releaseMeta, ok := versionTable.Data[cmd.ManifestVersion]
-		if !ok {
-			return errors.WithFields(errors.Fields{
-				"ManifestVersion": cmd.ManifestVersion,
-				"ManifestName":    cmd.ManifestName,
-			}).New("Failed to rollback to version, No such version")
-		}
-		releaseTable, err := releaseMeta.ReleaseTable()
-		if err != nil {
-			return errors.Wrap(err, "failed to get release table from Rollback ConfigMap")
+	defer func() {
+		if !lockLost(ctx) {
+			transaction.Cancel()
 		}
+	}()
 
-		currentReleases, err := session.ReleasesByManifest(cmd.ManifestName)
-		if err != nil {
-			return errors.Wrap(err, "failed to get current releases")
-		}
-		rts, err := cmd.ComputeRollback(session, transaction, releaseTable, currentReleases)
-		if err != nil {
-			return errors.Wrap(err, "failed to compute plan for rollback")
-		}
+	target, err := cmd.targetVersion(versions)
+	if err != nil {
+		return err
+	}
 
-		_, err = rts.Diff(session)
-		if err != nil {
-			return err
-		}
+	currentReleases, err := session.ReleasesByManifest(ctx, cmd.ManifestName)
+	if err != nil {
+		return errors.Wrap(timeoutError(ctx, "plan", err), "failed to get current releases")
+	}
+	rts, err := cmd.ComputeRollback(ctx, session, transaction, target, currentReleases)
+	if err != nil {
+		return errors.Wrap(timeoutError(ctx, "plan", err), "failed to compute plan for rollback")
+	}
+	transaction.Versions().Source = cmd.versionSource(target, rts)
 
-		if cmd.Options.Diff {
-			rts.LogDiff()
-			return nil
-		}
+	_, err = rts.Diff(ctx, session)
+	if err != nil {
+		return timeoutError(ctx, "plan", err)
+	}
+
+	if cmd.Options.Diff {
+		rts.LogDiff()
+		return nil
+	}
 
-		if err := rts.Apply(); err != nil {
-			return errors.Wrap(err, "Rollback failed")
+	rts.retry = defaultRetryPolicy(cmd.Options)
+	rts.interrupt = watchInterrupt(cmd.ManifestName)
+	defer rts.interrupt.Close()
+	if err := rts.Apply(ctx); err != nil {
+		if lockLost(ctx) {
+			return lockLostError(cmd.ManifestName, err)
 		}
+		err = timeoutError(ctx, "apply", err)
+		if cancelErr := transaction.Cancel(); cancelErr != nil {
+			err = errors.WithFields(errors.Fields{
+				"TransactionError": cancelErr.Error(),
+			}).Wrap(err, "transaction error while Canceling")
+		} else if rts.interrupt.Stopped() {
+			log.WithFields(log.Fields{
+				"ManifestName": cmd.ManifestName,
+			}).Warn("Transaction canceled after interrupt")
+		} else if ctx.Err() == context.DeadlineExceeded {
+			log.WithFields(log.Fields{
+				"ManifestName": cmd.ManifestName,
+			}).Warn("Transaction canceled after timeout")
+		}
+		return errors.Wrap(err, "Rollback failed")
 	}
 
+	transaction.Versions().HistoryMax = cmd.Options.HistoryLimit(cmd.Config, nil)
 	return transaction.Complete()
 }
 
-func (rts *RollbackTargets) Apply() error {
+// storedRelease returns the chart and values release name is reinstalled from by a rollback to target
+// they may be stored with an earlier version than target, see cluster.Versions.StoredRelease
+func (cmd *RollbackCmd) storedRelease(target *cluster.Version, name string) (*chart.Chart, *chart.Config, error) {
+	if cmd.versions == nil {
+		return target.StoredRelease(name)
+	}
+	return cmd.versions.StoredRelease(target, name)
+}
+
+// targetVersion returns the manifest version to roll back to, the version before the latest unless one is given
+func (cmd *RollbackCmd) targetVersion(versions *cluster.Versions) (*cluster.Version, error) {
+	cmd.latest = versions.Latest()
+	cmd.versions = versions
+	if cmd.ManifestVersion == 0 {
+		previous := versions.Previous()
+		if previous == nil {
+			return nil, errors.WithFields(errors.Fields{
+				"ManifestName": cmd.ManifestName,
+			}).New("Failed to rollback, there is no previous version")
+		}
+		log.WithFields(log.Fields{
+			"ManifestName":    cmd.ManifestName,
+			"ManifestVersion": previous.Revision,
+		}).Info("Rolling back to the previous version")
+		return previous, nil
+	}
+	target, ok := versions.Table().Data[cmd.ManifestVersion]
+	if !ok {
+		return nil, errors.WithFields(errors.Fields{
+			"ManifestVersion": cmd.ManifestVersion,
+			"ManifestName":    cmd.ManifestName,
+		}).New("Failed to rollback to version, No such version")
+	}
+	return target, nil
+}
+
+// Apply rolls back each target, a done ctx stops it before the next release
+func (rts *RollbackTargets) Apply(ctx context.Context) error {
+	if err := rts.checkpoint(); err != nil {
+		return err
+	}
 
 	for _, rt := range rts.Data {
+		if err := rts.interrupt.Err(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		switch rt.TransitionState {
 		case NoChange:
 			log.WithFields(log.Fields{
 				"ReleaseName": rt.ReleaseMeta.ReleaseName,
 			}).Info("No change in rollback")
 		case Installable:
-			return errors.WithFields(errors.Fields{
-				"ReleaseName":     rt.ReleaseMeta.ReleaseName,
-				"TransitionState": rt.TransitionState.String(),
-			}).New("Invalid transition state for rollback")
+			log.WithFields(log.Fields{
+				"ReleaseName": rt.ReleaseMeta.ReleaseName,
+				"Namespace":   rt.ReleaseMeta.Namespace,
+			}).Info("Reinstalling from the chart stored in the version")
+			rt.ReleaseMeta.DryRun = false
+			rt.ReleaseMeta.InstallTimeout = reinstallTimeout
+			var res *cluster.InstallReleaseResponse
+			err := rts.retry.Do(ctx, "install", func() error {
+				var err error
+				res, err = rts.session.InstallRelease(ctx, rt.ReleaseMeta, rts.ManifestName)
+				return err
+			})
+			if err != nil {
+				return errors.Wrap(err, "reinstall failed")
+			}
+			rt.ReleaseVersion.SetRevision(res.ReleaseVersion)
+			if err := rts.checkpoint(); err != nil {
+				return err
+			}
 		case Upgradable, Undeletable, Replaceable:
-			newRevision, err := rts.session.RollbackRelease(&cluster.RollbackMeta{
-				ReleaseName: rt.ReleaseMeta.ReleaseName,
-				Revision:    rt.Revision,
+			var newRevision int32
+			err := rts.retry.Do(ctx, "rollback", func() error {
+				var err error
+				newRevision, err = rts.session.RollbackRelease(ctx, &cluster.RollbackMeta{
+					ReleaseName:     rt.ReleaseMeta.ReleaseName,
+					Revision:        rt.Revision,
+					TillerNamespace: rt.ReleaseVersion.TillerNamespace,
+				})
+				return err
 			})
 			if err != nil {
 				return errors.Wrap(err, "rollback failed")
@@ -134,18 +252,27 @@ func (rts *RollbackTargets) Apply() error {
 				"ReleaseName": rt.ReleaseMeta.ReleaseName,
 				"Version":     newRevision,
 			}).Info("Release rolled back")
-			rt.ReleaseVersion.SetModified()
+			rt.ReleaseVersion.SetRevision(newRevision)
+			if err := rts.checkpoint(); err != nil {
+				return err
+			}
 		case Deletable:
 			log.WithFields(log.Fields{
 				"ReleaseName": rt.ReleaseMeta.ReleaseName,
 			}).Info("Rollback to deleted")
-			if err := rts.session.DeleteRelease(&cluster.DeleteMeta{
-				ReleaseName: rt.ReleaseMeta.ReleaseName,
-				Namespace:   rt.ReleaseVersion.Namespace,
+			if err := rts.retry.Do(ctx, "delete", func() error {
+				return rts.session.DeleteRelease(ctx, &cluster.DeleteMeta{
+					ReleaseName:     rt.ReleaseMeta.ReleaseName,
+					Namespace:       rt.ReleaseVersion.Namespace,
+					TillerNamespace: rt.ReleaseVersion.TillerNamespace,
+				})
 			}); err != nil {
 				return errors.Wrap(err, "error deleting release during rollback")
 			}
 			rt.ReleaseVersion.SetModified()
+			if err := rts.checkpoint(); err != nil {
+				return err
+			}
 		default:
 			// Not a thing
 			return errors.WithFields(errors.Fields{
@@ -160,9 +287,10 @@ func (rts *RollbackTargets) Apply() error {
 //ComputeReleases configures each potential release with a current state
 //states may be one of 'Installable', 'Upgradeable', 'Replaceable', 'NoChange'
 func (cmd *RollbackCmd) ComputeRollback(
+	ctx context.Context,
 	session cluster.Sessioner,
 	transaction cluster.Transactioner,
-	rollbackReleaseList map[string]*chart.Value,
+	target *cluster.Version,
 	currentReleases map[string]*cluster.ReleaseMeta) (*RollbackTargets, error) {
 
 	rts := &RollbackTargets{
@@ -171,7 +299,24 @@ func (cmd *RollbackCmd) ComputeRollback(
 		transaction:  transaction,
 	}
 
+	rollbackReleaseList, err := target.ReleaseTable()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get release table from Rollback ConfigMap")
+	}
+	entries := recordedEntries(target)
+	latestEntries := recordedEntries(cmd.latest)
+	selected := func(releaseName string) bool {
+		entry, ok := entries[releaseName]
+		if !ok {
+			entry = latestEntries[releaseName]
+		}
+		return cmd.selects(releaseName, entry)
+	}
+
 	for releaseName, v := range rollbackReleaseList {
+		if !selected(releaseName) {
+			continue
+		}
 		releaseExists := false
 		tmpVersion, err := strconv.Atoi(v.Value)
 		if err != nil {
@@ -182,6 +327,7 @@ func (cmd *RollbackCmd) ComputeRollback(
 		rt := &RollbackTarget{
 			ReleaseMeta: &cluster.ReleaseMeta{
 				ReleaseName: releaseName,
+				DiffOptions: diffOptions(cmd.Config, nil),
 			},
 			Revision:        revision,
 			TransitionState: NoChange, //Unless modified below
@@ -193,37 +339,44 @@ func (cmd *RollbackCmd) ComputeRollback(
 				releaseExists = true
 
 				rt.ReleaseVersion = &cluster.Version{
-					Name:      rel.ReleaseName,
-					Namespace: rel.Namespace,
-					Revision:  rel.Revision,
+					Name:             rel.ReleaseName,
+					Namespace:        rel.Namespace,
+					Revision:         rel.Revision,
+					PreviousRevision: rel.Revision,
+					TillerNamespace:  rel.TillerNamespace,
 				}
+				rt.ReleaseMeta.TillerNamespace = rel.TillerNamespace
+				rt.ReleaseMeta.Namespace = rel.Namespace
 
 				// The To Chart is needed to perform diffs
 				// not technically needed for the rollback
 				// but for operator analysis and avoiding rolling on no change
-				toMeta, err := session.GetRelease(rel.ReleaseName, revision)
+				toMeta, err := session.GetRelease(ctx, rel.ReleaseName, revision)
 				if err != nil {
 					return nil, errors.Wrap(err, "failed to get release")
 				}
 				rt.ReleaseVersion.Chart = toMeta.Chart
+				rt.ReleaseVersion.Config = toMeta.Config
 				rt.ReleaseMeta.Config = toMeta.Config
-				if err := rt.CalculateDiff(session); err != nil {
+				if err := rt.CalculateDiff(ctx, session); err != nil {
 					return nil, err
 				}
 				if rel.Status == cluster.Status_DELETED {
 					// Current release has been deleted, we track it seperately
 					rt.TransitionState = Undeletable
+					rt.ReleaseVersion.Prior = rel.Prior()
 				} else if rel.Status == cluster.Status_FAILED {
 					// Current release is in FAILED state AND force is enabled for this release
 					// setup for delete and install
 					rt.TransitionState = Replaceable
+					rt.ReleaseVersion.Prior = rel.Prior()
 				} else {
 					// Set to upgradeable
 					rt.TransitionState = Upgradable
 				}
 
 				if rt.TransitionState == Replaceable || rt.TransitionState == Upgradable {
-					if err := rt.CalculateDiff(session); err != nil {
+					if err := rt.CalculateDiff(ctx, session); err != nil {
 						return nil, err
 					}
 					if !rt.Changed {
@@ -234,10 +387,35 @@ func (cmd *RollbackCmd) ComputeRollback(
 			}
 		}
 		if !releaseExists {
-			//There is no existing releases, we can't roll to this
-			return nil, errors.WithFields(errors.Fields{
-				"ReleaseName": rt.ReleaseMeta.ReleaseName,
-			}).New("Cannot roll to release, it doesn't exist")
+			// The release was purged since the version, it is reinstalled from the chart stored with the version
+			stored, config, err := cmd.storedRelease(target, releaseName)
+			if err != nil {
+				return nil, err
+			}
+			if stored == nil {
+				return nil, errors.WithFields(errors.Fields{
+					"ReleaseName": rt.ReleaseMeta.ReleaseName,
+				}).New("Cannot roll to release, it doesn't exist and the version holds no chart for it")
+			}
+			rt.TransitionState = Installable
+			rt.ReleaseVersion = &cluster.Version{
+				Name:   releaseName,
+				Chart:  stored,
+				Config: config,
+			}
+			if entry, ok := entries[releaseName]; ok {
+				rt.ReleaseVersion.Namespace = entry.Namespace
+				rt.ReleaseVersion.TillerNamespace = entry.TillerNamespace
+			}
+			rt.ReleaseMeta.Chart = stored
+			rt.ReleaseMeta.Config = config
+			rt.ReleaseMeta.ValueOverrides = []byte(config.Raw)
+			rt.ReleaseMeta.Namespace = rt.ReleaseVersion.Namespace
+			rt.ReleaseMeta.TillerNamespace = rt.ReleaseVersion.TillerNamespace
+		}
+		if entry, ok := entries[releaseName]; ok {
+			rt.ReleaseVersion.MetaName = entry.Chart
+			rt.ReleaseVersion.ChartGroup = entry.ChartGroup
 		}
 
 		log.WithFields(log.Fields{
@@ -263,43 +441,197 @@ func (cmd *RollbackCmd) ComputeRollback(
 		if _, ok := rollbackReleaseList[rel.ReleaseName]; ok {
 			continue
 		}
+		if !selected(rel.ReleaseName) {
+			continue
+		}
 
+		// The deleted release is kept in the transaction so a cancel can restore it
 		rv := &cluster.Version{
-			Name:      rel.ReleaseName,
-			Namespace: rel.Namespace,
+			Name:             rel.ReleaseName,
+			Namespace:        rel.Namespace,
+			Revision:         rel.Revision,
+			PreviousRevision: rel.Revision,
+			TillerNamespace:  rel.TillerNamespace,
+			Prior:            rel.Prior(),
+			Removed:          true,
 		}
 		rts.Data = append(rts.Data, &RollbackTarget{
 			ReleaseMeta: &cluster.ReleaseMeta{
-				ReleaseName: rel.ReleaseName,
+				ReleaseName:     rel.ReleaseName,
+				TillerNamespace: rel.TillerNamespace,
 			},
 			ReleaseVersion:  rv,
 			TransitionState: Deletable,
 		})
-		rv.SetModified()
+		transaction.Versions().AddReleaseVersion(rv)
+	}
+
+	if !cmd.Partial() {
+		return rts, nil
+	}
+	if len(rts.Data) == 0 {
+		return nil, errors.WithFields(errors.Fields{
+			"Charts":      strings.Join(cmd.Charts, ","),
+			"ChartGroups": strings.Join(cmd.ChartGroups, ","),
+		}).New("no release matches the selected charts or chart groups")
+	}
+	// Releases left out of a partial rollback keep their current revision in the new version
+	for _, rel := range currentReleases {
+		if rel.Status == cluster.Status_DELETED || selected(rel.ReleaseName) {
+			continue
+		}
+		rv := &cluster.Version{
+			Name:             rel.ReleaseName,
+			Namespace:        rel.Namespace,
+			Revision:         rel.Revision,
+			PreviousRevision: rel.Revision,
+			TillerNamespace:  rel.TillerNamespace,
+			Chart:            rel.Chart,
+			Config:           rel.Config,
+		}
+		if entry, ok := latestEntries[rel.ReleaseName]; ok {
+			rv.MetaName = entry.Chart
+			rv.ChartGroup = entry.ChartGroup
+		}
+		rts.Data = append(rts.Data, &RollbackTarget{
+			ReleaseMeta: &cluster.ReleaseMeta{
+				ReleaseName:     rel.ReleaseName,
+				Namespace:       rel.Namespace,
+				TillerNamespace: rel.TillerNamespace,
+			},
+			ReleaseVersion:  rv,
+			Revision:        rel.Revision,
+			TransitionState: NoChange,
+		})
+		transaction.Versions().AddReleaseVersion(rv)
 	}
 	return rts, nil
 }
 
-func (rts *RollbackTargets) Diff(session cluster.Sessioner) (*RollbackTargets, error) {
+// versionSource returns the source of the version written by the rollback
+// a full rollback records the manifest and chart sources of target, a partial rollback records the
+// chart sources of the rolled back releases from target and those of the other releases from the latest version
+func (cmd *RollbackCmd) versionSource(target *cluster.Version, rts *RollbackTargets) *cluster.VersionSource {
+	source := newVersionSource()
+	targetSource := storedSource(target)
+	if !cmd.Partial() {
+		if targetSource != nil {
+			source.Manifest = targetSource.Manifest
+			source.Charts = targetSource.Charts
+		}
+		return source
+	}
+	latestSource := storedSource(cmd.latest)
+	for _, rt := range rts.Data {
+		from := targetSource
+		if rt.TransitionState == NoChange {
+			from = latestSource
+		}
+		if from == nil {
+			continue
+		}
+		if cs := from.Chart(rt.ReleaseVersion.MetaName); cs != nil {
+			source.Charts = append(source.Charts, cs)
+		}
+	}
+	return source
+}
+
+// storedSource returns the source recorded with version, nil when there is none or it cannot be read
+func storedSource(v *cluster.Version) *cluster.VersionSource {
+	if v == nil {
+		return nil
+	}
+	source, err := v.Source()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ManifestVersion": v.Revision,
+			"Error":           err.Error(),
+		}).Warn("Failed to read the source of version")
+		return nil
+	}
+	return source
+}
+
+// Partial reports whether only the releases of selected charts or chart groups are rolled back
+func (cmd *RollbackCmd) Partial() bool {
+	return len(cmd.Charts) > 0 || len(cmd.ChartGroups) > 0
+}
+
+// selects reports whether the rollback includes a release, by the chart or chart group recorded for it
+// releases of versions that recorded no chart are selected by release name
+func (cmd *RollbackCmd) selects(releaseName string, entry *cluster.ReleaseEntry) bool {
+	if !cmd.Partial() {
+		return true
+	}
+	chartName, group := releaseName, ""
+	if entry != nil {
+		if entry.Chart != "" {
+			chartName = entry.Chart
+		}
+		group = entry.ChartGroup
+	}
+	for _, c := range cmd.Charts {
+		if c == chartName || c == releaseName {
+			return true
+		}
+	}
+	for _, g := range cmd.ChartGroups {
+		if g != "" && g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// recordedEntries returns the releases recorded in version keyed by release name
+// versions written before release entries were recorded only hold the release table
+func recordedEntries(version *cluster.Version) map[string]*cluster.ReleaseEntry {
+	entries := make(map[string]*cluster.ReleaseEntry)
+	if version == nil {
+		return entries
+	}
+	if list, err := version.ReleaseEntries(); err == nil {
+		for _, entry := range list {
+			entries[entry.Name] = entry
+		}
+	}
+	return entries
+}
+
+// checkpoint records the release changes made so far in the transaction journal, if there is one
+func (rts *RollbackTargets) checkpoint() error {
+	if rts.transaction == nil {
+		return nil
+	}
+	if err := rts.transaction.Checkpoint(); err != nil {
+		return errors.Wrap(err, "failed to record transaction journal")
+	}
+	return nil
+}
+
+func (rts *RollbackTargets) Diff(ctx context.Context, session cluster.Sessioner) (*RollbackTargets, error) {
 	for _, v := range rts.Data {
 		v.ReleaseMeta.DryRun = true
-		if err := v.CalculateDiff(session); err != nil {
+		if err := v.CalculateDiff(ctx, session); err != nil {
 			return rts, err
 		}
 	}
 	return rts, nil
 }
 
-func (rt *RollbackTarget) CalculateDiff(session cluster.Sessioner) error {
+func (rt *RollbackTarget) CalculateDiff(ctx context.Context, session cluster.Sessioner) error {
 	var err error
 
 	switch rt.TransitionState {
 	case Upgradable, Replaceable:
-		rt.Changed, rt.Diff, err = session.DiffRelease(&cluster.ReleaseMeta{
-			Chart:          rt.ReleaseVersion.Chart,
-			ReleaseName:    rt.ReleaseVersion.Name,
-			Namespace:      rt.ReleaseVersion.Namespace,
-			ValueOverrides: []byte(rt.ReleaseMeta.Config.Raw),
+		rt.Changed, rt.Diff, err = session.DiffRelease(ctx, &cluster.ReleaseMeta{
+			Chart:           rt.ReleaseVersion.Chart,
+			ReleaseName:     rt.ReleaseVersion.Name,
+			Namespace:       rt.ReleaseVersion.Namespace,
+			ValueOverrides:  []byte(rt.ReleaseMeta.Config.Raw),
+			DiffOptions:     rt.ReleaseMeta.DiffOptions,
+			TillerNamespace: rt.ReleaseVersion.TillerNamespace,
 		})
 		if err != nil {
 			return err
@@ -309,6 +641,9 @@ func (rt *RollbackTarget) CalculateDiff(session cluster.Sessioner) error {
 }
 
 func (rt *RollbackTargets) LogDiff() {
+	if err := rt.WritePlan(os.Stdout); err != nil {
+		log.Error(errors.Wrap(err, "failed to write rollback plan"))
+	}
 	for _, v := range rt.Data {
 		switch v.TransitionState {
 		case Deletable:
@@ -316,7 +651,12 @@ func (rt *RollbackTargets) LogDiff() {
 				"Name":      v.ReleaseMeta.ReleaseName,
 				"Namespace": v.ReleaseMeta.Namespace,
 			}).Info("Would delete")
-		case Installable, Undeletable:
+		case Installable:
+			log.WithFields(log.Fields{
+				"Name":      v.ReleaseMeta.ReleaseName,
+				"Namespace": v.ReleaseMeta.Namespace,
+			}).Info("Would reinstall")
+		case Undeletable:
 			log.WithFields(log.Fields{
 				"Name":      v.ReleaseMeta.ReleaseName,
 				"Namespace": v.ReleaseMeta.Namespace,
@@ -336,3 +676,51 @@ func (rt *RollbackTargets) LogDiff() {
 		}
 	}
 }
+
+// WritePlan writes a table of the change the rollback makes to each release, ordered by release name
+func (rts *RollbackTargets) WritePlan(w io.Writer) error {
+	targets := make([]*RollbackTarget, len(rts.Data))
+	copy(targets, rts.Data)
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].ReleaseMeta.ReleaseName < targets[j].ReleaseMeta.ReleaseName
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RELEASE\tFROM REVISION\tTO REVISION\tACTION")
+	for _, rt := range targets {
+		from := int32(0)
+		if rt.ReleaseVersion != nil {
+			from = rt.ReleaseVersion.PreviousRevision
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			rt.ReleaseMeta.ReleaseName,
+			planRevision(from),
+			planRevision(rt.Revision),
+			rt.Action())
+	}
+	return tw.Flush()
+}
+
+// Action names the change the rollback makes to the release
+func (rt *RollbackTarget) Action() string {
+	switch rt.TransitionState {
+	case Upgradable, Replaceable:
+		return "rollback"
+	case Undeletable:
+		return "undelete"
+	case Installable:
+		return "reinstall"
+	case Deletable:
+		return "delete"
+	default:
+		return "none"
+	}
+}
+
+// planRevision formats a revision of the rollback plan, a release without one is shown as -
+func planRevision(revision int32) string {
+	if revision == 0 {
+		return "-"
+	}
+	return strconv.Itoa(int(revision))
+}
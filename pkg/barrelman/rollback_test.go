@@ -1,11 +1,17 @@
 package barrelman
 
 import (
+	"bytes"
+	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/charter-oss/barrelman/pkg/cluster"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 
 	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
 	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
@@ -35,6 +41,7 @@ func TestRollbackCmd(t *testing.T) {
 		rollbackCmd := newRollbackCmd()
 		session := &mocks.Sessioner{}
 		mockTransaction := &mocks.Transactioner{}
+		mockTransaction.On("Checkpoint").Return(nil).Maybe()
 
 		Convey("Should error on session.Init()", func() {
 			session.On("Init").Return(errors.New("simulated Init error"))
@@ -43,17 +50,328 @@ func TestRollbackCmd(t *testing.T) {
 			So(err.Error(), ShouldContainSubstring, "simulated")
 			session.AssertExpectations(t)
 		})
-		Convey("this", func() {
+		Convey("Should error without a previous version", func() {
 			session.On("Init").Return(nil)
+			session.On("Lock", rollbackCmd.ManifestName).Return(newTestUnlocker(), nil)
+			session.On("GetJournal", rollbackCmd.ManifestName).Return(nil, nil).Maybe()
 			session.On("GetKubeConfig").Return(rollbackCmd.Options.KubeConfigFile).Maybe()
 			session.On("GetKubeContext").Return(rollbackCmd.Options.KubeContext).Maybe()
 			session.On("GetVersions", rollbackCmd.ManifestName).Return(&cluster.Versions{}, nil)
 			session.On("NewTransaction", rollbackCmd.ManifestName).Return(mockTransaction, nil)
 			mockTransaction.On("Cancel").Return(nil)
-			mockTransaction.On("Complete").Return(nil)
+			err := rollbackCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no previous version")
+			session.AssertExpectations(t)
+		})
+		Convey("Should roll back to the previous version by default", func() {
+			versions := cluster.NewVersions(rollbackCmd.ManifestName)
+			versions.Data = append(versions.Data,
+				newTestVersion(1, &cluster.Version{Name: "app", Revision: 1}),
+				newTestVersion(2, &cluster.Version{Name: "app", Revision: 2}),
+			)
+			session.On("Init").Return(nil)
+			session.On("Lock", rollbackCmd.ManifestName).Return(newTestUnlocker(), nil)
+			session.On("GetJournal", rollbackCmd.ManifestName).Return(nil, nil).Maybe()
+			session.On("GetVersions", rollbackCmd.ManifestName).Return(versions, nil)
+			session.On("NewTransaction", rollbackCmd.ManifestName).Return(mockTransaction, nil)
+			session.On("ReleasesByManifest", mock.Anything, rollbackCmd.ManifestName).Return(map[string]*cluster.ReleaseMeta{
+				"app": &cluster.ReleaseMeta{ReleaseName: "app", Revision: 2, Status: cluster.Status_DEPLOYED},
+			}, nil)
+			session.On("GetRelease", mock.Anything, "app", int32(1)).Return(&cluster.ReleaseMeta{
+				Chart:  &chart.Chart{},
+				Config: &chart.Config{Raw: "setting: one\n"},
+			}, nil)
+			session.On("DiffRelease", mock.Anything, mock.Anything).Return(true, []byte("setting"), nil)
+			session.On("RollbackRelease", mock.Anything, mock.MatchedBy(func(m *cluster.RollbackMeta) bool {
+				return m.ReleaseName == "app" && m.Revision == 1
+			})).Return(int32(3), nil).Once()
+			written := cluster.NewVersions(rollbackCmd.ManifestName)
+			mockTransaction.On("Versions").Return(written)
+			mockTransaction.On("Cancel").Return(nil)
+			mockTransaction.On("Complete").Return(nil).Once()
 			err := rollbackCmd.Run(session)
 			So(err, ShouldBeNil)
+			So(written.Data, ShouldHaveLength, 1)
+			So(written.Data[0].Name, ShouldEqual, "app")
+			So(written.Data[0].Revision, ShouldEqual, 3)
+			session.AssertExpectations(t)
+			mockTransaction.AssertExpectations(t)
+		})
+		Convey("Should leave the transaction to recover when the lock is lost", func() {
+			versions := cluster.NewVersions(rollbackCmd.ManifestName)
+			versions.Data = append(versions.Data,
+				newTestVersion(1, &cluster.Version{Name: "app", Revision: 1}),
+				newTestVersion(2, &cluster.Version{Name: "app", Revision: 2}),
+			)
+			lost := make(chan struct{})
+			unlocker := &mocks.Unlocker{}
+			unlocker.On("Unlock").Return(nil)
+			unlocker.On("Lost").Return((<-chan struct{})(lost))
+			session.On("Init").Return(nil)
+			session.On("Lock", rollbackCmd.ManifestName).Return(unlocker, nil)
+			session.On("GetJournal", rollbackCmd.ManifestName).Return(nil, nil).Maybe()
+			session.On("GetVersions", rollbackCmd.ManifestName).Return(versions, nil)
+			session.On("NewTransaction", rollbackCmd.ManifestName).Return(mockTransaction, nil)
+			session.On("ReleasesByManifest", mock.Anything, rollbackCmd.ManifestName).Return(map[string]*cluster.ReleaseMeta{
+				"app": &cluster.ReleaseMeta{ReleaseName: "app", Revision: 2, Status: cluster.Status_DEPLOYED},
+			}, nil)
+			session.On("GetRelease", mock.Anything, "app", int32(1)).Return(&cluster.ReleaseMeta{
+				Chart:  &chart.Chart{},
+				Config: &chart.Config{Raw: "setting: one\n"},
+			}, nil)
+			session.On("DiffRelease", mock.Anything, mock.Anything).Return(true, []byte("setting"), nil)
+			session.On("RollbackRelease", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				// the lease is taken over while the release is rolled back
+				close(lost)
+				<-args.Get(0).(context.Context).Done()
+			}).Return(int32(0), context.Canceled).Once()
+			mockTransaction.On("Versions").Return(cluster.NewVersions(rollbackCmd.ManifestName))
+			err := rollbackCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "barrelman recover")
+			mockTransaction.AssertNotCalled(t, "Cancel")
+		})
+		Convey("Should verify the cluster guard of the latest version", func() {
+			versions := cluster.NewVersions(rollbackCmd.ManifestName)
+			versions.Data = append(versions.Data,
+				newTestVersion(1, &cluster.Version{Name: "app", Revision: 1}),
+				withManifestSource(newTestVersion(2, &cluster.Version{Name: "app", Revision: 2}), guardedManifest),
+			)
+			session.On("Init").Return(nil)
+			session.On("Lock", rollbackCmd.ManifestName).Return(newTestUnlocker(), nil)
+			session.On("GetJournal", rollbackCmd.ManifestName).Return(nil, nil).Maybe()
+			session.On("GetKubeContext").Return("staging").Maybe()
+			session.On("GetVersions", rollbackCmd.ManifestName).Return(versions, nil)
+
+			Convey("Refuses another cluster", func() {
+				session.On("VerifyCluster", &cluster.ClusterGuard{Labels: map[string]string{"env": "prod"}}).
+					Return(errors.New("cluster label env is staging")).Once()
+				err := rollbackCmd.Run(session)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "cluster guard failed")
+				session.AssertNotCalled(t, "ReleasesByManifest", mock.Anything, mock.Anything)
+			})
+			Convey("Is skipped with IgnoreClusterGuard", func() {
+				rollbackCmd.Options.IgnoreClusterGuard = true
+				session.On("NewTransaction", rollbackCmd.ManifestName).Return(mockTransaction, nil)
+				session.On("ReleasesByManifest", mock.Anything, rollbackCmd.ManifestName).
+					Return(nil, errors.New("simulated ReleasesByManifest error"))
+				mockTransaction.On("Cancel").Return(nil)
+				err := rollbackCmd.Run(session)
+				So(err.Error(), ShouldContainSubstring, "simulated ReleasesByManifest")
+				session.AssertNotCalled(t, "VerifyCluster", mock.Anything)
+			})
+		})
+		Convey("Should error when the manifest is locked", func() {
+			session.On("Init").Return(nil)
+			session.On("Lock", rollbackCmd.ManifestName).Return(nil, errors.New("manifest is locked by another operation"))
+			err := rollbackCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "locked")
+			session.AssertExpectations(t)
+		})
+	})
+}
+
+func TestComputeRollback(t *testing.T) {
+	Convey("ComputeRollback", t, func() {
+		rollbackCmd := &RollbackCmd{
+			ManifestName: "testManifest",
+			Config:       &Config{},
+		}
+		session := &mocks.Sessioner{}
+		transaction := &mocks.Transactioner{}
+		transaction.On("Checkpoint").Return(nil).Maybe()
+		transaction.On("Versions").Return(cluster.NewVersions("testManifest"))
+		purgedChart := &chart.Chart{Metadata: &chart.Metadata{Name: "cache", Version: "1.0.0"}}
+		target := newTestVersion(4,
+			&cluster.Version{Name: "app", Namespace: "apps", Revision: 2, Chart: &chart.Chart{}, Config: &chart.Config{}},
+			&cluster.Version{Name: "cache", Namespace: "apps", Revision: 3, Chart: purgedChart, Config: &chart.Config{Raw: "size: 2\n"}, Modified: true},
+		)
+		current := map[string]*cluster.ReleaseMeta{
+			"app":    &cluster.ReleaseMeta{ReleaseName: "app", Revision: 5, Status: cluster.Status_DEPLOYED},
+			"worker": &cluster.ReleaseMeta{ReleaseName: "worker", Revision: 1, Status: cluster.Status_DEPLOYED},
+		}
+		session.On("GetRelease", mock.Anything, "app", int32(2)).Return(&cluster.ReleaseMeta{
+			Chart:  &chart.Chart{},
+			Config: &chart.Config{},
+		}, nil)
+		session.On("DiffRelease", mock.Anything, mock.Anything).Return(true, []byte{}, nil)
+
+		rts, err := rollbackCmd.ComputeRollback(context.Background(), session, transaction, target, current)
+		So(err, ShouldBeNil)
+		states := map[string]TransitionState{}
+		for _, rt := range rts.Data {
+			states[rt.ReleaseMeta.ReleaseName] = rt.TransitionState
+		}
+		So(states, ShouldResemble, map[string]TransitionState{
+			"app":    Upgradable,
+			"cache":  Installable,
+			"worker": Deletable,
+		})
+
+		Convey("Reinstalls purged releases from the chart stored in the version", func() {
+			var reinstall *RollbackTarget
+			for _, rt := range rts.Data {
+				if rt.TransitionState == Installable {
+					reinstall = rt
+				}
+			}
+			So(reinstall.ReleaseMeta.Chart.Metadata.Name, ShouldEqual, "cache")
+			So(string(reinstall.ReleaseMeta.ValueOverrides), ShouldEqual, "size: 2\n")
+			So(reinstall.ReleaseMeta.Namespace, ShouldEqual, "apps")
+
+			rts.Data = []*RollbackTarget{reinstall}
+			rts.retry = &cluster.RetryPolicy{Attempts: 1}
+			session.On("InstallRelease", mock.Anything, mock.MatchedBy(func(m *cluster.ReleaseMeta) bool {
+				return m.ReleaseName == "cache" && !m.DryRun && m.InstallTimeout == 2*time.Minute
+			}), "testManifest").Return(&cluster.InstallReleaseResponse{ReleaseVersion: 1}, nil).Once()
+			So(rts.Apply(context.Background()), ShouldBeNil)
+			So(reinstall.ReleaseVersion.Modified, ShouldBeTrue)
+			So(reinstall.ReleaseVersion.PreviousRevision, ShouldEqual, 0)
 			session.AssertExpectations(t)
 		})
+		Convey("Writes the plan table", func() {
+			out := &bytes.Buffer{}
+			So(rts.WritePlan(out), ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 4)
+			So(strings.Fields(lines[0]), ShouldResemble, []string{"RELEASE", "FROM", "REVISION", "TO", "REVISION", "ACTION"})
+			So(strings.Fields(lines[1]), ShouldResemble, []string{"app", "5", "2", "rollback"})
+			So(strings.Fields(lines[2]), ShouldResemble, []string{"cache", "-", "3", "reinstall"})
+			So(strings.Fields(lines[3]), ShouldResemble, []string{"worker", "1", "-", "delete"})
+		})
+		Convey("Reinstalls purged releases from the chart stored with the version that changed them", func() {
+			changed := newTestVersion(3,
+				&cluster.Version{Name: "cache", Namespace: "apps", Revision: 3, Chart: purgedChart, Config: &chart.Config{Raw: "size: 2\n"}, Modified: true},
+			)
+			unchanged := newTestVersion(4,
+				&cluster.Version{Name: "cache", Namespace: "apps", Revision: 3, Chart: purgedChart, Config: &chart.Config{Raw: "size: 2\n"}},
+			)
+			rollbackCmd.versions = &cluster.Versions{Name: "testManifest", Data: []*cluster.Version{changed, unchanged}}
+			rts, err := rollbackCmd.ComputeRollback(context.Background(), session, transaction, unchanged, map[string]*cluster.ReleaseMeta{})
+			So(err, ShouldBeNil)
+			So(rts.Data, ShouldHaveLength, 1)
+			So(rts.Data[0].TransitionState, ShouldEqual, Installable)
+			So(rts.Data[0].ReleaseMeta.Chart.Metadata.Name, ShouldEqual, "cache")
+			So(string(rts.Data[0].ReleaseMeta.ValueOverrides), ShouldEqual, "size: 2\n")
+		})
+		Convey("Fails on a purged release without a stored chart", func() {
+			target := newTestVersion(4, &cluster.Version{Name: "cache", Revision: 3})
+			_, err := rollbackCmd.ComputeRollback(context.Background(), session, transaction, target, map[string]*cluster.ReleaseMeta{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "holds no chart")
+		})
+	})
+}
+
+func TestComputePartialRollback(t *testing.T) {
+	Convey("ComputeRollback of selected charts", t, func() {
+		session := &mocks.Sessioner{}
+		versions := cluster.NewVersions("testManifest")
+		transaction := &mocks.Transactioner{}
+		transaction.On("Checkpoint").Return(nil).Maybe()
+		transaction.On("Versions").Return(versions)
+		target := newTestVersion(4,
+			&cluster.Version{Name: "api", Namespace: "apps", Revision: 2, MetaName: "api", ChartGroup: "backend"},
+			&cluster.Version{Name: "worker", Namespace: "apps", Revision: 3, MetaName: "worker", ChartGroup: "backend"},
+			&cluster.Version{Name: "web", Namespace: "apps", Revision: 1, MetaName: "web", ChartGroup: "frontend"},
+		)
+		latest := newTestVersion(6,
+			&cluster.Version{Name: "api", Namespace: "apps", Revision: 5, MetaName: "api", ChartGroup: "backend"},
+			&cluster.Version{Name: "worker", Namespace: "apps", Revision: 4, MetaName: "worker", ChartGroup: "backend"},
+			&cluster.Version{Name: "web", Namespace: "apps", Revision: 7, MetaName: "web", ChartGroup: "frontend"},
+		)
+		current := map[string]*cluster.ReleaseMeta{
+			"api":    &cluster.ReleaseMeta{ReleaseName: "api", Namespace: "apps", Revision: 5, Status: cluster.Status_DEPLOYED},
+			"worker": &cluster.ReleaseMeta{ReleaseName: "worker", Namespace: "apps", Revision: 4, Status: cluster.Status_DEPLOYED},
+			"web":    &cluster.ReleaseMeta{ReleaseName: "web", Namespace: "apps", Revision: 7, Status: cluster.Status_DEPLOYED},
+		}
+		session.On("GetRelease", mock.Anything, mock.Anything, mock.Anything).Return(&cluster.ReleaseMeta{
+			Chart:  &chart.Chart{},
+			Config: &chart.Config{},
+		}, nil)
+		session.On("DiffRelease", mock.Anything, mock.Anything).Return(true, []byte{}, nil)
+		revisions := func(rts *RollbackTargets) map[string]int32 {
+			planned := map[string]int32{}
+			for _, rt := range rts.Data {
+				planned[rt.ReleaseMeta.ReleaseName] = rt.Revision
+			}
+			return planned
+		}
+
+		Convey("Rolls back only the selected charts", func() {
+			rollbackCmd := &RollbackCmd{ManifestName: "testManifest", Config: &Config{}, Charts: []string{"api"}, latest: latest}
+			rts, err := rollbackCmd.ComputeRollback(context.Background(), session, transaction, target, current)
+			So(err, ShouldBeNil)
+			states := map[string]TransitionState{}
+			for _, rt := range rts.Data {
+				states[rt.ReleaseMeta.ReleaseName] = rt.TransitionState
+			}
+			So(states, ShouldResemble, map[string]TransitionState{
+				"api":    Upgradable,
+				"worker": NoChange,
+				"web":    NoChange,
+			})
+			So(revisions(rts), ShouldResemble, map[string]int32{"api": 2, "worker": 4, "web": 7})
+			So(versions.Data, ShouldHaveLength, 3)
+
+			entries, err := versions.RawReleaseTable()
+			So(err, ShouldBeNil)
+			So(entries, ShouldContainSubstring, "ChartGroup: frontend")
+		})
+		Convey("Rolls back the releases of the selected chart groups", func() {
+			rollbackCmd := &RollbackCmd{ManifestName: "testManifest", Config: &Config{}, ChartGroups: []string{"backend"}, latest: latest}
+			rts, err := rollbackCmd.ComputeRollback(context.Background(), session, transaction, target, current)
+			So(err, ShouldBeNil)
+			So(revisions(rts), ShouldResemble, map[string]int32{"api": 2, "worker": 3, "web": 7})
+		})
+		Convey("Fails when nothing is selected", func() {
+			rollbackCmd := &RollbackCmd{ManifestName: "testManifest", Config: &Config{}, Charts: []string{"missing"}, latest: latest}
+			_, err := rollbackCmd.ComputeRollback(context.Background(), session, transaction, target, current)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no release matches")
+		})
 	})
 }
+
+// guardedManifest is the source of a manifest declaring a cluster guard
+const guardedManifest = `---
+schema: barrelman/Manifest/v1
+metadata:
+  schema: metadata/Document/v1
+  name: testManifest
+data:
+  cluster:
+    labels:
+      env: prod
+`
+
+// withManifestSource records manifest as the source version was applied from
+func withManifestSource(v *cluster.Version, manifest string) *cluster.Version {
+	source := newVersionSource()
+	source.Manifest = []byte(manifest)
+	files, err := source.Files()
+	So(err, ShouldBeNil)
+	v.Chart.Files = append(v.Chart.Files, files...)
+	return v
+}
+
+// newTestVersion returns a manifest version recording releases as written by a transaction
+func newTestVersion(revision int32, releases ...*cluster.Version) *cluster.Version {
+	versions := cluster.NewVersions("testManifest")
+	versions.Data = releases
+	raw, err := versions.RawReleaseTable()
+	So(err, ShouldBeNil)
+	files, err := versions.ReleaseFiles()
+	So(err, ShouldBeNil)
+	return &cluster.Version{
+		Name:     "testManifest",
+		Revision: revision,
+		Chart: &chart.Chart{
+			Values: &chart.Config{Raw: raw, Values: versions.ChartValues()},
+			Files:  files,
+		},
+	}
+}
@@ -0,0 +1,206 @@
+package barrelman
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/manifest"
+	"github.com/charter-oss/barrelman/pkg/version"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// Target statuses reported after a multi-cluster apply
+const (
+	TargetApplied = "applied"
+	TargetFailed  = "failed"
+	TargetSkipped = "skipped"
+)
+
+// SessionFactory returns a new session for the cluster of a kube context
+type SessionFactory func(kubeContext string) (cluster.Sessioner, error)
+
+// TargetResult reports the apply of the manifest to one cluster
+type TargetResult struct {
+	Target      string
+	KubeContext string
+	Status      string
+	Duration    time.Duration
+	Err         error
+}
+
+// Targets returns the clusters the manifest is applied to, nil when it is applied to a single cluster
+// --contexts selects targets of the manifest Targets document by name or kube context
+func (cmd *ApplyCmd) Targets() (*manifest.Targets, error) {
+	targets, err := manifest.LoadTargets(cmd.Options.ManifestFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load targets")
+	}
+	if len(cmd.Options.Contexts) > 0 {
+		targets = targets.Select(cmd.Options.Contexts)
+	}
+	if targets == nil {
+		return nil, nil
+	}
+	switch cmd.Options.Strategy {
+	case "":
+	case manifest.StrategySequential, manifest.StrategyParallel:
+		targets.Data.Strategy = cmd.Options.Strategy
+	default:
+		return nil, errors.WithFields(errors.Fields{
+			"Strategy": cmd.Options.Strategy,
+		}).New("unknown strategy, expected one of [ sequential | parallel ]")
+	}
+	return targets, nil
+}
+
+// RunTargets applies the manifest to every target cluster, each cluster keeps its own versions and journal
+// the charts are synced once, sequential rollouts halt on the first failed cluster
+func (cmd *ApplyCmd) RunTargets(targets *manifest.Targets, newSession SessionFactory) error {
+	var err error
+
+	log.Rep(version.Get()).Info("Barrelman")
+
+	cmd.Config, err = GetConfigFromFile(cmd.Options.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "got error while loading config")
+	}
+
+	if err := ensureWorkDir(cmd.Options.DataDir); err != nil {
+		return errors.Wrap(err, "failed to create working directory")
+	}
+
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	syncCtx, cancelSync := phaseContext(ctx, cmd.Options.SyncTimeout)
+	mfest, err := syncManifest(syncCtx, &manifest.Config{
+		DataDir:      cmd.Options.DataDir,
+		ManifestFile: cmd.Options.ManifestFile,
+		AccountTable: cmd.Config.Account,
+	}, cmd.Options.NoSync)
+	err = timeoutError(syncCtx, "sync", err)
+	cancelSync()
+	if err != nil {
+		return errors.Wrap(err, "apply failed")
+	}
+
+	// Archives are created up front, packaging checks out chart sources and must not run concurrently
+	list := targets.Data.Targets
+	archives := make([]*manifest.ArchiveFiles, len(list))
+	for i, target := range list {
+		archives[i], err = mfest.CreateTargetArchives(target)
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"Target": target.Name,
+			}).Wrap(err, "failed to create archives")
+		}
+	}
+
+	results := make([]*TargetResult, len(list))
+	switch targets.Strategy() {
+	case manifest.StrategyParallel:
+		var wg sync.WaitGroup
+		for i := range list {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = cmd.applyTarget(ctx, list[i], newSession, mfest, archives[i])
+			}(i)
+		}
+		wg.Wait()
+	default:
+		for i := range list {
+			if i > 0 && results[i-1].Status != TargetApplied {
+				results[i] = &TargetResult{
+					Target:      list[i].Name,
+					KubeContext: list[i].KubeContext,
+					Status:      TargetSkipped,
+				}
+				continue
+			}
+			results[i] = cmd.applyTarget(ctx, list[i], newSession, mfest, archives[i])
+		}
+	}
+	return reportTargets(mfest.Name, results)
+}
+
+// applyTarget applies the archives to the cluster of target through a new session
+func (cmd *ApplyCmd) applyTarget(
+	ctx context.Context,
+	target *manifest.Target,
+	newSession SessionFactory,
+	mfest *manifest.Manifest,
+	archives *manifest.ArchiveFiles) *TargetResult {
+	result := &TargetResult{
+		Target:      target.Name,
+		KubeContext: target.KubeContext,
+		Status:      TargetFailed,
+	}
+	start := time.Now()
+	result.Err = func() error {
+		log.WithFields(log.Fields{
+			"Target":      target.Name,
+			"KubeContext": target.KubeContext,
+		}).Info("Applying manifest to cluster")
+		session, err := newSession(target.KubeContext)
+		if err != nil {
+			return err
+		}
+		if err := session.Init(); err != nil {
+			return errors.Wrap(err, "failed to create new cluster session")
+		}
+		guard := mfest.Data.Cluster
+		if target.Cluster != nil {
+			guard = target.Cluster
+		}
+		return cmd.applyArchives(ctx, session, mfest, archives, guard)
+	}()
+	result.Duration = time.Since(start)
+	if result.Err == nil {
+		result.Status = TargetApplied
+	}
+	return result
+}
+
+// reportTargets logs the result of each cluster and returns an error naming the clusters that were not applied
+func reportTargets(manifestName string, results []*TargetResult) error {
+	notApplied := []string{}
+	for _, r := range results {
+		fields := log.Fields{
+			"Target":      r.Target,
+			"KubeContext": r.KubeContext,
+			"Status":      r.Status,
+			"Duration":    r.Duration.Round(time.Millisecond).String(),
+		}
+		if r.Err != nil {
+			fields["Error"] = r.Err.Error()
+		}
+		switch r.Status {
+		case TargetApplied:
+			log.WithFields(fields).Info("Cluster apply result")
+		case TargetSkipped:
+			log.WithFields(fields).Warn("Cluster apply result")
+		default:
+			log.WithFields(fields).Error("Cluster apply result")
+		}
+		if r.Status != TargetApplied {
+			notApplied = append(notApplied, r.Target)
+		}
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": manifestName,
+		"Applied":      len(results) - len(notApplied),
+		"Clusters":     len(results),
+	}).Info("Multi-cluster apply complete")
+	if len(notApplied) > 0 {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+			"Targets":      strings.Join(notApplied, ","),
+		}).New("manifest was not applied to every cluster")
+	}
+	return nil
+}
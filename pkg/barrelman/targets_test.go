@@ -0,0 +1,92 @@
+package barrelman
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+	"github.com/charter-oss/barrelman/pkg/manifest"
+	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
+)
+
+func TestApplyTargets(t *testing.T) {
+	Convey("Multi-cluster apply", t, func() {
+		applyCmd := &ApplyCmd{
+			Options: &CmdOptions{
+				Force:        &[]string{},
+				DataDir:      "testdata/datadir",
+				ConfigFile:   "testdata/config",
+				ManifestFile: "testdata/targets-manifest.yaml",
+			},
+		}
+		var mu sync.Mutex
+		contexts := []string{}
+		newSession := func(kubeContext string) (cluster.Sessioner, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			contexts = append(contexts, kubeContext)
+			session := &mocks.Sessioner{}
+			session.On("Init").Return(errors.New("simulated Init error"))
+			return session, nil
+		}
+
+		Convey("Reads the targets of the manifest", func() {
+			targets, err := applyCmd.Targets()
+			So(err, ShouldBeNil)
+			So(targets.Data.Targets, ShouldHaveLength, 2)
+			So(targets.Strategy(), ShouldEqual, manifest.StrategySequential)
+		})
+		Convey("Selects targets with --contexts and --strategy", func() {
+			applyCmd.Options.Contexts = []string{"us-west"}
+			applyCmd.Options.Strategy = manifest.StrategyParallel
+			targets, err := applyCmd.Targets()
+			So(err, ShouldBeNil)
+			So(targets.Data.Targets, ShouldHaveLength, 1)
+			So(targets.Data.Targets[0].Name, ShouldEqual, "west")
+			So(targets.Strategy(), ShouldEqual, manifest.StrategyParallel)
+		})
+		Convey("Is not used for a manifest without targets", func() {
+			applyCmd.Options.ManifestFile = "testdata/dir-test-manifest.yaml"
+			targets, err := applyCmd.Targets()
+			So(err, ShouldBeNil)
+			So(targets, ShouldBeNil)
+		})
+		Convey("Sequential rollout halts on the first failed cluster", func() {
+			targets, err := applyCmd.Targets()
+			So(err, ShouldBeNil)
+			err = applyCmd.RunTargets(targets, newSession)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not applied to every cluster")
+			So(contexts, ShouldResemble, []string{"us-east"})
+		})
+		Convey("Parallel rollout applies every cluster", func() {
+			applyCmd.Options.Strategy = manifest.StrategyParallel
+			targets, err := applyCmd.Targets()
+			So(err, ShouldBeNil)
+			err = applyCmd.RunTargets(targets, newSession)
+			So(err, ShouldNotBeNil)
+			So(contexts, ShouldHaveLength, 2)
+			So(contexts, ShouldContain, "us-west")
+		})
+		Reset(func() {
+			chartsync.Reset()
+			os.RemoveAll(applyCmd.Options.DataDir)
+		})
+	})
+
+	Convey("reportTargets", t, func() {
+		So(reportTargets("m", []*TargetResult{
+			{Target: "east", Status: TargetApplied},
+		}), ShouldBeNil)
+		err := reportTargets("m", []*TargetResult{
+			{Target: "east", Status: TargetFailed, Err: errors.New("simulated")},
+			{Target: "west", Status: TargetSkipped},
+		})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -122,18 +122,21 @@ func (cmd *TemplateCmd) Run() error {
 		cmd.Config = GetEmptyConfig()
 	}
 
-	archives, manifestName, err := processManifest(&bfest.Config{
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	archives, mfest, err := processManifest(ctx, &bfest.Config{
 		DataDir:      cmd.Options.DataDir,
 		ManifestFile: cmd.Options.ManifestFile,
 		AccountTable: cmd.Config.Account,
 	}, cmd.Options.NoSync)
 	if err != nil {
-		return errors.Wrap(err, "template failed")
+		return errors.Wrap(timeoutError(ctx, "sync", err), "template failed")
 	}
 
 	for _, v := range archives.List {
 		log.WithDetailedReport(v).WithFields(log.Fields{
-			"ManifestName": manifestName,
+			"ManifestName": mfest.Name,
 		}).Debug("Template")
 		if err := cmd.Export(v); err != nil {
 			return errors.WithFields(errors.Fields{
@@ -163,13 +166,16 @@ func (cmd *TemplateCmd) RunWithSections(ys []*yamlpack.YamlSection) error {
 		cmd.Config = GetEmptyConfig()
 	}
 
-	archives, err := processManifestSections(&bfest.Config{
+	ctx, cancel := cmd.Options.Context()
+	defer cancel()
+
+	archives, err := processManifestSections(ctx, &bfest.Config{
 		DataDir:      cmd.Options.DataDir,
 		ManifestFile: cmd.Options.ManifestFile,
 		AccountTable: cmd.Config.Account,
 	}, ys, cmd.Options.NoSync)
 	if err != nil {
-		return errors.Wrap(err, "template failed")
+		return errors.Wrap(timeoutError(ctx, "sync", err), "template failed")
 	}
 
 	for _, v := range archives.List {
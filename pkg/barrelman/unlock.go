@@ -0,0 +1,93 @@
+package barrelman
+
+import (
+	"context"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/version"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+type UnlockCmd struct {
+	Options      *CmdOptions
+	ManifestName string
+	Force        bool
+	LogOptions   *[]string
+}
+
+// Run removes the cluster lock of a manifest, a lock still being renewed is only removed with Force
+func (cmd *UnlockCmd) Run(session cluster.Sessioner) error {
+	log.Rep(version.Get()).Info("Barrelman")
+
+	log.Debug("connecting to cluster")
+	if err := session.Init(); err != nil {
+		return errors.Wrap(err, "failed to create new cluster session")
+	}
+
+	holder, err := session.BreakLock(cmd.ManifestName, cmd.Force)
+	if err != nil {
+		return errors.Wrap(err, "failed to unlock manifest")
+	}
+	if holder == nil {
+		log.WithFields(log.Fields{
+			"ManifestName": cmd.ManifestName,
+		}).Info("Manifest is not locked")
+		return nil
+	}
+	log.Rep(holder).Info("Removed manifest lock")
+	return nil
+}
+
+// lockKey is the context key of the lock taken by lockManifest
+type lockKey struct{}
+
+// lockManifest takes the cluster lock of manifestName, the returned func releases it
+// the returned context is canceled when the lock is lost, so the operation stops before its next release,
+// see lockLost
+func lockManifest(ctx context.Context, session cluster.Sessioner, manifestName string) (context.Context, func(), error) {
+	lock, err := session.Lock(manifestName)
+	if err != nil {
+		return ctx, nil, errors.Wrap(err, "failed to lock manifest")
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, lockKey{}, lock))
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		if err := lock.Unlock(); err != nil {
+			log.WithFields(log.Fields{
+				"ManifestName": manifestName,
+				"Error":        err.Error(),
+			}).Warn("Failed to release manifest lock")
+		}
+	}, nil
+}
+
+// lockLost reports whether the lock taken by lockManifest for ctx was lost
+// another operation may hold the manifest by then, so a failed operation must not cancel its transaction
+func lockLost(ctx context.Context) bool {
+	lock, ok := ctx.Value(lockKey{}).(cluster.Unlocker)
+	if !ok {
+		return false
+	}
+	select {
+	case <-lock.Lost():
+		return true
+	default:
+		return false
+	}
+}
+
+// lockLostError reports an operation stopped by the loss of its lock, its journal is left for recover
+func lockLostError(manifestName string, err error) error {
+	return errors.WithFields(errors.Fields{
+		"ManifestName": manifestName,
+	}).Wrap(err, "manifest lock was lost, the modified releases were left as they are; "+
+		"run \"barrelman recover "+manifestName+"\" once the other operation has finished")
+}
@@ -0,0 +1,78 @@
+package barrelman
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/mocks"
+	"github.com/cirrocloud/structured/errors"
+)
+
+func newTestUnlocker() *mocks.Unlocker {
+	unlocker := &mocks.Unlocker{}
+	unlocker.On("Unlock").Return(nil)
+	unlocker.On("Lost").Return((<-chan struct{})(nil)).Maybe()
+	return unlocker
+}
+
+func TestLockManifest(t *testing.T) {
+	Convey("lockManifest", t, func() {
+		session := &mocks.Sessioner{}
+		lost := make(chan struct{})
+		unlocker := &mocks.Unlocker{}
+		unlocker.On("Unlock").Return(nil)
+		unlocker.On("Lost").Return((<-chan struct{})(lost))
+		session.On("Lock", "testManifest").Return(unlocker, nil)
+
+		ctx, unlock, err := lockManifest(context.Background(), session, "testManifest")
+		So(err, ShouldBeNil)
+		defer unlock()
+		So(ctx.Err(), ShouldBeNil)
+
+		Convey("Cancels the operation when the lock is lost", func() {
+			close(lost)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			So(ctx.Err(), ShouldEqual, context.Canceled)
+		})
+	})
+}
+
+func TestUnlockCmd(t *testing.T) {
+	Convey("Unlock Command", t, func() {
+		unlockCmd := &UnlockCmd{
+			Options:      &CmdOptions{},
+			ManifestName: "testManifest",
+		}
+		session := &mocks.Sessioner{}
+		session.On("Init").Return(nil)
+
+		Convey("Should succeed when the manifest is not locked", func() {
+			session.On("BreakLock", "testManifest", false).Return(nil, nil)
+			So(unlockCmd.Run(session), ShouldBeNil)
+			session.AssertExpectations(t)
+		})
+		Convey("Should report a lock held by another operation", func() {
+			session.On("BreakLock", "testManifest", false).Return(
+				&cluster.LockHolder{ManifestName: "testManifest", User: "ci"},
+				errors.New("manifest lock is held, use --force to remove it"))
+			err := unlockCmd.Run(session)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--force")
+			session.AssertExpectations(t)
+		})
+		Convey("Should remove the lock with force", func() {
+			unlockCmd.Force = true
+			session.On("BreakLock", "testManifest", true).Return(
+				&cluster.LockHolder{ManifestName: "testManifest", User: "ci"}, nil)
+			So(unlockCmd.Run(session), ShouldBeNil)
+			session.AssertExpectations(t)
+		})
+	})
+}
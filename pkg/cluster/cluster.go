@@ -1,6 +1,7 @@
 //go:generate mockery -name=Sessioner
 //go:generate mockery -name=Clusterer
 //go:generate mockery -name=Transactioner
+//go:generate mockery -name=Unlocker
 
 package cluster
 
@@ -10,11 +11,14 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/helm/pkg/helm"
 	helm_env "k8s.io/helm/pkg/helm/environment"
 	"k8s.io/helm/pkg/kube"
@@ -30,6 +34,11 @@ type Sessioner interface {
 	Releaser
 	Versioner
 	NewTransactioner
+	Drifter
+	Locker
+	Journaler
+	TillerPooler
+	Guarder
 }
 
 type Clusterer interface {
@@ -41,12 +50,36 @@ type Clusterer interface {
 }
 
 type Session struct {
-	Helm        helm.Interface
-	Tunnel      *kube.Tunnel
-	Clientset   kubernetes.Interface
-	kubeConfig  string
-	kubeContext string
-	settings    helm_env.EnvSettings
+	Helm      helm.Interface
+	Tunnel    *kube.Tunnel
+	Clientset kubernetes.Interface
+	Objects   *ObjectClient
+	// TillerHost connects directly to Tiller at host:port instead of port forwarding to the Tiller pod
+	TillerHost string
+	// TillerNamespace holds the Tiller pod and its release storage, TILLER_NAMESPACE or kube-system when empty
+	TillerNamespace string
+	// TillerSelector is the label selector of the Tiller pod, DefaultTillerSelector when empty
+	TillerSelector string
+	// TillerConnectionTimeout bounds connecting to Tiller, DefaultTillerConnectionTimeout when zero
+	TillerConnectionTimeout time.Duration
+	// HelmHome locates the Helm TLS files, HELM_HOME or the Helm default when empty
+	HelmHome string
+	// VersionsNamespace holds Barrelman versions, locks and journals, the Tiller namespace when empty
+	VersionsNamespace string
+	// VersionsStorage stores Barrelman versions as ConfigMaps or Secrets, see NewVersionDriver
+	VersionsStorage string
+	kubeConfig      string
+	kubeContext     string
+	settings        helm_env.EnvSettings
+	// dialTiller re-establishes the port forward to Tiller, nil when connected directly
+	dialTiller func() error
+	dialMu     sync.Mutex
+	restConfig *rest.Config
+	// tillers pools the connections to Tillers of other namespaces, keyed by namespace
+	tillers map[string]*Session
+	// releaseTillers maps release names to the namespace of the pooled Tiller they were listed from
+	releaseTillers map[string]string
+	tillerMu       sync.Mutex
 }
 
 //NewSession returns a *Session with kubernetes connections established
@@ -72,12 +105,11 @@ func (s *Session) SetKubeContext(c string) {
 //Init establishes connections to the cluster
 func (s *Session) Init() error {
 
-	tillerNamespace := os.Getenv("TILLER_NAMESPACE")
-	if tillerNamespace == "" {
-		tillerNamespace = "kube-system"
+	if err := checkVersionsStorage(s.VersionsStorage); err != nil {
+		return err
 	}
 
-	if err := s.connect(tillerNamespace); err != nil {
+	if err := s.connect(s.tillerNamespace()); err != nil {
 		return errors.Wrap(err, "connection to kubernetes failed")
 	}
 
@@ -94,13 +126,13 @@ func (s *Session) Init() error {
 	log.WithFields(log.Fields{
 		"tillerVersion":          tillerVersion.Version.SemVer,
 		"clientServerCompatible": compatible,
-		"Host":                   fmt.Sprintf(":%v", s.Tunnel.Local),
+		"Host":                   s.tillerHost(),
 	}).Debug("Connected to Tiller")
 	if !compatible {
 		return errors.WithFields(errors.Fields{
 			"tillerVersion": tillerVersion.Version.SemVer,
 			"helmVersion":   version.Version,
-			"Host":          fmt.Sprintf(":%v", s.Tunnel.Local),
+			"Host":          s.tillerHost(),
 		}).New("incompatible version numbers")
 	}
 	return nil
@@ -122,6 +154,9 @@ func (s *Session) DetailedReport() map[string]interface{} {
 
 //connect builds connections for all supported APIs
 func (s *Session) connect(namespace string) error {
+	if s.TillerHost != "" {
+		return s.connectDirect(namespace)
+	}
 
 	//static analysis suggests use of these
 	kubeContext := s.GetKubeContext()
@@ -143,20 +178,52 @@ func (s *Session) connect(namespace string) error {
 		return errors.Wrap(err, "could not get kubernetes client")
 	}
 
-	podName, err := getTillerPodName(s.Clientset, namespace)
+	s.Objects, err = NewObjectClient(config, s.Clientset)
 	if err != nil {
-		return errors.Wrap(err, "could not get Tiller pod name")
+		return err
 	}
-	const tillerPort = 44134
-	s.Tunnel = kube.NewTunnel(s.Clientset.CoreV1().RESTClient(), config, namespace, podName, tillerPort)
-	err = s.Tunnel.ForwardPort()
-	if err != nil {
-		return errors.Wrap(err, "could not get Tiller tunnel")
+
+	s.restConfig = config
+	s.dialTiller = func() error {
+		return s.forwardTiller(config, namespace)
+	}
+	return s.dialTiller()
+}
+
+// connectDirect connects to Tiller at TillerHost without a port forward
+// a Clientset and Objects already present on the session, such as the in-memory cluster used in tests, are kept
+func (s *Session) connectDirect(namespace string) error {
+	if s.Clientset == nil {
+		kubeContext := s.GetKubeContext()
+		kubeConfig := s.GetKubeConfig()
+		config, err := kube.GetConfig(kubeContext, kubeConfig).ClientConfig()
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"KubeConfig":  kubeConfig,
+				"kubeContext": kubeContext,
+			}).Wrap(err, "could not get kubernetes config for context")
+		}
+		s.Clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return errors.Wrap(err, "could not get kubernetes client")
+		}
+		s.Objects, err = NewObjectClient(config, s.Clientset)
+		if err != nil {
+			return err
+		}
+		s.restConfig = config
 	}
+	s.setTLSConfig()
+	// Tiller storage and Barrelman versions are found through the Tunnel namespace
+	s.Tunnel = &kube.Tunnel{Namespace: namespace}
+	return s.connectTiller()
+}
 
+// connectTiller creates the Helm client for the Tiller host
+func (s *Session) connectTiller() error {
 	options := []helm.Option{
-		helm.Host(fmt.Sprintf("127.0.0.1:%v", s.Tunnel.Local)),
-		helm.ConnectTimeout(5),
+		helm.Host(s.tillerHost()),
+		helm.ConnectTimeout(int64(s.tillerConnectionTimeout() / time.Second)),
 	}
 
 	if s.settings.TLSVerify || s.settings.TLSEnable {
@@ -187,6 +254,14 @@ func (s *Session) connect(namespace string) error {
 	return nil
 }
 
+// tillerHost returns the address used to reach Tiller
+func (s *Session) tillerHost() string {
+	if s.TillerHost != "" {
+		return s.TillerHost
+	}
+	return fmt.Sprintf("127.0.0.1:%v", s.Tunnel.Local)
+}
+
 func (s *Session) setTLSConfig() {
 	// Setup TLS as done in helm/cmd/helm
 
@@ -194,6 +269,9 @@ func (s *Session) setTLSConfig() {
 		s.settings.TLSEnable = true
 	}
 
+	if s.HelmHome != "" {
+		os.Setenv("HELM_HOME", s.HelmHome)
+	}
 	if os.Getenv("HELM_HOME") == "" {
 		os.Setenv("HELM_HOME", helm_env.DefaultHelmHome)
 	}
@@ -243,23 +321,51 @@ func (s *Session) connectionHealthCheck() error {
 	log.WithFields(log.Fields{
 		"tillerVersion":          tillerVersion.Version.SemVer,
 		"clientServerCompatible": compatible,
-		"Host":                   fmt.Sprintf(":%v", s.Tunnel.Local),
+		"Host":                   s.tillerHost(),
 	}).Debug("Connected to Tiller")
 
 	if !compatible {
 		return errors.WithFields(errors.Fields{
 			"tillerVersion": tillerVersion.Version.SemVer,
 			"helmVersion":   version.Version,
-			"Host":          fmt.Sprintf(":%v", s.Tunnel.Local),
+			"Host":          s.tillerHost(),
 		}).New("incompatible version numbers")
 	}
 
 	return nil
 }
 
-func getTillerPodName(client kubernetes.Interface, namespace string) (string, error) {
-	// TODO use a const for labels
-	selector := labels.Set{"app": "helm", "name": "tiller"}.AsSelector()
+// tillerNamespace returns the namespace of Tiller, TillerNamespace then TILLER_NAMESPACE then kube-system
+func (s *Session) tillerNamespace() string {
+	if s.TillerNamespace != "" {
+		return s.TillerNamespace
+	}
+	if ns := os.Getenv("TILLER_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return DefaultTillerNamespace
+}
+
+// tillerConnectionTimeout returns TillerConnectionTimeout, rounded up to the whole seconds Helm accepts
+func (s *Session) tillerConnectionTimeout() time.Duration {
+	if s.TillerConnectionTimeout <= 0 {
+		return DefaultTillerConnectionTimeout
+	}
+	return (s.TillerConnectionTimeout + time.Second - 1).Truncate(time.Second)
+}
+
+// getTillerPodName returns the first ready pod in namespace matching the label selector
+// an empty selector finds pods labeled as installed by helm init
+func getTillerPodName(client kubernetes.Interface, namespace string, tillerSelector string) (string, error) {
+	if tillerSelector == "" {
+		tillerSelector = DefaultTillerSelector
+	}
+	selector, err := labels.Parse(tillerSelector)
+	if err != nil {
+		return "", errors.WithFields(errors.Fields{
+			"Selector": tillerSelector,
+		}).Wrap(err, "invalid Tiller label selector")
+	}
 	pod, err := getFirstRunningPod(client, namespace, selector)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get first running pod")
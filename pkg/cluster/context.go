@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/cirrocloud/structured/log"
+)
+
+// abandonedCallWait bounds waiting for a release changing call that outlived its context
+var abandonedCallWait = time.Minute
+
+// callContext runs call until it returns or ctx is done, call stores its results in variables of the caller
+// Helm 2 client calls take no context, a call abandoned when ctx is done finishes in the background
+func callContext(ctx context.Context, call func() error) error {
+	done, err := startCall(ctx, call)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callContextWait runs call like callContext, but once ctx is done waits up to abandonedCallWait for call to return.
+// Calls changing a release use it, so the release is settled before the caller cancels its transaction
+// and rolls back, rather than racing a call still running in Tiller. ctx.Err() is returned either way.
+func callContextWait(ctx context.Context, call func() error) error {
+	done, err := startCall(ctx, call)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	timer := time.NewTimer(abandonedCallWait)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		fields := log.Fields{"Error": ctx.Err().Error()}
+		if err != nil {
+			fields["CallError"] = err.Error()
+		}
+		log.WithFields(fields).Warn("Tiller call finished after its deadline")
+	case <-timer.C:
+		log.WithFields(log.Fields{
+			"Error": ctx.Err().Error(),
+			"Wait":  abandonedCallWait.String(),
+		}).Warn("Tiller call still running after its deadline, abandoning it")
+	}
+	return ctx.Err()
+}
+
+// startCall runs call in the background unless ctx is already done, its error is sent on the returned channel
+func startCall(ctx context.Context, call func() error) (<-chan error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	return done, nil
+}
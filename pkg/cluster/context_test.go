@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCallContext(t *testing.T) {
+	Convey("callContext", t, func() {
+		Convey("Returns the result of the call", func() {
+			var value int
+			err := callContext(context.Background(), func() error {
+				value = 7
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 7)
+
+			err = callContext(context.Background(), func() error {
+				return errors.New("simulated")
+			})
+			So(err.Error(), ShouldEqual, "simulated")
+		})
+		Convey("Does not call when ctx is already done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			called := false
+			err := callContext(ctx, func() error {
+				called = true
+				return nil
+			})
+			So(err, ShouldEqual, context.Canceled)
+			So(called, ShouldBeFalse)
+		})
+		Convey("Abandons a hung call at the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			release := make(chan struct{})
+			defer close(release)
+			err := callContext(ctx, func() error {
+				<-release
+				return nil
+			})
+			So(err, ShouldResemble, context.DeadlineExceeded)
+		})
+	})
+
+	Convey("callContextWait", t, func() {
+		wait := abandonedCallWait
+		abandonedCallWait = 200 * time.Millisecond
+		defer func() { abandonedCallWait = wait }()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		Convey("Waits for a call running past the deadline", func() {
+			finished := make(chan struct{})
+			err := callContextWait(ctx, func() error {
+				time.Sleep(60 * time.Millisecond)
+				close(finished)
+				return nil
+			})
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			select {
+			case <-finished:
+			default:
+				So("call still running", ShouldBeEmpty)
+			}
+		})
+		Convey("Abandons a hung call after the wait", func() {
+			release := make(chan struct{})
+			defer close(release)
+			start := time.Now()
+			err := callContextWait(ctx, func() error {
+				<-release
+				return nil
+			})
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, abandonedCallWait)
+		})
+	})
+}
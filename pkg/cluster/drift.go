@@ -0,0 +1,481 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mgutz/ansi"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/tiller"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// DriftState describes how a live object differs from its desired state
+type DriftState int
+
+const (
+	// DriftModified means the live object differs from the desired object
+	DriftModified DriftState = iota
+	// DriftMissing means the desired object does not exist in the cluster
+	DriftMissing
+	// DriftExtra means a live object belongs to the release but is not part of the desired state
+	DriftExtra
+)
+
+func (state DriftState) String() string {
+	switch state {
+	case DriftModified:
+		return "Modified"
+	case DriftMissing:
+		return "Missing"
+	case DriftExtra:
+		return "Extra"
+	}
+	return "UnknownDriftState"
+}
+
+// Drifter compares rendered releases with the objects running in the cluster
+type Drifter interface {
+	DetectDrift(ctx context.Context, m *ReleaseMeta) (*ReleaseDrift, error)
+	RepairDrift(ctx context.Context, drift *ReleaseDrift) error
+}
+
+// ObjectDrift describes a single drifted object
+type ObjectDrift struct {
+	Name      string
+	Kind      string
+	Namespace string
+	State     DriftState
+	Diff      []byte
+	// Object is the desired object, or the live object of an extra one
+	Object *unstructured.Unstructured `json:"-"`
+}
+
+// ReleaseDrift contains all drifted objects of one release
+type ReleaseDrift struct {
+	ReleaseName string
+	Namespace   string
+	Objects     []*ObjectDrift
+}
+
+// ignoredMetadata lists metadata fields populated by the API server
+var ignoredMetadata = []string{
+	"uid",
+	"resourceVersion",
+	"generation",
+	"creationTimestamp",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+	"selfLink",
+	"managedFields",
+	"ownerReferences",
+	"finalizers",
+	"initializers",
+}
+
+// releaseLabels are the conventional chart labels identifying objects belonging to a release
+var releaseLabels = []string{"release", "app.kubernetes.io/instance"}
+
+// Drifted reports whether any object in the release has drifted
+func (rd *ReleaseDrift) Drifted() bool {
+	return len(rd.Objects) > 0
+}
+
+func (od *ObjectDrift) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"Object": od.Name,
+		"State":  od.State.String(),
+	}
+}
+
+func (od *ObjectDrift) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"Object":    od.Name,
+		"Kind":      od.Kind,
+		"Namespace": od.Namespace,
+		"State":     od.State.String(),
+	}
+}
+
+// DetectDrift fetches each object of a release from the cluster and compares it with the desired state
+// The desired state is rendered from m.Chart when supplied, otherwise the stored release manifest is used
+func (s *Session) DetectDrift(ctx context.Context, m *ReleaseMeta) (*ReleaseDrift, error) {
+	t, err := s.tillerOf(m.ReleaseName, m.TillerNamespace)
+	if err != nil {
+		return nil, err
+	}
+	var currentR *services.GetReleaseContentResponse
+	err = tillerCall(ctx, t, true, func() (err error) {
+		currentR, err = t.Helm.ReleaseContent(m.ReleaseName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ReleaseName": m.ReleaseName,
+		}).Wrap(err, "failed to get current release")
+	}
+	stored := currentR.Release.Manifest
+	namespace := currentR.Release.Namespace
+
+	desired := stored
+	if m.Chart != nil {
+		var res *services.UpdateReleaseResponse
+		err := tillerCall(ctx, t, true, func() (err error) {
+			res, err = t.Helm.UpdateReleaseFromChart(
+				m.ReleaseName,
+				m.Chart,
+				helm.UpgradeDryRun(true),
+				helm.UpdateValueOverrides(m.ValueOverrides),
+			)
+			return err
+		})
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"ReleaseName": m.ReleaseName,
+			}).Wrap(err, "failed to render desired state")
+		}
+		desired = res.Release.Manifest
+	}
+
+	opts := m.DiffOptions
+	if opts == nil {
+		opts = NewDiffOptions()
+	}
+	return s.Objects.CompareManifests(m.ReleaseName, namespace, stored, desired, opts)
+}
+
+// RepairDrift applies the desired state of the drifted objects of a release, see ObjectClient.RepairDrift
+// an upgrade does not repair them, Tiller patches objects by comparing manifests rather than live objects
+func (s *Session) RepairDrift(ctx context.Context, drift *ReleaseDrift) error {
+	if s.Objects == nil {
+		return errors.New("repairing drift requires a kubernetes connection")
+	}
+	return s.Objects.RepairDrift(ctx, drift)
+}
+
+// RepairDrift replaces modified objects and recreates missing ones from their desired state
+// extra objects are left in place. It stops between objects once ctx is done.
+func (oc *ObjectClient) RepairDrift(ctx context.Context, rd *ReleaseDrift) error {
+	repair := make(map[string]*unstructured.Unstructured)
+	for _, od := range rd.Objects {
+		if od.State == DriftExtra || od.Object == nil {
+			continue
+		}
+		repair[od.Name] = od.Object.DeepCopy()
+	}
+	for _, obj := range orderObjects(repair, tiller.InstallOrder) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := oc.Apply(obj, rd.Namespace); err != nil {
+			return errors.WithFields(errors.Fields{
+				"ReleaseName": rd.ReleaseName,
+			}).Wrap(err, "failed to repair drifted object")
+		}
+		log.WithFields(log.Fields{
+			"ReleaseName": rd.ReleaseName,
+			"Kind":        obj.GetKind(),
+			"Name":        obj.GetName(),
+		}).Debug("Repaired drifted object")
+	}
+	return nil
+}
+
+// CompareManifests compares the live objects of a release against the desired manifest
+// objects present in stored but absent from desired, or carrying the release label, are reported as extra
+func (oc *ObjectClient) CompareManifests(releaseName, namespace, stored, desired string, opts *DiffOptions) (*ReleaseDrift, error) {
+	rd := &ReleaseDrift{
+		ReleaseName: releaseName,
+		Namespace:   namespace,
+		Objects:     []*ObjectDrift{},
+	}
+
+	desiredObjects, err := ObjectsFromManifest(desired, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode desired manifest")
+	}
+	storedObjects, err := ObjectsFromManifest(stored, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode stored manifest")
+	}
+
+	for _, key := range sortedKeys(desiredObjects) {
+		want := desiredObjects[key]
+		ri, err := oc.ResourceFor(want, namespace)
+		if err != nil {
+			return nil, err
+		}
+		live, err := ri.Get(want.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				rd.Objects = append(rd.Objects, newObjectDrift(key, want, DriftMissing, nil))
+				continue
+			}
+			return nil, errors.WithFields(errors.Fields{
+				"Object": key,
+			}).Wrap(err, "failed to get live object")
+		}
+		differences := compareObjects(want, live, hasString(opts.RedactedKinds, want.GetKind()))
+		if len(differences) > 0 {
+			rd.Objects = append(rd.Objects, newObjectDrift(key, want, DriftModified, differences))
+		}
+	}
+
+	extra := make(map[string]*unstructured.Unstructured)
+	for key, obj := range storedObjects {
+		if _, ok := desiredObjects[key]; !ok {
+			extra[key] = obj
+		}
+	}
+	labeled, err := oc.labeledObjects(releaseName, namespace, desiredObjects)
+	if err != nil {
+		return nil, err
+	}
+	for key, obj := range labeled {
+		if _, ok := desiredObjects[key]; !ok {
+			extra[key] = obj
+		}
+	}
+	for _, key := range sortedKeys(extra) {
+		obj := extra[key]
+		ri, err := oc.ResourceFor(obj, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ri.Get(obj.GetName(), metav1.GetOptions{}); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.WithFields(errors.Fields{
+				"Object": key,
+			}).Wrap(err, "failed to get live object")
+		}
+		rd.Objects = append(rd.Objects, newObjectDrift(key, obj, DriftExtra, nil))
+	}
+	return rd, nil
+}
+
+// labeledObjects lists objects of the kinds found in objects which carry a release label for releaseName
+func (oc *ObjectClient) labeledObjects(releaseName, namespace string, objects map[string]*unstructured.Unstructured) (map[string]*unstructured.Unstructured, error) {
+	ret := make(map[string]*unstructured.Unstructured)
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		if seen[gvk.String()] {
+			continue
+		}
+		seen[gvk.String()] = true
+		ri, err := oc.ResourceFor(obj, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, label := range releaseLabels {
+			list, err := ri.List(metav1.ListOptions{
+				LabelSelector: labels.Set{label: releaseName}.AsSelector().String(),
+			})
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Kind":  gvk.Kind,
+					"Error": err.Error(),
+				}).Debug("failed to list labeled objects")
+				continue
+			}
+			for i := range list.Items {
+				item := &list.Items[i]
+				if len(item.GetOwnerReferences()) > 0 {
+					// Created by a controller, not by the release
+					continue
+				}
+				ret[objectKey(item, namespace)] = item
+			}
+		}
+	}
+	return ret, nil
+}
+
+func newObjectDrift(key string, obj *unstructured.Unstructured, state DriftState, differences []string) *ObjectDrift {
+	od := &ObjectDrift{
+		Name:      key,
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		State:     state,
+		Object:    obj,
+	}
+	if len(differences) > 0 {
+		buf := bytes.NewBufferString("")
+		fmt.Fprint(buf, ansi.Color(fmt.Sprintf("%s has drifted:", key), "yellow")+"\n")
+		for _, v := range differences {
+			fmt.Fprintln(buf, v)
+		}
+		od.Diff = buf.Bytes()
+	}
+	return od
+}
+
+// objectKey returns the same key Parse uses for a manifest document
+func objectKey(obj *unstructured.Unstructured, defaultNamespace string) string {
+	m := metadata{
+		ApiVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+	}
+	m.Metadata.Name = obj.GetName()
+	m.Metadata.Namespace = obj.GetNamespace()
+	if m.Metadata.Namespace == "" {
+		m.Metadata.Namespace = defaultNamespace
+	}
+	return m.String()
+}
+
+// compareObjects returns a line for each field set in want which has a different value in live
+// fields populated by the server and status are ignored
+func compareObjects(want, live *unstructured.Unstructured, redact bool) []string {
+	desired := normalizeObject(want)
+	current := normalizeObject(live)
+	delete(desired, "status")
+	if md, ok := desired["metadata"].(map[string]interface{}); ok {
+		for _, v := range ignoredMetadata {
+			delete(md, v)
+		}
+	}
+	if desired["kind"] == "Secret" {
+		mergeStringData(desired)
+	}
+	differences := []string{}
+	compareValues("", desired, current, redact, &differences)
+	return differences
+}
+
+func compareValues(path string, want, got interface{}, redact bool, differences *[]string) {
+	switch w := want.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			if len(w) > 0 {
+				*differences = append(*differences, driftLine(path, want, got, redact))
+			}
+			return
+		}
+		for _, k := range sortedKeys(w) {
+			compareValues(joinPath(path, k), w[k], g[k], redact, differences)
+		}
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			if len(w) > 0 || len(g) > 0 {
+				*differences = append(*differences, driftLine(path, want, got, redact))
+			}
+			return
+		}
+		for i := range w {
+			compareValues(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], redact, differences)
+		}
+	default:
+		if !scalarEqual(want, got) {
+			*differences = append(*differences, driftLine(path, want, got, redact))
+		}
+	}
+}
+
+// scalarEqual compares values as the API server would, resource quantities are compared by value
+func scalarEqual(want, got interface{}) bool {
+	if reflect.DeepEqual(want, got) {
+		return true
+	}
+	ws, gs := fmt.Sprintf("%v", want), fmt.Sprintf("%v", got)
+	if ws == gs {
+		return true
+	}
+	wq, err := resource.ParseQuantity(ws)
+	if err != nil {
+		return false
+	}
+	gq, err := resource.ParseQuantity(gs)
+	if err != nil {
+		return false
+	}
+	return wq.Cmp(gq) == 0
+}
+
+func driftLine(path string, want, got interface{}, redact bool) string {
+	if redact {
+		return fmt.Sprintf("~ %s: %s", path, ansi.Color("<redacted value differs>", "yellow"))
+	}
+	return fmt.Sprintf("~ %s: %s %s",
+		path,
+		ansi.Color(fmt.Sprintf("- %v", compact(got)), "red"),
+		ansi.Color(fmt.Sprintf("+ %v", compact(want)), "green"))
+}
+
+func compact(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(raw)
+}
+
+// normalizeObject round trips an object through JSON so numbers compare consistently
+func normalizeObject(obj *unstructured.Unstructured) map[string]interface{} {
+	ret := make(map[string]interface{})
+	raw, err := json.Marshal(obj.Object)
+	if err != nil {
+		return ret
+	}
+	json.Unmarshal(raw, &ret)
+	return ret
+}
+
+// mergeStringData moves Secret stringData into data the way the API server stores it
+func mergeStringData(obj map[string]interface{}) {
+	stringData, ok := obj["stringData"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	data, ok := obj["data"].(map[string]interface{})
+	if !ok {
+		data = make(map[string]interface{})
+	}
+	for k, v := range stringData {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v", v)))
+	}
+	obj["data"] = data
+	delete(obj, "stringData")
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if strings.ContainsAny(key, "./") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/charter-oss/barrelman/pkg/cluster/simkube"
+)
+
+func newTestSimCluster(objects ...runtime.Object) *simkube.Cluster {
+	sim := simkube.New()
+	if err := sim.Add(objects...); err != nil {
+		panic(err)
+	}
+	return sim
+}
+
+func newTestClientset(objects ...runtime.Object) kubernetes.Interface {
+	return newTestSimCluster(objects...).Clientset()
+}
+
+func newTestObjectClient(objects ...runtime.Object) *ObjectClient {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	return &ObjectClient{
+		Dynamic: newTestSimCluster(objects...).Dynamic(),
+		Mapper:  mapper,
+	}
+}
+
+func newTestObject(kind, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"namespace":       "default",
+			"resourceVersion": "42",
+			"uid":             "1234",
+			"labels":          map[string]interface{}{"release": "app"},
+		},
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestDrift(t *testing.T) {
+	desired := `
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  labels:
+    release: app
+data:
+  replicas: "3"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: removed
+  labels:
+    release: app
+data:
+  a: b
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+  labels:
+    release: app
+stringData:
+  password: hunter2
+`
+	Convey("CompareManifests", t, func() {
+		Convey("Reports nothing when the cluster matches", func() {
+			oc := newTestObjectClient(
+				newTestObject("ConfigMap", "settings", map[string]interface{}{
+					"data": map[string]interface{}{"replicas": "3", "added": "by-server"},
+				}),
+				newTestObject("ConfigMap", "removed", map[string]interface{}{
+					"data": map[string]interface{}{"a": "b"},
+				}),
+				newTestObject("Secret", "creds", map[string]interface{}{
+					"data": map[string]interface{}{"password": "aHVudGVyMg=="},
+				}),
+			)
+			rd, err := oc.CompareManifests("app", "default", desired, desired, NewDiffOptions())
+			So(err, ShouldBeNil)
+			So(rd.Drifted(), ShouldBeFalse)
+		})
+		Convey("Reports modified, missing and extra objects", func() {
+			oc := newTestObjectClient(
+				newTestObject("ConfigMap", "settings", map[string]interface{}{
+					"data": map[string]interface{}{"replicas": "5"},
+				}),
+				newTestObject("ConfigMap", "removed", map[string]interface{}{
+					"data": map[string]interface{}{"a": "b"},
+				}),
+				newTestObject("ConfigMap", "handmade", map[string]interface{}{}),
+				newTestObject("Secret", "creds", map[string]interface{}{
+					"data": map[string]interface{}{"password": "Y2hhbmdlZA=="},
+				}),
+			)
+			proposed := `
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  replicas: "3"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+stringData:
+  password: hunter2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: absent
+`
+			rd, err := oc.CompareManifests("app", "default", desired, proposed, NewDiffOptions())
+			So(err, ShouldBeNil)
+			states := map[string]DriftState{}
+			for _, od := range rd.Objects {
+				states[od.Name] = od.State
+			}
+			So(states["default, settings, ConfigMap (v1)"], ShouldEqual, DriftModified)
+			So(states["default, absent, ConfigMap (v1)"], ShouldEqual, DriftMissing)
+			So(states["default, removed, ConfigMap (v1)"], ShouldEqual, DriftExtra)
+			So(states["default, handmade, ConfigMap (v1)"], ShouldEqual, DriftExtra)
+			So(states["default, creds, Secret (v1)"], ShouldEqual, DriftModified)
+			for _, od := range rd.Objects {
+				So(string(od.Diff), ShouldNotContainSubstring, "hunter2")
+				So(string(od.Diff), ShouldNotContainSubstring, "Y2hhbmdlZA==")
+			}
+		})
+		Convey("Errors without a dynamic client", func() {
+			oc := &ObjectClient{}
+			_, err := oc.CompareManifests("app", "default", desired, desired, NewDiffOptions())
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("compareObjects", t, func() {
+		Convey("Compares resource quantities by value", func() {
+			want := newTestObject("ConfigMap", "q", map[string]interface{}{"spec": map[string]interface{}{"cpu": "1000m"}})
+			got := newTestObject("ConfigMap", "q", map[string]interface{}{"spec": map[string]interface{}{"cpu": "1"}})
+			So(compareObjects(want, got, false), ShouldBeEmpty)
+		})
+		Convey("Ignores status and server metadata", func() {
+			want := newTestObject("ConfigMap", "s", map[string]interface{}{"status": map[string]interface{}{"phase": "x"}})
+			got := newTestObject("ConfigMap", "s", map[string]interface{}{})
+			got.SetResourceVersion("99")
+			So(compareObjects(want, got, false), ShouldBeEmpty)
+		})
+	})
+}
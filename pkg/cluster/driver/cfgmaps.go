@@ -35,7 +35,7 @@ import (
 	storageerrors "k8s.io/helm/pkg/storage/errors"
 )
 
-var _ Driver = (*ConfigMaps)(nil)
+var _ VersionDriver = (*ConfigMaps)(nil)
 
 // ConfigMapsDriverName is the string name of the driver.
 const ConfigMapsDriverName = "ConfigMap"
@@ -88,6 +88,29 @@ func (cfgmaps *ConfigMaps) Get(key string) (*rspb.Release, error) {
 // that filter(release) == true. An error is returned if the
 // configmap fails to retrieve the releases.
 func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	stored, err := cfgmaps.ListStored(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*rspb.Release
+	for _, s := range stored {
+		results = append(results, s.Release)
+	}
+	return results, nil
+}
+
+// StoredRelease is a release with the key and labels of the configmap holding it
+type StoredRelease struct {
+	Key     string
+	Labels  map[string]string
+	Release *rspb.Release
+}
+
+// ListStored fetches all releases such that filter(release) == true, along with
+// the configmap holding each. An error is returned if the configmap fails to
+// retrieve the releases.
+func (cfgmaps *ConfigMaps) ListStored(filter func(*rspb.Release) bool) ([]*StoredRelease, error) {
 	lsel := kblabels.Set{"OWNER": "BARRELMAN"}.AsSelector()
 	opts := metav1.ListOptions{LabelSelector: lsel.String()}
 
@@ -97,7 +120,7 @@ func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Releas
 		return nil, err
 	}
 
-	var results []*rspb.Release
+	var results []*StoredRelease
 
 	// iterate over the configmaps object list
 	// and decode each release
@@ -108,7 +131,11 @@ func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Releas
 			continue
 		}
 		if filter(rls) {
-			results = append(results, rls)
+			results = append(results, &StoredRelease{
+				Key:     item.Name,
+				Labels:  item.Labels,
+				Release: rls,
+			})
 		}
 	}
 	return results, nil
@@ -152,22 +179,29 @@ func (cfgmaps *ConfigMaps) Query(labels map[string]string) ([]*rspb.Release, err
 // Create creates a new ConfigMap holding the release. If the
 // ConfigMap already exists, ErrReleaseExists is returned.
 func (cfgmaps *ConfigMaps) Create(key string, rls *rspb.Release) error {
+	return cfgmaps.CreateStored(&StoredRelease{Key: key, Release: rls})
+}
+
+// CreateStored creates a new ConfigMap holding the stored release under its key.
+// The labels of the stored release are kept, the labels set by Create override them.
+func (cfgmaps *ConfigMaps) CreateStored(stored *StoredRelease) error {
 	// set labels for configmaps object meta data
 	var lbs labels
 
 	lbs.init()
+	lbs.fromMap(stored.Labels)
 	lbs.set("CREATED_AT", strconv.Itoa(int(time.Now().Unix())))
 
 	// create a new configmap to hold the release
-	obj, err := newConfigMapsObject(key, rls, lbs)
+	obj, err := newConfigMapsObject(stored.Key, stored.Release, lbs)
 	if err != nil {
-		cfgmaps.Log("create: failed to encode release %q: %s", rls.Name, err)
+		cfgmaps.Log("create: failed to encode release %q: %s", stored.Release.Name, err)
 		return err
 	}
 	// push the configmap object out into the kubiverse
 	if _, err := cfgmaps.impl.Create(obj); err != nil {
 		if apierrors.IsAlreadyExists(err) {
-			return storageerrors.ErrReleaseExists(key)
+			return storageerrors.ErrReleaseExists(stored.Key)
 		}
 		cfgmaps.Log("create: failed to create: %s", err)
 		return err
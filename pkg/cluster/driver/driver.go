@@ -79,3 +79,12 @@ type Driver interface {
 	Queryor
 	Name() string
 }
+
+// VersionDriver is the Driver storing Barrelman manifest versions. ListStored
+// returns the releases along with the key and labels of the object holding each,
+// CreateStored creates a release under its key, keeping its labels.
+type VersionDriver interface {
+	Driver
+	ListStored(filter func(*rspb.Release) bool) ([]*StoredRelease, error)
+	CreateStored(stored *StoredRelease) error
+}
@@ -0,0 +1,297 @@
+package driver
+
+// Storage compatible with "helm.sh/helm/v3/pkg/storage/driver" Secrets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	storageerrors "k8s.io/helm/pkg/storage/errors"
+)
+
+// Helm3SecretType is the Secret type used by Helm 3 release storage
+const Helm3SecretType = "helm.sh/release.v1"
+
+// Helm 3 release status values
+const (
+	Helm3StatusUnknown         = "unknown"
+	Helm3StatusDeployed        = "deployed"
+	Helm3StatusUninstalled     = "uninstalled"
+	Helm3StatusSuperseded      = "superseded"
+	Helm3StatusFailed          = "failed"
+	Helm3StatusUninstalling    = "uninstalling"
+	Helm3StatusPendingInstall  = "pending-install"
+	Helm3StatusPendingUpgrade  = "pending-upgrade"
+	Helm3StatusPendingRollback = "pending-rollback"
+)
+
+// Helm3Release is the JSON representation of a Helm 3 release
+type Helm3Release struct {
+	Name      string                 `json:"name,omitempty"`
+	Info      *Helm3Info             `json:"info,omitempty"`
+	Chart     *Helm3Chart            `json:"chart,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Manifest  string                 `json:"manifest,omitempty"`
+	Hooks     []*Helm3Hook           `json:"hooks,omitempty"`
+	Version   int                    `json:"version,omitempty"`
+	Namespace string                 `json:"namespace,omitempty"`
+	// Labels are additional labels written to the storage Secret
+	Labels map[string]string `json:"-"`
+}
+
+// Helm3Info describes release information
+type Helm3Info struct {
+	FirstDeployed time.Time `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time `json:"last_deployed,omitempty"`
+	Deleted       time.Time `json:"deleted"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+// Helm3Chart is a chart as stored within a Helm 3 release
+type Helm3Chart struct {
+	Metadata  *Helm3Metadata         `json:"metadata"`
+	Templates []*Helm3File           `json:"templates"`
+	Values    map[string]interface{} `json:"values"`
+	Files     []*Helm3File           `json:"files"`
+	// Dependencies are not written by Helm 3, they are kept so stored charts can be rendered again
+	Dependencies []*Helm3Chart `json:"dependencies,omitempty"`
+}
+
+// Helm3Metadata holds the Chart.yaml fields of a Helm 3 chart
+type Helm3Metadata struct {
+	Name        string            `json:"name,omitempty"`
+	Home        string            `json:"home,omitempty"`
+	Sources     []string          `json:"sources,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Icon        string            `json:"icon,omitempty"`
+	APIVersion  string            `json:"apiVersion,omitempty"`
+	Condition   string            `json:"condition,omitempty"`
+	Tags        string            `json:"tags,omitempty"`
+	AppVersion  string            `json:"appVersion,omitempty"`
+	Deprecated  bool              `json:"deprecated,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	KubeVersion string            `json:"kubeVersion,omitempty"`
+}
+
+// Helm3File is a file within a Helm 3 chart, data is base64 encoded in JSON
+type Helm3File struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// Helm3Hook is a release hook
+type Helm3Hook struct {
+	Name     string   `json:"name,omitempty"`
+	Kind     string   `json:"kind,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Manifest string   `json:"manifest,omitempty"`
+	Events   []string `json:"events,omitempty"`
+	Weight   int      `json:"weight,omitempty"`
+}
+
+// Helm3Secrets stores Helm 3 releases as Secrets in the release namespace
+type Helm3Secrets struct {
+	impl corev1.SecretsGetter
+	Log  func(string, ...interface{})
+}
+
+// NewHelm3Secrets initializes a new Helm3Secrets wrapping a kubernetes SecretsGetter
+func NewHelm3Secrets(impl corev1.SecretsGetter) *Helm3Secrets {
+	return &Helm3Secrets{
+		impl: impl,
+		Log:  func(_ string, _ ...interface{}) {},
+	}
+}
+
+// Helm3Key returns the storage Secret name of a release revision
+func Helm3Key(name string, version int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, version)
+}
+
+// Get fetches the release revision stored in namespace
+func (secrets *Helm3Secrets) Get(namespace, name string, version int) (*Helm3Release, error) {
+	key := Helm3Key(name, version)
+	obj, err := secrets.impl.Secrets(namespace).Get(key, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, storageerrors.ErrReleaseNotFound(key)
+		}
+		secrets.Log("get: failed to get %q: %s", key, err)
+		return nil, err
+	}
+	return decodeHelm3Release(string(obj.Data["release"]))
+}
+
+// Query fetches all releases in namespace matching labels, an empty namespace searches all namespaces
+func (secrets *Helm3Secrets) Query(namespace string, labels map[string]string) ([]*Helm3Release, error) {
+	ls := kblabels.Set{"owner": "helm"}
+	for k, v := range labels {
+		ls[k] = v
+	}
+	list, err := secrets.impl.Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: ls.AsSelector().String(),
+	})
+	if err != nil {
+		secrets.Log("query: failed to query with labels: %s", err)
+		return nil, err
+	}
+
+	var results []*Helm3Release
+	for _, item := range list.Items {
+		if item.Type != Helm3SecretType {
+			continue
+		}
+		rls, err := decodeHelm3Release(string(item.Data["release"]))
+		if err != nil {
+			secrets.Log("query: failed to decode release: %s", err)
+			continue
+		}
+		rls.Labels = item.Labels
+		results = append(results, rls)
+	}
+	return results, nil
+}
+
+// Create creates a new Secret holding the release
+func (secrets *Helm3Secrets) Create(rls *Helm3Release) error {
+	obj, err := newHelm3SecretsObject(rls)
+	if err != nil {
+		secrets.Log("create: failed to encode release %q: %s", rls.Name, err)
+		return err
+	}
+	obj.Labels["createdAt"] = strconv.Itoa(int(time.Now().Unix()))
+	if _, err := secrets.impl.Secrets(rls.Namespace).Create(obj); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return storageerrors.ErrReleaseExists(obj.Name)
+		}
+		secrets.Log("create: failed to create: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Update updates the Secret holding the release
+func (secrets *Helm3Secrets) Update(rls *Helm3Release) error {
+	obj, err := newHelm3SecretsObject(rls)
+	if err != nil {
+		secrets.Log("update: failed to encode release %q: %s", rls.Name, err)
+		return err
+	}
+	obj.Labels["modifiedAt"] = strconv.Itoa(int(time.Now().Unix()))
+	if _, err := secrets.impl.Secrets(rls.Namespace).Update(obj); err != nil {
+		secrets.Log("update: failed to update: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Delete deletes the Secret holding the release revision
+func (secrets *Helm3Secrets) Delete(rls *Helm3Release) error {
+	key := Helm3Key(rls.Name, rls.Version)
+	if err := secrets.impl.Secrets(rls.Namespace).Delete(key, &metav1.DeleteOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return storageerrors.ErrReleaseNotFound(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// newHelm3SecretsObject constructs a Secret in the format written by Helm 3
+//
+// The following labels are used within each secret:
+//
+//    "modifiedAt"     - timestamp indicating when this secret was last modified. (set in Update)
+//    "createdAt"      - timestamp indicating when this secret was created. (set in Create)
+//    "version"        - version of the release.
+//    "status"         - status of the release.
+//    "owner"          - owner of the secret, always "helm".
+//    "name"           - name of the release.
+//
+func newHelm3SecretsObject(rls *Helm3Release) (*v1.Secret, error) {
+	s, err := encodeHelm3Release(rls)
+	if err != nil {
+		return nil, err
+	}
+
+	var lbs labels
+	lbs.init()
+	for k, v := range rls.Labels {
+		lbs.set(k, v)
+	}
+	lbs.set("name", rls.Name)
+	lbs.set("owner", "helm")
+	lbs.set("version", strconv.Itoa(rls.Version))
+	if rls.Info != nil {
+		lbs.set("status", rls.Info.Status)
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Helm3Key(rls.Name, rls.Version),
+			Namespace: rls.Namespace,
+			Labels:    lbs.toMap(),
+		},
+		Type: Helm3SecretType,
+		Data: map[string][]byte{"release": []byte(s)},
+	}, nil
+}
+
+// encodeHelm3Release returns the base64 encoded gzipped JSON of a release
+func encodeHelm3Release(rls *Helm3Release) (string, error) {
+	b, err := json.Marshal(rls)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(b); err != nil {
+		return "", err
+	}
+	w.Close()
+
+	return b64.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeHelm3Release decodes the base64 gzipped JSON written by Helm 3
+func decodeHelm3Release(data string) (*Helm3Release, error) {
+	b, err := b64.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 3 && bytes.Equal(b[0:3], magicGzip) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		b2, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		b = b2
+	}
+
+	var rls Helm3Release
+	if err := json.Unmarshal(b, &rls); err != nil {
+		return nil, err
+	}
+	return &rls, nil
+}
@@ -33,21 +33,56 @@ var magicGzip = []byte{0x1f, 0x8b, 0x08}
 // encodeRelease encodes a release returning a base64 encoded
 // gzipped binary protobuf encoding representation, or error.
 func encodeRelease(rls *rspb.Release) (string, error) {
-	b, err := proto.Marshal(rls)
+	b, err := compressRelease(rls)
 	if err != nil {
 		return "", err
 	}
+	return b64.EncodeToString(b), nil
+}
+
+// EncodedSize returns the size of a release once encoded for a ConfigMap or Secret
+func EncodedSize(rls *rspb.Release) (int, error) {
+	b, err := compressRelease(rls)
+	if err != nil {
+		return 0, err
+	}
+	return b64.EncodedLen(len(b)), nil
+}
+
+// compressRelease returns the gzipped binary protobuf encoding of a release
+func compressRelease(rls *rspb.Release) ([]byte, error) {
+	b, err := proto.Marshal(rls)
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if _, err = w.Write(b); err != nil {
-		return "", err
+		return nil, err
 	}
 	w.Close()
 
-	return b64.EncodeToString(buf.Bytes()), nil
+	return buf.Bytes(), nil
+}
+
+// decompressRelease decodes the gzipped binary protobuf encoding of a release
+func decompressRelease(data []byte) (*rspb.Release, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var rls rspb.Release
+	if err := proto.Unmarshal(b, &rls); err != nil {
+		return nil, err
+	}
+	return &rls, nil
 }
 
 // decodeRelease decodes the bytes in data into a release
@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"regexp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// guardNamespace is the namespace whose UID and labels identify a cluster
+const guardNamespace = "kube-system"
+
+// ClusterGuard is the identity expected of the cluster a manifest is applied to, unset fields are not checked
+type ClusterGuard struct {
+	// KubeSystemUID is the UID of the kube-system namespace
+	KubeSystemUID string
+	// APIServer is a regular expression matched against the API server URL
+	APIServer string
+	// Labels are expected on the kube-system namespace
+	Labels map[string]string
+}
+
+// Guarder verifies the identity of the cluster a session is connected to
+type Guarder interface {
+	VerifyCluster(guard *ClusterGuard) error
+}
+
+// IsEmpty returns true when the guard checks nothing
+func (g *ClusterGuard) IsEmpty() bool {
+	return g == nil || (g.KubeSystemUID == "" && g.APIServer == "" && len(g.Labels) == 0)
+}
+
+// VerifyCluster returns an error when the cluster does not match guard
+func (s *Session) VerifyCluster(guard *ClusterGuard) error {
+	host := ""
+	if s.restConfig != nil {
+		host = s.restConfig.Host
+	}
+	return verifyCluster(s.Clientset, host, s.kubeContext, guard)
+}
+
+// VerifyCluster returns an error when the cluster does not match guard
+func (s *Helm3Session) VerifyCluster(guard *ClusterGuard) error {
+	return verifyCluster(s.Clientset, s.apiServer, s.kubeContext, guard)
+}
+
+// VerifyCluster accepts any guard, a simulated cluster has no identity to check
+func (s *SimSession) VerifyCluster(guard *ClusterGuard) error {
+	if !guard.IsEmpty() {
+		log.WithFields(log.Fields{
+			"StateFile": s.StateFile,
+		}).Debug("Cluster guard not checked for a simulated cluster")
+	}
+	return nil
+}
+
+func verifyCluster(client kubernetes.Interface, host, kubeContext string, guard *ClusterGuard) error {
+	if guard.IsEmpty() {
+		return nil
+	}
+	mismatch := func(check, expected, actual string) error {
+		return errors.WithFields(errors.Fields{
+			"KubeContext": kubeContext,
+			"Check":       check,
+			"Expected":    expected,
+			"Actual":      actual,
+		}).New("cluster does not match the identity declared in the manifest, check the kube context or use --ignore-cluster-guard")
+	}
+
+	if guard.APIServer != "" {
+		pattern, err := regexp.Compile(guard.APIServer)
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"APIServer": guard.APIServer,
+			}).Wrap(err, "invalid API server pattern in cluster guard")
+		}
+		if !pattern.MatchString(host) {
+			return mismatch("APIServer", guard.APIServer, host)
+		}
+	}
+
+	if guard.KubeSystemUID == "" && len(guard.Labels) == 0 {
+		return nil
+	}
+	if client == nil {
+		return errors.New("cluster guard requires a connection to kubernetes")
+	}
+	ns, err := client.CoreV1().Namespaces().Get(guardNamespace, metav1.GetOptions{})
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"Namespace": guardNamespace,
+		}).Wrap(err, "failed to get the namespace identifying the cluster")
+	}
+	if guard.KubeSystemUID != "" && string(ns.UID) != guard.KubeSystemUID {
+		return mismatch("KubeSystemUID", guard.KubeSystemUID, string(ns.UID))
+	}
+	for k, v := range guard.Labels {
+		if actual, ok := ns.Labels[k]; !ok || actual != v {
+			return mismatch("Label "+k, v, actual)
+		}
+	}
+	log.WithFields(log.Fields{
+		"KubeContext": kubeContext,
+	}).Debug("Cluster matches the manifest guard")
+	return nil
+}
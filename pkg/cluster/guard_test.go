@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestVerifyCluster(t *testing.T) {
+	Convey("VerifyCluster", t, func() {
+		s := &Session{
+			Clientset: newTestClientset(&core.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "kube-system",
+					UID:    "1234-abcd",
+					Labels: map[string]string{"cluster": "prod"},
+				},
+			}),
+			restConfig:  &rest.Config{Host: "https://prod.example.com:6443"},
+			kubeContext: "prod",
+		}
+
+		Convey("Accepts an empty guard", func() {
+			So(s.VerifyCluster(nil), ShouldBeNil)
+			So(s.VerifyCluster(&ClusterGuard{}), ShouldBeNil)
+		})
+		Convey("Accepts a matching cluster", func() {
+			So(s.VerifyCluster(&ClusterGuard{
+				KubeSystemUID: "1234-abcd",
+				APIServer:     `^https://prod\.`,
+				Labels:        map[string]string{"cluster": "prod"},
+			}), ShouldBeNil)
+		})
+		Convey("Rejects another kube-system UID", func() {
+			err := s.VerifyCluster(&ClusterGuard{KubeSystemUID: "9999"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not match the identity declared in the manifest")
+		})
+		Convey("Rejects another API server", func() {
+			err := s.VerifyCluster(&ClusterGuard{APIServer: `staging`})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not match")
+		})
+		Convey("Rejects a missing label", func() {
+			err := s.VerifyCluster(&ClusterGuard{Labels: map[string]string{"cluster": "staging"}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not match")
+		})
+		Convey("Rejects an invalid API server pattern", func() {
+			err := s.VerifyCluster(&ClusterGuard{APIServer: `(`})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid API server pattern")
+		})
+		Convey("A simulated cluster is not checked", func() {
+			So(NewSimSession("").VerifyCluster(&ClusterGuard{KubeSystemUID: "9999"}), ShouldBeNil)
+		})
+	})
+}
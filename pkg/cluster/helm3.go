@@ -0,0 +1,927 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/ptypes/any"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/manifest"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/releaseutil"
+	"k8s.io/helm/pkg/renderutil"
+	"k8s.io/helm/pkg/tiller"
+	"k8s.io/helm/pkg/timeconv"
+
+	"github.com/charter-oss/barrelman/pkg/cluster/driver"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// DefaultVersionsNamespace is the namespace holding Barrelman version ConfigMaps when Tiller is not used
+const DefaultVersionsNamespace = "kube-system"
+
+// manifestLabel is added to Helm 3 release Secrets owned by a Barrelman manifest
+const manifestLabel = "MANIFEST"
+
+const hookAnnotation = "helm.sh/hook"
+
+var whitespaceRegex = regexp.MustCompile(`^\s*$`)
+
+var _ Sessioner = (*Helm3Session)(nil)
+
+// Helm3Session is a Sessioner storing releases as Helm 3 release Secrets
+// Objects are installed, upgraded and deleted directly through the Kubernetes API, no Tiller is required
+// Chart hooks are recorded in the release but not executed
+type Helm3Session struct {
+	Clientset         kubernetes.Interface
+	Objects           *ObjectClient
+	Storage           *driver.Helm3Secrets
+	VersionsNamespace string
+	// VersionsStorage stores Barrelman versions as ConfigMaps or Secrets, see NewVersionDriver
+	VersionsStorage string
+	kubeConfig      string
+	kubeContext     string
+	apiServer       string
+}
+
+// NewHelm3Session returns a *Helm3Session, connections are established by Init
+func NewHelm3Session(kubeContext string, kubeConfig string) *Helm3Session {
+	return &Helm3Session{
+		VersionsNamespace: DefaultVersionsNamespace,
+		kubeConfig:        fullPath(kubeConfig),
+		kubeContext:       kubeContext,
+	}
+}
+
+func (s *Helm3Session) GetKubeConfig() string {
+	return s.kubeConfig
+}
+func (s *Helm3Session) SetKubeConfig(c string) {
+	s.kubeConfig = c
+}
+func (s *Helm3Session) GetKubeContext() string {
+	return s.kubeContext
+}
+func (s *Helm3Session) SetKubeContext(c string) {
+	s.kubeContext = c
+}
+
+// Init establishes connections to the cluster
+// clients already present on the session, such as fakes used in tests, are kept
+func (s *Helm3Session) Init() error {
+	if s.Clientset == nil {
+		config, err := kube.GetConfig(s.kubeContext, s.kubeConfig).ClientConfig()
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"KubeConfig":  s.kubeConfig,
+				"kubeContext": s.kubeContext,
+			}).Wrap(err, "could not get kubernetes config for context")
+		}
+		s.Clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return errors.Wrap(err, "could not get kubernetes client")
+		}
+		s.apiServer = config.Host
+		if s.Objects == nil {
+			s.Objects, err = NewObjectClient(config, s.Clientset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Storage == nil {
+		s.Storage = driver.NewHelm3Secrets(s.Clientset.CoreV1())
+	}
+	if s.VersionsNamespace == "" {
+		s.VersionsNamespace = DefaultVersionsNamespace
+	}
+	if err := checkVersionsStorage(s.VersionsStorage); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"VersionsNamespace": s.VersionsNamespace,
+		"VersionsStorage":   s.VersionsStorage,
+	}).Debug("Using Helm 3 release storage")
+	return nil
+}
+
+func (s *Helm3Session) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"KubeContext": s.kubeContext,
+		"KubeConfig":  s.kubeConfig,
+	}
+}
+
+func (s *Helm3Session) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"KubeContext":       s.kubeContext,
+		"KubeConfig":        s.kubeConfig,
+		"VersionsNamespace": s.VersionsNamespace,
+	}
+}
+
+//ListReleases returns an array of the latest revision of each release
+func (s *Helm3Session) ListReleases(ctx context.Context) ([]*Release, error) {
+	return s.ListReleasesByManifest(ctx, "")
+}
+
+//ListReleasesByManifest returns an array of releases filtered by the ManifestName tag
+func (s *Helm3Session) ListReleasesByManifest(ctx context.Context, manifestName string) ([]*Release, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	all, err := s.Storage.Query("", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list Helm 3 releases")
+	}
+	latest := make(map[string]*driver.Helm3Release)
+	for _, v := range all {
+		key := ReleaseKey(v.Namespace, v.Name)
+		if cur, ok := latest[key]; !ok || v.Version > cur.Version {
+			latest[key] = v
+		}
+	}
+
+	releases := []*Release{}
+	for _, key := range sortedKeys(latest) {
+		v := latest[key]
+		rel := &Release{
+			Chart:       Helm3ChartToChart(v.Chart),
+			ReleaseName: v.Name,
+			Namespace:   v.Namespace,
+			Status:      helm3Status(v),
+			Revision:    int32(v.Version),
+			Config:      helm3Config(v.Config),
+		}
+		if manifestName != "" && getChartManifestTag(rel.Chart) != manifestName {
+			continue
+		}
+		releases = append(releases, rel)
+	}
+	return releases, nil
+}
+
+//InstallRelease renders a chart and creates its objects as revision 1, or the next revision of a deleted release
+func (s *Helm3Session) InstallRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return &InstallReleaseResponse{}, err
+	}
+	version := 1
+	current, err := s.lastRelease(m.Namespace, m.ReleaseName)
+	if err != nil {
+		return &InstallReleaseResponse{}, err
+	}
+	if current != nil {
+		if current.Info.Status != driver.Helm3StatusUninstalled && !m.InstallReuseName {
+			return &InstallReleaseResponse{}, errors.WithFields(errors.Fields{
+				"Name":      m.ReleaseName,
+				"Namespace": current.Namespace,
+			}).New("cannot re-use a name that is still in use")
+		}
+		version = current.Version + 1
+	}
+
+	rls, err := s.newRelease(m, manifestName, version, false)
+	if err != nil {
+		return &InstallReleaseResponse{}, errors.WithFields(errors.Fields{
+			"File":      m.Path,
+			"Name":      m.MetaName,
+			"Namespace": m.Namespace,
+		}).Wrap(err, "failed install")
+	}
+	if m.DryRun {
+		return &InstallReleaseResponse{
+			Description:    "Dry run complete",
+			ReleaseName:    rls.Name,
+			ReleaseVersion: int32(rls.Version),
+		}, nil
+	}
+
+	rls.Info.Status = driver.Helm3StatusPendingInstall
+	if err := s.Storage.Create(rls); err != nil {
+		return &InstallReleaseResponse{}, errors.Wrap(err, "failed to store release")
+	}
+	if err := s.applyManifest(ctx, rls.Manifest, "", rls.Namespace); err != nil {
+		return &InstallReleaseResponse{}, s.failRelease(rls, err, "failed install")
+	}
+	rls.Info.Status = driver.Helm3StatusDeployed
+	rls.Info.Description = "Install complete"
+	if err := s.Storage.Update(rls); err != nil {
+		return &InstallReleaseResponse{}, errors.Wrap(err, "failed to store release")
+	}
+	return &InstallReleaseResponse{
+		Description:    rls.Info.Description,
+		ReleaseName:    rls.Name,
+		ReleaseVersion: int32(rls.Version),
+	}, nil
+}
+
+//DiffRelease compares the differences between a running release and a proposed release
+func (s *Helm3Session) DiffRelease(ctx context.Context, m *ReleaseMeta) (bool, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return false, nil, err
+	}
+	buf := bytes.NewBufferString("")
+	current, err := s.lastRelease(m.Namespace, m.ReleaseName)
+	if err != nil {
+		return false, nil, err
+	}
+	if current == nil {
+		return false, nil, errors.WithFields(errors.Fields{
+			"Name": m.ReleaseName,
+		}).New("release not found")
+	}
+	proposed, err := s.newRelease(m, getChartManifestTag(Helm3ChartToChart(current.Chart)), current.Version+1, true)
+	if err != nil {
+		return false, []byte{}, errors.Wrap(err, "failed to render proposed release")
+	}
+
+	opts := m.DiffOptions
+	if opts == nil {
+		opts = NewDiffOptions()
+	}
+	manifestsChanged := DiffManifests(
+		RedactMappings(Parse(normalizeManifest(current.Manifest), current.Namespace), opts),
+		RedactMappings(Parse(normalizeManifest(proposed.Manifest), proposed.Namespace), opts),
+		opts.SuppressedKinds,
+		opts.Context,
+		buf)
+	valuesChanged := DiffOverrides(helm3Config(current.Config).Raw, helm3Config(proposed.Config).Raw, opts, buf)
+	return manifestsChanged || valuesChanged, buf.Bytes(), nil
+}
+
+// GetRelease retrieves release data by release revision, revision 0 returns the latest revision
+// the release is searched for in every namespace, see history
+func (s *Helm3Session) GetRelease(ctx context.Context, releaseName string, revision int32) (*ReleaseMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rls, err := s.releaseRevision("", releaseName, revision)
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseMeta{
+		Chart:       Helm3ChartToChart(rls.Chart),
+		ReleaseName: rls.Name,
+		Namespace:   rls.Namespace,
+		Revision:    int32(rls.Version),
+		Status:      helm3Status(rls),
+		Config:      helm3Config(rls.Config),
+		Manifest:    normalizeManifest(rls.Manifest),
+	}, nil
+}
+
+//UpgradeRelease renders a chart and replaces the objects of the running release
+func (s *Helm3Session) UpgradeRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*UpgradeReleaseResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return &UpgradeReleaseResponse{}, err
+	}
+	current, err := s.lastRelease(m.Namespace, m.ReleaseName)
+	if err != nil {
+		return &UpgradeReleaseResponse{}, errors.Wrap(err, "Error during UpgradeRelease")
+	}
+	if current == nil {
+		return &UpgradeReleaseResponse{}, errors.WithFields(errors.Fields{
+			"Name": m.ReleaseName,
+		}).New("Error during UpgradeRelease, release not found")
+	}
+	// The release namespace cannot change during an upgrade
+	m.Namespace = current.Namespace
+
+	rls, err := s.newRelease(m, manifestName, current.Version+1, true)
+	if err != nil {
+		return &UpgradeReleaseResponse{}, errors.Wrap(err, "Error during UpgradeRelease")
+	}
+	rls.Info.FirstDeployed = current.Info.FirstDeployed
+	if m.DryRun {
+		return &UpgradeReleaseResponse{
+			Description:    "Dry run complete",
+			ReleaseVersion: int32(rls.Version),
+		}, nil
+	}
+	if err := s.replaceRelease(ctx, current, rls, "Upgrade complete"); err != nil {
+		return &UpgradeReleaseResponse{}, errors.Wrap(err, "Error during UpgradeRelease")
+	}
+	return &UpgradeReleaseResponse{
+		Description:    rls.Info.Description,
+		ReleaseVersion: int32(rls.Version),
+	}, nil
+}
+
+//DeleteReleases calls DeleteRelease on an array of Releases
+func (s *Helm3Session) DeleteReleases(ctx context.Context, dm []*DeleteMeta) error {
+	for _, v := range dm {
+		if err := s.DeleteRelease(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//DeleteRelease removes the objects of a release, Purge also removes the release history
+func (s *Helm3Session) DeleteRelease(ctx context.Context, m *DeleteMeta) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	current, err := s.lastRelease(m.Namespace, m.ReleaseName)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return errors.WithFields(errors.Fields{
+			"Name": m.ReleaseName,
+		}).New("release not found")
+	}
+	if current.Info.Status == driver.Helm3StatusUninstalled && !m.Purge {
+		return errors.WithFields(errors.Fields{
+			"Name": m.ReleaseName,
+		}).New("release is already deleted")
+	}
+
+	if current.Info.Status != driver.Helm3StatusUninstalled {
+		if err := s.deleteManifest(ctx, current.Manifest, current.Namespace); err != nil {
+			return errors.WithFields(errors.Fields{
+				"Name": m.ReleaseName,
+			}).Wrap(err, "failed to delete release objects")
+		}
+		if m.Wait {
+			if err := waitDeleted(ctx, s.Objects, m, normalizeManifest(current.Manifest), current.Namespace); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.Purge {
+		history, err := s.history(current.Namespace, m.ReleaseName)
+		if err != nil {
+			return err
+		}
+		for _, v := range history {
+			if err := s.Storage.Delete(v); err != nil {
+				return errors.Wrap(err, "failed to purge release history")
+			}
+		}
+		return nil
+	}
+
+	current.Info.Status = driver.Helm3StatusUninstalled
+	current.Info.Deleted = time.Now()
+	current.Info.Description = "Deletion complete"
+	return s.Storage.Update(current)
+}
+
+//RollbackRelease re-applies the manifest of a previous revision as a new revision
+func (s *Helm3Session) RollbackRelease(ctx context.Context, m *RollbackMeta) (int32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	current, err := s.lastRelease(m.Namespace, m.ReleaseName)
+	if err != nil {
+		return 0, err
+	}
+	if current == nil {
+		return 0, errors.WithFields(errors.Fields{
+			"Name": m.ReleaseName,
+		}).New("release not found")
+	}
+	revision := m.Revision
+	if revision == 0 {
+		revision = int32(current.Version - 1)
+	}
+	target, err := s.releaseRevision(current.Namespace, m.ReleaseName, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	rls := &driver.Helm3Release{
+		Name:      target.Name,
+		Namespace: target.Namespace,
+		Chart:     target.Chart,
+		Config:    target.Config,
+		Manifest:  target.Manifest,
+		Hooks:     target.Hooks,
+		Version:   current.Version + 1,
+		Labels:    target.Labels,
+		Info: &driver.Helm3Info{
+			FirstDeployed: current.Info.FirstDeployed,
+			LastDeployed:  time.Now(),
+		},
+	}
+	if err := s.replaceRelease(ctx, current, rls, fmt.Sprintf("Rollback to %d", revision)); err != nil {
+		return 0, err
+	}
+	return int32(rls.Version), nil
+}
+
+//Releases returns a map of the latest revision of each release
+func (s *Helm3Session) Releases(ctx context.Context) (map[string]*ReleaseMeta, error) {
+	return s.ReleasesByManifest(ctx, "")
+}
+
+func (s *Helm3Session) ReleasesByManifest(ctx context.Context, manifestName string) (map[string]*ReleaseMeta, error) {
+	releaseList, err := s.ListReleasesByManifest(ctx, manifestName)
+	if err != nil {
+		return make(map[string]*ReleaseMeta), errors.Wrap(err, "failed to list releases")
+	}
+	return releaseMetaMap(releaseList), nil
+}
+
+func (s *Helm3Session) DiffManifests(oldIndex, newIndex map[string]*MappingResult, suppressedKinds []string, context int, to io.Writer) bool {
+	return DiffManifests(oldIndex, newIndex, suppressedKinds, context, to)
+}
+
+func (s *Helm3Session) ChartFromArchive(aChart io.Reader) (*chart.Chart, error) {
+	return ChartFromArchive(aChart)
+}
+
+// RepairDrift applies the desired state of the drifted objects of a release, see ObjectClient.RepairDrift
+func (s *Helm3Session) RepairDrift(ctx context.Context, drift *ReleaseDrift) error {
+	return s.Objects.RepairDrift(ctx, drift)
+}
+
+// DetectDrift compares each object of a release with its live state
+func (s *Helm3Session) DetectDrift(ctx context.Context, m *ReleaseMeta) (*ReleaseDrift, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	current, err := s.lastRelease(m.Namespace, m.ReleaseName)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ReleaseName": m.ReleaseName,
+		}).New("failed to get current release")
+	}
+	desired := current.Manifest
+	if m.Chart != nil {
+		proposed, err := s.newRelease(m, "", current.Version+1, true)
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"ReleaseName": m.ReleaseName,
+			}).Wrap(err, "failed to render desired state")
+		}
+		desired = proposed.Manifest
+	}
+	opts := m.DiffOptions
+	if opts == nil {
+		opts = NewDiffOptions()
+	}
+	return s.Objects.CompareManifests(m.ReleaseName, current.Namespace, normalizeManifest(current.Manifest), normalizeManifest(desired), opts)
+}
+
+// NewVersionDriver returns the driver holding Barrelman versions
+func (s *Helm3Session) NewVersionDriver() driver.VersionDriver {
+	return NewVersionDriver(s.Clientset, s.VersionsNamespace, s.VersionsStorage)
+}
+
+func (s *Helm3Session) WriteVersions(versions *Versions) error {
+	return writeVersions(s.NewVersionDriver(), s.releaseSources(), versions)
+}
+
+// CheckVersionSize returns an error when versions would not fit the storage of the session, see checkVersionSize
+func (s *Helm3Session) CheckVersionSize(versions *Versions) error {
+	return checkVersionSize(versions, s.releaseSources() == nil)
+}
+
+func (s *Helm3Session) GetVersionsFromList(manifestNames *[]string) ([]*Versions, error) {
+	return getVersionsFromList(s, manifestNames)
+}
+
+func (s *Helm3Session) GetVersions(manifestName string) (*Versions, error) {
+	return getVersions(s.NewVersionDriver(), s.releaseSources(), manifestName)
+}
+
+// PruneVersions removes all but the keep latest versions of manifestName, see pruneVersions
+func (s *Helm3Session) PruneVersions(manifestName string, keep int, dryRun bool) ([]*Version, error) {
+	return pruneVersions(s.NewVersionDriver(), s.releaseSources(), manifestName, keep, dryRun)
+}
+
+// ListManifests returns list of unique Barrelman manifests recorded in cluster
+func (s *Helm3Session) ListManifests() ([]*Version, error) {
+	return listManifests(s.NewVersionDriver())
+}
+
+// NewTransaction initializes a transaction for manifestName
+func (s *Helm3Session) NewTransaction(manifestName string) (Transactioner, error) {
+	return newTransaction(s, manifestName)
+}
+
+// history returns every stored revision of a release in namespace ordered by version
+// Helm 3 scopes release names by namespace, an empty namespace searches every namespace and
+// fails when releases of the same name exist in more than one
+func (s *Helm3Session) history(namespace, releaseName string) ([]*driver.Helm3Release, error) {
+	list, err := s.Storage.Query(namespace, map[string]string{"name": releaseName})
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ReleaseName": releaseName,
+			"Namespace":   namespace,
+		}).Wrap(err, "failed to get release history")
+	}
+	for _, v := range list {
+		if v.Namespace != list[0].Namespace {
+			return nil, errors.WithFields(errors.Fields{
+				"ReleaseName": releaseName,
+				"Namespaces":  []string{list[0].Namespace, v.Namespace},
+			}).New("release exists in more than one namespace")
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// lastRelease returns the latest revision of a release in namespace, or nil when it has never been installed
+func (s *Helm3Session) lastRelease(namespace, releaseName string) (*driver.Helm3Release, error) {
+	list, err := s.history(namespace, releaseName)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return list[len(list)-1], nil
+}
+
+func (s *Helm3Session) releaseRevision(namespace, releaseName string, revision int32) (*driver.Helm3Release, error) {
+	list, err := s.history(namespace, releaseName)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(list) - 1; i >= 0; i-- {
+		if revision == 0 || list[i].Version == int(revision) {
+			return list[i], nil
+		}
+	}
+	return nil, errors.WithFields(errors.Fields{
+		"ReleaseName": releaseName,
+		"Revision":    revision,
+	}).New("failed to get release by version")
+}
+
+// newRelease renders m into a release revision, the release is not stored
+func (s *Helm3Session) newRelease(m *ReleaseMeta, manifestName string, version int, upgrade bool) (*driver.Helm3Release, error) {
+	if m.Chart == nil {
+		return nil, errors.New("no chart supplied")
+	}
+	ch := m.Chart
+	if manifestName != "" {
+		ch = setChartManifestTags(m.Chart, "Manifest="+manifestName)
+	}
+	namespace := m.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	config := &chart.Config{Raw: string(m.ValueOverrides)}
+	renderedManifest, hooks, notes, err := renderChart(ch, config, chartutil.ReleaseOptions{
+		Name:      m.ReleaseName,
+		Namespace: namespace,
+		Revision:  version,
+		IsInstall: !upgrade,
+		IsUpgrade: upgrade,
+		Time:      timeconv.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	values, err := chartutil.ReadValues(m.ValueOverrides)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse value overrides")
+	}
+
+	now := time.Now()
+	rls := &driver.Helm3Release{
+		Name:      m.ReleaseName,
+		Namespace: namespace,
+		Chart:     ChartToHelm3Chart(ch),
+		Config:    values,
+		Manifest:  renderedManifest,
+		Hooks:     hooks,
+		Version:   version,
+		Labels:    map[string]string{},
+		Info: &driver.Helm3Info{
+			FirstDeployed: now,
+			LastDeployed:  now,
+			Notes:         notes,
+		},
+	}
+	if manifestName != "" {
+		rls.Labels[manifestLabel] = manifestName
+	}
+	return rls, nil
+}
+
+// replaceRelease applies rls over the objects of current and marks current as superseded
+func (s *Helm3Session) replaceRelease(ctx context.Context, current, rls *driver.Helm3Release, description string) error {
+	rls.Info.Status = driver.Helm3StatusPendingUpgrade
+	if err := s.Storage.Create(rls); err != nil {
+		return errors.Wrap(err, "failed to store release")
+	}
+	if err := s.applyManifest(ctx, rls.Manifest, current.Manifest, rls.Namespace); err != nil {
+		return s.failRelease(rls, err, "failed to apply release")
+	}
+	if current.Info.Status != driver.Helm3StatusUninstalled {
+		current.Info.Status = driver.Helm3StatusSuperseded
+		if err := s.Storage.Update(current); err != nil {
+			return errors.Wrap(err, "failed to supersede previous release")
+		}
+	}
+	rls.Info.Status = driver.Helm3StatusDeployed
+	rls.Info.Description = description
+	return s.Storage.Update(rls)
+}
+
+// failRelease records a failed release revision and returns cause
+func (s *Helm3Session) failRelease(rls *driver.Helm3Release, cause error, msg string) error {
+	rls.Info.Status = driver.Helm3StatusFailed
+	rls.Info.Description = fmt.Sprintf("%s: %s", msg, cause.Error())
+	if err := s.Storage.Update(rls); err != nil {
+		log.WithFields(log.Fields{
+			"Name":  rls.Name,
+			"Error": err.Error(),
+		}).Warn("failed to record release failure")
+	}
+	return errors.WithFields(errors.Fields{
+		"Name":      rls.Name,
+		"Namespace": rls.Namespace,
+	}).Wrap(cause, msg)
+}
+
+// applyManifest creates or replaces every object in desired, objects only found in previous are deleted
+// it stops between objects once ctx is done
+func (s *Helm3Session) applyManifest(ctx context.Context, desired, previous, namespace string) error {
+	desiredObjects, err := ObjectsFromManifest(normalizeManifest(desired), namespace)
+	if err != nil {
+		return err
+	}
+	previousObjects, err := ObjectsFromManifest(normalizeManifest(previous), namespace)
+	if err != nil {
+		return err
+	}
+	for _, obj := range orderObjects(desiredObjects, tiller.InstallOrder) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.Objects.Apply(obj, namespace); err != nil {
+			return err
+		}
+	}
+	removed := make(map[string]*unstructured.Unstructured)
+	for key, obj := range previousObjects {
+		if _, ok := desiredObjects[key]; !ok {
+			removed[key] = obj
+		}
+	}
+	for _, obj := range orderObjects(removed, tiller.UninstallOrder) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.Objects.Delete(obj, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Helm3Session) deleteManifest(ctx context.Context, current, namespace string) error {
+	objects, err := ObjectsFromManifest(normalizeManifest(current), namespace)
+	if err != nil {
+		return err
+	}
+	for _, obj := range orderObjects(objects, tiller.UninstallOrder) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.Objects.Delete(obj, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderObjects sorts objects by kind in the given order, then by key
+func orderObjects(objects map[string]*unstructured.Unstructured, order tiller.SortOrder) []*unstructured.Unstructured {
+	rank := make(map[string]int)
+	for i, v := range order {
+		rank[v] = i
+	}
+	keys := sortedKeys(objects)
+	sort.SliceStable(keys, func(i, j int) bool {
+		ri, ok := rank[objects[keys[i]].GetKind()]
+		if !ok {
+			ri = len(order)
+		}
+		rj, ok := rank[objects[keys[j]].GetKind()]
+		if !ok {
+			rj = len(order)
+		}
+		return ri < rj
+	})
+	ret := []*unstructured.Unstructured{}
+	for _, k := range keys {
+		ret = append(ret, objects[k])
+	}
+	return ret
+}
+
+// renderChart renders a chart into a manifest in install order, hooks and notes are returned separately
+func renderChart(ch *chart.Chart, config *chart.Config, opts chartutil.ReleaseOptions) (string, []*driver.Helm3Hook, string, error) {
+	rendered, err := renderutil.Render(ch, config, renderutil.Options{ReleaseOptions: opts})
+	if err != nil {
+		return "", nil, "", errors.Wrap(err, "failed to render chart")
+	}
+
+	notes := ""
+	docs := map[string]string{}
+	for name, content := range rendered {
+		base := filepath.Base(name)
+		if base == "NOTES.txt" {
+			if strings.Count(name, "/charts/") == 0 {
+				notes = content
+			}
+			continue
+		}
+		if strings.HasPrefix(base, "_") || whitespaceRegex.MatchString(content) {
+			continue
+		}
+		for i, doc := range releaseutil.SplitManifests(content) {
+			if whitespaceRegex.MatchString(doc) {
+				continue
+			}
+			docs[fmt.Sprintf("%s#%s", name, i)] = doc
+		}
+	}
+
+	hooks := []*driver.Helm3Hook{}
+	manifests := []manifest.Manifest{}
+	for _, key := range sortedKeys(docs) {
+		name := strings.SplitN(key, "#", 2)[0]
+		var head releaseutil.SimpleHead
+		if err := yaml.Unmarshal([]byte(docs[key]), &head); err != nil {
+			return "", nil, "", errors.WithFields(errors.Fields{
+				"Template": name,
+			}).Wrap(err, "failed to parse rendered template")
+		}
+		if head.Metadata != nil {
+			if events, ok := head.Metadata.Annotations[hookAnnotation]; ok {
+				hooks = append(hooks, &driver.Helm3Hook{
+					Name:     head.Metadata.Name,
+					Kind:     head.Kind,
+					Path:     name,
+					Manifest: docs[key],
+					Events:   strings.Split(events, ","),
+				})
+				continue
+			}
+		}
+		manifests = append(manifests, manifest.Manifest{Name: name, Content: docs[key], Head: &head})
+	}
+
+	buf := bytes.NewBufferString("")
+	for _, m := range tiller.SortByKind(manifests) {
+		fmt.Fprintf(buf, "---\n# Source: %s\n%s\n", m.Name, m.Content)
+	}
+	return buf.String(), hooks, notes, nil
+}
+
+// normalizeManifest prefixes a manifest with a newline so Parse does not discard the first document
+func normalizeManifest(m string) string {
+	if strings.HasPrefix(m, "---") {
+		return "\n" + m
+	}
+	return m
+}
+
+// ChartToHelm3Chart converts a chart into the form stored in a Helm 3 release
+// dependencies are stored in a field ignored by the Helm 3 client
+func ChartToHelm3Chart(ch *chart.Chart) *driver.Helm3Chart {
+	if ch == nil {
+		return nil
+	}
+	ret := &driver.Helm3Chart{
+		Metadata:  &driver.Helm3Metadata{},
+		Templates: []*driver.Helm3File{},
+		Values:    map[string]interface{}{},
+		Files:     []*driver.Helm3File{},
+	}
+	if md := ch.Metadata; md != nil {
+		ret.Metadata = &driver.Helm3Metadata{
+			Name:        md.Name,
+			Home:        md.Home,
+			Sources:     md.Sources,
+			Version:     md.Version,
+			Description: md.Description,
+			Keywords:    md.Keywords,
+			Icon:        md.Icon,
+			APIVersion:  "v1",
+			Condition:   md.Condition,
+			Tags:        md.Tags,
+			AppVersion:  md.AppVersion,
+			Deprecated:  md.Deprecated,
+			Annotations: md.Annotations,
+			KubeVersion: md.KubeVersion,
+		}
+	}
+	for _, t := range ch.Templates {
+		ret.Templates = append(ret.Templates, &driver.Helm3File{Name: t.Name, Data: t.Data})
+	}
+	if ch.Values != nil {
+		if values, err := chartutil.ReadValues([]byte(ch.Values.Raw)); err == nil {
+			ret.Values = values
+		}
+	}
+	for _, f := range ch.Files {
+		ret.Files = append(ret.Files, &driver.Helm3File{Name: f.TypeUrl, Data: f.Value})
+	}
+	for _, d := range ch.Dependencies {
+		ret.Dependencies = append(ret.Dependencies, ChartToHelm3Chart(d))
+	}
+	return ret
+}
+
+// Helm3ChartToChart converts a chart stored in a Helm 3 release
+func Helm3ChartToChart(ch *driver.Helm3Chart) *chart.Chart {
+	ret := &chart.Chart{
+		Metadata: &chart.Metadata{},
+		Values:   &chart.Config{Raw: "{}\n"},
+	}
+	if ch == nil {
+		return ret
+	}
+	if md := ch.Metadata; md != nil {
+		ret.Metadata = &chart.Metadata{
+			Name:        md.Name,
+			Home:        md.Home,
+			Sources:     md.Sources,
+			Version:     md.Version,
+			Description: md.Description,
+			Keywords:    md.Keywords,
+			Icon:        md.Icon,
+			ApiVersion:  chartutil.ApiVersionV1,
+			Condition:   md.Condition,
+			Tags:        md.Tags,
+			AppVersion:  md.AppVersion,
+			Deprecated:  md.Deprecated,
+			Annotations: md.Annotations,
+			KubeVersion: md.KubeVersion,
+		}
+	}
+	for _, t := range ch.Templates {
+		ret.Templates = append(ret.Templates, &chart.Template{Name: t.Name, Data: t.Data})
+	}
+	ret.Values = helm3Config(ch.Values)
+	for _, f := range ch.Files {
+		ret.Files = append(ret.Files, &any.Any{TypeUrl: f.Name, Value: f.Data})
+	}
+	for _, d := range ch.Dependencies {
+		ret.Dependencies = append(ret.Dependencies, Helm3ChartToChart(d))
+	}
+	return ret
+}
+
+func helm3Config(values map[string]interface{}) *chart.Config {
+	raw, err := chartutil.Values(values).YAML()
+	if err != nil || len(values) == 0 {
+		raw = "{}\n"
+	}
+	return &chart.Config{Raw: raw}
+}
+
+func helm3Status(rls *driver.Helm3Release) Status {
+	if rls.Info == nil {
+		return Status_UNKNOWN
+	}
+	switch rls.Info.Status {
+	case driver.Helm3StatusDeployed:
+		return Status_DEPLOYED
+	case driver.Helm3StatusFailed:
+		return Status_FAILED
+	case driver.Helm3StatusUninstalled:
+		return Status_DELETED
+	case driver.Helm3StatusSuperseded:
+		return Status(release.Status_SUPERSEDED)
+	case driver.Helm3StatusUninstalling:
+		return Status(release.Status_DELETING)
+	case driver.Helm3StatusPendingInstall:
+		return Status_PENDING_INSTALL
+	case driver.Helm3StatusPendingUpgrade:
+		return Status_PENDING_UPGRADE
+	case driver.Helm3StatusPendingRollback:
+		return Status_PENDING_ROLLBACK
+	}
+	return Status_UNKNOWN
+}
@@ -0,0 +1,371 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/charter-oss/barrelman/pkg/cluster/driver"
+)
+
+func newTestHelm3Session() *Helm3Session {
+	s := NewHelm3Session("", "")
+	s.Clientset = newTestClientset()
+	s.Objects = newTestObjectClient()
+	return s
+}
+
+func newTestHelm3Chart() *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{
+			Name:    "app",
+			Version: "0.1.0",
+		},
+		Templates: []*chart.Template{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}-settings\ndata:\n  setting: {{ .Values.setting | quote }}\n"),
+			},
+			{
+				Name: "templates/hook.yaml",
+				Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}-hook\n  annotations:\n    helm.sh/hook: pre-install\n"),
+			},
+		},
+		Values: &chart.Config{Raw: "setting: one\n"},
+	}
+}
+
+func TestHelm3Session(t *testing.T) {
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	Convey("Helm3Session", t, func() {
+		s := newTestHelm3Session()
+		So(s.Init(), ShouldBeNil)
+		meta := &ReleaseMeta{
+			Chart:       newTestHelm3Chart(),
+			ReleaseName: "app",
+			Namespace:   "apps",
+		}
+		liveSetting := func() string {
+			obj, err := s.Objects.Dynamic.Resource(configMaps).Namespace("apps").Get("app-settings", metav1.GetOptions{})
+			So(err, ShouldBeNil)
+			data, _ := obj.Object["data"].(map[string]interface{})
+			return data["setting"].(string)
+		}
+
+		Convey("Dry run installs store nothing", func() {
+			meta.DryRun = true
+			res, err := s.InstallRelease(context.Background(), meta, "test-manifest")
+			So(err, ShouldBeNil)
+			So(res.ReleaseVersion, ShouldEqual, 1)
+			list, err := s.ListReleases(context.Background())
+			So(err, ShouldBeNil)
+			So(list, ShouldBeEmpty)
+		})
+
+		Convey("Install stores a Helm 3 release Secret", func() {
+			res, err := s.InstallRelease(context.Background(), meta, "test-manifest")
+			So(err, ShouldBeNil)
+			So(res.ReleaseVersion, ShouldEqual, 1)
+
+			secret, err := s.Clientset.CoreV1().Secrets("apps").Get("sh.helm.release.v1.app.v1", metav1.GetOptions{})
+			So(err, ShouldBeNil)
+			So(string(secret.Type), ShouldEqual, driver.Helm3SecretType)
+			So(secret.Labels["owner"], ShouldEqual, "helm")
+			So(secret.Labels["status"], ShouldEqual, driver.Helm3StatusDeployed)
+			So(secret.Labels[manifestLabel], ShouldEqual, "test-manifest")
+			So(liveSetting(), ShouldEqual, "one")
+
+			_, err = s.Objects.Dynamic.Resource(configMaps).Namespace("apps").Get("app-hook", metav1.GetOptions{})
+			So(err, ShouldNotBeNil)
+
+			Convey("Releases are filtered by manifest", func() {
+				owned, err := s.ReleasesByManifest(context.Background(), "test-manifest")
+				So(err, ShouldBeNil)
+				So(owned, ShouldContainKey, ReleaseKey("apps", "app"))
+				So(owned[ReleaseKey("apps", "app")].Status, ShouldEqual, Status_DEPLOYED)
+				So(owned[ReleaseKey("apps", "app")].Namespace, ShouldEqual, "apps")
+				other, err := s.ReleasesByManifest(context.Background(), "other")
+				So(err, ShouldBeNil)
+				So(other, ShouldBeEmpty)
+			})
+
+			Convey("Installing a running release fails", func() {
+				_, err := s.InstallRelease(context.Background(), meta, "test-manifest")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Releases of the same name are kept per namespace", func() {
+				web := *meta
+				web.Namespace = "web"
+				res, err := s.InstallRelease(context.Background(), &web, "test-manifest")
+				So(err, ShouldBeNil)
+				So(res.ReleaseVersion, ShouldEqual, 1)
+
+				up, err := s.UpgradeRelease(context.Background(), meta, "test-manifest")
+				So(err, ShouldBeNil)
+				So(up.ReleaseVersion, ShouldEqual, 2)
+				owned, err := s.ReleasesByManifest(context.Background(), "test-manifest")
+				So(err, ShouldBeNil)
+				So(owned, ShouldHaveLength, 2)
+				So(owned[ReleaseKey("apps", "app")].Revision, ShouldEqual, 2)
+				So(owned[ReleaseKey("web", "app")].Revision, ShouldEqual, 1)
+
+				_, err = s.GetRelease(context.Background(), "app", 0)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Upgrade and rollback create new revisions", func() {
+				meta.ValueOverrides = []byte("setting: two\n")
+				changed, diff, err := s.DiffRelease(context.Background(), meta)
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+				So(string(diff), ShouldContainSubstring, "two")
+
+				up, err := s.UpgradeRelease(context.Background(), meta, "test-manifest")
+				So(err, ShouldBeNil)
+				So(up.ReleaseVersion, ShouldEqual, 2)
+				So(liveSetting(), ShouldEqual, "two")
+				first, err := s.GetRelease(context.Background(), "app", 1)
+				So(err, ShouldBeNil)
+				So(first.Status, ShouldEqual, Status(release.Status_SUPERSEDED))
+				So(first.Manifest, ShouldContainSubstring, "setting: \"one\"")
+
+				version, err := s.RollbackRelease(context.Background(), &RollbackMeta{ReleaseName: "app", Revision: 1})
+				So(err, ShouldBeNil)
+				So(version, ShouldEqual, 3)
+				So(liveSetting(), ShouldEqual, "one")
+				latest, err := s.GetRelease(context.Background(), "app", 0)
+				So(err, ShouldBeNil)
+				So(latest.Revision, ShouldEqual, 3)
+				So(getChartManifestTag(latest.Chart), ShouldEqual, "test-manifest")
+			})
+
+			Convey("Delete removes objects and keeps history", func() {
+				So(s.DeleteRelease(context.Background(), &DeleteMeta{ReleaseName: "app"}), ShouldBeNil)
+				_, err := s.Objects.Dynamic.Resource(configMaps).Namespace("apps").Get("app-settings", metav1.GetOptions{})
+				So(err, ShouldNotBeNil)
+				owned, err := s.ReleasesByManifest(context.Background(), "test-manifest")
+				So(err, ShouldBeNil)
+				So(owned[ReleaseKey("apps", "app")].Status, ShouldEqual, Status_DELETED)
+
+				Convey("Deleted releases can be installed again", func() {
+					res, err := s.InstallRelease(context.Background(), meta, "test-manifest")
+					So(err, ShouldBeNil)
+					So(res.ReleaseVersion, ShouldEqual, 2)
+				})
+
+				Convey("Wait returns once the objects are gone", func() {
+					res, err := s.InstallRelease(context.Background(), meta, "test-manifest")
+					So(err, ShouldBeNil)
+					So(res.ReleaseVersion, ShouldEqual, 2)
+					So(s.DeleteRelease(context.Background(), &DeleteMeta{ReleaseName: "app", Wait: true}), ShouldBeNil)
+				})
+
+				Convey("Purge removes the history", func() {
+					So(s.DeleteRelease(context.Background(), &DeleteMeta{ReleaseName: "app", Purge: true}), ShouldBeNil)
+					list, err := s.ListReleases(context.Background())
+					So(err, ShouldBeNil)
+					So(list, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("Versions stored as Secrets hold the charts of changed releases", func() {
+			s.VersionsStorage = VersionsStorageSecret
+			versions := NewVersions("test-manifest")
+			versions.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 1, Chart: newTestHelm3Chart(), Modified: true})
+			versions.AddReleaseVersion(&Version{Name: "other", Namespace: "apps", Revision: 1, Chart: newTestHelm3Chart()})
+			So(s.WriteVersions(versions), ShouldBeNil)
+
+			stored, err := s.GetVersions("test-manifest")
+			So(err, ShouldBeNil)
+			changed, _, err := stored.Latest().StoredRelease("app")
+			So(err, ShouldBeNil)
+			So(changed.Metadata.Name, ShouldEqual, "app")
+			unchanged, _, err := stored.Latest().StoredRelease("other")
+			So(err, ShouldBeNil)
+			So(unchanged, ShouldBeNil)
+			secrets, err := s.Clientset.CoreV1().Secrets(DefaultVersionsNamespace).List(metav1.ListOptions{})
+			So(err, ShouldBeNil)
+			So(secrets.Items, ShouldHaveLength, 1)
+			So(string(secrets.Items[0].Type), ShouldEqual, driver.SecretType)
+		})
+
+		Convey("Versions are stored in the versions namespace", func() {
+			transaction, err := s.NewTransaction("test-manifest")
+			So(err, ShouldBeNil)
+			transaction.Versions().AddReleaseVersion(&Version{
+				Name:      "app",
+				Namespace: "apps",
+				Revision:  1,
+				Chart:     newTestHelm3Chart(),
+				Config:    &chart.Config{Raw: "setting: two\n"},
+				Modified:  true,
+			})
+			source := NewVersionSource("1.2.3", "abc123")
+			source.Manifest = []byte("---\nschema: barrelman/Manifest/v1\n")
+			source.Charts = []*ChartSource{{Name: "app", Type: "git", Location: "https://example.com/charts", Commit: "0123abcd"}}
+			transaction.Versions().Source = source
+			transaction.SetChanged()
+			So(transaction.Complete(), ShouldBeNil)
+
+			versions, err := s.GetVersions("test-manifest")
+			So(err, ShouldBeNil)
+			So(versions.Data, ShouldHaveLength, 1)
+			cms, err := s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).List(metav1.ListOptions{})
+			So(err, ShouldBeNil)
+			So(cms.Items, ShouldHaveLength, 1)
+			So(versions.Previous(), ShouldBeNil)
+
+			Convey("The chart and values of changed releases are stored apart from the version ConfigMap", func() {
+				embedded, _, err := versions.Latest().StoredRelease("app")
+				So(err, ShouldBeNil)
+				So(embedded, ShouldBeNil)
+				secrets, err := s.Clientset.CoreV1().Secrets(DefaultVersionsNamespace).List(metav1.ListOptions{})
+				So(err, ShouldBeNil)
+				So(secrets.Items, ShouldHaveLength, 1)
+				So(string(secrets.Items[0].Type), ShouldEqual, ReleaseSourceType)
+
+				stored, config, err := versions.StoredRelease(versions.Latest(), "app")
+				So(err, ShouldBeNil)
+				So(stored.Metadata.Name, ShouldEqual, "app")
+				So(stored.Templates, ShouldHaveLength, 2)
+				So(config.Raw, ShouldEqual, "setting: two\n")
+				missing, _, err := versions.StoredRelease(versions.Latest(), "other")
+				So(err, ShouldBeNil)
+				So(missing, ShouldBeNil)
+
+				Convey("A version leaving the release unchanged shares them", func() {
+					unchanged := NewVersions("test-manifest")
+					unchanged.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 1, Chart: newTestHelm3Chart()})
+					unchanged.AddReleaseVersion(&Version{Name: "other", Namespace: "apps", Revision: 1, Chart: newTestHelm3Chart(), Modified: true})
+					So(s.WriteVersions(unchanged), ShouldBeNil)
+					versions, err := s.GetVersions("test-manifest")
+					So(err, ShouldBeNil)
+					secrets, err := s.Clientset.CoreV1().Secrets(DefaultVersionsNamespace).List(metav1.ListOptions{})
+					So(err, ShouldBeNil)
+					So(secrets.Items, ShouldHaveLength, 2)
+
+					stored, config, err := versions.StoredRelease(versions.Latest(), "app")
+					So(err, ShouldBeNil)
+					So(stored.Metadata.Name, ShouldEqual, "app")
+					So(config.Raw, ShouldEqual, "setting: two\n")
+
+					Convey("Unless the release changed revision since", func() {
+						upgraded := NewVersions("test-manifest")
+						upgraded.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 2, Chart: newTestHelm3Chart()})
+						So(s.WriteVersions(upgraded), ShouldBeNil)
+						versions, err := s.GetVersions("test-manifest")
+						So(err, ShouldBeNil)
+						stored, _, err := versions.StoredRelease(versions.Latest(), "app")
+						So(err, ShouldBeNil)
+						So(stored, ShouldBeNil)
+					})
+
+					Convey("Pruning a version removes its release sources", func() {
+						pruned, err := s.PruneVersions("test-manifest", 1, false)
+						So(err, ShouldBeNil)
+						So(pruned, ShouldHaveLength, 1)
+						secrets, err := s.Clientset.CoreV1().Secrets(DefaultVersionsNamespace).List(metav1.ListOptions{})
+						So(err, ShouldBeNil)
+						So(secrets.Items, ShouldHaveLength, 1)
+						So(secrets.Items[0].Labels["RELEASE"], ShouldEqual, "other")
+					})
+				})
+			})
+
+			Convey("The manifest and chart sources are stored", func() {
+				stored, err := versions.Latest().Source()
+				So(err, ShouldBeNil)
+				So(stored.BarrelmanVersion, ShouldEqual, "1.2.3")
+				So(stored.User, ShouldNotBeEmpty)
+				So(string(stored.Manifest), ShouldEqual, "---\nschema: barrelman/Manifest/v1\n")
+				So(stored.Chart("app").String(), ShouldEqual, "git https://example.com/charts@0123abcd")
+				So(stored.Chart("other"), ShouldBeNil)
+			})
+
+			Convey("A deleted version records the teardown", func() {
+				deleted := NewVersions("test-manifest")
+				deleted.Deleted = true
+				deleted.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 1, Modified: true})
+				So(s.WriteVersions(deleted), ShouldBeNil)
+				versions, err := s.GetVersions("test-manifest")
+				So(err, ShouldBeNil)
+				latest := versions.Latest()
+				So(latest.Revision, ShouldEqual, 2)
+				So(versions.Previous().Revision, ShouldEqual, 1)
+				So(latest.Info.GetStatus().GetCode(), ShouldEqual, release.Status_DELETED)
+				entries, err := latest.ReleaseEntries()
+				So(err, ShouldBeNil)
+				So(entries, ShouldBeEmpty)
+			})
+
+			Convey("Old versions are pruned", func() {
+				write := func(revision int32, historyMax int) {
+					next := NewVersions("test-manifest")
+					next.HistoryMax = historyMax
+					next.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: revision, Modified: true})
+					So(s.WriteVersions(next), ShouldBeNil)
+				}
+				revisions := func() []int32 {
+					versions, err := s.GetVersions("test-manifest")
+					So(err, ShouldBeNil)
+					revs := []int32{}
+					for _, v := range versions.Data {
+						revs = append(revs, v.Revision)
+					}
+					sort.Slice(revs, func(i, j int) bool { return revs[i] < revs[j] })
+					return revs
+				}
+				for i := int32(2); i <= 5; i++ {
+					write(i, 0)
+				}
+				So(revisions(), ShouldResemble, []int32{1, 2, 3, 4, 5})
+
+				// tag version 2 so it is kept
+				cms, err := s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).List(metav1.ListOptions{LabelSelector: "VERSION=2"})
+				So(err, ShouldBeNil)
+				So(cms.Items, ShouldHaveLength, 1)
+				tagged := cms.Items[0]
+				tagged.Labels[versionTagLabel] = "stable"
+				_, err = s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).Update(&tagged)
+				So(err, ShouldBeNil)
+
+				Convey("A dry run removes nothing", func() {
+					pruned, err := s.PruneVersions("test-manifest", 2, true)
+					So(err, ShouldBeNil)
+					So(pruned, ShouldHaveLength, 2)
+					So(revisions(), ShouldResemble, []int32{1, 2, 3, 4, 5})
+				})
+
+				Convey("The newest and tagged versions are kept", func() {
+					pruned, err := s.PruneVersions("test-manifest", 2, false)
+					So(err, ShouldBeNil)
+					So(pruned, ShouldHaveLength, 2)
+					So(revisions(), ShouldResemble, []int32{2, 4, 5})
+					versions, err := s.GetVersions("test-manifest")
+					So(err, ShouldBeNil)
+					So(versions.Table().Data[2].Tag, ShouldEqual, "stable")
+				})
+
+				Convey("The latest version is always kept", func() {
+					_, err := s.PruneVersions("test-manifest", 0, false)
+					So(err, ShouldBeNil)
+					So(revisions(), ShouldResemble, []int32{2, 5})
+				})
+
+				Convey("HistoryMax prunes as versions are written", func() {
+					write(6, 3)
+					So(revisions(), ShouldResemble, []int32{2, 4, 5, 6})
+				})
+			})
+		})
+	})
+}
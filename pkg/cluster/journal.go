@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"encoding/json"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/cirrocloud/structured/errors"
+)
+
+// journalPrefix names the ConfigMap holding the journal of a manifest
+const journalPrefix = "barrelman-journal."
+
+// Journaler stores the journal of an in-progress transaction
+// a journal left behind by an interrupted process is recovered with the recover command
+type Journaler interface {
+	GetJournal(manifestName string) (*Journal, error)
+	WriteJournal(journal *Journal) error
+	DeleteJournal(manifestName string) error
+}
+
+// Journal is the durable record of a transaction
+type Journal struct {
+	ManifestName string
+	Started      time.Time
+	Updated      time.Time
+	// StartVersion is the latest manifest version when the transaction started
+	StartVersion int32
+	Releases     []*JournalEntry
+}
+
+// JournalEntry records a release of a transaction
+type JournalEntry struct {
+	Name             string
+	Namespace        string
+	Revision         int32
+	PreviousRevision int32
+	Modified         bool
+	TillerNamespace  string `json:",omitempty"`
+	// Removed marks a release deleted by the transaction
+	Removed bool `json:",omitempty"`
+	// Deleted marks a release that was deleted before the transaction changed it
+	// the chart and config of replaced releases are not journaled, a recovered cancel relies on the release history
+	Deleted bool `json:",omitempty"`
+}
+
+func (j *Journal) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"ManifestName": j.ManifestName,
+		"Started":      j.Started.Format(time.RFC3339),
+		"Modified":     len(j.Modified()),
+	}
+}
+
+func (j *Journal) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"ManifestName": j.ManifestName,
+		"Started":      j.Started.Format(time.RFC3339),
+		"Updated":      j.Updated.Format(time.RFC3339),
+		"StartVersion": j.StartVersion,
+		"Releases":     len(j.Releases),
+		"Modified":     len(j.Modified()),
+	}
+}
+
+// Modified returns the entries of releases changed by the transaction
+func (j *Journal) Modified() []*JournalEntry {
+	modified := []*JournalEntry{}
+	for _, entry := range j.Releases {
+		if entry.Modified {
+			modified = append(modified, entry)
+		}
+	}
+	return modified
+}
+
+// Lookup returns the entry of release name
+func (j *Journal) Lookup(name string) *JournalEntry {
+	for _, entry := range j.Releases {
+		if entry.Name == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (je *JournalEntry) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"Name":     je.Name,
+		"Revision": je.Revision,
+		"Modified": je.Modified,
+	}
+}
+
+func (je *JournalEntry) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"Name":             je.Name,
+		"Namespace":        je.Namespace,
+		"Revision":         je.Revision,
+		"PreviousRevision": je.PreviousRevision,
+		"Modified":         je.Modified,
+		"TillerNamespace":  je.TillerNamespace,
+	}
+}
+
+// Version returns the entry as a transaction Version
+func (je *JournalEntry) Version() *Version {
+	v := &Version{
+		Name:             je.Name,
+		Namespace:        je.Namespace,
+		Revision:         je.Revision,
+		PreviousRevision: je.PreviousRevision,
+		Modified:         je.Modified,
+		TillerNamespace:  je.TillerNamespace,
+		Removed:          je.Removed,
+	}
+	if je.Deleted {
+		v.Prior = &PriorRelease{Status: Status_DELETED}
+	}
+	return v
+}
+
+// GetJournal returns the journal of manifestName from the Tiller namespace, nil when there is none
+func (s *Session) GetJournal(manifestName string) (*Journal, error) {
+	return NewConfigMapJournal(s.Clientset.CoreV1().ConfigMaps(s.versionsNamespace())).GetJournal(manifestName)
+}
+
+// WriteJournal records journal in the Tiller namespace
+func (s *Session) WriteJournal(journal *Journal) error {
+	return NewConfigMapJournal(s.Clientset.CoreV1().ConfigMaps(s.versionsNamespace())).WriteJournal(journal)
+}
+
+// DeleteJournal removes the journal of manifestName from the Tiller namespace
+func (s *Session) DeleteJournal(manifestName string) error {
+	return NewConfigMapJournal(s.Clientset.CoreV1().ConfigMaps(s.versionsNamespace())).DeleteJournal(manifestName)
+}
+
+// GetJournal returns the journal of manifestName from the versions namespace, nil when there is none
+func (s *Helm3Session) GetJournal(manifestName string) (*Journal, error) {
+	return NewConfigMapJournal(s.Clientset.CoreV1().ConfigMaps(s.VersionsNamespace)).GetJournal(manifestName)
+}
+
+// WriteJournal records journal in the versions namespace
+func (s *Helm3Session) WriteJournal(journal *Journal) error {
+	return NewConfigMapJournal(s.Clientset.CoreV1().ConfigMaps(s.VersionsNamespace)).WriteJournal(journal)
+}
+
+// DeleteJournal removes the journal of manifestName from the versions namespace
+func (s *Helm3Session) DeleteJournal(manifestName string) error {
+	return NewConfigMapJournal(s.Clientset.CoreV1().ConfigMaps(s.VersionsNamespace)).DeleteJournal(manifestName)
+}
+
+// ConfigMapJournal stores journals as ConfigMaps
+type ConfigMapJournal struct {
+	ConfigMaps corev1.ConfigMapInterface
+}
+
+// NewConfigMapJournal returns a *ConfigMapJournal storing journals with configMaps
+func NewConfigMapJournal(configMaps corev1.ConfigMapInterface) *ConfigMapJournal {
+	return &ConfigMapJournal{
+		ConfigMaps: configMaps,
+	}
+}
+
+func (cj *ConfigMapJournal) GetJournal(manifestName string) (*Journal, error) {
+	cm, err := cj.ConfigMaps.Get(journalPrefix+manifestName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+		}).Wrap(err, "failed to get transaction journal")
+	}
+	journal := &Journal{}
+	if err := json.Unmarshal([]byte(cm.Data["journal"]), journal); err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+		}).Wrap(err, "failed to decode transaction journal")
+	}
+	return journal, nil
+}
+
+func (cj *ConfigMapJournal) WriteJournal(journal *Journal) error {
+	journal.Updated = time.Now()
+	raw, err := json.Marshal(journal)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode transaction journal")
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: journalPrefix + journal.ManifestName,
+			Labels: map[string]string{
+				"NAME": journal.ManifestName,
+				// Not BARRELMAN, journals are not listed with the versions
+				"OWNER": "BARRELMAN_JOURNAL",
+			},
+		},
+		Data: map[string]string{
+			"journal": string(raw),
+		},
+	}
+	_, err = cj.ConfigMaps.Create(cm)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = cj.ConfigMaps.Update(cm)
+	}
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": journal.ManifestName,
+		}).Wrap(err, "failed to write transaction journal")
+	}
+	return nil
+}
+
+func (cj *ConfigMapJournal) DeleteJournal(manifestName string) error {
+	err := cj.ConfigMaps.Delete(journalPrefix+manifestName, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+		}).Wrap(err, "failed to remove transaction journal")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cirrocloud/structured/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// journalSession records the release changes made by a Transaction
+type journalSession struct {
+	*ConfigMapJournal
+	rolledBack map[string]int32
+	deleted    []string
+	purged     []string
+	installed  []*ReleaseMeta
+	// gone lists the releases whose history was purged, they cannot be rolled back
+	gone    map[string]bool
+	written *Versions
+}
+
+func (js *journalSession) GetVersions(manifestName string) (*Versions, error) {
+	versions := NewVersions(manifestName)
+	versions.Data = append(versions.Data, &Version{Name: manifestName, Revision: 7})
+	return versions, nil
+}
+
+func (js *journalSession) WriteVersions(versions *Versions) error {
+	js.written = versions
+	return nil
+}
+
+func (js *journalSession) DeleteRelease(ctx context.Context, m *DeleteMeta) error {
+	js.deleted = append(js.deleted, m.ReleaseName)
+	if m.Purge {
+		js.purged = append(js.purged, m.ReleaseName)
+	}
+	return nil
+}
+
+func (js *journalSession) InstallRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error) {
+	js.installed = append(js.installed, m)
+	return &InstallReleaseResponse{ReleaseName: m.ReleaseName, ReleaseVersion: 1}, nil
+}
+
+func (js *journalSession) RollbackRelease(ctx context.Context, m *RollbackMeta) (int32, error) {
+	if js.gone[m.ReleaseName] {
+		return 0, errors.New("release: not found")
+	}
+	js.rolledBack[m.ReleaseName] = m.Revision
+	return m.Revision + 1, nil
+}
+
+func TestJournal(t *testing.T) {
+	Convey("ConfigMapJournal", t, func() {
+		journals := NewConfigMapJournal(newTestClientset().CoreV1().ConfigMaps("kube-system"))
+
+		journal, err := journals.GetJournal("testManifest")
+		So(err, ShouldBeNil)
+		So(journal, ShouldBeNil)
+
+		So(journals.WriteJournal(&Journal{
+			ManifestName: "testManifest",
+			StartVersion: 3,
+			Releases:     []*JournalEntry{{Name: "one", Revision: 2, PreviousRevision: 1, Modified: true}},
+		}), ShouldBeNil)
+		So(journals.WriteJournal(&Journal{
+			ManifestName: "testManifest",
+			StartVersion: 3,
+			Releases: []*JournalEntry{
+				{Name: "one", Revision: 2, PreviousRevision: 1, Modified: true},
+				{Name: "two", Revision: 5, PreviousRevision: 5},
+			},
+		}), ShouldBeNil)
+
+		journal, err = journals.GetJournal("testManifest")
+		So(err, ShouldBeNil)
+		So(journal.StartVersion, ShouldEqual, 3)
+		So(len(journal.Releases), ShouldEqual, 2)
+		So(len(journal.Modified()), ShouldEqual, 1)
+		So(journal.Lookup("one").PreviousRevision, ShouldEqual, 1)
+
+		So(journals.DeleteJournal("testManifest"), ShouldBeNil)
+		So(journals.DeleteJournal("testManifest"), ShouldBeNil)
+		journal, err = journals.GetJournal("testManifest")
+		So(err, ShouldBeNil)
+		So(journal, ShouldBeNil)
+	})
+
+	Convey("Transaction journal", t, func() {
+		session := &journalSession{
+			ConfigMapJournal: NewConfigMapJournal(newTestClientset().CoreV1().ConfigMaps("kube-system")),
+			rolledBack:       map[string]int32{},
+		}
+		transaction, err := newTransaction(session, "testManifest")
+		So(err, ShouldBeNil)
+		upgraded := &Version{Name: "upgraded", Revision: 2, PreviousRevision: 2}
+		transaction.Versions().AddReleaseVersion(upgraded)
+		transaction.Versions().AddReleaseVersion(&Version{Name: "pending", Revision: 1, PreviousRevision: 1})
+
+		So(transaction.Checkpoint(), ShouldBeNil)
+		upgraded.SetRevision(3)
+		So(transaction.Checkpoint(), ShouldBeNil)
+
+		journal, err := session.GetJournal("testManifest")
+		So(err, ShouldBeNil)
+		So(journal.StartVersion, ShouldEqual, 7)
+		So(len(journal.Modified()), ShouldEqual, 1)
+		So(journal.Lookup("upgraded").Revision, ShouldEqual, 3)
+
+		Convey("is removed on complete", func() {
+			So(transaction.Complete(), ShouldBeNil)
+			So(session.written, ShouldNotBeNil)
+			journal, err := session.GetJournal("testManifest")
+			So(err, ShouldBeNil)
+			So(journal, ShouldBeNil)
+		})
+
+		Convey("is removed on cancel", func() {
+			So(transaction.Cancel(), ShouldBeNil)
+			So(session.rolledBack["upgraded"], ShouldEqual, 2)
+			journal, err := session.GetJournal("testManifest")
+			So(err, ShouldBeNil)
+			So(journal, ShouldBeNil)
+		})
+
+		Convey("can be canceled by a resuming transaction", func() {
+			journal.Releases = append(journal.Releases, &JournalEntry{Name: "installed", Revision: 1, Modified: true})
+			resumed, err := newTransaction(session, "testManifest")
+			So(err, ShouldBeNil)
+			resumed.Resume(journal)
+			So(resumed.Cancel(), ShouldBeNil)
+			So(session.rolledBack["upgraded"], ShouldEqual, 2)
+			So(session.deleted, ShouldResemble, []string{"installed"})
+			_, ok := session.rolledBack["pending"]
+			So(ok, ShouldBeFalse)
+			journal, err := session.GetJournal("testManifest")
+			So(err, ShouldBeNil)
+			So(journal, ShouldBeNil)
+		})
+
+		Convey("is carried forward by a resuming transaction", func() {
+			resumed, err := newTransaction(session, "testManifest")
+			So(err, ShouldBeNil)
+			resumed.Resume(journal)
+			current := &Version{Name: "upgraded", Revision: 3, PreviousRevision: 3}
+			resumed.Versions().AddReleaseVersion(current)
+			So(resumed.Complete(), ShouldBeNil)
+			So(current.Modified, ShouldBeTrue)
+			So(current.PreviousRevision, ShouldEqual, 2)
+			So(session.written.Lookup("upgraded").Revision, ShouldEqual, 3)
+			journal, err := session.GetJournal("testManifest")
+			So(err, ShouldBeNil)
+			So(journal, ShouldBeNil)
+		})
+	})
+}
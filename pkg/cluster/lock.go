@@ -0,0 +1,337 @@
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// DefaultLockStaleTimeout is how long a lock survives without being renewed
+const DefaultLockStaleTimeout = 2 * time.Minute
+
+// lockPrefix names the ConfigMap holding the lock of a manifest
+const lockPrefix = "barrelman-lock."
+
+// Locker serializes operations on a manifest across processes
+type Locker interface {
+	Lock(manifestName string) (Unlocker, error)
+	BreakLock(manifestName string, force bool) (*LockHolder, error)
+}
+
+// Unlocker releases a lock taken by Lock
+type Unlocker interface {
+	Unlock() error
+	// Lost is closed once the lock is no longer held, the operation holding it must stop
+	Lost() <-chan struct{}
+}
+
+// errLockTaken is returned when the lock of a lease was removed or taken by another operation
+var errLockTaken = errors.New("manifest lock is held by another operation")
+
+// LockHolder describes the process holding a lock
+type LockHolder struct {
+	ManifestName string
+	ID           string
+	User         string
+	Host         string
+	Started      time.Time
+	Renewed      time.Time
+}
+
+func (lh *LockHolder) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"ManifestName": lh.ManifestName,
+		"User":         lh.User,
+		"Host":         lh.Host,
+		"Started":      lh.Started.Format(time.RFC3339),
+	}
+}
+
+func (lh *LockHolder) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"ManifestName": lh.ManifestName,
+		"ID":           lh.ID,
+		"User":         lh.User,
+		"Host":         lh.Host,
+		"Started":      lh.Started.Format(time.RFC3339),
+		"Renewed":      lh.Renewed.Format(time.RFC3339),
+	}
+}
+
+// Stale returns true when the lock has not been renewed within timeout
+func (lh *LockHolder) Stale(timeout time.Duration) bool {
+	return time.Since(lh.Renewed) > timeout
+}
+
+// Lock takes the lock of manifestName in the Tiller namespace
+func (s *Session) Lock(manifestName string) (Unlocker, error) {
+	return NewConfigMapLocker(s.Clientset.CoreV1().ConfigMaps(s.versionsNamespace())).Lock(manifestName)
+}
+
+// BreakLock removes the lock of manifestName from the Tiller namespace
+func (s *Session) BreakLock(manifestName string, force bool) (*LockHolder, error) {
+	return NewConfigMapLocker(s.Clientset.CoreV1().ConfigMaps(s.versionsNamespace())).BreakLock(manifestName, force)
+}
+
+// Lock takes the lock of manifestName in the versions namespace
+func (s *Helm3Session) Lock(manifestName string) (Unlocker, error) {
+	return NewConfigMapLocker(s.Clientset.CoreV1().ConfigMaps(s.VersionsNamespace)).Lock(manifestName)
+}
+
+// BreakLock removes the lock of manifestName from the versions namespace
+func (s *Helm3Session) BreakLock(manifestName string, force bool) (*LockHolder, error) {
+	return NewConfigMapLocker(s.Clientset.CoreV1().ConfigMaps(s.VersionsNamespace)).BreakLock(manifestName, force)
+}
+
+// ConfigMapLocker holds leases on manifests as ConfigMaps
+// a lease is renewed while held and may be taken over once it is stale
+type ConfigMapLocker struct {
+	ConfigMaps   corev1.ConfigMapInterface
+	StaleTimeout time.Duration
+}
+
+// NewConfigMapLocker returns a *ConfigMapLocker storing locks with configMaps
+func NewConfigMapLocker(configMaps corev1.ConfigMapInterface) *ConfigMapLocker {
+	return &ConfigMapLocker{
+		ConfigMaps:   configMaps,
+		StaleTimeout: DefaultLockStaleTimeout,
+	}
+}
+
+// Lock takes the lock of manifestName, failing with the details of the holder when it is held
+func (cl *ConfigMapLocker) Lock(manifestName string) (Unlocker, error) {
+	holder := newLockHolder(manifestName)
+	_, err := cl.ConfigMaps.Create(lockConfigMap(holder))
+	if apierrors.IsAlreadyExists(err) {
+		err = cl.takeStale(holder)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": manifestName,
+		"ID":           holder.ID,
+	}).Debug("Acquired manifest lock")
+
+	lease := &lease{
+		locker: cl,
+		holder: holder,
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	lease.wg.Add(1)
+	go lease.renew()
+	return lease, nil
+}
+
+// BreakLock removes the lock of manifestName and returns its holder
+// without force only a stale lock is removed
+func (cl *ConfigMapLocker) BreakLock(manifestName string, force bool) (*LockHolder, error) {
+	cm, err := cl.ConfigMaps.Get(lockPrefix+manifestName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+		}).Wrap(err, "failed to get manifest lock")
+	}
+	holder := lockHolderFrom(manifestName, cm)
+	if !force && !holder.Stale(cl.StaleTimeout) {
+		return holder, lockedError(holder, "manifest lock is held, use --force to remove it")
+	}
+	if err := cl.ConfigMaps.Delete(cm.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return holder, errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+		}).Wrap(err, "failed to remove manifest lock")
+	}
+	return holder, nil
+}
+
+// takeStale replaces an existing lock with holder when it has not been renewed within StaleTimeout
+func (cl *ConfigMapLocker) takeStale(holder *LockHolder) error {
+	cm, err := cl.ConfigMaps.Get(lockPrefix+holder.ManifestName, metav1.GetOptions{})
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": holder.ManifestName,
+		}).Wrap(err, "failed to get manifest lock")
+	}
+	current := lockHolderFrom(holder.ManifestName, cm)
+	if !current.Stale(cl.StaleTimeout) {
+		return lockedError(current, "manifest is locked by another operation")
+	}
+	log.Rep(current).Warn("Taking over stale manifest lock")
+	replacement := lockConfigMap(holder)
+	// The resourceVersion ensures only one process takes over the stale lock
+	replacement.ResourceVersion = cm.ResourceVersion
+	if _, err := cl.ConfigMaps.Update(replacement); err != nil {
+		if apierrors.IsConflict(err) {
+			return lockedError(current, "manifest is locked by another operation")
+		}
+		return errors.WithFields(errors.Fields{
+			"ManifestName": holder.ManifestName,
+		}).Wrap(err, "failed to take over stale manifest lock")
+	}
+	return nil
+}
+
+// lease is a lock held by this process
+type lease struct {
+	locker *ConfigMapLocker
+	holder *LockHolder
+	done   chan struct{}
+	lost   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// Lost is closed once the lease is taken by another operation, or could not be renewed for half the stale timeout
+func (l *lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock stops renewing the lease and removes the lock when it is still held by this process
+func (l *lease) Unlock() error {
+	l.once.Do(func() { close(l.done) })
+	l.wg.Wait()
+	cm, err := l.locker.ConfigMaps.Get(lockPrefix+l.holder.ManifestName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": l.holder.ManifestName,
+		}).Wrap(err, "failed to get manifest lock")
+	}
+	if cm.Data["id"] != l.holder.ID {
+		log.Rep(lockHolderFrom(l.holder.ManifestName, cm)).Warn("Manifest lock was taken by another operation")
+		return nil
+	}
+	if err := l.locker.ConfigMaps.Delete(cm.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.WithFields(errors.Fields{
+			"ManifestName": l.holder.ManifestName,
+		}).Wrap(err, "failed to remove manifest lock")
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": l.holder.ManifestName,
+	}).Debug("Released manifest lock")
+	return nil
+}
+
+// renew refreshes the lease until Unlock is called or the lease is lost
+// a lease that cannot be renewed is given up well before another operation may take it over as stale
+func (l *lease) renew() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.locker.StaleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			err := l.renewOnce()
+			if err == nil {
+				continue
+			}
+			log.WithFields(log.Fields{
+				"ManifestName": l.holder.ManifestName,
+				"Error":        err.Error(),
+			}).Warn("Failed to renew manifest lock")
+			if err == errLockTaken || time.Since(l.holder.Renewed) >= l.locker.StaleTimeout/2 {
+				log.WithFields(log.Fields{
+					"ManifestName": l.holder.ManifestName,
+				}).Error("Lost manifest lock, stopping the operation")
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+func (l *lease) renewOnce() error {
+	cm, err := l.locker.ConfigMaps.Get(lockPrefix+l.holder.ManifestName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return errLockTaken
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data["id"] != l.holder.ID {
+		return errLockTaken
+	}
+	l.holder.Renewed = time.Now()
+	cm.Data["renewed"] = l.holder.Renewed.Format(time.RFC3339Nano)
+	_, err = l.locker.ConfigMaps.Update(cm)
+	return err
+}
+
+func newLockHolder(manifestName string) *LockHolder {
+	now := time.Now()
+	holder := &LockHolder{
+		ManifestName: manifestName,
+		ID:           RandomString(16),
+		User:         "unknown",
+		Host:         "unknown",
+		Started:      now,
+		Renewed:      now,
+	}
+	if usr, err := user.Current(); err == nil {
+		holder.User = usr.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		holder.Host = fmt.Sprintf("%s (pid %d)", host, os.Getpid())
+	}
+	return holder
+}
+
+func lockConfigMap(holder *LockHolder) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: lockPrefix + holder.ManifestName,
+			Labels: map[string]string{
+				"NAME": holder.ManifestName,
+				// Not BARRELMAN, locks are not listed with the versions
+				"OWNER": "BARRELMAN_LOCK",
+			},
+		},
+		Data: map[string]string{
+			"id":      holder.ID,
+			"user":    holder.User,
+			"host":    holder.Host,
+			"started": holder.Started.Format(time.RFC3339Nano),
+			"renewed": holder.Renewed.Format(time.RFC3339Nano),
+		},
+	}
+}
+
+func lockHolderFrom(manifestName string, cm *v1.ConfigMap) *LockHolder {
+	holder := &LockHolder{
+		ManifestName: manifestName,
+		ID:           cm.Data["id"],
+		User:         cm.Data["user"],
+		Host:         cm.Data["host"],
+	}
+	holder.Started, _ = time.Parse(time.RFC3339Nano, cm.Data["started"])
+	holder.Renewed, _ = time.Parse(time.RFC3339Nano, cm.Data["renewed"])
+	return holder
+}
+
+func lockedError(holder *LockHolder, msg string) error {
+	return errors.WithFields(errors.Fields{
+		"ManifestName": holder.ManifestName,
+		"User":         holder.User,
+		"Host":         holder.Host,
+		"Started":      holder.Started.Format(time.RFC3339),
+		"Renewed":      holder.Renewed.Format(time.RFC3339),
+	}).New(msg)
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigMapLocker(t *testing.T) {
+	Convey("ConfigMapLocker", t, func() {
+		configMaps := newTestClientset().CoreV1().ConfigMaps("kube-system")
+		locker := NewConfigMapLocker(configMaps)
+
+		lock, err := locker.Lock("test-manifest")
+		So(err, ShouldBeNil)
+		cm, err := configMaps.Get("barrelman-lock.test-manifest", metav1.GetOptions{})
+		So(err, ShouldBeNil)
+		So(cm.Data["user"], ShouldNotBeEmpty)
+		So(cm.Data["host"], ShouldNotBeEmpty)
+
+		Convey("A held lock cannot be taken", func() {
+			_, err := locker.Lock("test-manifest")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "locked by another operation")
+			So(err.Error(), ShouldContainSubstring, cm.Data["user"])
+
+			Convey("Other manifests are not locked", func() {
+				other, err := locker.Lock("other-manifest")
+				So(err, ShouldBeNil)
+				So(other.Unlock(), ShouldBeNil)
+			})
+		})
+
+		Convey("Unlock removes the lock", func() {
+			So(lock.Unlock(), ShouldBeNil)
+			So(lock.Unlock(), ShouldBeNil)
+			_, err := configMaps.Get("barrelman-lock.test-manifest", metav1.GetOptions{})
+			So(err, ShouldNotBeNil)
+			again, err := locker.Lock("test-manifest")
+			So(err, ShouldBeNil)
+			So(again.Unlock(), ShouldBeNil)
+		})
+
+		Convey("A stale lock is taken over", func() {
+			cm.Data["renewed"] = time.Now().Add(-2 * DefaultLockStaleTimeout).Format(time.RFC3339)
+			_, err := configMaps.Update(cm)
+			So(err, ShouldBeNil)
+			next, err := locker.Lock("test-manifest")
+			So(err, ShouldBeNil)
+
+			// The previous holder no longer owns the lock and leaves it in place
+			So(lock.Unlock(), ShouldBeNil)
+			_, err = configMaps.Get("barrelman-lock.test-manifest", metav1.GetOptions{})
+			So(err, ShouldBeNil)
+			So(next.Unlock(), ShouldBeNil)
+		})
+
+		Convey("BreakLock", func() {
+			_, err := locker.BreakLock("test-manifest", false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--force")
+
+			holder, err := locker.BreakLock("test-manifest", true)
+			So(err, ShouldBeNil)
+			So(holder.User, ShouldEqual, cm.Data["user"])
+
+			holder, err = locker.BreakLock("test-manifest", false)
+			So(err, ShouldBeNil)
+			So(holder, ShouldBeNil)
+			So(lock.Unlock(), ShouldBeNil)
+		})
+
+		Convey("The lease is renewed while held", func() {
+			So(lock.Unlock(), ShouldBeNil)
+			locker.StaleTimeout = 40 * time.Millisecond
+			renewed, err := locker.Lock("test-manifest")
+			So(err, ShouldBeNil)
+			time.Sleep(100 * time.Millisecond)
+			cm, err := configMaps.Get("barrelman-lock.test-manifest", metav1.GetOptions{})
+			So(err, ShouldBeNil)
+			So(cm.Data["renewed"], ShouldNotEqual, cm.Data["started"])
+			So(lockHolderFrom("test-manifest", cm).Stale(locker.StaleTimeout), ShouldBeFalse)
+			select {
+			case <-renewed.Lost():
+				t.Error("a renewed lease should not be lost")
+			default:
+			}
+			So(renewed.Unlock(), ShouldBeNil)
+		})
+
+		Convey("A lease taken by another operation is lost", func() {
+			So(lock.Unlock(), ShouldBeNil)
+			locker.StaleTimeout = 40 * time.Millisecond
+			taken, err := locker.Lock("test-manifest")
+			So(err, ShouldBeNil)
+			_, err = locker.BreakLock("test-manifest", true)
+			So(err, ShouldBeNil)
+			select {
+			case <-taken.Lost():
+			case <-time.After(time.Second):
+				t.Error("the lease should be lost once its lock is removed")
+			}
+			So(taken.Unlock(), ShouldBeNil)
+		})
+	})
+}
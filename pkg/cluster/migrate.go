@@ -0,0 +1,445 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	storagedriver "k8s.io/helm/pkg/storage/driver"
+	"k8s.io/helm/pkg/timeconv"
+
+	"github.com/charter-oss/barrelman/pkg/cluster/driver"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// Helm3Migrator moves releases owned by a manifest into Helm 3 storage
+type Helm3Migrator interface {
+	Init() error
+	MigrateManifest(ctx context.Context, manifestName string, target *Helm3Session, opts *MigrateOptions) ([]*MigrateResult, error)
+}
+
+// MigrateOptions controls the migration of releases from Tiller storage to Helm 3 storage
+type MigrateOptions struct {
+	// DryRun reports what would be migrated without writing anything
+	DryRun bool
+	// Cleanup removes the Tiller release ConfigMaps once a release has been migrated
+	Cleanup bool
+	// Progress is called after each release has been processed
+	Progress func(*MigrateResult)
+}
+
+// VersionMigrator moves Barrelman versions stored as ConfigMaps into the versions storage of a session
+type VersionMigrator interface {
+	Init() error
+	MigrateVersions(opts *VersionMigrateOptions) ([]*VersionMigrateResult, error)
+}
+
+var (
+	_ VersionMigrator = (*Session)(nil)
+	_ VersionMigrator = (*Helm3Session)(nil)
+	_ VersionMigrator = (*SimSession)(nil)
+)
+
+// VersionMigrateOptions controls the migration of Barrelman versions stored as ConfigMaps
+type VersionMigrateOptions struct {
+	// FromNamespace holds the version ConfigMaps, the versions namespace of the session when empty
+	FromNamespace string
+	// DryRun reports what would be migrated without writing anything
+	DryRun bool
+	// Cleanup removes the version ConfigMaps once they are present in the versions storage
+	Cleanup bool
+}
+
+// VersionMigrateResult describes the migration of the versions of a single manifest
+type VersionMigrateResult struct {
+	ManifestName string
+	Migrated     []int32
+	Skipped      []int32
+	CleanedUp    bool
+}
+
+// MigrateResult describes the migration of a single release
+type MigrateResult struct {
+	ReleaseName string
+	Namespace   string
+	Migrated    []int32
+	Skipped     []int32
+	CleanedUp   bool
+}
+
+func (mr *MigrateResult) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"ReleaseName": mr.ReleaseName,
+		"Namespace":   mr.Namespace,
+		"Migrated":    len(mr.Migrated),
+		"Skipped":     len(mr.Skipped),
+	}
+}
+
+func (mr *MigrateResult) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"ReleaseName": mr.ReleaseName,
+		"Namespace":   mr.Namespace,
+		"Migrated":    mr.Migrated,
+		"Skipped":     mr.Skipped,
+		"CleanedUp":   mr.CleanedUp,
+	}
+}
+
+// tillerStorage returns the driver for release ConfigMaps written by the Tiller of a release
+func (s *Session) tillerStorage(releaseName string) *storagedriver.ConfigMaps {
+	namespace := s.releaseTiller(releaseName)
+	if namespace == "" {
+		namespace = s.Tunnel.Namespace
+	}
+	return storagedriver.NewConfigMaps(s.Clientset.CoreV1().ConfigMaps(namespace))
+}
+
+// TillerHistory returns every revision of a release stored by Tiller, ordered by version
+func (s *Session) TillerHistory(releaseName string) ([]*release.Release, error) {
+	history, err := s.tillerStorage(releaseName).Query(map[string]string{
+		"NAME":  releaseName,
+		"OWNER": "TILLER",
+	})
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ReleaseName": releaseName,
+		}).Wrap(err, "failed to get Tiller release history")
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Version < history[j].Version
+	})
+	return history, nil
+}
+
+// MigrateManifest copies every revision of the releases owned by manifestName from Tiller storage
+// into Helm 3 release Secrets, followed by the Barrelman versions of the manifest
+// revisions already present in Helm 3 storage are skipped, so a migration may be repeated
+func (s *Session) MigrateManifest(ctx context.Context, manifestName string, target *Helm3Session, opts *MigrateOptions) ([]*MigrateResult, error) {
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+	releases, err := s.ListReleasesByManifest(ctx, manifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases for migration")
+	}
+
+	results := []*MigrateResult{}
+	for _, rel := range releases {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		result, err := s.migrateRelease(rel.ReleaseName, manifestName, target, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+		if opts.Progress != nil {
+			opts.Progress(result)
+		}
+	}
+
+	copied, err := copyVersions(s.NewVersionDriver(), target.NewVersionDriver(), s.releaseSources(), target.releaseSources(), manifestName, opts.DryRun)
+	if err != nil {
+		return results, errors.Wrap(err, "failed to migrate Barrelman versions")
+	}
+	log.WithFields(log.Fields{
+		"ManifestName": manifestName,
+		"Versions":     copied,
+		"DryRun":       opts.DryRun,
+	}).Debug("Migrated Barrelman versions")
+	return results, nil
+}
+
+func (s *Session) migrateRelease(releaseName, manifestName string, target *Helm3Session, opts *MigrateOptions) (*MigrateResult, error) {
+	result := &MigrateResult{
+		ReleaseName: releaseName,
+		Migrated:    []int32{},
+		Skipped:     []int32{},
+	}
+	history, err := s.TillerHistory(releaseName)
+	if err != nil {
+		return result, err
+	}
+	present := make(map[int]bool)
+	if len(history) > 0 {
+		existing, err := target.history(history[len(history)-1].Namespace, releaseName)
+		if err != nil {
+			return result, err
+		}
+		for _, v := range existing {
+			present[v.Version] = true
+		}
+	}
+
+	for _, rls := range history {
+		result.Namespace = rls.Namespace
+		if present[int(rls.Version)] {
+			result.Skipped = append(result.Skipped, rls.Version)
+			continue
+		}
+		if !opts.DryRun {
+			if err := target.Storage.Create(ReleaseToHelm3(rls, manifestName)); err != nil {
+				return result, errors.WithFields(errors.Fields{
+					"ReleaseName": releaseName,
+					"Revision":    rls.Version,
+				}).Wrap(err, "failed to store Helm 3 release")
+			}
+		}
+		result.Migrated = append(result.Migrated, rls.Version)
+	}
+
+	if opts.Cleanup && !opts.DryRun {
+		storage := s.tillerStorage(releaseName)
+		for _, rls := range history {
+			if _, err := storage.Delete(fmt.Sprintf("%s.v%d", rls.Name, rls.Version)); err != nil {
+				return result, errors.WithFields(errors.Fields{
+					"ReleaseName": releaseName,
+					"Revision":    rls.Version,
+				}).Wrap(err, "failed to remove Tiller release")
+			}
+		}
+		result.CleanedUp = true
+	}
+	return result, nil
+}
+
+// copyVersions copies the versions of manifestName missing from to, returning the number copied
+// the release sources kept apart in fromSources follow each version, see moveReleaseSources
+func copyVersions(from, to driver.VersionDriver, fromSources, toSources *ReleaseSources, manifestName string, dryRun bool) (int, error) {
+	source, err := from.List(getReleaseFilter(manifestName))
+	if err != nil {
+		return 0, err
+	}
+	existing, err := to.List(getReleaseFilter(manifestName))
+	if err != nil {
+		return 0, err
+	}
+	present := make(map[int32]bool)
+	for _, v := range existing {
+		present[v.Version] = true
+	}
+	copied := 0
+	for _, v := range source {
+		if present[v.Version] {
+			continue
+		}
+		copied++
+		if dryRun {
+			continue
+		}
+		if err := moveReleaseSources(fromSources, toSources, v); err != nil {
+			return copied, err
+		}
+		resourceName := fmt.Sprintf("%s.v%d.%s", v.Name, v.Version, NewSHA1Hash())
+		if err := to.Create(resourceName, v); err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// moveReleaseSources copies the release sources of the version rls kept apart in from to the store to
+// they are added to the files of rls when to is nil, as versions stored as Secrets hold them
+func moveReleaseSources(from, to *ReleaseSources, rls *release.Release) error {
+	if from == nil {
+		return nil
+	}
+	files, err := from.Files(rls.Name, rls.Version)
+	if err != nil {
+		return err
+	}
+	if to != nil {
+		return to.WriteFiles(rls.Name, rls.Version, files)
+	}
+	if rls.Chart == nil {
+		rls.Chart = &chart.Chart{}
+	}
+	rls.Chart.Files = append(rls.Chart.Files, files...)
+	return nil
+}
+
+// MigrateVersions copies the Barrelman versions stored as ConfigMaps into the versions storage of the session
+func (s *Session) MigrateVersions(opts *VersionMigrateOptions) ([]*VersionMigrateResult, error) {
+	return migrateVersions(s.Clientset, s.versionsNamespace(), s.VersionsStorage, opts)
+}
+
+// MigrateVersions copies the Barrelman versions stored as ConfigMaps into the versions storage of the session
+func (s *Helm3Session) MigrateVersions(opts *VersionMigrateOptions) ([]*VersionMigrateResult, error) {
+	return migrateVersions(s.Clientset, s.VersionsNamespace, s.VersionsStorage, opts)
+}
+
+// migrateVersions copies every version ConfigMap of opts.FromNamespace into storage in namespace
+// versions keep their key and labels, those already present are skipped so a migration may be repeated
+func migrateVersions(clientset kubernetes.Interface, namespace, storage string, opts *VersionMigrateOptions) ([]*VersionMigrateResult, error) {
+	if opts == nil {
+		opts = &VersionMigrateOptions{}
+	}
+	fromNamespace := opts.FromNamespace
+	if fromNamespace == "" {
+		fromNamespace = namespace
+	}
+	if storage != VersionsStorageSecret && fromNamespace == namespace {
+		return nil, errors.WithFields(errors.Fields{
+			"Namespace": namespace,
+		}).New("versions are already stored as ConfigMaps in namespace, set --versions-storage or --versions-namespace")
+	}
+	from := driver.NewConfigMaps(clientset.CoreV1().ConfigMaps(fromNamespace))
+	to := NewVersionDriver(clientset, namespace, storage)
+	fromSources := NewReleaseSources(clientset, fromNamespace, VersionsStorageConfigMap)
+	toSources := NewReleaseSources(clientset, namespace, storage)
+
+	source, err := from.ListStored(getNoopManifestFilter())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list version ConfigMaps")
+	}
+	existing, err := to.List(getNoopManifestFilter())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list stored versions")
+	}
+	present := make(map[string]bool)
+	for _, v := range existing {
+		present[fmt.Sprintf("%s.v%d", v.Name, v.Version)] = true
+	}
+	sort.Slice(source, func(i, j int) bool {
+		if source[i].Release.Name != source[j].Release.Name {
+			return source[i].Release.Name < source[j].Release.Name
+		}
+		return source[i].Release.Version < source[j].Release.Version
+	})
+
+	results := []*VersionMigrateResult{}
+	var result *VersionMigrateResult
+	for _, s := range source {
+		if result == nil || result.ManifestName != s.Release.Name {
+			result = &VersionMigrateResult{
+				ManifestName: s.Release.Name,
+				Migrated:     []int32{},
+				Skipped:      []int32{},
+			}
+			results = append(results, result)
+		}
+		if present[fmt.Sprintf("%s.v%d", s.Release.Name, s.Release.Version)] {
+			result.Skipped = append(result.Skipped, s.Release.Version)
+			continue
+		}
+		if !opts.DryRun {
+			if err := moveReleaseSources(fromSources, toSources, s.Release); err != nil {
+				return results, err
+			}
+			if err := to.CreateStored(s); err != nil {
+				return results, errors.WithFields(errors.Fields{
+					"ManifestName": s.Release.Name,
+					"Version":      s.Release.Version,
+				}).Wrap(err, "failed to store manifest version")
+			}
+		}
+		result.Migrated = append(result.Migrated, s.Release.Version)
+	}
+
+	if opts.Cleanup && !opts.DryRun {
+		for _, s := range source {
+			if _, err := from.Delete(s.Key); err != nil {
+				return results, errors.WithFields(errors.Fields{
+					"ManifestName": s.Release.Name,
+					"Version":      s.Release.Version,
+				}).Wrap(err, "failed to remove version ConfigMap")
+			}
+			if err := fromSources.Delete(s.Release.Name, s.Release.Version); err != nil {
+				return results, err
+			}
+		}
+		for _, result := range results {
+			result.CleanedUp = true
+		}
+	}
+	return results, nil
+}
+
+// ReleaseToHelm3 converts a release stored by Tiller into a Helm 3 release
+func ReleaseToHelm3(rls *release.Release, manifestName string) *driver.Helm3Release {
+	ret := &driver.Helm3Release{
+		Name:      rls.Name,
+		Namespace: rls.Namespace,
+		Chart:     ChartToHelm3Chart(rls.Chart),
+		Config:    map[string]interface{}{},
+		Manifest:  rls.Manifest,
+		Hooks:     []*driver.Helm3Hook{},
+		Version:   int(rls.Version),
+		Labels:    map[string]string{},
+		Info:      &driver.Helm3Info{Status: driver.Helm3StatusUnknown},
+	}
+	if manifestName != "" {
+		ret.Labels[manifestLabel] = manifestName
+	}
+	if rls.Config != nil {
+		if values, err := chartutil.ReadValues([]byte(rls.Config.Raw)); err == nil {
+			ret.Config = values
+		}
+	}
+	for _, h := range rls.Hooks {
+		events := []string{}
+		for _, e := range h.Events {
+			events = append(events, strings.Replace(strings.ToLower(e.String()), "_", "-", -1))
+		}
+		ret.Hooks = append(ret.Hooks, &driver.Helm3Hook{
+			Name:     h.Name,
+			Kind:     h.Kind,
+			Path:     h.Path,
+			Manifest: h.Manifest,
+			Events:   events,
+			Weight:   int(h.Weight),
+		})
+	}
+	if info := rls.Info; info != nil {
+		ret.Info = &driver.Helm3Info{
+			FirstDeployed: helm3Time(info.FirstDeployed),
+			LastDeployed:  helm3Time(info.LastDeployed),
+			Deleted:       helm3Time(info.Deleted),
+			Description:   info.Description,
+			Status:        driver.Helm3StatusUnknown,
+		}
+		if info.Status != nil {
+			ret.Info.Status = helm3StatusName(info.Status.Code)
+			ret.Info.Notes = info.Status.Notes
+		}
+	}
+	return ret
+}
+
+func helm3Time(ts *timestamp.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return timeconv.Time(ts)
+}
+
+func helm3StatusName(code release.Status_Code) string {
+	switch code {
+	case release.Status_DEPLOYED:
+		return driver.Helm3StatusDeployed
+	case release.Status_SUPERSEDED:
+		return driver.Helm3StatusSuperseded
+	case release.Status_FAILED:
+		return driver.Helm3StatusFailed
+	case release.Status_DELETED:
+		return driver.Helm3StatusUninstalled
+	case release.Status_DELETING:
+		return driver.Helm3StatusUninstalling
+	case release.Status_PENDING_INSTALL:
+		return driver.Helm3StatusPendingInstall
+	case release.Status_PENDING_UPGRADE:
+		return driver.Helm3StatusPendingUpgrade
+	case release.Status_PENDING_ROLLBACK:
+		return driver.Helm3StatusPendingRollback
+	}
+	return driver.Helm3StatusUnknown
+}
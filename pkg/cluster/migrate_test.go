@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	storagedriver "k8s.io/helm/pkg/storage/driver"
+	"k8s.io/helm/pkg/timeconv"
+
+	"github.com/charter-oss/barrelman/pkg/cluster/driver"
+)
+
+func newTestTillerRelease(version int32, code release.Status_Code, setting string) *release.Release {
+	return &release.Release{
+		Name:      "app",
+		Namespace: "apps",
+		Version:   version,
+		Chart: &chart.Chart{
+			Metadata: &chart.Metadata{Name: "app", Version: "0.1.0", Tags: "Manifest=test-manifest"},
+			Values:   &chart.Config{Raw: "setting: one\n"},
+		},
+		Config:   &chart.Config{Raw: "setting: " + setting + "\n"},
+		Manifest: "\n---\n# Source: app/templates/configmap.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app-settings\n",
+		Hooks: []*release.Hook{
+			{Name: "app-hook", Kind: "Job", Events: []release.Hook_Event{release.Hook_PRE_INSTALL}},
+		},
+		Info: &release.Info{
+			FirstDeployed: timeconv.Now(),
+			LastDeployed:  timeconv.Now(),
+			Status:        &release.Status{Code: code},
+		},
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	Convey("MigrateManifest", t, func() {
+		clientset := newTestClientset()
+		tiller := storagedriver.NewConfigMaps(clientset.CoreV1().ConfigMaps("kube-system"))
+		history := []*release.Release{
+			newTestTillerRelease(1, release.Status_SUPERSEDED, "one"),
+			newTestTillerRelease(2, release.Status_DEPLOYED, "two"),
+		}
+		for _, v := range history {
+			So(tiller.Create(fmt.Sprintf("%s.v%d", v.Name, v.Version), v), ShouldBeNil)
+		}
+		source := &Session{
+			Helm:      &helm.FakeClient{Rels: []*release.Release{history[1]}},
+			Tunnel:    &kube.Tunnel{Namespace: "kube-system"},
+			Clientset: clientset,
+		}
+		versions := NewVersions("test-manifest")
+		versions.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 2})
+		So(source.WriteVersions(versions), ShouldBeNil)
+
+		target := NewHelm3Session("", "")
+		target.Clientset = clientset
+		target.VersionsNamespace = "barrelman"
+		So(target.Init(), ShouldBeNil)
+
+		Convey("Dry run writes nothing", func() {
+			results, err := source.MigrateManifest(context.Background(), "test-manifest", target, &MigrateOptions{DryRun: true})
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].Migrated, ShouldResemble, []int32{1, 2})
+			secrets, err := clientset.CoreV1().Secrets("apps").List(metav1.ListOptions{})
+			So(err, ShouldBeNil)
+			So(secrets.Items, ShouldBeEmpty)
+		})
+
+		Convey("Each revision becomes a Helm 3 release Secret", func() {
+			progress := []string{}
+			results, err := source.MigrateManifest(context.Background(), "test-manifest", target, &MigrateOptions{
+				Progress: func(r *MigrateResult) { progress = append(progress, r.ReleaseName) },
+			})
+			So(err, ShouldBeNil)
+			So(progress, ShouldResemble, []string{"app"})
+			So(results[0].Namespace, ShouldEqual, "apps")
+
+			first, err := target.Storage.Get("apps", "app", 1)
+			So(err, ShouldBeNil)
+			So(first.Info.Status, ShouldEqual, driver.Helm3StatusSuperseded)
+			So(first.Config["setting"], ShouldEqual, "one")
+			So(first.Hooks[0].Events, ShouldResemble, []string{"pre-install"})
+
+			owned, err := target.ReleasesByManifest(context.Background(), "test-manifest")
+			So(err, ShouldBeNil)
+			So(owned[ReleaseKey("apps", "app")].Revision, ShouldEqual, 2)
+			So(owned[ReleaseKey("apps", "app")].Status, ShouldEqual, Status_DEPLOYED)
+
+			migrated, err := target.GetVersions("test-manifest")
+			So(err, ShouldBeNil)
+			So(migrated.Data, ShouldHaveLength, 1)
+
+			Convey("Repeated migrations skip existing revisions", func() {
+				results, err := source.MigrateManifest(context.Background(), "test-manifest", target, nil)
+				So(err, ShouldBeNil)
+				So(results[0].Migrated, ShouldBeEmpty)
+				So(results[0].Skipped, ShouldResemble, []int32{1, 2})
+				migrated, err := target.GetVersions("test-manifest")
+				So(err, ShouldBeNil)
+				So(migrated.Data, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("Cleanup removes the Tiller releases", func() {
+			results, err := source.MigrateManifest(context.Background(), "test-manifest", target, &MigrateOptions{Cleanup: true})
+			So(err, ShouldBeNil)
+			So(results[0].CleanedUp, ShouldBeTrue)
+			remaining, err := source.TillerHistory("app")
+			So(err, ShouldNotBeNil)
+			So(remaining, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestMigrateVersions(t *testing.T) {
+	Convey("MigrateVersions", t, func() {
+		s := newTestHelm3Session()
+		So(s.Init(), ShouldBeNil)
+		for _, name := range []string{"test-manifest", "other-manifest"} {
+			versions := NewVersions(name)
+			versions.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 1, Chart: newTestHelm3Chart(), Modified: true})
+			So(s.WriteVersions(versions), ShouldBeNil)
+		}
+		listSources := func(namespace string) []string {
+			secrets, err := s.Clientset.CoreV1().Secrets(namespace).List(metav1.ListOptions{LabelSelector: "OWNER=BARRELMAN_SOURCE"})
+			So(err, ShouldBeNil)
+			names := []string{}
+			for _, item := range secrets.Items {
+				names = append(names, item.Labels["NAME"])
+			}
+			return names
+		}
+		So(listSources(DefaultVersionsNamespace), ShouldHaveLength, 2)
+		cms, err := s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).List(metav1.ListOptions{LabelSelector: "NAME=test-manifest"})
+		So(err, ShouldBeNil)
+		So(cms.Items, ShouldHaveLength, 1)
+		tagged := cms.Items[0]
+		tagged.Labels[versionTagLabel] = "stable"
+		_, err = s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).Update(&tagged)
+		So(err, ShouldBeNil)
+
+		Convey("ConfigMaps are not migrated onto themselves", func() {
+			_, err := s.MigrateVersions(nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		s.VersionsStorage = VersionsStorageSecret
+		listSecrets := func() []string {
+			secrets, err := s.Clientset.CoreV1().Secrets(DefaultVersionsNamespace).List(metav1.ListOptions{LabelSelector: "OWNER=BARRELMAN"})
+			So(err, ShouldBeNil)
+			names := []string{}
+			for _, item := range secrets.Items {
+				So(string(item.Type), ShouldEqual, driver.SecretType)
+				names = append(names, item.Labels["NAME"])
+			}
+			return names
+		}
+
+		Convey("Dry run writes nothing", func() {
+			results, err := s.MigrateVersions(&VersionMigrateOptions{DryRun: true})
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 2)
+			So(listSecrets(), ShouldBeEmpty)
+		})
+
+		Convey("Each version becomes a Secret", func() {
+			results, err := s.MigrateVersions(nil)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 2)
+			So(results[0].ManifestName, ShouldEqual, "other-manifest")
+			So(results[1].Migrated, ShouldResemble, []int32{1})
+			So(listSecrets(), ShouldHaveLength, 2)
+
+			migrated, err := s.GetVersions("test-manifest")
+			So(err, ShouldBeNil)
+			So(migrated.Data, ShouldHaveLength, 1)
+			So(migrated.Latest().Tag, ShouldEqual, "stable")
+			entries, err := migrated.Latest().ReleaseEntries()
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Name, ShouldEqual, "app")
+			stored, _, err := migrated.Latest().StoredRelease("app")
+			So(err, ShouldBeNil)
+			So(stored.Metadata.Name, ShouldEqual, "app")
+
+			Convey("Repeated migrations skip existing versions", func() {
+				results, err := s.MigrateVersions(nil)
+				So(err, ShouldBeNil)
+				So(results[1].Migrated, ShouldBeEmpty)
+				So(results[1].Skipped, ShouldResemble, []int32{1})
+				So(listSecrets(), ShouldHaveLength, 2)
+			})
+
+			Convey("New versions are written as Secrets", func() {
+				versions := NewVersions("test-manifest")
+				versions.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 2, Modified: true})
+				So(s.WriteVersions(versions), ShouldBeNil)
+				So(listSecrets(), ShouldHaveLength, 3)
+				cms, err := s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).List(metav1.ListOptions{LabelSelector: "OWNER=BARRELMAN"})
+				So(err, ShouldBeNil)
+				So(cms.Items, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("Cleanup removes the version ConfigMaps", func() {
+			results, err := s.MigrateVersions(&VersionMigrateOptions{Cleanup: true})
+			So(err, ShouldBeNil)
+			So(results[0].CleanedUp, ShouldBeTrue)
+			cms, err := s.Clientset.CoreV1().ConfigMaps(DefaultVersionsNamespace).List(metav1.ListOptions{LabelSelector: "OWNER=BARRELMAN"})
+			So(err, ShouldBeNil)
+			So(cms.Items, ShouldBeEmpty)
+			So(listSecrets(), ShouldHaveLength, 2)
+			So(listSources(DefaultVersionsNamespace), ShouldBeEmpty)
+		})
+
+		Convey("Versions may be moved to another namespace", func() {
+			s.VersionsStorage = VersionsStorageConfigMap
+			s.VersionsNamespace = "barrelman"
+			results, err := s.MigrateVersions(&VersionMigrateOptions{FromNamespace: DefaultVersionsNamespace})
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 2)
+			manifests, err := s.ListManifests()
+			So(err, ShouldBeNil)
+			So(manifests, ShouldHaveLength, 2)
+			So(listSources("barrelman"), ShouldHaveLength, 2)
+			migrated, err := s.GetVersions("test-manifest")
+			So(err, ShouldBeNil)
+			stored, _, err := migrated.StoredRelease(migrated.Latest(), "app")
+			So(err, ShouldBeNil)
+			So(stored.Metadata.Name, ShouldEqual, "app")
+		})
+
+		Convey("An unknown storage is refused", func() {
+			s.VersionsStorage = "etcd"
+			So(s.Init(), ShouldNotBeNil)
+		})
+	})
+}
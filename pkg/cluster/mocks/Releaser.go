@@ -2,6 +2,7 @@
 
 package mocks
 
+import context "context"
 import chart "k8s.io/helm/pkg/proto/hapi/chart"
 import cluster "github.com/charter-oss/barrelman/pkg/cluster"
 import io "io"
@@ -35,13 +36,13 @@ func (_m *Releaser) ChartFromArchive(aChart io.Reader) (*chart.Chart, error) {
 	return r0, r1
 }
 
-// DeleteRelease provides a mock function with given fields: m
-func (_m *Releaser) DeleteRelease(m *cluster.DeleteMeta) error {
-	ret := _m.Called(m)
+// DeleteRelease provides a mock function with given fields: ctx, m
+func (_m *Releaser) DeleteRelease(ctx context.Context, m *cluster.DeleteMeta) error {
+	ret := _m.Called(ctx, m)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*cluster.DeleteMeta) error); ok {
-		r0 = rf(m)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.DeleteMeta) error); ok {
+		r0 = rf(ctx, m)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -49,13 +50,13 @@ func (_m *Releaser) DeleteRelease(m *cluster.DeleteMeta) error {
 	return r0
 }
 
-// DeleteReleases provides a mock function with given fields: dm
-func (_m *Releaser) DeleteReleases(dm []*cluster.DeleteMeta) error {
-	ret := _m.Called(dm)
+// DeleteReleases provides a mock function with given fields: ctx, dm
+func (_m *Releaser) DeleteReleases(ctx context.Context, dm []*cluster.DeleteMeta) error {
+	ret := _m.Called(ctx, dm)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]*cluster.DeleteMeta) error); ok {
-		r0 = rf(dm)
+	if rf, ok := ret.Get(0).(func(context.Context, []*cluster.DeleteMeta) error); ok {
+		r0 = rf(ctx, dm)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -77,20 +78,20 @@ func (_m *Releaser) DiffManifests(_a0 map[string]*cluster.MappingResult, _a1 map
 	return r0
 }
 
-// DiffRelease provides a mock function with given fields: m
-func (_m *Releaser) DiffRelease(m *cluster.ReleaseMeta) (bool, []byte, error) {
-	ret := _m.Called(m)
+// DiffRelease provides a mock function with given fields: ctx, m
+func (_m *Releaser) DiffRelease(ctx context.Context, m *cluster.ReleaseMeta) (bool, []byte, error) {
+	ret := _m.Called(ctx, m)
 
 	var r0 bool
-	if rf, ok := ret.Get(0).(func(*cluster.ReleaseMeta) bool); ok {
-		r0 = rf(m)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta) bool); ok {
+		r0 = rf(ctx, m)
 	} else {
 		r0 = ret.Get(0).(bool)
 	}
 
 	var r1 []byte
-	if rf, ok := ret.Get(1).(func(*cluster.ReleaseMeta) []byte); ok {
-		r1 = rf(m)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta) []byte); ok {
+		r1 = rf(ctx, m)
 	} else {
 		if ret.Get(1) != nil {
 			r1 = ret.Get(1).([]byte)
@@ -98,8 +99,8 @@ func (_m *Releaser) DiffRelease(m *cluster.ReleaseMeta) (bool, []byte, error) {
 	}
 
 	var r2 error
-	if rf, ok := ret.Get(2).(func(*cluster.ReleaseMeta) error); ok {
-		r2 = rf(m)
+	if rf, ok := ret.Get(2).(func(context.Context, *cluster.ReleaseMeta) error); ok {
+		r2 = rf(ctx, m)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -107,13 +108,13 @@ func (_m *Releaser) DiffRelease(m *cluster.ReleaseMeta) (bool, []byte, error) {
 	return r0, r1, r2
 }
 
-// GetRelease provides a mock function with given fields: releaseName, revision
-func (_m *Releaser) GetRelease(releaseName string, revision int32) (*cluster.ReleaseMeta, error) {
-	ret := _m.Called(releaseName, revision)
+// GetRelease provides a mock function with given fields: ctx, releaseName, revision
+func (_m *Releaser) GetRelease(ctx context.Context, releaseName string, revision int32) (*cluster.ReleaseMeta, error) {
+	ret := _m.Called(ctx, releaseName, revision)
 
 	var r0 *cluster.ReleaseMeta
-	if rf, ok := ret.Get(0).(func(string, int32) *cluster.ReleaseMeta); ok {
-		r0 = rf(releaseName, revision)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int32) *cluster.ReleaseMeta); ok {
+		r0 = rf(ctx, releaseName, revision)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*cluster.ReleaseMeta)
@@ -121,8 +122,8 @@ func (_m *Releaser) GetRelease(releaseName string, revision int32) (*cluster.Rel
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, int32) error); ok {
-		r1 = rf(releaseName, revision)
+	if rf, ok := ret.Get(1).(func(context.Context, string, int32) error); ok {
+		r1 = rf(ctx, releaseName, revision)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -130,13 +131,13 @@ func (_m *Releaser) GetRelease(releaseName string, revision int32) (*cluster.Rel
 	return r0, r1
 }
 
-// InstallRelease provides a mock function with given fields: m, manifestName
-func (_m *Releaser) InstallRelease(m *cluster.ReleaseMeta, manifestName string) (*cluster.InstallReleaseResponse, error) {
-	ret := _m.Called(m, manifestName)
+// InstallRelease provides a mock function with given fields: ctx, m, manifestName
+func (_m *Releaser) InstallRelease(ctx context.Context, m *cluster.ReleaseMeta, manifestName string) (*cluster.InstallReleaseResponse, error) {
+	ret := _m.Called(ctx, m, manifestName)
 
 	var r0 *cluster.InstallReleaseResponse
-	if rf, ok := ret.Get(0).(func(*cluster.ReleaseMeta, string) *cluster.InstallReleaseResponse); ok {
-		r0 = rf(m, manifestName)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta, string) *cluster.InstallReleaseResponse); ok {
+		r0 = rf(ctx, m, manifestName)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*cluster.InstallReleaseResponse)
@@ -144,8 +145,8 @@ func (_m *Releaser) InstallRelease(m *cluster.ReleaseMeta, manifestName string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*cluster.ReleaseMeta, string) error); ok {
-		r1 = rf(m, manifestName)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta, string) error); ok {
+		r1 = rf(ctx, m, manifestName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -153,13 +154,13 @@ func (_m *Releaser) InstallRelease(m *cluster.ReleaseMeta, manifestName string)
 	return r0, r1
 }
 
-// ListReleases provides a mock function with given fields:
-func (_m *Releaser) ListReleases() ([]*cluster.Release, error) {
-	ret := _m.Called()
+// ListReleases provides a mock function with given fields: ctx
+func (_m *Releaser) ListReleases(ctx context.Context) ([]*cluster.Release, error) {
+	ret := _m.Called(ctx)
 
 	var r0 []*cluster.Release
-	if rf, ok := ret.Get(0).(func() []*cluster.Release); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) []*cluster.Release); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*cluster.Release)
@@ -167,8 +168,8 @@ func (_m *Releaser) ListReleases() ([]*cluster.Release, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -176,13 +177,13 @@ func (_m *Releaser) ListReleases() ([]*cluster.Release, error) {
 	return r0, r1
 }
 
-// Releases provides a mock function with given fields:
-func (_m *Releaser) Releases() (map[string]*cluster.ReleaseMeta, error) {
-	ret := _m.Called()
+// Releases provides a mock function with given fields: ctx
+func (_m *Releaser) Releases(ctx context.Context) (map[string]*cluster.ReleaseMeta, error) {
+	ret := _m.Called(ctx)
 
 	var r0 map[string]*cluster.ReleaseMeta
-	if rf, ok := ret.Get(0).(func() map[string]*cluster.ReleaseMeta); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) map[string]*cluster.ReleaseMeta); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]*cluster.ReleaseMeta)
@@ -190,8 +191,8 @@ func (_m *Releaser) Releases() (map[string]*cluster.ReleaseMeta, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -199,13 +200,13 @@ func (_m *Releaser) Releases() (map[string]*cluster.ReleaseMeta, error) {
 	return r0, r1
 }
 
-// ReleasesByManifest provides a mock function with given fields: manifest
-func (_m *Releaser) ReleasesByManifest(manifest string) (map[string]*cluster.ReleaseMeta, error) {
-	ret := _m.Called(manifest)
+// ReleasesByManifest provides a mock function with given fields: ctx, manifest
+func (_m *Releaser) ReleasesByManifest(ctx context.Context, manifest string) (map[string]*cluster.ReleaseMeta, error) {
+	ret := _m.Called(ctx, manifest)
 
 	var r0 map[string]*cluster.ReleaseMeta
-	if rf, ok := ret.Get(0).(func(string) map[string]*cluster.ReleaseMeta); ok {
-		r0 = rf(manifest)
+	if rf, ok := ret.Get(0).(func(context.Context, string) map[string]*cluster.ReleaseMeta); ok {
+		r0 = rf(ctx, manifest)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]*cluster.ReleaseMeta)
@@ -213,8 +214,8 @@ func (_m *Releaser) ReleasesByManifest(manifest string) (map[string]*cluster.Rel
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(manifest)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, manifest)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -222,20 +223,20 @@ func (_m *Releaser) ReleasesByManifest(manifest string) (map[string]*cluster.Rel
 	return r0, r1
 }
 
-// RollbackRelease provides a mock function with given fields: m
-func (_m *Releaser) RollbackRelease(m *cluster.RollbackMeta) (int32, error) {
-	ret := _m.Called(m)
+// RollbackRelease provides a mock function with given fields: ctx, m
+func (_m *Releaser) RollbackRelease(ctx context.Context, m *cluster.RollbackMeta) (int32, error) {
+	ret := _m.Called(ctx, m)
 
 	var r0 int32
-	if rf, ok := ret.Get(0).(func(*cluster.RollbackMeta) int32); ok {
-		r0 = rf(m)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.RollbackMeta) int32); ok {
+		r0 = rf(ctx, m)
 	} else {
 		r0 = ret.Get(0).(int32)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*cluster.RollbackMeta) error); ok {
-		r1 = rf(m)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.RollbackMeta) error); ok {
+		r1 = rf(ctx, m)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -243,13 +244,13 @@ func (_m *Releaser) RollbackRelease(m *cluster.RollbackMeta) (int32, error) {
 	return r0, r1
 }
 
-// UpgradeRelease provides a mock function with given fields: m, manifestName
-func (_m *Releaser) UpgradeRelease(m *cluster.ReleaseMeta, manifestName string) (*cluster.UpgradeReleaseResponse, error) {
-	ret := _m.Called(m, manifestName)
+// UpgradeRelease provides a mock function with given fields: ctx, m, manifestName
+func (_m *Releaser) UpgradeRelease(ctx context.Context, m *cluster.ReleaseMeta, manifestName string) (*cluster.UpgradeReleaseResponse, error) {
+	ret := _m.Called(ctx, m, manifestName)
 
 	var r0 *cluster.UpgradeReleaseResponse
-	if rf, ok := ret.Get(0).(func(*cluster.ReleaseMeta, string) *cluster.UpgradeReleaseResponse); ok {
-		r0 = rf(m, manifestName)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta, string) *cluster.UpgradeReleaseResponse); ok {
+		r0 = rf(ctx, m, manifestName)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*cluster.UpgradeReleaseResponse)
@@ -257,8 +258,8 @@ func (_m *Releaser) UpgradeRelease(m *cluster.ReleaseMeta, manifestName string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*cluster.ReleaseMeta, string) error); ok {
-		r1 = rf(m, manifestName)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta, string) error); ok {
+		r1 = rf(ctx, m, manifestName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -2,6 +2,7 @@
 
 package mocks
 
+import context "context"
 import chart "k8s.io/helm/pkg/proto/hapi/chart"
 import cluster "github.com/charter-oss/barrelman/pkg/cluster"
 import io "io"
@@ -12,6 +13,29 @@ type Sessioner struct {
 	mock.Mock
 }
 
+// BreakLock provides a mock function with given fields: manifestName, force
+func (_m *Sessioner) BreakLock(manifestName string, force bool) (*cluster.LockHolder, error) {
+	ret := _m.Called(manifestName, force)
+
+	var r0 *cluster.LockHolder
+	if rf, ok := ret.Get(0).(func(string, bool) *cluster.LockHolder); ok {
+		r0 = rf(manifestName, force)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*cluster.LockHolder)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, bool) error); ok {
+		r1 = rf(manifestName, force)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ChartFromArchive provides a mock function with given fields: aChart
 func (_m *Sessioner) ChartFromArchive(aChart io.Reader) (*chart.Chart, error) {
 	ret := _m.Called(aChart)
@@ -35,13 +59,13 @@ func (_m *Sessioner) ChartFromArchive(aChart io.Reader) (*chart.Chart, error) {
 	return r0, r1
 }
 
-// DeleteRelease provides a mock function with given fields: m
-func (_m *Sessioner) DeleteRelease(m *cluster.DeleteMeta) error {
-	ret := _m.Called(m)
+// CheckVersionSize provides a mock function with given fields: versions
+func (_m *Sessioner) CheckVersionSize(versions *cluster.Versions) error {
+	ret := _m.Called(versions)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*cluster.DeleteMeta) error); ok {
-		r0 = rf(m)
+	if rf, ok := ret.Get(0).(func(*cluster.Versions) error); ok {
+		r0 = rf(versions)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -49,13 +73,13 @@ func (_m *Sessioner) DeleteRelease(m *cluster.DeleteMeta) error {
 	return r0
 }
 
-// DeleteReleases provides a mock function with given fields: dm
-func (_m *Sessioner) DeleteReleases(dm []*cluster.DeleteMeta) error {
-	ret := _m.Called(dm)
+// DeleteRelease provides a mock function with given fields: ctx, m
+func (_m *Sessioner) DeleteRelease(ctx context.Context, m *cluster.DeleteMeta) error {
+	ret := _m.Called(ctx, m)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]*cluster.DeleteMeta) error); ok {
-		r0 = rf(dm)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.DeleteMeta) error); ok {
+		r0 = rf(ctx, m)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -63,6 +87,57 @@ func (_m *Sessioner) DeleteReleases(dm []*cluster.DeleteMeta) error {
 	return r0
 }
 
+// DeleteJournal provides a mock function with given fields: manifestName
+func (_m *Sessioner) DeleteJournal(manifestName string) error {
+	ret := _m.Called(manifestName)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(manifestName)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteReleases provides a mock function with given fields: ctx, dm
+func (_m *Sessioner) DeleteReleases(ctx context.Context, dm []*cluster.DeleteMeta) error {
+	ret := _m.Called(ctx, dm)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []*cluster.DeleteMeta) error); ok {
+		r0 = rf(ctx, dm)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DetectDrift provides a mock function with given fields: ctx, m
+func (_m *Sessioner) DetectDrift(ctx context.Context, m *cluster.ReleaseMeta) (*cluster.ReleaseDrift, error) {
+	ret := _m.Called(ctx, m)
+
+	var r0 *cluster.ReleaseDrift
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta) *cluster.ReleaseDrift); ok {
+		r0 = rf(ctx, m)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*cluster.ReleaseDrift)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta) error); ok {
+		r1 = rf(ctx, m)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DiffManifests provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4
 func (_m *Sessioner) DiffManifests(_a0 map[string]*cluster.MappingResult, _a1 map[string]*cluster.MappingResult, _a2 []string, _a3 int, _a4 io.Writer) bool {
 	ret := _m.Called(_a0, _a1, _a2, _a3, _a4)
@@ -77,20 +152,20 @@ func (_m *Sessioner) DiffManifests(_a0 map[string]*cluster.MappingResult, _a1 ma
 	return r0
 }
 
-// DiffRelease provides a mock function with given fields: m
-func (_m *Sessioner) DiffRelease(m *cluster.ReleaseMeta) (bool, []byte, error) {
-	ret := _m.Called(m)
+// DiffRelease provides a mock function with given fields: ctx, m
+func (_m *Sessioner) DiffRelease(ctx context.Context, m *cluster.ReleaseMeta) (bool, []byte, error) {
+	ret := _m.Called(ctx, m)
 
 	var r0 bool
-	if rf, ok := ret.Get(0).(func(*cluster.ReleaseMeta) bool); ok {
-		r0 = rf(m)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta) bool); ok {
+		r0 = rf(ctx, m)
 	} else {
 		r0 = ret.Get(0).(bool)
 	}
 
 	var r1 []byte
-	if rf, ok := ret.Get(1).(func(*cluster.ReleaseMeta) []byte); ok {
-		r1 = rf(m)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta) []byte); ok {
+		r1 = rf(ctx, m)
 	} else {
 		if ret.Get(1) != nil {
 			r1 = ret.Get(1).([]byte)
@@ -98,8 +173,8 @@ func (_m *Sessioner) DiffRelease(m *cluster.ReleaseMeta) (bool, []byte, error) {
 	}
 
 	var r2 error
-	if rf, ok := ret.Get(2).(func(*cluster.ReleaseMeta) error); ok {
-		r2 = rf(m)
+	if rf, ok := ret.Get(2).(func(context.Context, *cluster.ReleaseMeta) error); ok {
+		r2 = rf(ctx, m)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -107,6 +182,29 @@ func (_m *Sessioner) DiffRelease(m *cluster.ReleaseMeta) (bool, []byte, error) {
 	return r0, r1, r2
 }
 
+// GetJournal provides a mock function with given fields: manifestName
+func (_m *Sessioner) GetJournal(manifestName string) (*cluster.Journal, error) {
+	ret := _m.Called(manifestName)
+
+	var r0 *cluster.Journal
+	if rf, ok := ret.Get(0).(func(string) *cluster.Journal); ok {
+		r0 = rf(manifestName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*cluster.Journal)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(manifestName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetKubeConfig provides a mock function with given fields:
 func (_m *Sessioner) GetKubeConfig() string {
 	ret := _m.Called()
@@ -135,13 +233,13 @@ func (_m *Sessioner) GetKubeContext() string {
 	return r0
 }
 
-// GetRelease provides a mock function with given fields: releaseName, revision
-func (_m *Sessioner) GetRelease(releaseName string, revision int32) (*cluster.ReleaseMeta, error) {
-	ret := _m.Called(releaseName, revision)
+// GetRelease provides a mock function with given fields: ctx, releaseName, revision
+func (_m *Sessioner) GetRelease(ctx context.Context, releaseName string, revision int32) (*cluster.ReleaseMeta, error) {
+	ret := _m.Called(ctx, releaseName, revision)
 
 	var r0 *cluster.ReleaseMeta
-	if rf, ok := ret.Get(0).(func(string, int32) *cluster.ReleaseMeta); ok {
-		r0 = rf(releaseName, revision)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int32) *cluster.ReleaseMeta); ok {
+		r0 = rf(ctx, releaseName, revision)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*cluster.ReleaseMeta)
@@ -149,8 +247,8 @@ func (_m *Sessioner) GetRelease(releaseName string, revision int32) (*cluster.Re
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, int32) error); ok {
-		r1 = rf(releaseName, revision)
+	if rf, ok := ret.Get(1).(func(context.Context, string, int32) error); ok {
+		r1 = rf(ctx, releaseName, revision)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -218,13 +316,13 @@ func (_m *Sessioner) Init() error {
 	return r0
 }
 
-// InstallRelease provides a mock function with given fields: m, manifestName
-func (_m *Sessioner) InstallRelease(m *cluster.ReleaseMeta, manifestName string) (*cluster.InstallReleaseResponse, error) {
-	ret := _m.Called(m, manifestName)
+// InstallRelease provides a mock function with given fields: ctx, m, manifestName
+func (_m *Sessioner) InstallRelease(ctx context.Context, m *cluster.ReleaseMeta, manifestName string) (*cluster.InstallReleaseResponse, error) {
+	ret := _m.Called(ctx, m, manifestName)
 
 	var r0 *cluster.InstallReleaseResponse
-	if rf, ok := ret.Get(0).(func(*cluster.ReleaseMeta, string) *cluster.InstallReleaseResponse); ok {
-		r0 = rf(m, manifestName)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta, string) *cluster.InstallReleaseResponse); ok {
+		r0 = rf(ctx, m, manifestName)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*cluster.InstallReleaseResponse)
@@ -232,8 +330,8 @@ func (_m *Sessioner) InstallRelease(m *cluster.ReleaseMeta, manifestName string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*cluster.ReleaseMeta, string) error); ok {
-		r1 = rf(m, manifestName)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta, string) error); ok {
+		r1 = rf(ctx, m, manifestName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -264,13 +362,13 @@ func (_m *Sessioner) ListManifests() ([]*cluster.Version, error) {
 	return r0, r1
 }
 
-// ListReleases provides a mock function with given fields:
-func (_m *Sessioner) ListReleases() ([]*cluster.Release, error) {
-	ret := _m.Called()
+// ListReleases provides a mock function with given fields: ctx
+func (_m *Sessioner) ListReleases(ctx context.Context) ([]*cluster.Release, error) {
+	ret := _m.Called(ctx)
 
 	var r0 []*cluster.Release
-	if rf, ok := ret.Get(0).(func() []*cluster.Release); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) []*cluster.Release); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*cluster.Release)
@@ -278,8 +376,31 @@ func (_m *Sessioner) ListReleases() ([]*cluster.Release, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Lock provides a mock function with given fields: manifestName
+func (_m *Sessioner) Lock(manifestName string) (cluster.Unlocker, error) {
+	ret := _m.Called(manifestName)
+
+	var r0 cluster.Unlocker
+	if rf, ok := ret.Get(0).(func(string) cluster.Unlocker); ok {
+		r0 = rf(manifestName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(cluster.Unlocker)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(manifestName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -310,13 +431,36 @@ func (_m *Sessioner) NewTransaction(_a0 string) (cluster.Transactioner, error) {
 	return r0, r1
 }
 
-// Releases provides a mock function with given fields:
-func (_m *Sessioner) Releases() (map[string]*cluster.ReleaseMeta, error) {
-	ret := _m.Called()
+// PruneVersions provides a mock function with given fields: manifestName, keep, dryRun
+func (_m *Sessioner) PruneVersions(manifestName string, keep int, dryRun bool) ([]*cluster.Version, error) {
+	ret := _m.Called(manifestName, keep, dryRun)
+
+	var r0 []*cluster.Version
+	if rf, ok := ret.Get(0).(func(string, int, bool) []*cluster.Version); ok {
+		r0 = rf(manifestName, keep, dryRun)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*cluster.Version)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int, bool) error); ok {
+		r1 = rf(manifestName, keep, dryRun)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Releases provides a mock function with given fields: ctx
+func (_m *Sessioner) Releases(ctx context.Context) (map[string]*cluster.ReleaseMeta, error) {
+	ret := _m.Called(ctx)
 
 	var r0 map[string]*cluster.ReleaseMeta
-	if rf, ok := ret.Get(0).(func() map[string]*cluster.ReleaseMeta); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) map[string]*cluster.ReleaseMeta); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]*cluster.ReleaseMeta)
@@ -324,8 +468,8 @@ func (_m *Sessioner) Releases() (map[string]*cluster.ReleaseMeta, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -333,13 +477,13 @@ func (_m *Sessioner) Releases() (map[string]*cluster.ReleaseMeta, error) {
 	return r0, r1
 }
 
-// ReleasesByManifest provides a mock function with given fields: manifest
-func (_m *Sessioner) ReleasesByManifest(manifest string) (map[string]*cluster.ReleaseMeta, error) {
-	ret := _m.Called(manifest)
+// ReleasesByManifest provides a mock function with given fields: ctx, manifest
+func (_m *Sessioner) ReleasesByManifest(ctx context.Context, manifest string) (map[string]*cluster.ReleaseMeta, error) {
+	ret := _m.Called(ctx, manifest)
 
 	var r0 map[string]*cluster.ReleaseMeta
-	if rf, ok := ret.Get(0).(func(string) map[string]*cluster.ReleaseMeta); ok {
-		r0 = rf(manifest)
+	if rf, ok := ret.Get(0).(func(context.Context, string) map[string]*cluster.ReleaseMeta); ok {
+		r0 = rf(ctx, manifest)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]*cluster.ReleaseMeta)
@@ -347,8 +491,8 @@ func (_m *Sessioner) ReleasesByManifest(manifest string) (map[string]*cluster.Re
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(manifest)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, manifest)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -356,20 +500,34 @@ func (_m *Sessioner) ReleasesByManifest(manifest string) (map[string]*cluster.Re
 	return r0, r1
 }
 
-// RollbackRelease provides a mock function with given fields: m
-func (_m *Sessioner) RollbackRelease(m *cluster.RollbackMeta) (int32, error) {
-	ret := _m.Called(m)
+// RepairDrift provides a mock function with given fields: ctx, drift
+func (_m *Sessioner) RepairDrift(ctx context.Context, drift *cluster.ReleaseDrift) error {
+	ret := _m.Called(ctx, drift)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseDrift) error); ok {
+		r0 = rf(ctx, drift)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RollbackRelease provides a mock function with given fields: ctx, m
+func (_m *Sessioner) RollbackRelease(ctx context.Context, m *cluster.RollbackMeta) (int32, error) {
+	ret := _m.Called(ctx, m)
 
 	var r0 int32
-	if rf, ok := ret.Get(0).(func(*cluster.RollbackMeta) int32); ok {
-		r0 = rf(m)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.RollbackMeta) int32); ok {
+		r0 = rf(ctx, m)
 	} else {
 		r0 = ret.Get(0).(int32)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*cluster.RollbackMeta) error); ok {
-		r1 = rf(m)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.RollbackMeta) error); ok {
+		r1 = rf(ctx, m)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -387,13 +545,13 @@ func (_m *Sessioner) SetKubeContext(c string) {
 	_m.Called(c)
 }
 
-// UpgradeRelease provides a mock function with given fields: m, manifestName
-func (_m *Sessioner) UpgradeRelease(m *cluster.ReleaseMeta, manifestName string) (*cluster.UpgradeReleaseResponse, error) {
-	ret := _m.Called(m, manifestName)
+// UpgradeRelease provides a mock function with given fields: ctx, m, manifestName
+func (_m *Sessioner) UpgradeRelease(ctx context.Context, m *cluster.ReleaseMeta, manifestName string) (*cluster.UpgradeReleaseResponse, error) {
+	ret := _m.Called(ctx, m, manifestName)
 
 	var r0 *cluster.UpgradeReleaseResponse
-	if rf, ok := ret.Get(0).(func(*cluster.ReleaseMeta, string) *cluster.UpgradeReleaseResponse); ok {
-		r0 = rf(m, manifestName)
+	if rf, ok := ret.Get(0).(func(context.Context, *cluster.ReleaseMeta, string) *cluster.UpgradeReleaseResponse); ok {
+		r0 = rf(ctx, m, manifestName)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*cluster.UpgradeReleaseResponse)
@@ -401,11 +559,67 @@ func (_m *Sessioner) UpgradeRelease(m *cluster.ReleaseMeta, manifestName string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*cluster.ReleaseMeta, string) error); ok {
-		r1 = rf(m, manifestName)
+	if rf, ok := ret.Get(1).(func(context.Context, *cluster.ReleaseMeta, string) error); ok {
+		r1 = rf(ctx, m, manifestName)
 	} else {
 		r1 = ret.Error(1)
 	}
 
 	return r0, r1
 }
+
+// UseTillers provides a mock function with given fields: namespaces
+func (_m *Sessioner) UseTillers(namespaces []string) error {
+	ret := _m.Called(namespaces)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]string) error); ok {
+		r0 = rf(namespaces)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyCluster provides a mock function with given fields: guard
+func (_m *Sessioner) VerifyCluster(guard *cluster.ClusterGuard) error {
+	ret := _m.Called(guard)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*cluster.ClusterGuard) error); ok {
+		r0 = rf(guard)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WriteJournal provides a mock function with given fields: journal
+func (_m *Sessioner) WriteJournal(journal *cluster.Journal) error {
+	ret := _m.Called(journal)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*cluster.Journal) error); ok {
+		r0 = rf(journal)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WriteVersions provides a mock function with given fields: versions
+func (_m *Sessioner) WriteVersions(versions *cluster.Versions) error {
+	ret := _m.Called(versions)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*cluster.Versions) error); ok {
+		r0 = rf(versions)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -52,6 +52,20 @@ func (_m *Transactioner) Changed() bool {
 	return r0
 }
 
+// Checkpoint provides a mock function with given fields:
+func (_m *Transactioner) Checkpoint() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Complete provides a mock function with given fields:
 func (_m *Transactioner) Complete() error {
 	ret := _m.Called()
@@ -80,6 +94,11 @@ func (_m *Transactioner) Completed() bool {
 	return r0
 }
 
+// Resume provides a mock function with given fields: journal
+func (_m *Transactioner) Resume(journal *cluster.Journal) {
+	_m.Called(journal)
+}
+
 // SetChanged provides a mock function with given fields:
 func (_m *Transactioner) SetChanged() {
 	_m.Called()
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ghodss/yaml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+
+	"github.com/cirrocloud/structured/errors"
+)
+
+// DefaultDeleteWaitTimeout bounds waiting for the objects of a deleted release to be removed
+const DefaultDeleteWaitTimeout = 5 * time.Minute
+
+// deleteWaitInterval is the time between checks for deleted objects
+var deleteWaitInterval = 2 * time.Second
+
+// ObjectClient resolves Kubernetes objects of any kind to a dynamic resource client
+type ObjectClient struct {
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+}
+
+// NewObjectClient returns an *ObjectClient using the discovery API of clientset to map kinds to resources
+func NewObjectClient(config *rest.Config, clientset kubernetes.Interface) (*ObjectClient, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get dynamic kubernetes client")
+	}
+	return &ObjectClient{
+		Dynamic: dynamicClient,
+		Mapper:  restmapper.NewDeferredDiscoveryRESTMapper(&uncachedDiscovery{clientset.Discovery()}),
+	}, nil
+}
+
+// uncachedDiscovery lets the deferred REST mapper use the discovery API directly
+// the mapper keeps the resources discovered on first use, so the results are always reported fresh
+type uncachedDiscovery struct {
+	discovery.DiscoveryInterface
+}
+
+func (d *uncachedDiscovery) Fresh() bool {
+	return true
+}
+
+func (d *uncachedDiscovery) Invalidate() {}
+
+// ResourceFor returns a dynamic client scoped to the resource and namespace of obj
+// the namespace of a cluster scoped obj is cleared
+func (oc *ObjectClient) ResourceFor(obj *unstructured.Unstructured, defaultNamespace string) (dynamic.ResourceInterface, error) {
+	if oc.Dynamic == nil || oc.Mapper == nil {
+		return nil, errors.New("dynamic kubernetes client is not configured")
+	}
+	gvk := obj.GroupVersionKind()
+	mapping, err := oc.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"Kind":       gvk.Kind,
+			"APIVersion": gvk.GroupVersion().String(),
+		}).Wrap(err, "failed to map kind to resource")
+	}
+	ri := oc.Dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return ri, nil
+	}
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return ri.Namespace(namespace), nil
+}
+
+// Apply creates obj, or replaces the live object when it already exists
+func (oc *ObjectClient) Apply(obj *unstructured.Unstructured, defaultNamespace string) error {
+	ri, err := oc.ResourceFor(obj, defaultNamespace)
+	if err != nil {
+		return err
+	}
+	live, err := ri.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return errors.WithFields(errors.Fields{
+				"Name": obj.GetName(),
+				"Kind": obj.GetKind(),
+			}).Wrap(err, "failed to get object")
+		}
+		if _, err := ri.Create(obj, metav1.CreateOptions{}); err != nil {
+			return errors.WithFields(errors.Fields{
+				"Name": obj.GetName(),
+				"Kind": obj.GetKind(),
+			}).Wrap(err, "failed to create object")
+		}
+		return nil
+	}
+	obj.SetResourceVersion(live.GetResourceVersion())
+	if _, err := ri.Update(obj, metav1.UpdateOptions{}); err != nil {
+		return errors.WithFields(errors.Fields{
+			"Name": obj.GetName(),
+			"Kind": obj.GetKind(),
+		}).Wrap(err, "failed to update object")
+	}
+	return nil
+}
+
+// Delete removes obj from the cluster, objects which no longer exist are ignored
+func (oc *ObjectClient) Delete(obj *unstructured.Unstructured, defaultNamespace string) error {
+	ri, err := oc.ResourceFor(obj, defaultNamespace)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = ri.Delete(obj.GetName(), &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.WithFields(errors.Fields{
+			"Name": obj.GetName(),
+			"Kind": obj.GetKind(),
+		}).Wrap(err, "failed to delete object")
+	}
+	return nil
+}
+
+// WaitDeleted polls until none of objects exist, bounded by timeout or DefaultDeleteWaitTimeout when zero
+func (oc *ObjectClient) WaitDeleted(ctx context.Context, objects map[string]*unstructured.Unstructured, defaultNamespace string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultDeleteWaitTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for key, obj := range objects {
+		ri, err := oc.ResourceFor(obj, defaultNamespace)
+		if err != nil {
+			return err
+		}
+		for {
+			_, err := ri.Get(obj.GetName(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				break
+			}
+			if err != nil {
+				return errors.WithFields(errors.Fields{
+					"Object": key,
+				}).Wrap(err, "failed to get object while waiting for its deletion")
+			}
+			select {
+			case <-ctx.Done():
+				return errors.WithFields(errors.Fields{
+					"Object": key,
+				}).Wrap(ctx.Err(), "timed out waiting for object deletion")
+			case <-time.After(deleteWaitInterval):
+			}
+		}
+	}
+	return nil
+}
+
+// ObjectsFromManifest decodes each document of a rendered manifest into an object
+// keyed the same way as Parse
+func ObjectsFromManifest(manifest string, defaultNamespace string) (map[string]*unstructured.Unstructured, error) {
+	ret := make(map[string]*unstructured.Unstructured)
+	for key, v := range Parse(manifest, defaultNamespace) {
+		obj, err := objectFromYAML(v.Content)
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"Object": key,
+			}).Wrap(err, "failed to decode object")
+		}
+		if obj == nil {
+			continue
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(defaultNamespace)
+		}
+		ret[key] = obj
+	}
+	return ret, nil
+}
+
+func objectFromYAML(content string) (*unstructured.Unstructured, error) {
+	raw, err := yaml.YAMLToJSON([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || data["kind"] == nil {
+		return nil, nil
+	}
+	return &unstructured.Unstructured{Object: data}, nil
+}
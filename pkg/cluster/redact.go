@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cirrocloud/structured/log"
+)
+
+// DiffOptions controls how sensitive content is presented by DiffRelease
+type DiffOptions struct {
+	// SuppressedKinds hides all changes for resources of these kinds
+	SuppressedKinds []string
+	// RedactedKinds masks every value under data and stringData for resources of these kinds
+	RedactedKinds []string
+	// RedactedPaths masks the value found at each dotted field path, e.g. "spec.password"
+	RedactedPaths []string
+	// RedactedKeys are regular expressions matched against keys in override values
+	RedactedKeys []string
+	// Context is the number of unchanged lines printed around each change
+	Context int
+}
+
+// NewDiffOptions returns the default DiffOptions, Secret data and common credential keys are masked
+func NewDiffOptions() *DiffOptions {
+	return &DiffOptions{
+		SuppressedKinds: []string{},
+		RedactedKinds:   []string{"Secret"},
+		RedactedPaths:   []string{},
+		RedactedKeys:    []string{"(?i)password", "(?i)secret", "(?i)token", `(?i)(^|[._-])(api[._-]?key|private[._-]?key)$`},
+		Context:         10,
+	}
+}
+
+// Merge returns a new *DiffOptions containing the union of both option sets
+func (o *DiffOptions) Merge(other *DiffOptions) *DiffOptions {
+	if other == nil {
+		return o
+	}
+	return &DiffOptions{
+		SuppressedKinds: appendUnique(o.SuppressedKinds, other.SuppressedKinds...),
+		RedactedKinds:   appendUnique(o.RedactedKinds, other.RedactedKinds...),
+		RedactedPaths:   appendUnique(o.RedactedPaths, other.RedactedPaths...),
+		RedactedKeys:    appendUnique(o.RedactedKeys, other.RedactedKeys...),
+		Context:         o.Context,
+	}
+}
+
+// RedactMappings returns a copy of index with sensitive content replaced by hashes
+func RedactMappings(index map[string]*MappingResult, opts *DiffOptions) map[string]*MappingResult {
+	ret := make(map[string]*MappingResult)
+	for k, v := range index {
+		ret[k] = redactMapping(v, opts)
+	}
+	return ret
+}
+
+// RedactValues replaces the values of keys matching opts.RedactedKeys with hashes
+func RedactValues(raw string, opts *DiffOptions) string {
+	if len(opts.RedactedKeys) == 0 || strings.TrimSpace(raw) == "" {
+		return raw
+	}
+	patterns := []*regexp.Regexp{}
+	for _, v := range opts.RedactedKeys {
+		rx, err := regexp.Compile(v)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Pattern": v,
+				"Error":   err.Error(),
+			}).Warn("Ignoring invalid redaction pattern")
+			continue
+		}
+		patterns = append(patterns, rx)
+	}
+	var values yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(raw), &values); err != nil {
+		return raw
+	}
+	out, err := yaml.Marshal(redactKeys(values, patterns))
+	if err != nil {
+		return raw
+	}
+	return string(out)
+}
+
+func redactMapping(m *MappingResult, opts *DiffOptions) *MappingResult {
+	paths := [][]string{}
+	if hasString(opts.RedactedKinds, m.Kind) {
+		paths = append(paths, []string{"data", "*"}, []string{"stringData", "*"})
+	}
+	for _, v := range opts.RedactedPaths {
+		paths = append(paths, strings.Split(v, "."))
+	}
+	if len(paths) == 0 {
+		return m
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(m.Content), &doc); err != nil {
+		return m
+	}
+	redacted := false
+	for _, path := range paths {
+		if redactPath(doc, path) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		return m
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return m
+	}
+	return &MappingResult{
+		Name:    m.Name,
+		Kind:    m.Kind,
+		Content: strings.TrimSuffix(string(out), "\n"),
+	}
+}
+
+// redactPath masks the value at path within doc, "*" matches every key at that level
+func redactPath(doc yaml.MapSlice, path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	redacted := false
+	for i, item := range doc {
+		if path[0] != "*" && fmt.Sprintf("%v", item.Key) != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = hashValue(item.Value)
+			redacted = true
+			continue
+		}
+		if inner, ok := item.Value.(yaml.MapSlice); ok {
+			if redactPath(inner, path[1:]) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+func redactKeys(values yaml.MapSlice, patterns []*regexp.Regexp) yaml.MapSlice {
+	for i, item := range values {
+		key := fmt.Sprintf("%v", item.Key)
+		if matchAny(patterns, key) {
+			values[i].Value = hashValue(item.Value)
+			continue
+		}
+		switch v := item.Value.(type) {
+		case yaml.MapSlice:
+			values[i].Value = redactKeys(v, patterns)
+		case []interface{}:
+			for j, iv := range v {
+				if ms, ok := iv.(yaml.MapSlice); ok {
+					v[j] = redactKeys(ms, patterns)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// hashValue returns a placeholder that changes whenever the underlying value changes
+func hashValue(v interface{}) string {
+	raw, err := yaml.Marshal(v)
+	if err != nil {
+		raw = []byte(fmt.Sprintf("%v", v))
+	}
+	sum := sha256.Sum256(raw)
+	return fmt.Sprintf("<redacted sha256:%x>", sum[:8])
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, rx := range patterns {
+		if rx.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, items ...string) []string {
+	ret := append([]string{}, list...)
+	for _, v := range items {
+		if !hasString(ret, v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
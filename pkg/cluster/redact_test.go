@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedact(t *testing.T) {
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\ndata:\n  password: c2VjcmV0\n  user: YWRtaW4="
+	changedSecret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\ndata:\n  password: bmV3c2VjcmV0\n  user: YWRtaW4="
+	Convey("RedactMappings", t, func() {
+		opts := NewDiffOptions()
+		Convey("Masks Secret data by default", func() {
+			res := RedactMappings(map[string]*MappingResult{
+				"creds": {Name: "creds", Kind: "Secret", Content: secret},
+			}, opts)
+			So(res["creds"].Content, ShouldNotContainSubstring, "c2VjcmV0")
+			So(res["creds"].Content, ShouldContainSubstring, "<redacted sha256:")
+			So(res["creds"].Content, ShouldContainSubstring, "name: creds")
+		})
+		Convey("Changes remain visible through the hash", func() {
+			buf := bytes.NewBufferString("")
+			changed := DiffManifests(
+				RedactMappings(map[string]*MappingResult{"creds": {Kind: "Secret", Content: secret}}, opts),
+				RedactMappings(map[string]*MappingResult{"creds": {Kind: "Secret", Content: changedSecret}}, opts),
+				opts.SuppressedKinds, opts.Context, buf)
+			So(changed, ShouldBeTrue)
+			So(buf.String(), ShouldNotContainSubstring, "bmV3c2VjcmV0")
+		})
+		Convey("Masks configured field paths", func() {
+			opts = opts.Merge(&DiffOptions{RedactedPaths: []string{"spec.auth.password"}})
+			res := RedactMappings(map[string]*MappingResult{
+				"db": {Kind: "Database", Content: "kind: Database\nspec:\n  auth:\n    password: hunter2\n    user: admin"},
+			}, opts)
+			So(res["db"].Content, ShouldNotContainSubstring, "hunter2")
+			So(res["db"].Content, ShouldContainSubstring, "user: admin")
+		})
+		Convey("Leaves other kinds untouched", func() {
+			content := "kind: ConfigMap\ndata:\n  password: plain"
+			res := RedactMappings(map[string]*MappingResult{"cm": {Kind: "ConfigMap", Content: content}}, opts)
+			So(res["cm"].Content, ShouldEqual, content)
+		})
+	})
+
+	Convey("DiffOverrides", t, func() {
+		opts := NewDiffOptions()
+		Convey("Redacts matching keys", func() {
+			buf := bytes.NewBufferString("")
+			changed := DiffOverrides("db:\n  password: one\n  host: a\n", "db:\n  password: two\n  host: a\n", opts, buf)
+			So(changed, ShouldBeTrue)
+			So(buf.String(), ShouldNotContainSubstring, "one")
+			So(buf.String(), ShouldNotContainSubstring, "two")
+		})
+		Convey("Redacts API and private keys only", func() {
+			redacted := RedactValues("apiKey: a1\ntls:\n  private_key: b2\nkeyspace: c3\nmonkey: d4\n", opts)
+			So(redacted, ShouldNotContainSubstring, "a1")
+			So(redacted, ShouldNotContainSubstring, "b2")
+			So(redacted, ShouldContainSubstring, "keyspace: c3")
+			So(redacted, ShouldContainSubstring, "monkey: d4")
+		})
+		Convey("Supports custom patterns", func() {
+			opts = opts.Merge(&DiffOptions{RedactedKeys: []string{"^cert$"}})
+			So(RedactValues("cert: abc\nname: x\n", opts), ShouldNotContainSubstring, "abc")
+		})
+	})
+
+	Convey("Suppressed kinds", t, func() {
+		buf := bytes.NewBufferString("")
+		DiffManifests(
+			map[string]*MappingResult{"cm": {Kind: "ConfigMap", Content: "a: 1"}},
+			map[string]*MappingResult{"cm": {Kind: "ConfigMap", Content: "a: 2"}},
+			[]string{"ConfigMap"}, 10, buf)
+		So(buf.String(), ShouldContainSubstring, "Changes suppressed")
+		So(buf.String(), ShouldNotContainSubstring, "a: 2")
+	})
+}
@@ -4,6 +4,7 @@ package cluster
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
@@ -20,6 +21,7 @@ import (
 	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
 
 	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/structured/log"
@@ -52,6 +54,11 @@ type ReleaseMeta struct {
 	InstallWait      bool
 	InstallTimeout   time.Duration
 	DryRun           bool
+	DiffOptions      *DiffOptions
+	// TillerNamespace selects the Tiller of the release, empty for the Tiller it was listed from
+	TillerNamespace string
+	// Manifest is the rendered manifest of the revision, only set by GetRelease
+	Manifest string
 }
 
 //DeleteMeta is used with the DeleteRelease method
@@ -60,6 +67,10 @@ type DeleteMeta struct {
 	Namespace     string
 	Purge         bool
 	DeleteTimeout time.Duration
+	// TillerNamespace selects the Tiller of the release, empty for the Tiller it was listed from
+	TillerNamespace string
+	// Wait returns once the objects of the release are removed, bounded by DeleteTimeout
+	Wait bool
 }
 
 //RollbackMeta is used with the RollbackRelease method
@@ -67,6 +78,8 @@ type RollbackMeta struct {
 	ReleaseName string
 	Namespace   string
 	Revision    int32
+	// TillerNamespace selects the Tiller of the release, empty for the Tiller it was listed from
+	TillerNamespace string
 }
 
 type Revision struct {
@@ -84,6 +97,8 @@ type Release struct {
 	Status      Status
 	Revision    int32
 	Config      *chart.Config
+	// TillerNamespace is the namespace of the pooled Tiller holding the release, empty for the primary Tiller
+	TillerNamespace string
 }
 
 type InstallReleaseResponse struct {
@@ -100,43 +115,71 @@ type UpgradeReleaseResponse struct {
 type ReleaseDiff struct {
 }
 
+// Releaser methods calling Tiller or the cluster return ctx.Err() once ctx is done
 type Releaser interface {
-	ListReleases() ([]*Release, error)
-	InstallRelease(m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error)
-	DiffRelease(m *ReleaseMeta) (bool, []byte, error)
-	UpgradeRelease(m *ReleaseMeta, manifestName string) (*UpgradeReleaseResponse, error)
-	DeleteReleases(dm []*DeleteMeta) error
-	DeleteRelease(m *DeleteMeta) error
-	Releases() (map[string]*ReleaseMeta, error)
-	ReleasesByManifest(manifest string) (map[string]*ReleaseMeta, error)
+	ListReleases(ctx context.Context) ([]*Release, error)
+	InstallRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error)
+	DiffRelease(ctx context.Context, m *ReleaseMeta) (bool, []byte, error)
+	UpgradeRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*UpgradeReleaseResponse, error)
+	DeleteReleases(ctx context.Context, dm []*DeleteMeta) error
+	DeleteRelease(ctx context.Context, m *DeleteMeta) error
+	Releases(ctx context.Context) (map[string]*ReleaseMeta, error)
+	ReleasesByManifest(ctx context.Context, manifest string) (map[string]*ReleaseMeta, error)
 	DiffManifests(map[string]*MappingResult, map[string]*MappingResult, []string, int, io.Writer) bool
 	ChartFromArchive(aChart io.Reader) (*chart.Chart, error)
-	GetRelease(releaseName string, revision int32) (*ReleaseMeta, error)
-	RollbackRelease(m *RollbackMeta) (int32, error)
+	GetRelease(ctx context.Context, releaseName string, revision int32) (*ReleaseMeta, error)
+	RollbackRelease(ctx context.Context, m *RollbackMeta) (int32, error)
 }
 
 //ListReleases returns an array of running releases as reported by the cluster
-func (s *Session) ListReleases() ([]*Release, error) {
-	return s.ListReleasesByManifest("")
+func (s *Session) ListReleases(ctx context.Context) ([]*Release, error) {
+	return s.ListReleasesByManifest(ctx, "")
 }
 
 //ListReleases by Manifest returns an array of running releases as reported by the cluster
 // filtered by the ManifestName label
-func (s *Session) ListReleasesByManifest(manifestName string) ([]*Release, error) {
+// releases are listed from every Tiller in the pool, including those recorded in the latest version of the manifest
+func (s *Session) ListReleasesByManifest(ctx context.Context, manifestName string) ([]*Release, error) {
+	if manifestName != "" {
+		if err := s.useRecordedTillers(manifestName); err != nil {
+			return nil, err
+		}
+	}
+	var releases []*Release
+	for _, t := range s.pool() {
+		list, err := t.listReleases(ctx, manifestName)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			v.TillerNamespace = s.pooledNamespace(t)
+			s.rememberTiller(v.ReleaseName, v.TillerNamespace)
+		}
+		releases = append(releases, list...)
+	}
+	return releases, nil
+}
+
+// listReleases returns the releases of the Tiller of s, filtered by the ManifestName label unless empty
+func (s *Session) listReleases(ctx context.Context, manifestName string) ([]*Release, error) {
 	var releases []*Release
 	var filteredReleases []*Release
 
-	r, err := s.Helm.ListReleases(
-		helm.ReleaseListStatuses([]release.Status_Code{
-			release.Status_DELETED,
-			release.Status_DEPLOYED,
-			release.Status_FAILED,
-			release.Status_PENDING_INSTALL,
-			release.Status_PENDING_ROLLBACK,
-			release.Status_PENDING_UPGRADE,
-			release.Status_UNKNOWN,
-		}),
-	)
+	var r *services.ListReleasesResponse
+	err := tillerCall(ctx, s, true, func() (err error) {
+		r, err = s.Helm.ListReleases(
+			helm.ReleaseListStatuses([]release.Status_Code{
+				release.Status_DELETED,
+				release.Status_DEPLOYED,
+				release.Status_FAILED,
+				release.Status_PENDING_INSTALL,
+				release.Status_PENDING_ROLLBACK,
+				release.Status_PENDING_UPGRADE,
+				release.Status_UNKNOWN,
+			}),
+		)
+		return err
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to Helm.ListReleases()")
 	}
@@ -164,17 +207,25 @@ func (s *Session) ListReleasesByManifest(manifestName string) ([]*Release, error
 }
 
 //InstallRelease uploads a chart and starts a release
-func (s *Session) InstallRelease(m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error) {
-	res, err := s.Helm.InstallReleaseFromChart(
-		setChartManifestTags(m.Chart, "Manifest="+manifestName),
-		m.Namespace,
-		helm.ReleaseName(m.ReleaseName),
-		helm.ValueOverrides(m.ValueOverrides),
-		helm.InstallDryRun(m.DryRun),
-		helm.InstallReuseName(m.InstallReuseName),
-		helm.InstallWait(m.InstallWait),
-		helm.InstallTimeout(int64(m.InstallTimeout.Seconds())),
-	)
+func (s *Session) InstallRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error) {
+	t, err := s.tillerFor(m.TillerNamespace)
+	if err != nil {
+		return &InstallReleaseResponse{}, err
+	}
+	var res *services.InstallReleaseResponse
+	err = tillerCall(ctx, t, m.DryRun, func() (err error) {
+		res, err = t.Helm.InstallReleaseFromChart(
+			setChartManifestTags(m.Chart, "Manifest="+manifestName),
+			m.Namespace,
+			helm.ReleaseName(m.ReleaseName),
+			helm.ValueOverrides(m.ValueOverrides),
+			helm.InstallDryRun(m.DryRun),
+			helm.InstallReuseName(m.InstallReuseName),
+			helm.InstallWait(m.InstallWait),
+			helm.InstallTimeout(int64(m.InstallTimeout.Seconds())),
+		)
+		return err
+	})
 	if err != nil {
 		return &InstallReleaseResponse{}, errors.WithFields(errors.Fields{
 			"File":      m.Path,
@@ -182,6 +233,9 @@ func (s *Session) InstallRelease(m *ReleaseMeta, manifestName string) (*InstallR
 			"Namespace": m.Namespace,
 		}).Wrap(err, "failed install")
 	}
+	if !m.DryRun {
+		s.rememberTiller(res.Release.Name, s.pooledNamespace(t))
+	}
 
 	return &InstallReleaseResponse{
 		Description:    res.Release.Info.Description,
@@ -191,33 +245,62 @@ func (s *Session) InstallRelease(m *ReleaseMeta, manifestName string) (*InstallR
 }
 
 //DiffRelease compares the differences between a running release and a proposed release
-func (s *Session) DiffRelease(m *ReleaseMeta) (bool, []byte, error) {
+func (s *Session) DiffRelease(ctx context.Context, m *ReleaseMeta) (bool, []byte, error) {
 	buf := bytes.NewBufferString("")
-	currentR, err := s.Helm.ReleaseContent(m.ReleaseName)
+	t, err := s.tillerOf(m.ReleaseName, m.TillerNamespace)
+	if err != nil {
+		return false, nil, err
+	}
+	var currentR *services.GetReleaseContentResponse
+	err = tillerCall(ctx, t, true, func() (err error) {
+		currentR, err = t.Helm.ReleaseContent(m.ReleaseName)
+		return err
+	})
 	if err != nil {
 		return false, nil, errors.Wrap(err, "Upgrade failed to get current release")
 	}
 	currentParsed := ParseRelease(currentR.Release)
-	res, err := s.Helm.UpdateReleaseFromChart(
-		m.ReleaseName,
-		m.Chart,
-		helm.UpgradeDryRun(true),
-		helm.UpdateValueOverrides(m.ValueOverrides),
-	)
+	var res *services.UpdateReleaseResponse
+	err = tillerCall(ctx, t, true, func() (err error) {
+		res, err = t.Helm.UpdateReleaseFromChart(
+			m.ReleaseName,
+			m.Chart,
+			helm.UpgradeDryRun(true),
+			helm.UpdateValueOverrides(m.ValueOverrides),
+		)
+		return err
+	})
 	if err != nil {
 		return false, []byte{}, errors.Wrap(err, "Failed to get results from Tiller")
 	}
 
 	newParsed := ParseRelease(res.Release)
 
-	manifestsChanged := DiffManifests(currentParsed, newParsed, []string{}, int(10), buf)
-	valuesChanged := DiffOverrides(currentR.Release.Config.Raw, res.Release.Config.Raw, buf)
+	opts := m.DiffOptions
+	if opts == nil {
+		opts = NewDiffOptions()
+	}
+	manifestsChanged := DiffManifests(
+		RedactMappings(currentParsed, opts),
+		RedactMappings(newParsed, opts),
+		opts.SuppressedKinds,
+		opts.Context,
+		buf)
+	valuesChanged := DiffOverrides(currentR.Release.Config.Raw, res.Release.Config.Raw, opts, buf)
 	return manifestsChanged || valuesChanged, buf.Bytes(), err
 }
 
 // GetRelease retrieves release data by release revision
-func (s *Session) GetRelease(releaseName string, revision int32) (*ReleaseMeta, error) {
-	currentR, err := s.Helm.ReleaseContent(releaseName, helm.ContentReleaseVersion(revision))
+func (s *Session) GetRelease(ctx context.Context, releaseName string, revision int32) (*ReleaseMeta, error) {
+	t, err := s.tillerOf(releaseName, "")
+	if err != nil {
+		return nil, err
+	}
+	var currentR *services.GetReleaseContentResponse
+	err = tillerCall(ctx, t, true, func() (err error) {
+		currentR, err = t.Helm.ReleaseContent(releaseName, helm.ContentReleaseVersion(revision))
+		return err
+	})
 	if err != nil {
 		return nil, errors.WithFields(errors.Fields{
 			"ReleaseName": releaseName,
@@ -225,23 +308,33 @@ func (s *Session) GetRelease(releaseName string, revision int32) (*ReleaseMeta,
 		}).Wrap(err, "failed to get release by version")
 	}
 	return &ReleaseMeta{
-		Chart:       currentR.Release.Chart,
-		ReleaseName: currentR.Release.Name,
-		Namespace:   currentR.Release.Namespace,
-		Revision:    currentR.Release.Version,
-		Config:      currentR.Release.Config,
+		Chart:           currentR.Release.Chart,
+		ReleaseName:     currentR.Release.Name,
+		Namespace:       currentR.Release.Namespace,
+		Revision:        currentR.Release.Version,
+		Config:          currentR.Release.Config,
+		TillerNamespace: s.pooledNamespace(t),
+		Manifest:        releaseManifest(currentR.Release),
 	}, nil
 }
 
 //UpgradeRelease applies changes to an already running release, potentially triggering a restart
-func (s *Session) UpgradeRelease(m *ReleaseMeta, manifestName string) (*UpgradeReleaseResponse, error) {
-	res, err := s.Helm.UpdateReleaseFromChart(
-		m.ReleaseName,
-		setChartManifestTags(m.Chart, "Manifest="+manifestName),
-		helm.UpgradeForce(true),
-		helm.UpgradeDryRun(m.DryRun),
-		helm.UpdateValueOverrides(m.ValueOverrides),
-	)
+func (s *Session) UpgradeRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*UpgradeReleaseResponse, error) {
+	t, err := s.tillerOf(m.ReleaseName, m.TillerNamespace)
+	if err != nil {
+		return &UpgradeReleaseResponse{}, err
+	}
+	var res *services.UpdateReleaseResponse
+	err = tillerCall(ctx, t, m.DryRun, func() (err error) {
+		res, err = t.Helm.UpdateReleaseFromChart(
+			m.ReleaseName,
+			setChartManifestTags(m.Chart, "Manifest="+manifestName),
+			helm.UpgradeForce(true),
+			helm.UpgradeDryRun(m.DryRun),
+			helm.UpdateValueOverrides(m.ValueOverrides),
+		)
+		return err
+	})
 	if err != nil {
 		return &UpgradeReleaseResponse{}, errors.Wrap(err, "Error during UpgradeRelease")
 	}
@@ -252,9 +345,9 @@ func (s *Session) UpgradeRelease(m *ReleaseMeta, manifestName string) (*UpgradeR
 }
 
 //DeleteReleases calls DeleteRelease on an array of Releases
-func (s *Session) DeleteReleases(dm []*DeleteMeta) error {
+func (s *Session) DeleteReleases(ctx context.Context, dm []*DeleteMeta) error {
 	for _, v := range dm {
-		if err := s.DeleteRelease(v); err != nil {
+		if err := s.DeleteRelease(ctx, v); err != nil {
 			return err
 		}
 	}
@@ -262,25 +355,76 @@ func (s *Session) DeleteReleases(dm []*DeleteMeta) error {
 }
 
 //DeleteRelease runs a DeleteRelease command based on a release name
-func (s *Session) DeleteRelease(m *DeleteMeta) error {
-	_, err := s.Helm.DeleteRelease(
-		m.ReleaseName,
-		helm.DeletePurge(m.Purge),
-		helm.DeleteTimeout(int64(m.DeleteTimeout.Seconds())),
-	)
+func (s *Session) DeleteRelease(ctx context.Context, m *DeleteMeta) error {
+	t, err := s.tillerOf(m.ReleaseName, m.TillerNamespace)
+	if err != nil {
+		return err
+	}
+	var content *services.GetReleaseContentResponse
+	if m.Wait {
+		err = tillerCall(ctx, t, true, func() (err error) {
+			content, err = t.Helm.ReleaseContent(m.ReleaseName)
+			return err
+		})
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"Name": m.ReleaseName,
+			}).Wrap(err, "failed to get release content")
+		}
+	}
+	err = tillerCall(ctx, t, false, func() error {
+		_, err := t.Helm.DeleteRelease(
+			m.ReleaseName,
+			helm.DeletePurge(m.Purge),
+			helm.DeleteTimeout(int64(m.DeleteTimeout.Seconds())),
+		)
+		return err
+	})
 	if err != nil {
 		return errors.New(grpc.ErrorDesc(err))
 	}
+	if content == nil || content.GetRelease().GetInfo().GetStatus().GetCode() == release.Status_DELETED {
+		return nil
+	}
+	return waitDeleted(ctx, t.Objects, m, content.Release.Manifest, content.Release.Namespace)
+}
+
+// waitDeleted waits for the objects of the deleted release manifest to be removed
+func waitDeleted(ctx context.Context, oc *ObjectClient, m *DeleteMeta, manifest, namespace string) error {
+	if oc == nil {
+		return errors.New("waiting for deletion requires a kubernetes connection")
+	}
+	objects, err := ObjectsFromManifest(manifest, namespace)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"Name":    m.ReleaseName,
+		"Objects": len(objects),
+	}).Debug("Waiting for release objects to be deleted")
+	if err := oc.WaitDeleted(ctx, objects, namespace, m.DeleteTimeout); err != nil {
+		return errors.WithFields(errors.Fields{
+			"Name": m.ReleaseName,
+		}).Wrap(err, "release objects were not deleted")
+	}
 	return nil
 }
 
 //RollbackRelease sets the deployed revision
-func (s *Session) RollbackRelease(m *RollbackMeta) (int32, error) {
-	resp, err := s.Helm.RollbackRelease(
-		m.ReleaseName,
-		helm.RollbackForce(true),
-		helm.RollbackVersion(m.Revision),
-	)
+func (s *Session) RollbackRelease(ctx context.Context, m *RollbackMeta) (int32, error) {
+	t, err := s.tillerOf(m.ReleaseName, m.TillerNamespace)
+	if err != nil {
+		return 0, err
+	}
+	var resp *services.RollbackReleaseResponse
+	err = tillerCall(ctx, t, false, func() (err error) {
+		resp, err = t.Helm.RollbackRelease(
+			m.ReleaseName,
+			helm.RollbackForce(true),
+			helm.RollbackVersion(m.Revision),
+		)
+		return err
+	})
 	if err != nil {
 		return 0, errors.New(grpc.ErrorDesc(err))
 	}
@@ -289,30 +433,64 @@ func (s *Session) RollbackRelease(m *RollbackMeta) (int32, error) {
 }
 
 //Releases queries a cluster and returns a map of currently deployed releases
-func (s *Session) Releases() (map[string]*ReleaseMeta, error) {
-	return s.ReleasesByManifest("")
+func (s *Session) Releases(ctx context.Context) (map[string]*ReleaseMeta, error) {
+	return s.ReleasesByManifest(ctx, "")
 }
 
-func (s *Session) ReleasesByManifest(manifestName string) (map[string]*ReleaseMeta, error) {
+func (s *Session) ReleasesByManifest(ctx context.Context, manifestName string) (map[string]*ReleaseMeta, error) {
 	ret := make(map[string]*ReleaseMeta)
 
-	releaseList, err := s.ListReleasesByManifest(manifestName)
+	releaseList, err := s.ListReleasesByManifest(ctx, manifestName)
 	if err != nil {
 		return ret, errors.Wrap(err, "failed to list releases")
 	}
+	return releaseMetaMap(releaseList), nil
+}
 
+// ReleaseKey returns the key of a release in the maps returned by Releases and ReleasesByManifest
+func ReleaseKey(namespace, releaseName string) string {
+	return namespace + "/" + releaseName
+}
+
+// FindRelease returns the release of releaseName in namespace, a release of the same name in another
+// namespace is returned when there is none in namespace. nil is returned when there is no such release.
+func FindRelease(releases map[string]*ReleaseMeta, namespace, releaseName string) *ReleaseMeta {
+	if rel, ok := releases[ReleaseKey(namespace, releaseName)]; ok {
+		return rel
+	}
+	for _, key := range sortedKeys(releases) {
+		if releases[key].ReleaseName == releaseName {
+			return releases[key]
+		}
+	}
+	return nil
+}
+
+// releaseMetaMap returns releases keyed by namespace and release name, see ReleaseKey
+func releaseMetaMap(releaseList []*Release) map[string]*ReleaseMeta {
+	ret := make(map[string]*ReleaseMeta)
 	for _, v := range releaseList {
-		ret[v.ReleaseName] = &ReleaseMeta{
-			Chart:       v.Chart,
-			ReleaseName: v.ReleaseName,
-			ChartName:   v.Chart.GetMetadata().Name,
-			Namespace:   v.Namespace,
-			Status:      v.Status,
-			Revision:    v.Revision,
-			Config:      v.Config,
+		ret[ReleaseKey(v.Namespace, v.ReleaseName)] = &ReleaseMeta{
+			Chart:           v.Chart,
+			ReleaseName:     v.ReleaseName,
+			ChartName:       v.Chart.GetMetadata().Name,
+			Namespace:       v.Namespace,
+			Status:          v.Status,
+			Revision:        v.Revision,
+			Config:          v.Config,
+			TillerNamespace: v.TillerNamespace,
 		}
 	}
-	return ret, nil
+	return ret
+}
+
+// Prior returns the state of the release for a transaction to restore it
+func (rm *ReleaseMeta) Prior() *PriorRelease {
+	return &PriorRelease{
+		Chart:  rm.Chart,
+		Config: rm.Config,
+		Status: rm.Status,
+	}
 }
 
 func (rm *ReleaseMeta) ShortReport() map[string]interface{} {
@@ -400,9 +578,15 @@ func splitSpec(token string) (string, string) {
 	return "", ""
 }
 
-func DiffOverrides(current string, proposed string, to io.Writer) (changed bool) {
+//DiffOverrides prints the differences between two sets of override values
+//values with keys matching opts.RedactedKeys are replaced by hashes
+func DiffOverrides(current string, proposed string, opts *DiffOptions, to io.Writer) (changed bool) {
 	if current != proposed {
-		fmt.Fprintf(to, ansi.Color("Override Values has changed:", "magenta")+"\n")
+		fmt.Fprint(to, ansi.Color("Override Values has changed:", "magenta")+"\n")
+		if opts != nil {
+			current = RedactValues(current, opts)
+			proposed = RedactValues(proposed, opts)
+		}
 		diffs := diffStrings(current, proposed)
 		if len(diffs) > 0 {
 			changed = true
@@ -413,13 +597,18 @@ func DiffOverrides(current string, proposed string, to io.Writer) (changed bool)
 }
 
 func ParseRelease(release *release.Release) map[string]*MappingResult {
+	return Parse(releaseManifest(release), release.Namespace)
+}
+
+// releaseManifest returns the manifest of release followed by the manifests of its hooks
+func releaseManifest(release *release.Release) string {
 	manifest := release.Manifest
 	for _, hook := range release.Hooks {
 		manifest += "\n---\n"
 		manifest += fmt.Sprintf("# Source: %s\n", hook.Path)
 		manifest += hook.Manifest
 	}
-	return Parse(manifest, release.Namespace)
+	return manifest
 }
 
 func Parse(manifest string, defaultNamespace string) map[string]*MappingResult {
@@ -527,7 +716,7 @@ func printDiffRecords(suppressedKinds []string, kind string, context int, diffs
 	for _, ckind := range suppressedKinds {
 		if ckind == kind {
 			str := fmt.Sprintf("+ Changes suppressed on sensitive content of type %s\n", kind)
-			fmt.Fprintf(to, ansi.Color(str, "yellow"))
+			fmt.Fprint(to, ansi.Color(str, "yellow"))
 			return
 		}
 	}
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -39,7 +40,7 @@ func TestListReleases(t *testing.T) {
 				Count:    int64(len(r)),
 				Releases: r,
 			}, errors.New("LsitReleases should fail")).Once()
-			_, err := s.ListReleases()
+			_, err := s.ListReleases(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 		Convey("Can succeed", func() {
@@ -62,7 +63,7 @@ func TestListReleases(t *testing.T) {
 				Count:    int64(len(r)),
 				Releases: r,
 			}, nil).Once()
-			releases, err := s.ListReleases()
+			releases, err := s.ListReleases(context.Background())
 			So(err, ShouldBeNil)
 			So(releases, ShouldHaveLength, 1)
 			So(releases[0].Status, ShouldEqual, Status_DEPLOYED)
@@ -88,7 +89,7 @@ func TestInstallRelease(t *testing.T) {
 				mock.Anything,
 			).Return(&rls.InstallReleaseResponse{},
 				errors.New("Sucessfully failed")).Once()
-			_, err := s.InstallRelease(&ReleaseMeta{
+			_, err := s.InstallRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 				Chart: &hapi_chart3.Chart{
@@ -125,7 +126,7 @@ func TestInstallRelease(t *testing.T) {
 				mock.Anything,
 				mock.Anything,
 			).Return(r, nil).Once()
-			_, err := s.InstallRelease(&ReleaseMeta{
+			_, err := s.InstallRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 				Chart: &hapi_chart3.Chart{
@@ -151,7 +152,7 @@ func TestUpgradeRelease(t *testing.T) {
 				mock.Anything,
 			).Return(&rls.UpdateReleaseResponse{},
 				errors.New("Sucessfully failed")).Once()
-			_, err := s.UpgradeRelease(&ReleaseMeta{
+			_, err := s.UpgradeRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 				Chart: &hapi_chart3.Chart{
@@ -185,7 +186,7 @@ func TestUpgradeRelease(t *testing.T) {
 				mock.Anything,
 				mock.Anything,
 			).Return(r, nil).Once()
-			_, err := s.UpgradeRelease(&ReleaseMeta{
+			_, err := s.UpgradeRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 				Chart: &hapi_chart3.Chart{
@@ -208,7 +209,7 @@ func TestDeleteRelease(t *testing.T) {
 				mock.Anything,
 			).Return(&rls.UninstallReleaseResponse{},
 				grpc.Errorf(grpc.Code(grpc.ErrServerStopped), "Failure sucessful")).Once()
-			err := s.DeleteReleases([]*DeleteMeta{
+			err := s.DeleteReleases(context.Background(), []*DeleteMeta{
 				{
 					ReleaseName: "this",
 					Namespace:   "here",
@@ -233,7 +234,7 @@ func TestDeleteRelease(t *testing.T) {
 				mock.Anything,
 				mock.Anything,
 			).Return(r, nil).Twice()
-			err := s.DeleteReleases([]*DeleteMeta{
+			err := s.DeleteReleases(context.Background(), []*DeleteMeta{
 				{
 					ReleaseName: "this1",
 					Namespace:   "here1",
@@ -256,7 +257,7 @@ func TestReleases(t *testing.T) {
 				Count:    int64(len(r)),
 				Releases: r,
 			}, errors.New("successfuly failed")).Once()
-			_, err := s.Releases()
+			_, err := s.Releases(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 		Convey("Can succeed", func() {
@@ -279,10 +280,10 @@ func TestReleases(t *testing.T) {
 				Count:    int64(len(r)),
 				Releases: r,
 			}, nil).Once()
-			m, err := s.Releases()
+			m, err := s.Releases(context.Background())
 			So(err, ShouldBeNil)
 			Print(m)
-			So(m, ShouldContainKey, "this_chartname")
+			So(m, ShouldContainKey, ReleaseKey("", "this_chartname"))
 		})
 	})
 }
@@ -310,14 +311,14 @@ func TestGetRelease(t *testing.T) {
 			TestHelm.On("ReleaseContent", releaseName, mock.Anything).Return(&rls.GetReleaseContentResponse{
 				Release: r,
 			}, nil).Once()
-			release, err := s.GetRelease(releaseName, revision)
+			release, err := s.GetRelease(context.Background(), releaseName, revision)
 			So(err, ShouldBeNil)
 			So(release.ReleaseName, ShouldEqual, releaseName)
 		})
 
 		Convey("Can fail", func() {
 			TestHelm.On("ReleaseContent", releaseName, mock.Anything).Return(nil, errors.New("Sim error")).Once()
-			_, err := s.GetRelease(releaseName, revision)
+			_, err := s.GetRelease(context.Background(), releaseName, revision)
 			So(err, ShouldNotBeNil)
 			So(err.Error(), ShouldContainSubstring, "Sim error")
 		})
@@ -377,7 +378,7 @@ func TestDiffRelease(t *testing.T) {
 			TestHelm.On("ReleaseContent", mock.Anything).Return(&rls.GetReleaseContentResponse{
 				Release: nil,
 			}, errors.New("ReleaseContent should fail")).Once()
-			_, _, err := s.DiffRelease(&ReleaseMeta{
+			_, _, err := s.DiffRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 			})
@@ -396,7 +397,7 @@ func TestDiffRelease(t *testing.T) {
 				mock.Anything,
 			).Return(nil, errors.New("UpdateRelease should fail")).Once()
 
-			_, _, err := s.DiffRelease(&ReleaseMeta{
+			_, _, err := s.DiffRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 			})
@@ -427,7 +428,7 @@ func TestDiffRelease(t *testing.T) {
 				mock.Anything,
 				mock.Anything,
 			).Return(updateReleaseResp, nil).Once()
-			changed, bytes, err := s.DiffRelease(&ReleaseMeta{
+			changed, bytes, err := s.DiffRelease(context.Background(), &ReleaseMeta{
 				ReleaseName: "something",
 				Namespace:   "that_namespace",
 			})
@@ -437,3 +438,23 @@ func TestDiffRelease(t *testing.T) {
 		})
 	})
 }
+
+func TestFindRelease(t *testing.T) {
+	Convey("FindRelease", t, func() {
+		releases := map[string]*ReleaseMeta{
+			ReleaseKey("apps", "app"): {ReleaseName: "app", Namespace: "apps"},
+			ReleaseKey("web", "app"):  {ReleaseName: "app", Namespace: "web"},
+			ReleaseKey("apps", "db"):  {ReleaseName: "db", Namespace: "apps"},
+		}
+		Convey("Prefers the release in the namespace", func() {
+			So(FindRelease(releases, "web", "app").Namespace, ShouldEqual, "web")
+			So(FindRelease(releases, "apps", "app").Namespace, ShouldEqual, "apps")
+		})
+		Convey("Falls back to a release of the same name in another namespace", func() {
+			So(FindRelease(releases, "data", "db").Namespace, ShouldEqual, "apps")
+		})
+		Convey("Returns nil for unknown releases", func() {
+			So(FindRelease(releases, "apps", "cache"), ShouldBeNil)
+		})
+	})
+}
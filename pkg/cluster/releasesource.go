@@ -0,0 +1,226 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/any"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/cirrocloud/structured/errors"
+)
+
+// releaseSourcePrefix names the Secrets holding the release sources of a manifest version
+const releaseSourcePrefix = "barrelman-source."
+
+// ReleaseSourceType is the type of the Secrets holding release sources
+const ReleaseSourceType = "barrelman.io/release-source.v1"
+
+// The chart is stored compressed, the values as they were given
+const (
+	releaseSourceChartKey  = "chart.gz"
+	releaseSourceValuesKey = "values.yaml"
+)
+
+// ReleaseSources stores the chart and values of each release changed by a manifest version
+// as a Secret per release in Namespace, apart from versions stored as ConfigMaps
+type ReleaseSources struct {
+	Clientset kubernetes.Interface
+	Namespace string
+}
+
+// NewReleaseSources returns the store of release sources for versions kept with storage in namespace
+// it is nil when versions are stored as Secrets, they hold the release sources themselves
+func NewReleaseSources(clientset kubernetes.Interface, namespace, storage string) *ReleaseSources {
+	if storage == VersionsStorageSecret {
+		return nil
+	}
+	return &ReleaseSources{
+		Clientset: clientset,
+		Namespace: namespace,
+	}
+}
+
+// releaseSources returns where the release sources of the session are stored apart from the versions
+func (s *Session) releaseSources() *ReleaseSources {
+	return NewReleaseSources(s.Clientset, s.versionsNamespace(), s.VersionsStorage)
+}
+
+// releaseSources returns where the release sources of the session are stored apart from the versions
+func (s *Helm3Session) releaseSources() *ReleaseSources {
+	return NewReleaseSources(s.Clientset, s.VersionsNamespace, s.VersionsStorage)
+}
+
+func (rs *ReleaseSources) secrets() corev1.SecretInterface {
+	return rs.Clientset.CoreV1().Secrets(rs.Namespace)
+}
+
+// WriteFiles stores the release files of revision of manifestName, one Secret per release
+// an existing Secret is replaced, it is left by a version that failed to be written
+func (rs *ReleaseSources) WriteFiles(manifestName string, revision int32, files []*any.Any) error {
+	secrets := make(map[string]*v1.Secret)
+	names := []string{}
+	for _, f := range files {
+		releaseName, key, ok := releaseFileKey(f.TypeUrl)
+		if !ok {
+			continue
+		}
+		secret, ok := secrets[releaseName]
+		if !ok {
+			secret = newReleaseSourceSecret(manifestName, revision, releaseName)
+			secrets[releaseName] = secret
+			names = append(names, releaseName)
+		}
+		value := f.Value
+		if key == releaseSourceChartKey {
+			compressed, err := compressSource(f.Value)
+			if err != nil {
+				return errors.WithFields(errors.Fields{
+					"ManifestName": manifestName,
+					"Release":      releaseName,
+				}).Wrap(err, "failed to compress release chart")
+			}
+			value = compressed
+		}
+		secret.Data[key] = value
+	}
+	for _, name := range names {
+		secret := secrets[name]
+		_, err := rs.secrets().Create(secret)
+		if apierrors.IsAlreadyExists(err) {
+			_, err = rs.secrets().Update(secret)
+		}
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"ManifestName": manifestName,
+				"Revision":     revision,
+				"Release":      name,
+			}).Wrap(err, "failed to store release source")
+		}
+	}
+	return nil
+}
+
+// Files returns the release files stored for revision of manifestName
+func (rs *ReleaseSources) Files(manifestName string, revision int32) ([]*any.Any, error) {
+	secrets, err := rs.list(manifestName, revision)
+	if err != nil {
+		return nil, err
+	}
+	files := []*any.Any{}
+	for _, secret := range secrets {
+		releaseName := secret.Labels["RELEASE"]
+		if raw, ok := secret.Data[releaseSourceChartKey]; ok {
+			chart, err := decompressSource(raw)
+			if err != nil {
+				return nil, errors.WithFields(errors.Fields{
+					"ManifestName": manifestName,
+					"Revision":     revision,
+					"Release":      releaseName,
+				}).Wrap(err, "failed to decompress release chart")
+			}
+			files = append(files, &any.Any{TypeUrl: fmt.Sprintf(storedChartPath, releaseName), Value: chart})
+		}
+		if values, ok := secret.Data[releaseSourceValuesKey]; ok {
+			files = append(files, &any.Any{TypeUrl: fmt.Sprintf(storedValuesPath, releaseName), Value: values})
+		}
+	}
+	return files, nil
+}
+
+// Delete removes the release sources of revision of manifestName
+func (rs *ReleaseSources) Delete(manifestName string, revision int32) error {
+	secrets, err := rs.list(manifestName, revision)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		err := rs.secrets().Delete(secret.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.WithFields(errors.Fields{
+				"ManifestName": manifestName,
+				"Revision":     revision,
+				"Release":      secret.Labels["RELEASE"],
+			}).Wrap(err, "failed to remove release source")
+		}
+	}
+	return nil
+}
+
+func (rs *ReleaseSources) list(manifestName string, revision int32) ([]v1.Secret, error) {
+	list, err := rs.secrets().List(metav1.ListOptions{
+		LabelSelector: kblabels.Set{
+			"OWNER":   "BARRELMAN_SOURCE",
+			"NAME":    manifestName,
+			"VERSION": strconv.Itoa(int(revision)),
+		}.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"ManifestName": manifestName,
+			"Revision":     revision,
+		}).Wrap(err, "failed to list release sources")
+	}
+	return list.Items, nil
+}
+
+func newReleaseSourceSecret(manifestName string, revision int32, releaseName string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s%s.v%d.%s", releaseSourcePrefix, manifestName, revision, releaseName),
+			Labels: map[string]string{
+				"NAME":    manifestName,
+				"VERSION": strconv.Itoa(int(revision)),
+				"RELEASE": releaseName,
+				// Not BARRELMAN, release sources are not listed with the versions
+				"OWNER": "BARRELMAN_SOURCE",
+			},
+		},
+		Type: ReleaseSourceType,
+		Data: map[string][]byte{},
+	}
+}
+
+// releaseFileKey returns the release and Secret key of a release file of a manifest version
+func releaseFileKey(path string) (string, string, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] != "releases" {
+		return "", "", false
+	}
+	switch path {
+	case fmt.Sprintf(storedChartPath, parts[1]):
+		return parts[1], releaseSourceChartKey, true
+	case fmt.Sprintf(storedValuesPath, parts[1]):
+		return parts[1], releaseSourceValuesKey, true
+	}
+	return "", "", false
+}
+
+func compressSource(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	if _, err := gzw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressSource(data []byte) ([]byte, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(gzr)
+}
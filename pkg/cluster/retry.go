@@ -0,0 +1,169 @@
+package cluster
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// RetryPolicy retries transient Tiller and Kubernetes errors with jittered exponential backoff
+type RetryPolicy struct {
+	// Attempts is the total number of calls, 1 disables retries
+	Attempts        int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// Jitter randomizes each interval by up to this fraction of it
+	Jitter float64
+}
+
+// NewRetryPolicy returns a *RetryPolicy with the default limits
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Attempts:        3,
+		InitialInterval: time.Second,
+		MaxInterval:     30 * time.Second,
+		Jitter:          0.5,
+	}
+}
+
+// Merge returns a new *RetryPolicy with the limits set in other replacing those of p
+func (p *RetryPolicy) Merge(other *RetryPolicy) *RetryPolicy {
+	merged := *p
+	if other == nil {
+		return &merged
+	}
+	if other.Attempts > 0 {
+		merged.Attempts = other.Attempts
+	}
+	if other.InitialInterval > 0 {
+		merged.InitialInterval = other.InitialInterval
+	}
+	if other.MaxInterval > 0 {
+		merged.MaxInterval = other.MaxInterval
+	}
+	if other.Jitter > 0 {
+		merged.Jitter = other.Jitter
+	}
+	return &merged
+}
+
+// Backoff returns the wait after the given failed attempt, doubling from InitialInterval up to MaxInterval
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	wait := p.InitialInterval
+	for i := 1; i < attempt && wait < p.MaxInterval; i++ {
+		wait *= 2
+	}
+	if p.MaxInterval > 0 && wait > p.MaxInterval {
+		wait = p.MaxInterval
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * p.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// Do calls fn until it succeeds, fails with a permanent error, runs out of attempts or ctx is done
+// a nil policy calls fn once
+func (p *RetryPolicy) Do(ctx context.Context, operation string, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= p.Attempts {
+			return errors.WithFields(errors.Fields{
+				"Operation": operation,
+				"Attempts":  attempt,
+			}).Wrap(err, "giving up after transient errors")
+		}
+		wait := p.Backoff(attempt)
+		log.WithFields(log.Fields{
+			"Operation": operation,
+			"Attempt":   attempt,
+			"Wait":      wait.String(),
+			"Error":     err.Error(),
+		}).Warn("Transient error, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// transientMessages identify transient errors which have lost their type while being wrapped
+var transientMessages = []string{
+	"code = Unavailable",
+	"code = DeadlineExceeded",
+	"transport is closing",
+	"connection refused",
+	"connection reset by peer",
+	"broken pipe",
+	"unexpected EOF",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"lost connection to pod",
+	"error upgrading connection",
+	"an error on the server",
+	"the server is currently unable to handle the request",
+	"the server was unable to return a response in the time allotted",
+	"Too many requests",
+	"too many requests",
+}
+
+// permanentMessages identify errors retrying cannot fix, they take precedence over transientMessages
+var permanentMessages = []string{
+	"field is immutable",
+	"is invalid",
+	"error validating",
+	"validation failed",
+	"parse error",
+	"render error",
+}
+
+// IsTransient reports whether err may succeed when retried
+// Tiller being unavailable or timing out, a dropped port-forward and API throttling or server errors are transient,
+// validation errors, immutable fields and unrecognized errors are permanent
+func IsTransient(err error) bool {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	for _, e := range []error{err, errors.Cause(err)} {
+		if e == nil {
+			continue
+		}
+		if s, ok := status.FromError(e); ok && s.Code() != codes.Unknown && s.Code() != codes.OK {
+			return s.Code() == codes.Unavailable || s.Code() == codes.DeadlineExceeded
+		}
+		if apiStatus, ok := e.(apierrors.APIStatus); ok {
+			code := apiStatus.Status().Code
+			return code == 429 || code >= 500
+		}
+	}
+	msg := err.Error()
+	for _, m := range permanentMessages {
+		if strings.Contains(msg, m) {
+			return false
+		}
+	}
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cirrocloud/structured/errors"
+)
+
+func TestIsTransient(t *testing.T) {
+	Convey("IsTransient", t, func() {
+		Convey("Tiller unavailable or timed out is transient", func() {
+			So(IsTransient(status.Error(codes.Unavailable, "transport is closing")), ShouldBeTrue)
+			So(IsTransient(status.Error(codes.DeadlineExceeded, "timeout")), ShouldBeTrue)
+			So(IsTransient(errors.Wrap(status.Error(codes.Unavailable, "down"), "failed to install")), ShouldBeTrue)
+		})
+		Convey("Other Tiller codes are permanent", func() {
+			So(IsTransient(status.Error(codes.InvalidArgument, "bad chart")), ShouldBeFalse)
+		})
+		Convey("A dropped port-forward is transient", func() {
+			So(IsTransient(errors.New("lost connection to pod")), ShouldBeTrue)
+			So(IsTransient(errors.New("read tcp 127.0.0.1:44134: connection reset by peer")), ShouldBeTrue)
+		})
+		Convey("API throttling and server errors are transient", func() {
+			So(IsTransient(apierrors.NewTooManyRequests("slow down", 1)), ShouldBeTrue)
+			So(IsTransient(apierrors.NewInternalError(errors.New("etcd"))), ShouldBeTrue)
+			So(IsTransient(apierrors.NewServiceUnavailable("down")), ShouldBeTrue)
+		})
+		Convey("Validation errors and immutable fields are permanent", func() {
+			gk := schema.GroupKind{Kind: "Service"}
+			So(IsTransient(apierrors.NewInvalid(gk, "web", nil)), ShouldBeFalse)
+			So(IsTransient(apierrors.NewBadRequest("bad")), ShouldBeFalse)
+			So(IsTransient(errors.New("spec.clusterIP: Invalid value: field is immutable, connection refused")), ShouldBeFalse)
+		})
+		Convey("Unrecognized errors and the operation deadline are permanent", func() {
+			So(IsTransient(errors.New("simulated")), ShouldBeFalse)
+			So(IsTransient(context.DeadlineExceeded), ShouldBeFalse)
+			So(IsTransient(nil), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("RetryPolicy", t, func() {
+		policy := &RetryPolicy{
+			Attempts:        3,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     2 * time.Millisecond,
+		}
+		transient := status.Error(codes.Unavailable, "transport is closing")
+		calls := 0
+
+		Convey("Retries transient errors until success", func() {
+			err := policy.Do(context.Background(), "install", func() error {
+				calls++
+				if calls < 3 {
+					return transient
+				}
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 3)
+		})
+		Convey("Gives up after the attempts", func() {
+			err := policy.Do(context.Background(), "install", func() error {
+				calls++
+				return transient
+			})
+			So(err.Error(), ShouldContainSubstring, "giving up")
+			So(calls, ShouldEqual, 3)
+		})
+		Convey("Does not retry permanent errors", func() {
+			err := policy.Do(context.Background(), "install", func() error {
+				calls++
+				return errors.New("simulated")
+			})
+			So(err.Error(), ShouldEqual, "simulated")
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("Stops once ctx is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			err := policy.Do(ctx, "install", func() error {
+				calls++
+				cancel()
+				return transient
+			})
+			So(err, ShouldEqual, transient)
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("A nil policy calls once", func() {
+			var none *RetryPolicy
+			none.Do(context.Background(), "install", func() error {
+				calls++
+				return transient
+			})
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("Backoff doubles up to the max interval with jitter", func() {
+			p := &RetryPolicy{InitialInterval: time.Second, MaxInterval: 5 * time.Second}
+			So(p.Backoff(1), ShouldEqual, time.Second)
+			So(p.Backoff(2), ShouldEqual, 2*time.Second)
+			So(p.Backoff(3), ShouldEqual, 4*time.Second)
+			So(p.Backoff(4), ShouldEqual, 5*time.Second)
+			p.Jitter = 0.5
+			wait := p.Backoff(2)
+			So(wait, ShouldBeGreaterThan, time.Second)
+			So(wait, ShouldBeLessThanOrEqualTo, 2*time.Second)
+		})
+		Convey("Merge replaces the limits which are set", func() {
+			merged := NewRetryPolicy().Merge(&RetryPolicy{Attempts: 5})
+			So(merged.Attempts, ShouldEqual, 5)
+			So(merged.InitialInterval, ShouldEqual, time.Second)
+			So(NewRetryPolicy().Merge(nil).Attempts, ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,345 @@
+package simkube
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// Clientset returns a clientset serving the ConfigMaps, Secrets, Namespaces and Pods of the cluster
+// with Create, Update, Get, List and Delete. Other resources and methods are not served, calling them panics.
+// Discovery reports ServerVersion and no API groups, so clients such as Tiller use their default API versions.
+func (c *Cluster) Clientset() kubernetes.Interface {
+	return &clientset{core: &coreV1{cluster: c}}
+}
+
+type clientset struct {
+	kubernetes.Interface
+	core *coreV1
+}
+
+func (c *clientset) CoreV1() corev1.CoreV1Interface {
+	return c.core
+}
+
+func (c *clientset) Core() corev1.CoreV1Interface {
+	return c.core
+}
+
+func (c *clientset) Discovery() discovery.DiscoveryInterface {
+	return &discoveryClient{}
+}
+
+// ServerVersion is the Kubernetes version reported by the simulated cluster
+var ServerVersion = version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.0"}
+
+type discoveryClient struct {
+	discovery.DiscoveryInterface
+}
+
+func (d *discoveryClient) ServerVersion() (*version.Info, error) {
+	info := ServerVersion
+	return &info, nil
+}
+
+func (d *discoveryClient) ServerGroups() (*metav1.APIGroupList, error) {
+	return &metav1.APIGroupList{}, nil
+}
+
+type coreV1 struct {
+	corev1.CoreV1Interface
+	cluster *Cluster
+}
+
+func (c *coreV1) ConfigMaps(namespace string) corev1.ConfigMapInterface {
+	return &configMaps{typedResource: c.resource("configmaps", "ConfigMap", namespace)}
+}
+
+func (c *coreV1) Secrets(namespace string) corev1.SecretInterface {
+	return &secrets{typedResource: c.resource("secrets", "Secret", namespace)}
+}
+
+func (c *coreV1) Namespaces() corev1.NamespaceInterface {
+	return &namespaces{typedResource: c.resource("namespaces", "Namespace", "")}
+}
+
+func (c *coreV1) Pods(namespace string) corev1.PodInterface {
+	return &pods{typedResource: c.resource("pods", "Pod", namespace)}
+}
+
+func (c *coreV1) resource(resource, kind, namespace string) *typedResource {
+	return &typedResource{
+		cluster:   c.cluster,
+		gvr:       schema.GroupVersionResource{Version: "v1", Resource: resource},
+		kind:      kind,
+		namespace: namespace,
+	}
+}
+
+// typedResource converts the typed objects of a core resource to and from the objects of the cluster
+type typedResource struct {
+	cluster   *Cluster
+	gvr       schema.GroupVersionResource
+	kind      string
+	namespace string
+}
+
+func (t *typedResource) create(in runtime.Object, out interface{}) error {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(in)
+	if err != nil {
+		return err
+	}
+	obj, err := t.cluster.create(t.gvr, t.namespace, t.withKind(u))
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, out)
+}
+
+func (t *typedResource) update(in runtime.Object, out interface{}) error {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(in)
+	if err != nil {
+		return err
+	}
+	obj, err := t.cluster.update(t.gvr, t.namespace, t.withKind(u))
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, out)
+}
+
+func (t *typedResource) get(name string, out interface{}) error {
+	obj, err := t.cluster.get(t.gvr, t.namespace, name)
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, out)
+}
+
+func (t *typedResource) delete(name string) error {
+	return t.cluster.delete(t.gvr, t.namespace, name)
+}
+
+// list calls add with the content of each object matching opts
+func (t *typedResource) list(opts metav1.ListOptions, add func(map[string]interface{}) error) error {
+	items, err := t.cluster.list(t.gvr, t.namespace, opts)
+	if err != nil {
+		return err
+	}
+	for _, obj := range items {
+		if err := add(obj.Object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withKind sets the type information typed clients leave empty, so the objects can be read by the dynamic client
+func (t *typedResource) withKind(content map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetAPIVersion(t.gvr.GroupVersion().String())
+	obj.SetKind(t.kind)
+	return obj
+}
+
+type configMaps struct {
+	corev1.ConfigMapInterface
+	*typedResource
+}
+
+func (c *configMaps) Create(in *v1.ConfigMap) (*v1.ConfigMap, error) {
+	out := &v1.ConfigMap{}
+	if err := c.create(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *configMaps) Update(in *v1.ConfigMap) (*v1.ConfigMap, error) {
+	out := &v1.ConfigMap{}
+	if err := c.update(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *configMaps) Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error) {
+	out := &v1.ConfigMap{}
+	if err := c.get(name, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *configMaps) List(opts metav1.ListOptions) (*v1.ConfigMapList, error) {
+	list := &v1.ConfigMapList{}
+	err := c.list(opts, func(content map[string]interface{}) error {
+		item := v1.ConfigMap{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &item); err != nil {
+			return err
+		}
+		list.Items = append(list.Items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (c *configMaps) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.delete(name)
+}
+
+type secrets struct {
+	corev1.SecretInterface
+	*typedResource
+}
+
+func (s *secrets) Create(in *v1.Secret) (*v1.Secret, error) {
+	out := &v1.Secret{}
+	if err := s.create(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *secrets) Update(in *v1.Secret) (*v1.Secret, error) {
+	out := &v1.Secret{}
+	if err := s.update(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *secrets) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
+	out := &v1.Secret{}
+	if err := s.get(name, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *secrets) List(opts metav1.ListOptions) (*v1.SecretList, error) {
+	list := &v1.SecretList{}
+	err := s.list(opts, func(content map[string]interface{}) error {
+		item := v1.Secret{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &item); err != nil {
+			return err
+		}
+		list.Items = append(list.Items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *secrets) Delete(name string, options *metav1.DeleteOptions) error {
+	return s.delete(name)
+}
+
+type namespaces struct {
+	corev1.NamespaceInterface
+	*typedResource
+}
+
+func (n *namespaces) Create(in *v1.Namespace) (*v1.Namespace, error) {
+	out := &v1.Namespace{}
+	if err := n.create(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (n *namespaces) Update(in *v1.Namespace) (*v1.Namespace, error) {
+	out := &v1.Namespace{}
+	if err := n.update(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (n *namespaces) Get(name string, options metav1.GetOptions) (*v1.Namespace, error) {
+	out := &v1.Namespace{}
+	if err := n.get(name, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (n *namespaces) List(opts metav1.ListOptions) (*v1.NamespaceList, error) {
+	list := &v1.NamespaceList{}
+	err := n.list(opts, func(content map[string]interface{}) error {
+		item := v1.Namespace{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &item); err != nil {
+			return err
+		}
+		list.Items = append(list.Items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (n *namespaces) Delete(name string, options *metav1.DeleteOptions) error {
+	return n.delete(name)
+}
+
+type pods struct {
+	corev1.PodInterface
+	*typedResource
+}
+
+func (p *pods) Create(in *v1.Pod) (*v1.Pod, error) {
+	out := &v1.Pod{}
+	if err := p.create(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *pods) Update(in *v1.Pod) (*v1.Pod, error) {
+	out := &v1.Pod{}
+	if err := p.update(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *pods) Get(name string, options metav1.GetOptions) (*v1.Pod, error) {
+	out := &v1.Pod{}
+	if err := p.get(name, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *pods) List(opts metav1.ListOptions) (*v1.PodList, error) {
+	list := &v1.PodList{}
+	err := p.list(opts, func(content map[string]interface{}) error {
+		item := v1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &item); err != nil {
+			return err
+		}
+		list.Items = append(list.Items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *pods) Delete(name string, options *metav1.DeleteOptions) error {
+	return p.delete(name)
+}
@@ -0,0 +1,268 @@
+// Package simkube is an in-memory Kubernetes API for running Barrelman without a cluster
+//
+// Objects are kept as unstructured objects keyed by resource, namespace and name. They are served
+// by a dynamic client and by a clientset limited to the core resources Barrelman uses, see Clientset.
+package simkube
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Cluster holds the objects of a simulated cluster
+type Cluster struct {
+	mu      sync.Mutex
+	objects map[string]*unstructured.Unstructured
+	version int64
+}
+
+// New returns an empty *Cluster
+func New() *Cluster {
+	return &Cluster{objects: make(map[string]*unstructured.Unstructured)}
+}
+
+// Add stores typed or unstructured objects, the resource of each is guessed from its kind
+func (c *Cluster) Add(objects ...runtime.Object) error {
+	for _, obj := range objects {
+		u, gvk, err := toUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+		if _, err := c.create(gvr, u.GetNamespace(), u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dynamic returns a dynamic client serving the objects of the cluster
+func (c *Cluster) Dynamic() dynamic.Interface {
+	return &dynamicClient{cluster: c}
+}
+
+func key(gvr schema.GroupVersionResource, namespace, name string) string {
+	return gvr.Group + "/" + gvr.Resource + "/" + namespace + "/" + name
+}
+
+func (c *Cluster) create(gvr schema.GroupVersionResource, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if obj.GetName() == "" {
+		return nil, apierrors.NewBadRequest("name is required")
+	}
+	k := key(gvr, namespace, obj.GetName())
+	if _, ok := c.objects[k]; ok {
+		return nil, apierrors.NewAlreadyExists(gvr.GroupResource(), obj.GetName())
+	}
+	stored := obj.DeepCopy()
+	stored.SetNamespace(namespace)
+	if stored.GetUID() == "" {
+		stored.SetUID(types.UID(fmt.Sprintf("sim-%d", c.version+1)))
+	}
+	stored.SetCreationTimestamp(metav1.Now())
+	c.store(k, stored)
+	return stored.DeepCopy(), nil
+}
+
+// update replaces a stored object, a set resource version must match the stored one
+func (c *Cluster) update(gvr schema.GroupVersionResource, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(gvr, namespace, obj.GetName())
+	current, ok := c.objects[k]
+	if !ok {
+		return nil, apierrors.NewNotFound(gvr.GroupResource(), obj.GetName())
+	}
+	if v := obj.GetResourceVersion(); v != "" && v != current.GetResourceVersion() {
+		return nil, apierrors.NewConflict(gvr.GroupResource(), obj.GetName(),
+			fmt.Errorf("resource version %s does not match %s", v, current.GetResourceVersion()))
+	}
+	stored := obj.DeepCopy()
+	stored.SetNamespace(namespace)
+	stored.SetUID(current.GetUID())
+	stored.SetCreationTimestamp(current.GetCreationTimestamp())
+	c.store(k, stored)
+	return stored.DeepCopy(), nil
+}
+
+func (c *Cluster) store(k string, obj *unstructured.Unstructured) {
+	c.version++
+	obj.SetResourceVersion(strconv.FormatInt(c.version, 10))
+	c.objects[k] = obj
+}
+
+func (c *Cluster) get(gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[key(gvr, namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(gvr.GroupResource(), name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (c *Cluster) delete(gvr schema.GroupVersionResource, namespace, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(gvr, namespace, name)
+	if _, ok := c.objects[k]; !ok {
+		return apierrors.NewNotFound(gvr.GroupResource(), name)
+	}
+	delete(c.objects, k)
+	return nil
+}
+
+// list returns the objects of a resource matching the label selector of opts ordered by key
+// an empty namespace lists every namespace
+func (c *Cluster) list(gvr schema.GroupVersionResource, namespace string, opts metav1.ListOptions) ([]*unstructured.Unstructured, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := []string{}
+	for k, obj := range c.objects {
+		if k != key(gvr, obj.GetNamespace(), obj.GetName()) {
+			continue
+		}
+		if namespace != "" && obj.GetNamespace() != namespace {
+			continue
+		}
+		if !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := []*unstructured.Unstructured{}
+	for _, k := range keys {
+		items = append(items, c.objects[k].DeepCopy())
+	}
+	return items, nil
+}
+
+// toUnstructured converts obj, the kind of typed objects without type information is looked up in the client-go scheme
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, schema.GroupVersionKind, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Empty() {
+		kinds, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			return nil, gvk, err
+		}
+		gvk = kinds[0]
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u, gvk, nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, gvk, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return u, gvk, nil
+}
+
+type dynamicClient struct {
+	cluster *Cluster
+}
+
+func (d *dynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &resourceClient{cluster: d.cluster, gvr: gvr}
+}
+
+// resourceClient serves a resource of the cluster, subresources are not supported
+type resourceClient struct {
+	cluster   *Cluster
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+func (r *resourceClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return &resourceClient{cluster: r.cluster, gvr: r.gvr, namespace: namespace}
+}
+
+func (r *resourceClient) Create(obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) > 0 {
+		return nil, r.notSupported("create " + subresources[0])
+	}
+	return r.cluster.create(r.gvr, r.namespace, obj)
+}
+
+func (r *resourceClient) Update(obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) > 0 {
+		return nil, r.notSupported("update " + subresources[0])
+	}
+	return r.cluster.update(r.gvr, r.namespace, obj)
+}
+
+func (r *resourceClient) UpdateStatus(obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return r.cluster.update(r.gvr, r.namespace, obj)
+}
+
+func (r *resourceClient) Delete(name string, options *metav1.DeleteOptions, subresources ...string) error {
+	if len(subresources) > 0 {
+		return r.notSupported("delete " + subresources[0])
+	}
+	return r.cluster.delete(r.gvr, r.namespace, name)
+}
+
+func (r *resourceClient) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	items, err := r.cluster.list(r.gvr, r.namespace, listOptions)
+	if err != nil {
+		return err
+	}
+	for _, obj := range items {
+		if err := r.cluster.delete(r.gvr, obj.GetNamespace(), obj.GetName()); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resourceClient) Get(name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) > 0 {
+		return nil, r.notSupported("get " + subresources[0])
+	}
+	return r.cluster.get(r.gvr, r.namespace, name)
+}
+
+func (r *resourceClient) List(opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	items, err := r.cluster.list(r.gvr, r.namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+	for _, obj := range items {
+		list.Items = append(list.Items, *obj)
+	}
+	return list, nil
+}
+
+func (r *resourceClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return nil, r.notSupported("watch")
+}
+
+func (r *resourceClient) Patch(name string, pt types.PatchType, data []byte, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, r.notSupported("patch")
+}
+
+func (r *resourceClient) notSupported(action string) error {
+	return apierrors.NewMethodNotSupported(r.gvr.GroupResource(), action)
+}
@@ -0,0 +1,81 @@
+package simkube
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCluster(t *testing.T) {
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	Convey("Cluster", t, func() {
+		sim := New()
+		So(sim.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "1234"}}), ShouldBeNil)
+		client := sim.Clientset().CoreV1()
+
+		Convey("Keeps the UID of added objects", func() {
+			ns, err := client.Namespaces().Get("kube-system", metav1.GetOptions{})
+			So(err, ShouldBeNil)
+			So(string(ns.UID), ShouldEqual, "1234")
+		})
+
+		Convey("Serves typed objects to the dynamic client", func() {
+			_, err := client.ConfigMaps("apps").Create(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Labels: map[string]string{"app": "web"}},
+				Data:       map[string]string{"setting": "one"},
+			})
+			So(err, ShouldBeNil)
+			obj, err := sim.Dynamic().Resource(configMaps).Namespace("apps").Get("settings", metav1.GetOptions{})
+			So(err, ShouldBeNil)
+			So(obj.GetKind(), ShouldEqual, "ConfigMap")
+			So(obj.Object["data"], ShouldResemble, map[string]interface{}{"setting": "one"})
+
+			_, err = client.ConfigMaps("apps").Create(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings"}})
+			So(apierrors.IsAlreadyExists(err), ShouldBeTrue)
+		})
+
+		Convey("Lists by namespace and label selector", func() {
+			for _, ns := range []string{"apps", "web"} {
+				_, err := client.Secrets(ns).Create(&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "creds", Labels: map[string]string{"owner": "helm"}},
+					Data:       map[string][]byte{"password": []byte("secret")},
+				})
+				So(err, ShouldBeNil)
+			}
+			list, err := client.Secrets("").List(metav1.ListOptions{LabelSelector: "owner=helm"})
+			So(err, ShouldBeNil)
+			So(list.Items, ShouldHaveLength, 2)
+			So(string(list.Items[0].Data["password"]), ShouldEqual, "secret")
+			list, err = client.Secrets("web").List(metav1.ListOptions{})
+			So(err, ShouldBeNil)
+			So(list.Items, ShouldHaveLength, 1)
+			list, err = client.Secrets("").List(metav1.ListOptions{LabelSelector: "owner=barrelman"})
+			So(err, ShouldBeNil)
+			So(list.Items, ShouldBeEmpty)
+		})
+
+		Convey("Rejects updates of stale objects", func() {
+			cm, err := client.ConfigMaps("apps").Create(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "lock"}})
+			So(err, ShouldBeNil)
+			cm.Data = map[string]string{"id": "one"}
+			_, err = client.ConfigMaps("apps").Update(cm)
+			So(err, ShouldBeNil)
+			_, err = client.ConfigMaps("apps").Update(cm)
+			So(apierrors.IsConflict(err), ShouldBeTrue)
+		})
+
+		Convey("Deletes objects", func() {
+			So(apierrors.IsNotFound(client.ConfigMaps("apps").Delete("missing", &metav1.DeleteOptions{})), ShouldBeTrue)
+			_, err := client.ConfigMaps("apps").Create(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "gone"}})
+			So(err, ShouldBeNil)
+			So(sim.Dynamic().Resource(configMaps).Namespace("apps").Delete("gone", &metav1.DeleteOptions{}), ShouldBeNil)
+			_, err = client.ConfigMaps("apps").Get("gone", metav1.GetOptions{})
+			So(apierrors.IsNotFound(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,293 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/charter-oss/barrelman/pkg/cluster/driver"
+	"github.com/charter-oss/barrelman/pkg/cluster/simkube"
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+var _ Sessioner = (*SimSession)(nil)
+
+// clusterScopedKinds are the kinds the simulated cluster does not place in a namespace
+var clusterScopedKinds = map[string]bool{
+	"APIService":                     true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"MutatingWebhookConfiguration":   true,
+	"Namespace":                      true,
+	"PersistentVolume":               true,
+	"PodSecurityPolicy":              true,
+	"PriorityClass":                  true,
+	"StorageClass":                   true,
+	"ValidatingWebhookConfiguration": true,
+}
+
+// SimState is the persisted state of a simulated cluster
+type SimState struct {
+	// Releases holds every stored revision of every release
+	Releases []*driver.Helm3Release `json:"releases"`
+	// Versions holds the Barrelman versions of each manifest
+	Versions []*release.Release `json:"versions"`
+}
+
+// SimSession is an in-memory Sessioner for running Barrelman without a cluster
+// Charts are rendered and releases are stored as with the Helm 3 backend, objects are kept in memory by simkube
+// When StateFile is set the state is loaded by Init and written after each change
+type SimSession struct {
+	*Helm3Session
+	StateFile string
+}
+
+// NewSimSession returns a *SimSession persisting its state to stateFile, an empty stateFile is not persisted
+func NewSimSession(stateFile string) *SimSession {
+	return &SimSession{
+		Helm3Session: &Helm3Session{VersionsNamespace: DefaultVersionsNamespace},
+		StateFile:    stateFile,
+	}
+}
+
+// Init creates the in-memory cluster and loads the state file when it exists
+func (s *SimSession) Init() error {
+	sim := simkube.New()
+	s.Clientset = sim.Clientset()
+	s.Objects = NewSimObjectClient(sim)
+	s.Storage = nil
+	if err := s.Helm3Session.Init(); err != nil {
+		return err
+	}
+	if s.StateFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.StateFile)
+	if os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"StateFile": s.StateFile,
+		}).Info("Starting simulation with an empty cluster")
+		return nil
+	}
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"StateFile": s.StateFile,
+		}).Wrap(err, "failed to read simulation state")
+	}
+	state := &SimState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return errors.WithFields(errors.Fields{
+			"StateFile": s.StateFile,
+		}).Wrap(err, "failed to parse simulation state")
+	}
+	return s.Load(state)
+}
+
+func (s *SimSession) ShortReport() map[string]interface{} {
+	return map[string]interface{}{
+		"StateFile": s.StateFile,
+	}
+}
+
+func (s *SimSession) DetailedReport() map[string]interface{} {
+	return map[string]interface{}{
+		"StateFile":         s.StateFile,
+		"VersionsNamespace": s.VersionsNamespace,
+	}
+}
+
+// Load replaces the simulated cluster with state
+// the objects of each deployed release are recreated from its manifest
+func (s *SimSession) Load(state *SimState) error {
+	for _, rls := range state.Releases {
+		rls.Labels = map[string]string{}
+		if manifestName := getChartManifestTag(Helm3ChartToChart(rls.Chart)); manifestName != "" {
+			rls.Labels[manifestLabel] = manifestName
+		}
+		if err := s.Storage.Create(rls); err != nil {
+			return errors.WithFields(errors.Fields{
+				"ReleaseName": rls.Name,
+				"Revision":    rls.Version,
+			}).Wrap(err, "failed to load simulated release")
+		}
+		if rls.Info != nil && rls.Info.Status == driver.Helm3StatusDeployed {
+			if err := s.applyManifest(context.Background(), rls.Manifest, "", rls.Namespace); err != nil {
+				return errors.WithFields(errors.Fields{
+					"ReleaseName": rls.Name,
+				}).Wrap(err, "failed to load simulated release objects")
+			}
+		}
+	}
+	store := s.NewVersionDriver()
+	for _, v := range state.Versions {
+		if err := store.Create(fmt.Sprintf("%s.v%d.%s", v.Name, v.Version, NewSHA1Hash()), v); err != nil {
+			return errors.WithFields(errors.Fields{
+				"ManifestName": v.Name,
+				"Version":      v.Version,
+			}).Wrap(err, "failed to load simulated versions")
+		}
+	}
+	return nil
+}
+
+// State returns the current state of the simulated cluster
+func (s *SimSession) State() (*SimState, error) {
+	releases, err := s.Storage.Query("", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list simulated releases")
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Name != releases[j].Name {
+			return releases[i].Name < releases[j].Name
+		}
+		return releases[i].Version < releases[j].Version
+	})
+	versions, err := s.NewVersionDriver().List(getNoopManifestFilter())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list simulated versions")
+	}
+	// the release sources kept apart are saved within their versions, which hold them once loaded
+	sources := s.releaseSources()
+	for _, v := range versions {
+		if err := moveReleaseSources(sources, nil, v); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Name != versions[j].Name {
+			return versions[i].Name < versions[j].Name
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return &SimState{
+		Releases: releases,
+		Versions: versions,
+	}, nil
+}
+
+// Save writes the state of the simulated cluster to StateFile
+func (s *SimSession) Save() error {
+	if s.StateFile == "" {
+		return nil
+	}
+	state, err := s.State()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode simulation state")
+	}
+	if err := ioutil.WriteFile(s.StateFile, data, 0644); err != nil {
+		return errors.WithFields(errors.Fields{
+			"StateFile": s.StateFile,
+		}).Wrap(err, "failed to write simulation state")
+	}
+	return nil
+}
+
+// InstallRelease installs a release into the simulated cluster
+func (s *SimSession) InstallRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error) {
+	res, err := s.Helm3Session.InstallRelease(ctx, m, manifestName)
+	return res, s.save(err)
+}
+
+// UpgradeRelease upgrades a release in the simulated cluster
+func (s *SimSession) UpgradeRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*UpgradeReleaseResponse, error) {
+	res, err := s.Helm3Session.UpgradeRelease(ctx, m, manifestName)
+	return res, s.save(err)
+}
+
+// DeleteReleases calls DeleteRelease on an array of Releases
+func (s *SimSession) DeleteReleases(ctx context.Context, dm []*DeleteMeta) error {
+	for _, v := range dm {
+		if err := s.DeleteRelease(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRelease deletes a release from the simulated cluster
+func (s *SimSession) DeleteRelease(ctx context.Context, m *DeleteMeta) error {
+	return s.save(s.Helm3Session.DeleteRelease(ctx, m))
+}
+
+// RollbackRelease rolls back a release in the simulated cluster
+func (s *SimSession) RollbackRelease(ctx context.Context, m *RollbackMeta) (int32, error) {
+	version, err := s.Helm3Session.RollbackRelease(ctx, m)
+	return version, s.save(err)
+}
+
+func (s *SimSession) WriteVersions(versions *Versions) error {
+	return s.save(s.Helm3Session.WriteVersions(versions))
+}
+
+// PruneVersions removes old manifest versions from the simulated cluster
+func (s *SimSession) PruneVersions(manifestName string, keep int, dryRun bool) ([]*Version, error) {
+	pruned, err := s.Helm3Session.PruneVersions(manifestName, keep, dryRun)
+	return pruned, s.save(err)
+}
+
+// MigrateVersions moves simulated version ConfigMaps into the versions storage of the session
+func (s *SimSession) MigrateVersions(opts *VersionMigrateOptions) ([]*VersionMigrateResult, error) {
+	results, err := s.Helm3Session.MigrateVersions(opts)
+	return results, s.save(err)
+}
+
+// NewTransaction initializes a transaction for manifestName
+func (s *SimSession) NewTransaction(manifestName string) (Transactioner, error) {
+	return newTransaction(s, manifestName)
+}
+
+// save persists the state after an operation, failed operations may have changed state and are saved too
+func (s *SimSession) save(opErr error) error {
+	if err := s.Save(); err != nil {
+		if opErr != nil {
+			log.WithFields(log.Fields{
+				"Error": err.Error(),
+			}).Warn("failed to save simulation state")
+			return opErr
+		}
+		return err
+	}
+	return opErr
+}
+
+// NewSimObjectClient returns an *ObjectClient for the objects of sim, kinds are mapped to resources by guessing their plural name
+func NewSimObjectClient(sim *simkube.Cluster) *ObjectClient {
+	return &ObjectClient{
+		Dynamic: sim.Dynamic(),
+		Mapper:  &simMapper{meta.NewDefaultRESTMapper([]schema.GroupVersion{})},
+	}
+}
+
+// simMapper maps any kind to a resource by guessing its plural name
+type simMapper struct {
+	*meta.DefaultRESTMapper
+}
+
+func (m *simMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	if mapping, err := m.DefaultRESTMapper.RESTMapping(gk, versions...); err == nil {
+		return mapping, nil
+	}
+	version := ""
+	if len(versions) > 0 {
+		version = versions[0]
+	}
+	scope := meta.RESTScopeNamespace
+	if clusterScopedKinds[gk.Kind] {
+		scope = meta.RESTScopeRoot
+	}
+	m.Add(gk.WithVersion(version), scope)
+	return m.DefaultRESTMapper.RESTMapping(gk, versions...)
+}
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestSimSession(t *testing.T) {
+	Convey("SimSession", t, func() {
+		dir, err := ioutil.TempDir(".", "sim-state")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		stateFile := filepath.Join(dir, "state.json")
+
+		s := NewSimSession(stateFile)
+		So(s.Init(), ShouldBeNil)
+		ch := newTestHelm3Chart()
+		ch.Templates = append(ch.Templates, &chart.Template{
+			Name: "templates/namespace.yaml",
+			Data: []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: {{ .Release.Name }}-ns\n"),
+		})
+		meta := &ReleaseMeta{
+			Chart:       ch,
+			ReleaseName: "app",
+			Namespace:   "apps",
+		}
+
+		Convey("An empty state file starts an empty cluster", func() {
+			list, err := s.ListReleases(context.Background())
+			So(err, ShouldBeNil)
+			So(list, ShouldBeEmpty)
+			_, err = os.Stat(stateFile)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Changes are persisted between sessions", func() {
+			_, err := s.InstallRelease(context.Background(), meta, "test-manifest")
+			So(err, ShouldBeNil)
+			transaction, err := s.NewTransaction("test-manifest")
+			So(err, ShouldBeNil)
+			transaction.Versions().AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: 1})
+			transaction.SetChanged()
+			So(transaction.Complete(), ShouldBeNil)
+
+			meta.ValueOverrides = []byte("setting: two\n")
+			up, err := s.UpgradeRelease(context.Background(), meta, "test-manifest")
+			So(err, ShouldBeNil)
+			So(up.ReleaseVersion, ShouldEqual, 2)
+
+			next := NewSimSession(stateFile)
+			So(next.Init(), ShouldBeNil)
+			owned, err := next.ReleasesByManifest(context.Background(), "test-manifest")
+			So(err, ShouldBeNil)
+			So(owned[ReleaseKey("apps", "app")].Revision, ShouldEqual, 2)
+			versions, err := next.GetVersions("test-manifest")
+			So(err, ShouldBeNil)
+			So(versions.Data, ShouldHaveLength, 1)
+
+			drift, err := next.DetectDrift(context.Background(), &ReleaseMeta{ReleaseName: "app"})
+			So(err, ShouldBeNil)
+			So(drift.Drifted(), ShouldBeFalse)
+
+			version, err := next.RollbackRelease(context.Background(), &RollbackMeta{ReleaseName: "app", Revision: 1})
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 3)
+
+			last := NewSimSession(stateFile)
+			So(last.Init(), ShouldBeNil)
+			rel, err := last.GetRelease(context.Background(), "app", 0)
+			So(err, ShouldBeNil)
+			So(rel.Revision, ShouldEqual, 3)
+			So(rel.Config.Raw, ShouldNotContainSubstring, "two")
+		})
+
+		Convey("A corrupt state file is an error", func() {
+			So(ioutil.WriteFile(stateFile, []byte("{"), 0644), ShouldBeNil)
+			So(NewSimSession(stateFile).Init(), ShouldNotBeNil)
+		})
+	})
+}
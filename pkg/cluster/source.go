@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/ptypes/any"
+)
+
+// The source of a manifest version is stored as files next to the charts of its releases
+const (
+	sourceManifestPath = "source/manifest.yaml.gz"
+	sourceOriginPath   = "source/origin.yaml"
+)
+
+// VersionSource records what produced a manifest version
+type VersionSource struct {
+	BarrelmanVersion string
+	BarrelmanCommit  string `json:",omitempty"`
+	User             string
+	Host             string
+	Charts           []*ChartSource `json:",omitempty"`
+	// Manifest is the manifest YAML as applied, it is stored compressed beside the other fields
+	Manifest []byte `json:"-"`
+}
+
+// ChartSource is the source a chart was archived from, resolved to the commit or chart version used
+type ChartSource struct {
+	Name         string
+	Type         string
+	Location     string
+	SubPath      string `json:",omitempty"`
+	Reference    string `json:",omitempty"`
+	Commit       string `json:",omitempty"`
+	ChartVersion string `json:",omitempty"`
+}
+
+// NewVersionSource returns the source of a version written by this process
+// the invoking user and host are recorded, "unknown" when they cannot be found
+func NewVersionSource(barrelmanVersion, barrelmanCommit string) *VersionSource {
+	source := &VersionSource{
+		BarrelmanVersion: barrelmanVersion,
+		BarrelmanCommit:  barrelmanCommit,
+		User:             "unknown",
+		Host:             "unknown",
+	}
+	if usr, err := user.Current(); err == nil {
+		source.User = usr.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		source.Host = host
+	}
+	return source
+}
+
+// Chart returns the source of the chart named name, nil when none was recorded
+func (source *VersionSource) Chart(name string) *ChartSource {
+	for _, c := range source.Charts {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Files returns the source as files of a manifest version
+func (source *VersionSource) Files() ([]*any.Any, error) {
+	origin, err := yaml.Marshal(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal version source")
+	}
+	files := []*any.Any{{TypeUrl: sourceOriginPath, Value: origin}}
+	if len(source.Manifest) > 0 {
+		buf := &bytes.Buffer{}
+		gzw := gzip.NewWriter(buf)
+		if _, err := gzw.Write(source.Manifest); err != nil {
+			return nil, errors.Wrap(err, "failed to compress source manifest")
+		}
+		if err := gzw.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to compress source manifest")
+		}
+		files = append(files, &any.Any{TypeUrl: sourceManifestPath, Value: buf.Bytes()})
+	}
+	return files, nil
+}
+
+// Source returns the source recorded with the manifest version
+// it is nil for versions written before sources were recorded
+func (version *Version) Source() (*VersionSource, error) {
+	if version.Chart == nil {
+		return nil, nil
+	}
+	var source *VersionSource
+	var manifest []byte
+	for _, f := range version.Chart.Files {
+		switch f.TypeUrl {
+		case sourceOriginPath:
+			source = &VersionSource{}
+			if err := yaml.Unmarshal(f.Value, source); err != nil {
+				return nil, errors.WithFields(errors.Fields{
+					"Name":     version.Name,
+					"Revision": version.Revision,
+				}).Wrap(err, "failed to read the source of version")
+			}
+		case sourceManifestPath:
+			gzr, err := gzip.NewReader(bytes.NewReader(f.Value))
+			if err != nil {
+				return nil, errors.WithFields(errors.Fields{
+					"Name":     version.Name,
+					"Revision": version.Revision,
+				}).Wrap(err, "failed to decompress the manifest of version")
+			}
+			manifest, err = ioutil.ReadAll(gzr)
+			if err != nil {
+				return nil, errors.WithFields(errors.Fields{
+					"Name":     version.Name,
+					"Revision": version.Revision,
+				}).Wrap(err, "failed to decompress the manifest of version")
+			}
+		}
+	}
+	if source == nil {
+		return nil, nil
+	}
+	source.Manifest = manifest
+	return source, nil
+}
+
+// String describes where the chart came from, with the commit or chart version it resolved to
+func (cs *ChartSource) String() string {
+	resolved := cs.Commit
+	if resolved == "" {
+		resolved = cs.ChartVersion
+	}
+	location := cs.Location
+	if cs.SubPath != "" {
+		location += "/" + cs.SubPath
+	}
+	if resolved == "" {
+		return fmt.Sprintf("%s %s", cs.Type, location)
+	}
+	return fmt.Sprintf("%s %s@%s", cs.Type, location, resolved)
+}
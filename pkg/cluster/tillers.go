@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"sort"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// Tiller pools
+// A manifest may spread its releases across the Tillers of several tenant namespaces.
+// The Session connects to the Tiller of its own namespace, the primary Tiller, and keeps a pool of
+// connections to the other Tillers the manifest touches, keyed by their namespace.
+// Barrelman versions, locks and journals stay in the primary Tiller namespace,
+// each release recorded in a version carries the namespace of its Tiller.
+
+// TillerPooler connects a session to the Tillers of other namespaces
+type TillerPooler interface {
+	UseTillers(namespaces []string) error
+}
+
+// UseTillers connects to the Tiller of each namespace, the namespace of the primary Tiller is ignored
+func (s *Session) UseTillers(namespaces []string) error {
+	for _, ns := range namespaces {
+		if _, err := s.tillerFor(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseTillers has nothing to connect to without Tiller, releases are stored in their own namespace
+func (s *Helm3Session) UseTillers(namespaces []string) error {
+	return nil
+}
+
+// primaryNamespace returns the namespace of the Tiller the session connected to in Init
+func (s *Session) primaryNamespace() string {
+	if s.Tunnel != nil && s.Tunnel.Namespace != "" {
+		return s.Tunnel.Namespace
+	}
+	return s.tillerNamespace()
+}
+
+// tillerFor returns the session connected to the Tiller of namespace, connecting on first use
+// an empty namespace is the primary Tiller
+func (s *Session) tillerFor(namespace string) (*Session, error) {
+	if namespace == "" || namespace == s.primaryNamespace() {
+		return s, nil
+	}
+	s.tillerMu.Lock()
+	defer s.tillerMu.Unlock()
+	if t, ok := s.tillers[namespace]; ok {
+		return t, nil
+	}
+	if s.TillerHost != "" || s.restConfig == nil {
+		return nil, errors.WithFields(errors.Fields{
+			"TillerNamespace": namespace,
+			"Host":            s.TillerHost,
+		}).New("a Tiller namespace can only be reached by port forwarding, not with a direct Tiller host")
+	}
+	t := s.newPooledTiller(s.restConfig, namespace)
+	if err := t.dialTiller(); err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"TillerNamespace": namespace,
+		}).Wrap(err, "failed to connect to Tiller")
+	}
+	if err := t.connectionHealthCheck(); err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"TillerNamespace": namespace,
+		}).Wrap(err, "Tiller connection failed health check")
+	}
+	if s.tillers == nil {
+		s.tillers = make(map[string]*Session)
+	}
+	s.tillers[namespace] = t
+	log.WithFields(log.Fields{
+		"TillerNamespace": namespace,
+		"Pod":             t.Tunnel.PodName,
+	}).Debug("Added Tiller to pool")
+	return t, nil
+}
+
+// newPooledTiller returns a session for the Tiller of namespace sharing the clients and settings of s
+func (s *Session) newPooledTiller(config *rest.Config, namespace string) *Session {
+	t := &Session{
+		Clientset:               s.Clientset,
+		Objects:                 s.Objects,
+		TillerNamespace:         namespace,
+		TillerSelector:          s.TillerSelector,
+		TillerConnectionTimeout: s.TillerConnectionTimeout,
+		HelmHome:                s.HelmHome,
+		kubeConfig:              s.kubeConfig,
+		kubeContext:             s.kubeContext,
+		settings:                s.settings,
+		restConfig:              config,
+	}
+	t.dialTiller = func() error {
+		return t.forwardTiller(config, namespace)
+	}
+	return t
+}
+
+// tillerOf returns the session connected to the Tiller of a release
+// an explicit namespace wins, otherwise the Tiller the release was last listed from is used
+func (s *Session) tillerOf(releaseName, namespace string) (*Session, error) {
+	if namespace == "" {
+		namespace = s.releaseTiller(releaseName)
+	}
+	return s.tillerFor(namespace)
+}
+
+// pool returns the primary Tiller followed by the pooled Tillers ordered by namespace
+func (s *Session) pool() []*Session {
+	s.tillerMu.Lock()
+	defer s.tillerMu.Unlock()
+	namespaces := []string{}
+	for ns := range s.tillers {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	pool := []*Session{s}
+	for _, ns := range namespaces {
+		pool = append(pool, s.tillers[ns])
+	}
+	return pool
+}
+
+// pooledNamespace returns the Tiller namespace recorded for releases of t, empty for the primary Tiller
+func (s *Session) pooledNamespace(t *Session) string {
+	if t == s {
+		return ""
+	}
+	return t.TillerNamespace
+}
+
+// rememberTiller records the Tiller namespace a release was listed from
+func (s *Session) rememberTiller(releaseName, namespace string) {
+	s.tillerMu.Lock()
+	defer s.tillerMu.Unlock()
+	if namespace == "" {
+		delete(s.releaseTillers, releaseName)
+		return
+	}
+	if s.releaseTillers == nil {
+		s.releaseTillers = make(map[string]string)
+	}
+	s.releaseTillers[releaseName] = namespace
+}
+
+// releaseTiller returns the Tiller namespace a release was listed from, empty for the primary Tiller
+func (s *Session) releaseTiller(releaseName string) string {
+	s.tillerMu.Lock()
+	defer s.tillerMu.Unlock()
+	return s.releaseTillers[releaseName]
+}
+
+// useRecordedTillers connects to the Tillers recorded in the latest version of the manifest
+// only a port forwarding session can reach other Tillers, others keep the primary Tiller alone
+func (s *Session) useRecordedTillers(manifestName string) error {
+	if s.restConfig == nil || s.TillerHost != "" {
+		return nil
+	}
+	versions, err := s.GetVersions(manifestName)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the Tillers recorded for the manifest")
+	}
+	namespaces, err := versions.TillerNamespaces()
+	if err != nil {
+		return err
+	}
+	return s.UseTillers(namespaces)
+}
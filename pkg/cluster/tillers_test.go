@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	rls "k8s.io/helm/pkg/proto/hapi/services"
+
+	mockHelm "github.com/charter-oss/barrelman/pkg/cluster/mockHelm"
+)
+
+func newPoolRelease(name, manifestName string) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: "apps",
+		Version:   1,
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: name, Tags: "Manifest=" + manifestName}},
+		Info:      &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}},
+		Config:    &chart.Config{},
+	}
+}
+
+func TestTillerPool(t *testing.T) {
+	Convey("Tiller pool", t, func() {
+		primary := &mockHelm.Interface{}
+		tenant := &mockHelm.Interface{}
+		s := &Session{
+			Helm:   primary,
+			Tunnel: &kube.Tunnel{Namespace: "kube-system"},
+		}
+		s.tillers = map[string]*Session{
+			"tenant-a": {
+				Helm:            tenant,
+				Tunnel:          &kube.Tunnel{Namespace: "tenant-a"},
+				TillerNamespace: "tenant-a",
+			},
+		}
+		primary.On("ListReleases", mock.Anything).Return(&rls.ListReleasesResponse{
+			Releases: []*release.Release{newPoolRelease("web", "shop"), newPoolRelease("other", "blog")},
+		}, nil)
+		tenant.On("ListReleases", mock.Anything).Return(&rls.ListReleasesResponse{
+			Releases: []*release.Release{newPoolRelease("db", "shop")},
+		}, nil)
+
+		Convey("Lists the releases of the manifest across every Tiller", func() {
+			releases, err := s.ReleasesByManifest(context.Background(), "shop")
+			So(err, ShouldBeNil)
+			So(releases, ShouldHaveLength, 2)
+			So(releases[ReleaseKey("apps", "web")].TillerNamespace, ShouldEqual, "")
+			So(releases[ReleaseKey("apps", "db")].TillerNamespace, ShouldEqual, "tenant-a")
+		})
+		Convey("Routes calls to the Tiller a release was listed from", func() {
+			_, err := s.ListReleases(context.Background())
+			So(err, ShouldBeNil)
+			tenant.On("DeleteRelease", "db", mock.Anything, mock.Anything).Return(&rls.UninstallReleaseResponse{}, nil).Once()
+			So(s.DeleteRelease(context.Background(), &DeleteMeta{ReleaseName: "db"}), ShouldBeNil)
+			tenant.AssertExpectations(t)
+			primary.AssertNotCalled(t, "DeleteRelease", "db", mock.Anything, mock.Anything)
+		})
+		Convey("An explicit Tiller namespace wins", func() {
+			tenant.On("RollbackRelease", "web", mock.Anything, mock.Anything).Return(&rls.RollbackReleaseResponse{
+				Release: &release.Release{Version: 3},
+			}, nil).Once()
+			revision, err := s.RollbackRelease(context.Background(), &RollbackMeta{ReleaseName: "web", TillerNamespace: "tenant-a"})
+			So(err, ShouldBeNil)
+			So(revision, ShouldEqual, 3)
+		})
+		Convey("The primary namespace is the session itself", func() {
+			tiller, err := s.tillerFor("kube-system")
+			So(err, ShouldBeNil)
+			So(tiller, ShouldEqual, s)
+		})
+		Convey("A new Tiller namespace needs a port forward", func() {
+			s.TillerHost = "tiller:44134"
+			_, err := s.tillerFor("tenant-b")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "port forwarding")
+		})
+	})
+}
+
+func TestVersionTillerNamespaces(t *testing.T) {
+	Convey("Versions record the Tiller of each release", t, func() {
+		releases := NewVersions("shop")
+		releases.AddReleaseVersion(&Version{Name: "web", Namespace: "apps", Revision: 2})
+		releases.AddReleaseVersion(&Version{Name: "db", Namespace: "apps", Revision: 1, TillerNamespace: "tenant-a"})
+		raw, err := releases.RawReleaseTable()
+		So(err, ShouldBeNil)
+		So(raw, ShouldNotContainSubstring, "TillerNamespace: \"\"")
+
+		versions := NewVersions("shop")
+		versions.Data = []*Version{
+			{Name: "shop", Revision: 1, Chart: &chart.Chart{Values: &chart.Config{Raw: "- Name: web\n  Revision: 1\n"}}},
+			{Name: "shop", Revision: 2, Chart: &chart.Chart{Values: &chart.Config{Raw: raw}}},
+		}
+		namespaces, err := versions.TillerNamespaces()
+		So(err, ShouldBeNil)
+		So(namespaces, ShouldResemble, []string{"tenant-a"})
+
+		entries, err := versions.Latest().ReleaseEntries()
+		So(err, ShouldBeNil)
+		So(entries, ShouldHaveLength, 2)
+		So(entries[1].TillerNamespace, ShouldEqual, "tenant-a")
+
+		empty, err := NewVersions("none").TillerNamespaces()
+		So(err, ShouldBeNil)
+		So(empty, ShouldBeEmpty)
+	})
+}
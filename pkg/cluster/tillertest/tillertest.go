@@ -0,0 +1,87 @@
+// Package tillertest runs Tiller in-process for tests without a Kubernetes cluster
+//
+// Releases are kept in Tiller memory storage, objects are not created by Tiller, and the
+// Kubernetes API is an in-memory cluster shared with the cluster.Session under test.
+package tillertest
+
+import (
+	"io/ioutil"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/storage"
+	"k8s.io/helm/pkg/storage/driver"
+	"k8s.io/helm/pkg/tiller"
+	"k8s.io/helm/pkg/tiller/environment"
+
+	"github.com/charter-oss/barrelman/pkg/cluster"
+	"github.com/charter-oss/barrelman/pkg/cluster/simkube"
+	"github.com/cirrocloud/structured/errors"
+)
+
+// Namespace is the namespace Tiller and the Barrelman versions are found in
+const Namespace = "kube-system"
+
+// Server is an in-process Tiller gRPC server
+type Server struct {
+	// Addr is the host:port Tiller is listening on
+	Addr string
+	// Env is the Tiller environment, Env.Releases holds the stored releases
+	Env *environment.Environment
+	// Clientset is the in-memory Kubernetes API used by Tiller and by sessions from Session
+	Clientset kubernetes.Interface
+	// Cluster holds the objects served by Clientset, sessions from Session read and write them as release objects
+	Cluster *simkube.Cluster
+
+	grpcServer *grpc.Server
+	listener   net.Listener
+}
+
+// NewServer starts Tiller with memory release storage on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen for Tiller")
+	}
+
+	env := environment.New()
+	env.Releases = storage.Init(driver.NewMemory())
+	env.KubeClient = &environment.PrintingKubeClient{Out: ioutil.Discard}
+	sim := simkube.New()
+	clientset := sim.Clientset()
+
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("Tiller", healthpb.HealthCheckResponse_SERVING)
+	grpcServer := tiller.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthSrv)
+	services.RegisterReleaseServiceServer(grpcServer, tiller.NewReleaseServer(env, clientset, false))
+	go grpcServer.Serve(listener)
+
+	return &Server{
+		Addr:       listener.Addr().String(),
+		Env:        env,
+		Clientset:  clientset,
+		Cluster:    sim,
+		grpcServer: grpcServer,
+		listener:   listener,
+	}, nil
+}
+
+// Session returns a *cluster.Session connecting directly to the server, Init must still be called
+func (ts *Server) Session() *cluster.Session {
+	s := cluster.NewSession("", "")
+	s.TillerHost = ts.Addr
+	s.Clientset = ts.Clientset
+	s.Objects = cluster.NewSimObjectClient(ts.Cluster)
+	return s
+}
+
+// Stop stops the server and closes its listener
+func (ts *Server) Stop() {
+	ts.grpcServer.Stop()
+	ts.listener.Close()
+}
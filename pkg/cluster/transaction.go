@@ -1,6 +1,9 @@
 package cluster
 
 import (
+	"context"
+	"time"
+
 	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/structured/log"
 )
@@ -28,6 +31,11 @@ import (
 // Once a manifest has been applied with changes, and fully succeeeds, a new state will be recorded.
 // Partially applied manifests will not result in a new state being recorded.
 
+// Journal
+// A transaction is recorded in a journal as each release changes, and the journal is removed once
+// the transaction completes or is canceled. A journal left behind by an interrupted process
+// is resumed or canceled by a later transaction.
+
 type NewTransactioner interface {
 	NewTransaction(string) (Transactioner, error)
 }
@@ -42,6 +50,8 @@ type Transactioner interface {
 	SetChanged()
 	Changed() bool
 	Completed() bool
+	Checkpoint() error
+	Resume(journal *Journal)
 }
 
 type Transaction struct {
@@ -50,7 +60,23 @@ type Transaction struct {
 	endState     *State
 	canceled     bool
 	changed      bool
-	session      *Session
+	started      time.Time
+	journaled    bool
+	resumed      *Journal
+	// Retry limits the retries of transient errors while writing versions and canceling
+	Retry   *RetryPolicy
+	session transactionSession
+}
+
+// transactionSession is the subset of a Sessioner used by a Transaction
+type transactionSession interface {
+	GetVersions(manifestName string) (*Versions, error)
+	WriteVersions(versions *Versions) error
+	DeleteRelease(ctx context.Context, m *DeleteMeta) error
+	InstallRelease(ctx context.Context, m *ReleaseMeta, manifestName string) (*InstallReleaseResponse, error)
+	RollbackRelease(ctx context.Context, m *RollbackMeta) (int32, error)
+	WriteJournal(journal *Journal) error
+	DeleteJournal(manifestName string) error
 }
 
 type State struct {
@@ -67,6 +93,10 @@ type ChangedRelease struct {
 // NewTransaction initializes a transaction data structure
 // this transaction can then be used to track changes and perform rollbacks
 func (s *Session) NewTransaction(manifestName string) (Transactioner, error) {
+	return newTransaction(s, manifestName)
+}
+
+func newTransaction(s transactionSession, manifestName string) (Transactioner, error) {
 	currentVersions, err := s.GetVersions(manifestName)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get current versions while creating rollback transaction")
@@ -75,6 +105,8 @@ func (s *Session) NewTransaction(manifestName string) (Transactioner, error) {
 	transaction := &Transaction{
 		ManifestName: manifestName,
 		session:      s,
+		started:      time.Now(),
+		Retry:        NewRetryPolicy(),
 		startState: &State{
 			Versions: currentVersions,
 		},
@@ -124,6 +156,7 @@ func (t *Transaction) completeTransaction() error {
 	}
 
 	t.endState.completed = true // we do not attempt this twice
+	t.mergeResumed()
 	changedList, changed := t.calculateChanged()
 	if changed {
 		//Log the changed releases
@@ -142,11 +175,13 @@ func (t *Transaction) completeTransaction() error {
 		//No changes, no new version
 		log.Debug("No change")
 	}
-	return nil
+	return t.clearJournal()
 }
 
 func (t *Transaction) WriteNewVersion() error {
-	return t.session.WriteVersions(t.Versions())
+	return t.Retry.Do(context.Background(), "write versions", func() error {
+		return t.session.WriteVersions(t.Versions())
+	})
 }
 
 // SetChanged sets a global transaction changed flag allowing it to generate a new version
@@ -172,7 +207,9 @@ func (t *Transaction) Versions() *Versions {
 
 // Cancel sets the versions and installation state to the previously recorded versions
 // effectivly undoing any commanded actions performed within the transaction
+// Cancel runs to completion even when the operation deadline has passed
 func (t *Transaction) Cancel() error {
+	ctx := context.Background()
 	if t.Canceled() {
 		//Tollerate multiple calls to Cancel
 		return nil
@@ -187,31 +224,202 @@ func (t *Transaction) Cancel() error {
 		}).New("cannot cancel this transaction, it hasent been started")
 	}
 	t.canceled = true
-	for _, v := range t.endState.Versions.Data {
-		if !v.Modified {
-			continue
+	t.mergeResumed()
+	for _, v := range t.cancelable() {
+		if err := t.restore(ctx, v); err != nil {
+			return err
 		}
-		if v.PreviousRevision == 0 {
-			// Release it didnt exist before this transaction
-			err := t.session.DeleteRelease(&DeleteMeta{
-				ReleaseName: v.Name,
-				Namespace:   v.Namespace,
-				Purge:       true,
+	}
+	return t.clearJournal()
+}
+
+// restore returns a release changed by the transaction to its state before the transaction
+// a release that did not exist is purged, one that was deleted is deleted again and any other
+// is rolled back to its previous revision. A deleted or replaced release whose history is gone
+// is reinstalled from its prior chart and config.
+func (t *Transaction) restore(ctx context.Context, v *Version) error {
+	if v.PreviousRevision == 0 {
+		// Release it didnt exist before this transaction
+		err := t.Retry.Do(ctx, "delete", func() error {
+			return t.session.DeleteRelease(ctx, &DeleteMeta{
+				ReleaseName:     v.Name,
+				Namespace:       v.Namespace,
+				Purge:           true,
+				TillerNamespace: v.TillerNamespace,
 			})
-			if err != nil {
-				return errors.Wrap(err, "failed to delete release durring cancelation")
-			}
-			continue
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to delete release durring cancelation")
 		}
-		_, err := t.session.RollbackRelease(&RollbackMeta{
-			ReleaseName: v.Name,
-			Namespace:   v.Namespace,
-			Revision:    v.PreviousRevision,
+		return nil
+	}
+	if v.Prior != nil && v.Prior.Status == Status_DELETED {
+		// Release was undeleted by this transaction
+		err := t.Retry.Do(ctx, "delete", func() error {
+			return t.session.DeleteRelease(ctx, &DeleteMeta{
+				ReleaseName:     v.Name,
+				Namespace:       v.Namespace,
+				TillerNamespace: v.TillerNamespace,
+			})
 		})
 		if err != nil {
-			return errors.Wrap(err, "failed to revert release durring cancelation")
+			return errors.Wrap(err, "failed to delete release durring cancelation")
+		}
+		return nil
+	}
+	err := t.Retry.Do(ctx, "rollback", func() error {
+		_, err := t.session.RollbackRelease(ctx, &RollbackMeta{
+			ReleaseName:     v.Name,
+			Namespace:       v.Namespace,
+			Revision:        v.PreviousRevision,
+			TillerNamespace: v.TillerNamespace,
+		})
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+	if v.Prior == nil || v.Prior.Chart == nil {
+		return errors.Wrap(err, "failed to revert release durring cancelation")
+	}
+	log.WithFields(log.Fields{
+		"ReleaseName": v.Name,
+		"Revision":    v.PreviousRevision,
+		"Error":       err.Error(),
+	}).Warn("Rollback failed durring cancelation, reinstalling the prior release")
+	return t.reinstall(ctx, v)
+}
+
+// reinstall purges what is left of a release and installs it again from its prior chart and config
+func (t *Transaction) reinstall(ctx context.Context, v *Version) error {
+	if err := t.session.DeleteRelease(ctx, &DeleteMeta{
+		ReleaseName:     v.Name,
+		Namespace:       v.Namespace,
+		Purge:           true,
+		TillerNamespace: v.TillerNamespace,
+	}); err != nil {
+		// the release may already be purged
+		log.WithFields(log.Fields{
+			"ReleaseName": v.Name,
+			"Error":       err.Error(),
+		}).Debug("purge before reinstall reported error")
+	}
+	m := &ReleaseMeta{
+		Chart:           v.Prior.Chart,
+		ReleaseName:     v.Name,
+		Namespace:       v.Namespace,
+		TillerNamespace: v.TillerNamespace,
+	}
+	if v.Prior.Config != nil {
+		m.ValueOverrides = []byte(v.Prior.Config.Raw)
+	}
+	err := t.Retry.Do(ctx, "install", func() error {
+		_, err := t.session.InstallRelease(ctx, m, t.ManifestName)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to reinstall release durring cancelation")
+	}
+	return nil
+}
+
+// Checkpoint records the releases of the transaction in its journal
+// it is called before the first release is changed and after each change
+func (t *Transaction) Checkpoint() error {
+	t.mergeResumed()
+	if err := t.session.WriteJournal(t.journal()); err != nil {
+		return err
+	}
+	t.journaled = true
+	return nil
+}
+
+// Resume adopts the journal of an interrupted transaction
+// releases it modified are canceled or recorded along with the releases of this transaction
+func (t *Transaction) Resume(journal *Journal) {
+	t.resumed = journal
+}
+
+// mergeResumed carries the releases modified by a resumed transaction into endState
+func (t *Transaction) mergeResumed() {
+	if t.resumed == nil {
+		return
+	}
+	for _, v := range t.endState.Versions.Data {
+		entry := t.resumed.Lookup(v.Name)
+		if entry == nil || !entry.Modified {
+			continue
+		}
+		v.PreviousRevision = entry.PreviousRevision
+		v.SetModified()
+	}
+}
+
+// cancelable returns the modified releases, including those of a resumed transaction
+func (t *Transaction) cancelable() []*Version {
+	versions := []*Version{}
+	for _, v := range t.endState.Versions.Data {
+		if v.Modified {
+			versions = append(versions, v)
+		}
+	}
+	if t.resumed == nil {
+		return versions
+	}
+	for _, entry := range t.resumed.Modified() {
+		if t.endState.Versions.Lookup(entry.Name) == nil {
+			versions = append(versions, entry.Version())
 		}
 	}
+	return versions
+}
+
+// journal returns the journal recording the current state of the transaction
+func (t *Transaction) journal() *Journal {
+	journal := &Journal{
+		ManifestName: t.ManifestName,
+		Started:      t.started,
+		Releases:     []*JournalEntry{},
+	}
+	for _, v := range t.startState.Versions.Data {
+		if v.Revision > journal.StartVersion {
+			journal.StartVersion = v.Revision
+		}
+	}
+	if t.resumed != nil {
+		journal.Started = t.resumed.Started
+		journal.StartVersion = t.resumed.StartVersion
+	}
+	for _, v := range t.endState.Versions.Data {
+		journal.Releases = append(journal.Releases, &JournalEntry{
+			Name:             v.Name,
+			Namespace:        v.Namespace,
+			Revision:         v.Revision,
+			PreviousRevision: v.PreviousRevision,
+			Modified:         v.Modified,
+			TillerNamespace:  v.TillerNamespace,
+			Removed:          v.Removed,
+			Deleted:          v.Prior != nil && v.Prior.Status == Status_DELETED,
+		})
+	}
+	if t.resumed != nil {
+		for _, entry := range t.resumed.Releases {
+			if t.endState.Versions.Lookup(entry.Name) == nil {
+				journal.Releases = append(journal.Releases, entry)
+			}
+		}
+	}
+	return journal
+}
+
+// clearJournal removes the journal once the transaction has ended
+func (t *Transaction) clearJournal() error {
+	if !t.journaled && t.resumed == nil {
+		return nil
+	}
+	if err := t.session.DeleteJournal(t.ManifestName); err != nil {
+		return errors.Wrap(err, "failed to remove transaction journal")
+	}
 	return nil
 }
 
@@ -243,3 +243,93 @@ func TestTransactions(t *testing.T) {
 		})
 	})
 }
+
+func TestTransactionCancel(t *testing.T) {
+	Convey("Cancel restores the release of each transition", t, func() {
+		session := &journalSession{
+			ConfigMapJournal: NewConfigMapJournal(newTestClientset().CoreV1().ConfigMaps("kube-system")),
+			rolledBack:       map[string]int32{},
+		}
+		transaction, err := newTransaction(session, "testManifest")
+		So(err, ShouldBeNil)
+		prior := &PriorRelease{
+			Chart:  &chart.Chart{Metadata: &chart.Metadata{Name: "app"}},
+			Config: &chart.Config{Raw: "setting: one\n"},
+			Status: Status_DEPLOYED,
+		}
+		add := func(v *Version) *Version {
+			transaction.Versions().AddReleaseVersion(v)
+			return v
+		}
+		unchanged := add(&Version{Name: "unchanged", Revision: 4, PreviousRevision: 4})
+		installed := add(&Version{Name: "installed"})
+		upgraded := add(&Version{Name: "upgraded", Revision: 2, PreviousRevision: 2})
+		replaced := add(&Version{Name: "replaced", Revision: 5, PreviousRevision: 5, Prior: prior})
+		undeleted := add(&Version{Name: "undeleted", Revision: 3, PreviousRevision: 3, Prior: &PriorRelease{Status: Status_DELETED}})
+		removed := add(&Version{Name: "removed", Revision: 6, PreviousRevision: 6, Prior: prior, Removed: true})
+		installed.SetRevision(1)
+		upgraded.SetRevision(3)
+		replaced.SetRevision(6)
+		undeleted.SetRevision(5)
+		removed.SetModified()
+
+		Convey("NoChange releases are left alone", func() {
+			So(transaction.Cancel(), ShouldBeNil)
+			_, ok := session.rolledBack[unchanged.Name]
+			So(ok, ShouldBeFalse)
+			So(session.deleted, ShouldNotContain, unchanged.Name)
+		})
+		Convey("Installable releases are purged", func() {
+			So(transaction.Cancel(), ShouldBeNil)
+			So(session.purged, ShouldResemble, []string{"installed"})
+		})
+		Convey("Upgradable releases are rolled back", func() {
+			So(transaction.Cancel(), ShouldBeNil)
+			So(session.rolledBack["upgraded"], ShouldEqual, 2)
+		})
+		Convey("Replaceable and Deletable releases are rolled back to their last revision", func() {
+			So(transaction.Cancel(), ShouldBeNil)
+			So(session.rolledBack["replaced"], ShouldEqual, 5)
+			So(session.rolledBack["removed"], ShouldEqual, 6)
+			So(session.installed, ShouldBeEmpty)
+		})
+		Convey("Undeletable releases are deleted again without purging", func() {
+			So(transaction.Cancel(), ShouldBeNil)
+			So(session.deleted, ShouldContain, "undeleted")
+			So(session.purged, ShouldNotContain, "undeleted")
+			_, ok := session.rolledBack["undeleted"]
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Releases whose history is gone are reinstalled from their prior chart and config", func() {
+			session.gone = map[string]bool{"replaced": true, "removed": true}
+			So(transaction.Cancel(), ShouldBeNil)
+			So(session.installed, ShouldHaveLength, 2)
+			for _, m := range session.installed {
+				So(m.Chart, ShouldEqual, prior.Chart)
+				So(string(m.ValueOverrides), ShouldEqual, "setting: one\n")
+			}
+			So(session.purged, ShouldContain, "replaced")
+			So(session.purged, ShouldContain, "removed")
+		})
+		Convey("Releases without a prior state fail when the rollback fails", func() {
+			session.gone = map[string]bool{"upgraded": true}
+			transaction.(*Transaction).Retry = &RetryPolicy{Attempts: 1}
+			err := transaction.Cancel()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "failed to revert release")
+		})
+		Convey("Removed releases are journaled but not recorded in the new version", func() {
+			So(transaction.Checkpoint(), ShouldBeNil)
+			journal, err := session.GetJournal("testManifest")
+			So(err, ShouldBeNil)
+			So(journal.Lookup("removed").Removed, ShouldBeTrue)
+			So(journal.Lookup("undeleted").Deleted, ShouldBeTrue)
+			So(journal.Lookup("undeleted").Version().Prior.Status, ShouldEqual, Status_DELETED)
+			So(transaction.Complete(), ShouldBeNil)
+			table, err := session.written.RawReleaseTable()
+			So(err, ShouldBeNil)
+			So(table, ShouldNotContainSubstring, "removed")
+			So(table, ShouldContainSubstring, "replaced")
+		})
+	})
+}
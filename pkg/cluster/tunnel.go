@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/rest"
+	"k8s.io/helm/pkg/kube"
+
+	"github.com/cirrocloud/structured/errors"
+	"github.com/cirrocloud/structured/log"
+)
+
+// tillerPort is the gRPC port of the Tiller pod
+const tillerPort = 44134
+
+const (
+	// DefaultTillerNamespace is the namespace helm init installs Tiller in
+	DefaultTillerNamespace = "kube-system"
+	// DefaultTillerSelector matches the labels helm init gives the Tiller pod
+	DefaultTillerSelector = "app=helm,name=tiller"
+	// DefaultTillerConnectionTimeout bounds connecting to Tiller
+	DefaultTillerConnectionTimeout = 5 * time.Second
+)
+
+// tunnelFailures identify calls which failed because the port forward to Tiller dropped
+var tunnelFailures = []string{
+	"connection refused",
+	"connection reset by peer",
+	"transport is closing",
+	"lost connection to pod",
+	"error reading from server: EOF",
+}
+
+// tunnelFailed reports whether err was caused by a lost Tiller connection
+func tunnelFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if s, ok := status.FromError(err); ok && s.Code() == codes.Unavailable {
+		return true
+	}
+	msg := err.Error()
+	for _, m := range tunnelFailures {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// tillerCall runs a Helm client call, reconnecting to Tiller when the port forward has dropped
+// an idempotent call is repeated once on the new connection, other calls return their error to the caller
+// and are waited for past the deadline of ctx, see callContextWait
+func tillerCall(ctx context.Context, s *Session, idempotent bool, call func() error) error {
+	run := callContext
+	if !idempotent {
+		run = callContextWait
+	}
+	err := run(ctx, call)
+	if err == nil || ctx.Err() != nil || !tunnelFailed(err) {
+		return err
+	}
+	if rerr := s.reconnect(err); rerr != nil {
+		return errors.WithFields(errors.Fields{
+			"ReconnectError": rerr.Error(),
+		}).Wrap(err, "lost connection to Tiller")
+	}
+	if !idempotent {
+		return err
+	}
+	return callContext(ctx, call)
+}
+
+// reconnect re-establishes the Tiller connection after cause, sessions without a port forward are left as they are
+func (s *Session) reconnect(cause error) error {
+	if s.dialTiller == nil {
+		return cause
+	}
+	s.dialMu.Lock()
+	defer s.dialMu.Unlock()
+
+	log.WithFields(log.Fields{
+		"Error": cause.Error(),
+	}).Warn("Lost connection to Tiller, reconnecting")
+	if err := s.dialTiller(); err != nil {
+		return errors.Wrap(err, "failed to reconnect to Tiller")
+	}
+	log.WithFields(log.Fields{
+		"Host": s.tillerHost(),
+		"Pod":  s.Tunnel.PodName,
+	}).Info("Reconnected to Tiller")
+	return nil
+}
+
+// forwardTiller port forwards to a ready Tiller pod in namespace and connects the Helm client through it
+// the port forward of an earlier connection is closed first
+func (s *Session) forwardTiller(config *rest.Config, namespace string) error {
+	if s.Tunnel != nil && s.Tunnel.Local != 0 {
+		s.Tunnel.Close()
+	}
+	podName, err := getTillerPodName(s.Clientset, namespace, s.TillerSelector)
+	if err != nil {
+		return errors.WithFields(errors.Fields{
+			"Namespace": namespace,
+		}).Wrap(err, "could not get Tiller pod name")
+	}
+	s.Tunnel = kube.NewTunnel(s.Clientset.CoreV1().RESTClient(), config, namespace, podName, tillerPort)
+	if err := s.Tunnel.ForwardPort(); err != nil {
+		return errors.Wrap(err, "could not get Tiller tunnel")
+	}
+	return s.connectTiller()
+}
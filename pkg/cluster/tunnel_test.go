@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/kube"
+	rls "k8s.io/helm/pkg/proto/hapi/services"
+
+	mockHelm "github.com/charter-oss/barrelman/pkg/cluster/mockHelm"
+	"github.com/cirrocloud/structured/errors"
+)
+
+func TestTillerReconnect(t *testing.T) {
+	Convey("Tiller reconnect", t, func() {
+		helm := &mockHelm.Interface{}
+		dials := 0
+		s := &Session{
+			Helm:   helm,
+			Tunnel: &kube.Tunnel{PodName: "tiller-deploy-1"},
+		}
+		s.dialTiller = func() error {
+			dials++
+			return nil
+		}
+		dropped := status.Error(codes.Unavailable, "transport is closing")
+
+		Convey("Repeats an idempotent call on the new connection", func() {
+			helm.On("ListReleases", mock.Anything).Return(nil, dropped).Once()
+			helm.On("ListReleases", mock.Anything).Return(&rls.ListReleasesResponse{}, nil).Once()
+			_, err := s.ListReleases(context.Background())
+			So(err, ShouldBeNil)
+			So(dials, ShouldEqual, 1)
+			helm.AssertExpectations(t)
+		})
+		Convey("Returns the error of a call which is not idempotent", func() {
+			helm.On("RollbackRelease", "app", mock.Anything, mock.Anything).Return(nil, dropped).Once()
+			_, err := s.RollbackRelease(context.Background(), &RollbackMeta{ReleaseName: "app", Revision: 1})
+			So(err, ShouldNotBeNil)
+			So(dials, ShouldEqual, 1)
+			helm.AssertNumberOfCalls(t, "RollbackRelease", 1)
+		})
+		Convey("Does not reconnect on other errors", func() {
+			helm.On("ListReleases", mock.Anything).Return(nil, errors.New("simulated")).Once()
+			_, err := s.ListReleases(context.Background())
+			So(err, ShouldNotBeNil)
+			So(dials, ShouldEqual, 0)
+		})
+		Convey("Reports a failed reconnect", func() {
+			s.dialTiller = func() error {
+				return errors.New("could not find a ready tiller pod")
+			}
+			helm.On("ListReleases", mock.Anything).Return(nil, dropped).Once()
+			_, err := s.ListReleases(context.Background())
+			So(err.Error(), ShouldContainSubstring, "lost connection to Tiller")
+			So(err.Error(), ShouldContainSubstring, "ready tiller pod")
+		})
+		Convey("Leaves a direct connection as it is", func() {
+			s.dialTiller = nil
+			helm.On("ListReleases", mock.Anything).Return(nil, dropped).Once()
+			_, err := s.ListReleases(context.Background())
+			So(err, ShouldNotBeNil)
+			helm.AssertNumberOfCalls(t, "ListReleases", 1)
+		})
+	})
+}
+
+func TestTillerDiscovery(t *testing.T) {
+	Convey("Tiller discovery", t, func() {
+		ready := []core.PodCondition{{Type: core.PodReady}}
+		client := newTestClientset(
+			&core.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "tiller-deploy-1", Namespace: "kube-system", Labels: map[string]string{"app": "helm", "name": "tiller"}},
+				Status:     core.PodStatus{Conditions: ready},
+			},
+			&core.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "tiller-custom-1", Namespace: "tiller", Labels: map[string]string{"app": "tiller"}},
+				Status:     core.PodStatus{Conditions: ready},
+			},
+		)
+
+		Convey("Finds the pod helm init labels by default", func() {
+			name, err := getTillerPodName(client, "kube-system", "")
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "tiller-deploy-1")
+		})
+		Convey("Finds the pod with a custom selector", func() {
+			name, err := getTillerPodName(client, "tiller", "app=tiller")
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "tiller-custom-1")
+		})
+		Convey("Fails on an invalid selector", func() {
+			_, err := getTillerPodName(client, "tiller", "app in (")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid Tiller label selector")
+		})
+		Convey("Namespace falls back to TILLER_NAMESPACE then kube-system", func() {
+			os.Setenv("TILLER_NAMESPACE", "")
+			So((&Session{}).tillerNamespace(), ShouldEqual, DefaultTillerNamespace)
+			os.Setenv("TILLER_NAMESPACE", "tiller")
+			defer os.Unsetenv("TILLER_NAMESPACE")
+			So((&Session{}).tillerNamespace(), ShouldEqual, "tiller")
+			So((&Session{TillerNamespace: "other"}).tillerNamespace(), ShouldEqual, "other")
+		})
+		Convey("Connection timeout defaults and rounds up to whole seconds", func() {
+			So((&Session{}).tillerConnectionTimeout(), ShouldEqual, DefaultTillerConnectionTimeout)
+			So((&Session{TillerConnectionTimeout: 1500 * time.Millisecond}).tillerConnectionTimeout(), ShouldEqual, 2*time.Second)
+			So((&Session{TillerConnectionTimeout: 30 * time.Second}).tillerConnectionTimeout(), ShouldEqual, 30*time.Second)
+		})
+	})
+}
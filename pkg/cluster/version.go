@@ -7,25 +7,52 @@ import (
 	"sort"
 	"time"
 
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/helm/pkg/timeconv"
 
 	"github.com/charter-oss/barrelman/pkg/cluster/driver"
 	"github.com/cirrocloud/structured/errors"
 	"github.com/cirrocloud/structured/log"
 	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/proto/hapi/release"
 )
 
+// maxVersionSize is the largest encoded version or release source a ConfigMap or Secret holds
+const maxVersionSize = 1024 * 1024
+
+// versionTagLabel names the label tagging a stored manifest version, tagged versions are never pruned
+const versionTagLabel = "TAG"
+
+// The chart and values of each release changed by a manifest version are stored as its files
+// or, when versions are stored as ConfigMaps, in ReleaseSources
+const (
+	storedChartPath  = "releases/%s/chart"
+	storedValuesPath = "releases/%s/values.yaml"
+)
+
 type Versioner interface {
 	ListManifests() ([]*Version, error)
 	GetVersionsFromList(manifestNames *[]string) ([]*Versions, error)
 	GetVersions(manifestName string) (*Versions, error)
+	WriteVersions(versions *Versions) error
+	CheckVersionSize(versions *Versions) error
+	PruneVersions(manifestName string, keep int, dryRun bool) ([]*Version, error)
 }
 
 type Versions struct {
 	Name string
 	Data []*Version
+	// Deleted records the teardown of the manifest, the version holds no releases
+	Deleted bool
+	// Source records the manifest and chart sources that produced the version, when known
+	Source *VersionSource
+	// HistoryMax limits the versions kept once the version is written, zero keeps them all
+	HistoryMax int
+	// sources holds the release sources apart from the versions, nil when they are in the versions
+	sources *ReleaseSources
 }
 
 type VersionTable struct {
@@ -45,66 +72,310 @@ type Version struct {
 	Chart            *chart.Chart
 	Info             *release.Info
 	Modified         bool
+	// Config is the values of the release, stored with its chart in the manifest version
+	Config *chart.Config
+	// TillerNamespace is the namespace of the Tiller holding the release, empty for the primary Tiller
+	TillerNamespace string
+	// Prior is the state of a release the transaction deletes or replaces, restored on cancel
+	Prior *PriorRelease
+	// Removed marks a release deleted by the transaction, it is not recorded in the new version
+	Removed bool
+	// MetaName and ChartGroup name the chart of the release in the manifest and its group
+	MetaName   string
+	ChartGroup string
+	// Tag is the TAG label of the manifest version ConfigMap, a tagged version is never pruned
+	Tag string
+}
+
+// PriorRelease is a release as it was before a transaction deleted or replaced it
+type PriorRelease struct {
+	Chart  *chart.Chart
+	Config *chart.Config
+	Status Status
+}
+
+// ReleaseEntry is a release recorded in a manifest version
+type ReleaseEntry struct {
+	Name            string
+	Namespace       string
+	Revision        int32
+	TillerNamespace string `json:",omitempty"`
+	// Chart and ChartGroup name the release in the manifest, they select releases of a partial rollback
+	Chart      string `json:",omitempty"`
+	ChartGroup string `json:",omitempty"`
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-func (s *Session) NewConfigMaps() *driver.ConfigMaps {
-	return driver.NewConfigMaps(s.Clientset.CoreV1().ConfigMaps(s.Tunnel.Namespace))
+// Barrelman versions are stored as ConfigMaps unless Secrets are requested
+const (
+	VersionsStorageConfigMap = "configmap"
+	VersionsStorageSecret    = "secret"
+)
+
+// checkVersionsStorage returns an error unless storage names a versions storage
+func checkVersionsStorage(storage string) error {
+	switch storage {
+	case "", VersionsStorageConfigMap, VersionsStorageSecret:
+		return nil
+	}
+	return errors.WithFields(errors.Fields{
+		"VersionsStorage": storage,
+	}).New("unknown versions storage, expected one of [ configmap | secret ]")
+}
+
+// NewVersionDriver returns the driver storing Barrelman versions in namespace
+// versions are stored as Secrets when storage is "secret", as ConfigMaps otherwise
+func NewVersionDriver(clientset kubernetes.Interface, namespace, storage string) driver.VersionDriver {
+	if storage == VersionsStorageSecret {
+		return driver.NewSecrets(clientset.CoreV1().Secrets(namespace))
+	}
+	return driver.NewConfigMaps(clientset.CoreV1().ConfigMaps(namespace))
+}
+
+// versionsNamespace returns the namespace holding Barrelman versions, locks and journals
+func (s *Session) versionsNamespace() string {
+	if s.VersionsNamespace != "" {
+		return s.VersionsNamespace
+	}
+	return s.Tunnel.Namespace
+}
+
+// NewVersionDriver returns the driver holding Barrelman versions
+func (s *Session) NewVersionDriver() driver.VersionDriver {
+	return NewVersionDriver(s.Clientset, s.versionsNamespace(), s.VersionsStorage)
 }
 
 func (s *Session) WriteVersions(versions *Versions) error {
-	cmap := s.NewConfigMaps()
-	releases, err := cmap.List(getReleaseFilter(versions.Name))
+	return writeVersions(s.NewVersionDriver(), s.releaseSources(), versions)
+}
+
+// CheckVersionSize returns an error when versions would not fit the storage of the session, see checkVersionSize
+func (s *Session) CheckVersionSize(versions *Versions) error {
+	return checkVersionSize(versions, s.releaseSources() == nil)
+}
+
+func (s *Session) GetVersionsFromList(manifestNames *[]string) ([]*Versions, error) {
+	return getVersionsFromList(s, manifestNames)
+}
+
+func (s *Session) GetVersions(manifestName string) (*Versions, error) {
+	return getVersions(s.NewVersionDriver(), s.releaseSources(), manifestName)
+}
+
+// PruneVersions removes all but the keep latest versions of manifestName, see pruneVersions
+func (s *Session) PruneVersions(manifestName string, keep int, dryRun bool) ([]*Version, error) {
+	return pruneVersions(s.NewVersionDriver(), s.releaseSources(), manifestName, keep, dryRun)
+}
+
+// ListManifests returns list of unique Barrelman manifests recorded in cluster
+func (s *Session) ListManifests() ([]*Version, error) {
+	return listManifests(s.NewVersionDriver())
+}
+
+// writeVersions records versions as a new manifest version in store
+// the release sources go to sources ahead of the version, or into the version when sources is nil
+func writeVersions(store driver.VersionDriver, sources *ReleaseSources, versions *Versions) error {
+	releases, err := store.List(getReleaseFilter(versions.Name))
 	if err != nil {
 		return errors.Wrap(err, "GetVersion failed to get release list during write")
 	}
 
-	rawReleaseValues, err := versions.RawReleaseTable()
+	if versions.Deleted {
+		// the releases deleted by a teardown are not part of the version
+		versions = &Versions{
+			Name:       versions.Name,
+			Data:       []*Version{},
+			Deleted:    true,
+			Source:     versions.Source,
+			HistoryMax: versions.HistoryMax,
+		}
+	}
+	version := CalculateLastVersion(releases) + 1
+	rls, err := newVersionRecord(versions, version, sources == nil)
 	if err != nil {
 		return err
 	}
-	releaseValues := versions.ChartValues()
-
-	for r, v := range releaseValues {
+	for r, v := range rls.Chart.Values.Values {
 		log.WithFields(log.Fields{
 			"Release": r,
 			"Version": v.GetValue(),
 		}).Debug("Adding release to metaversion")
 	}
+	if sources != nil {
+		files, err := versions.ReleaseFiles()
+		if err != nil {
+			return err
+		}
+		if err := sources.WriteFiles(versions.Name, version, files); err != nil {
+			return err
+		}
+	}
 
-	version := CalculateLastVersion(releases) + 1
-	rls := &release.Release{
+	resourceName := fmt.Sprintf("%s.v%d.%s", versions.Name, version, NewSHA1Hash())
+
+	log.WithFields(log.Fields{
+		"Name": resourceName,
+	}).Debug("creating rollback resource")
+
+	if err := store.Create(resourceName, rls); err != nil {
+		return err
+	}
+
+	// the version is written, a failed prune is left for the next write or "history prune"
+	// rather than failing the write and having it retried into a duplicate version
+	if versions.HistoryMax > 0 {
+		if _, err := pruneVersions(store, sources, versions.Name, versions.HistoryMax, false); err != nil {
+			log.WithFields(log.Fields{
+				"ManifestName": versions.Name,
+				"HistoryMax":   versions.HistoryMax,
+				"Error":        err.Error(),
+			}).Warn("Failed to prune manifest versions")
+		}
+	}
+	return nil
+}
+
+// newVersionRecord returns the release recording versions as revision version of the manifest
+// the release sources are among its files when embedReleases is set
+func newVersionRecord(versions *Versions, version int32, embedReleases bool) (*release.Release, error) {
+	rawReleaseValues, err := versions.RawReleaseTable()
+	if err != nil {
+		return nil, err
+	}
+	files := []*any.Any{}
+	if embedReleases {
+		files, err = versions.ReleaseFiles()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if versions.Source != nil {
+		sourceFiles, err := versions.Source.Files()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sourceFiles...)
+	}
+
+	info := &release.Info{LastDeployed: timeconv.Timestamp(time.Now())}
+	if versions.Deleted {
+		info.Status = &release.Status{Code: release.Status_DELETED}
+		info.Description = "Deletion complete"
+	}
+	return &release.Release{
 		Name: versions.Name,
-		Info: &release.Info{LastDeployed: timeconv.Timestamp(time.Now())},
+		Info: info,
 		Chart: &chart.Chart{
 			Metadata: &chart.Metadata{
 				Name: versions.Name,
 			},
 			Values: &chart.Config{
 				Raw:    rawReleaseValues,
-				Values: releaseValues,
+				Values: versions.ChartValues(),
 			},
+			Files: files,
 		},
 		Version: version,
-	}
-
-	resourceName := fmt.Sprintf("%s.v%d.%s", versions.Name, version, NewSHA1Hash())
-
-	log.WithFields(log.Fields{
-		"Name": resourceName,
-	}).Debug("creating rollback resource")
+	}, nil
+}
 
-	if err := cmap.Create(resourceName, rls); err != nil {
+// checkVersionSize returns an error when the version recording versions, or the source of one of
+// its releases kept apart when embedReleases is not set, exceeds what a ConfigMap or Secret holds
+// it is checked before releases are changed, a version that cannot be written fails the transaction
+func checkVersionSize(versions *Versions, embedReleases bool) error {
+	rls, err := newVersionRecord(versions, 1, embedReleases)
+	if err != nil {
 		return err
 	}
-
+	size, err := driver.EncodedSize(rls)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest version")
+	}
+	if size > maxVersionSize {
+		hint := "store fewer releases in the manifest"
+		if embedReleases {
+			hint = "store versions as ConfigMaps, which keep release charts apart"
+		}
+		return errors.WithFields(errors.Fields{
+			"ManifestName": versions.Name,
+			"Size":         size,
+			"Limit":        maxVersionSize,
+			"Hint":         hint,
+		}).New("manifest version is too large to be stored")
+	}
+	if embedReleases {
+		return nil
+	}
+	files, err := versions.ReleaseFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if len(f.Value) > maxVersionSize {
+			return errors.WithFields(errors.Fields{
+				"ManifestName": versions.Name,
+				"File":         f.TypeUrl,
+				"Size":         len(f.Value),
+				"Limit":        maxVersionSize,
+			}).New("release source is too large to be stored")
+		}
+	}
 	return nil
 }
 
-func (s *Session) GetVersionsFromList(manifestNames *[]string) ([]*Versions, error) {
+// pruneVersions removes the versions of manifestName older than the keep latest ones and returns them
+// along with the release sources they hold in sources
+// the latest version, which records the deployed state, and tagged versions are never pruned
+// with dryRun the versions are returned without being removed
+func pruneVersions(store driver.VersionDriver, sources *ReleaseSources, manifestName string, keep int, dryRun bool) ([]*Version, error) {
+	stored, err := store.ListStored(getReleaseFilter(manifestName))
+	if err != nil {
+		return nil, errors.Wrap(err, "PruneVersions failed to get release list")
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].Release.Version > stored[j].Release.Version
+	})
+	if keep < 1 {
+		keep = 1
+	}
+
+	pruned := []*Version{}
+	for i, s := range stored {
+		if i < keep || s.Labels[versionTagLabel] != "" {
+			continue
+		}
+		if !dryRun {
+			if _, err := store.Delete(s.Key); err != nil {
+				return pruned, errors.WithFields(errors.Fields{
+					"Name":     manifestName,
+					"Revision": s.Release.Version,
+				}).Wrap(err, "failed to remove manifest version")
+			}
+			if sources != nil {
+				if err := sources.Delete(manifestName, s.Release.Version); err != nil {
+					return pruned, err
+				}
+			}
+		}
+		log.WithFields(log.Fields{
+			"Name":     manifestName,
+			"Revision": s.Release.Version,
+			"DryRun":   dryRun,
+		}).Debug("Pruned manifest version")
+		pruned = append(pruned, &Version{
+			Name:      s.Release.Name,
+			Namespace: s.Release.Namespace,
+			Revision:  s.Release.Version,
+			Info:      s.Release.Info,
+		})
+	}
+	return pruned, nil
+}
+
+func getVersionsFromList(s Versioner, manifestNames *[]string) ([]*Versions, error) {
 	allVersions := []*Versions{}
 	for _, v := range *manifestNames {
 		versions, err := s.GetVersions(v)
@@ -118,34 +389,34 @@ func (s *Session) GetVersionsFromList(manifestNames *[]string) ([]*Versions, err
 	return allVersions, nil
 }
 
-func (s *Session) GetVersions(manifestName string) (*Versions, error) {
+func getVersions(store driver.VersionDriver, sources *ReleaseSources, manifestName string) (*Versions, error) {
 	log.WithFields(log.Fields{
 		"ManifestName": manifestName,
 	}).Debug("Getting rollback information")
 	versions := NewVersions(manifestName)
-	cmap := driver.NewConfigMaps(s.Clientset.CoreV1().ConfigMaps(s.Tunnel.Namespace))
-	releases, err := cmap.List(getReleaseFilter(manifestName))
+	versions.sources = sources
+	releases, err := store.ListStored(getReleaseFilter(manifestName))
 	if err != nil {
 		return nil, errors.Wrap(err, "GetVersion failed to get release list")
 	}
-	for _, v := range releases {
+	for _, s := range releases {
+		v := s.Release
 		versions.Data = append(versions.Data, &Version{
 			Name:      v.Name,
 			Namespace: v.Namespace,
 			Revision:  v.Version,
 			Chart:     v.Chart,
 			Info:      v.Info,
+			Tag:       s.Labels[versionTagLabel],
 		})
 	}
 	return versions, nil
 }
 
-// ListManifests returns list of unique Barrelman manifests recorded in cluster
-func (s *Session) ListManifests() ([]*Version, error) {
+func listManifests(store driver.VersionDriver) ([]*Version, error) {
 	outVersions := []*Version{}
 	internalData := make(map[string]map[string][]*Version)
-	cmap := driver.NewConfigMaps(s.Clientset.CoreV1().ConfigMaps(s.Tunnel.Namespace))
-	allReleases, err := cmap.List(getNoopManifestFilter())
+	allReleases, err := store.List(getNoopManifestFilter())
 	if err != nil {
 		return nil, errors.Wrap(err, "ListManifests failed to get release list")
 	}
@@ -245,6 +516,165 @@ func (version *Version) ReleaseTable() (map[string]*chart.Value, error) {
 	return version.Chart.Values.Values, nil
 }
 
+// ReleaseEntries returns the releases recorded in the version
+func (version *Version) ReleaseEntries() ([]*ReleaseEntry, error) {
+	if version.Chart == nil || version.Chart.Values == nil {
+		return nil, errors.New("Values does not exist in version, cannot extract release entries")
+	}
+	entries := []*ReleaseEntry{}
+	if err := yaml.Unmarshal([]byte(version.Chart.Values.Raw), &entries); err != nil {
+		return nil, errors.WithFields(errors.Fields{
+			"Name":     version.Name,
+			"Revision": version.Revision,
+		}).Wrap(err, "failed to parse release entries of version")
+	}
+	return entries, nil
+}
+
+// StoredRelease returns the chart and values of release name a rollback to target reinstalls it from
+// they are stored with the version that last changed the release, the versions before target are
+// searched for it as long as they record the revision of the release in target
+// the chart is nil when none is found, as for versions written before charts were stored
+func (versions *Versions) StoredRelease(target *Version, name string) (*chart.Chart, *chart.Config, error) {
+	stored, config, err := target.StoredRelease(name)
+	if err != nil || stored != nil {
+		return stored, config, err
+	}
+	revision, ok, err := target.releaseRevision(name)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	candidates := []*Version{}
+	for _, v := range versions.Data {
+		if v.Revision <= target.Revision {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Revision > candidates[j].Revision
+	})
+	for _, v := range candidates {
+		if r, ok, err := v.releaseRevision(name); err != nil || !ok || r != revision {
+			return nil, nil, err
+		}
+		stored, config, err := v.StoredRelease(name)
+		if err != nil || stored != nil {
+			return stored, config, err
+		}
+		if versions.sources == nil {
+			continue
+		}
+		files, err := versions.sources.Files(versions.Name, v.Revision)
+		if err != nil {
+			return nil, nil, err
+		}
+		stored, config, err = v.storedReleaseFiles(files, name)
+		if err != nil || stored != nil {
+			return stored, config, err
+		}
+	}
+	return nil, nil, nil
+}
+
+// releaseRevision returns the revision of release name recorded in the version, false when it holds none
+func (version *Version) releaseRevision(name string) (int32, bool, error) {
+	if version.Chart == nil || version.Chart.Values == nil {
+		return 0, false, nil
+	}
+	entries, err := version.ReleaseEntries()
+	if err != nil {
+		return 0, false, err
+	}
+	for _, e := range entries {
+		if e.Name == name {
+			return e.Revision, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// StoredRelease returns the chart and values of release name stored in the manifest version
+// the chart is nil when the version holds none, as for versions that left the release unchanged
+func (version *Version) StoredRelease(name string) (*chart.Chart, *chart.Config, error) {
+	if version.Chart == nil {
+		return nil, nil, nil
+	}
+	return version.storedReleaseFiles(version.Chart.Files, name)
+}
+
+// storedReleaseFiles returns the chart and values of release name among the files of the version
+func (version *Version) storedReleaseFiles(files []*any.Any, name string) (*chart.Chart, *chart.Config, error) {
+	var stored *chart.Chart
+	config := &chart.Config{}
+	for _, f := range files {
+		switch f.TypeUrl {
+		case fmt.Sprintf(storedChartPath, name):
+			stored = &chart.Chart{}
+			if err := proto.Unmarshal(f.Value, stored); err != nil {
+				return nil, nil, errors.WithFields(errors.Fields{
+					"Name":     version.Name,
+					"Revision": version.Revision,
+					"Release":  name,
+				}).Wrap(err, "failed to read the chart stored in version")
+			}
+		case fmt.Sprintf(storedValuesPath, name):
+			config.Raw = string(f.Value)
+		}
+	}
+	if stored == nil {
+		return nil, nil, nil
+	}
+	return stored, config, nil
+}
+
+// Latest returns the manifest version with the highest revision, nil when there are none
+func (versions *Versions) Latest() *Version {
+	var latest *Version
+	for _, v := range versions.Data {
+		if latest == nil || v.Revision > latest.Revision {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// Previous returns the manifest version before the latest, nil when there is none
+func (versions *Versions) Previous() *Version {
+	latest := versions.Latest()
+	if latest == nil {
+		return nil
+	}
+	var previous *Version
+	for _, v := range versions.Data {
+		if v.Revision < latest.Revision && (previous == nil || v.Revision > previous.Revision) {
+			previous = v
+		}
+	}
+	return previous
+}
+
+// TillerNamespaces returns the namespaces of the Tillers other than the primary recorded in the latest version
+func (versions *Versions) TillerNamespaces() ([]string, error) {
+	namespaces := []string{}
+	latest := versions.Latest()
+	if latest == nil {
+		return namespaces, nil
+	}
+	entries, err := latest.ReleaseEntries()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.TillerNamespace != "" && !seen[e.TillerNamespace] {
+			seen[e.TillerNamespace] = true
+			namespaces = append(namespaces, e.TillerNamespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
 func (version *Version) SetRevision(newVersion int32) {
 	version.Revision = newVersion
 	version.SetModified()
@@ -261,24 +691,50 @@ func (version *Version) IsModified() bool {
 func (versions *Versions) ChartValues() map[string]*chart.Value {
 	values := make(map[string]*chart.Value)
 	for _, v := range versions.Data {
-		values[v.Name] = &chart.Value{fmt.Sprintf("%d", v.Revision)}
+		if v.Removed {
+			continue
+		}
+		values[v.Name] = &chart.Value{Value: fmt.Sprintf("%d", v.Revision)}
 	}
 	return values
 }
 
-func (versions *Versions) RawReleaseTable() (string, error) {
-	type entry struct {
-		Name      string
-		Namespace string
-		Revision  int32
+// ReleaseFiles returns the chart and values of each release changed by the version as its files
+// they let a rollback reinstall releases that were purged since, a release left unchanged
+// shares them with the version that last changed it
+func (versions *Versions) ReleaseFiles() ([]*any.Any, error) {
+	files := []*any.Any{}
+	for _, v := range versions.Data {
+		if v.Removed || !v.Modified || v.Chart == nil {
+			continue
+		}
+		raw, err := proto.Marshal(v.Chart)
+		if err != nil {
+			return nil, errors.WithFields(errors.Fields{
+				"Release": v.Name,
+			}).Wrap(err, "failed to marshal release chart")
+		}
+		files = append(files, &any.Any{TypeUrl: fmt.Sprintf(storedChartPath, v.Name), Value: raw})
+		if v.Config != nil {
+			files = append(files, &any.Any{TypeUrl: fmt.Sprintf(storedValuesPath, v.Name), Value: []byte(v.Config.Raw)})
+		}
 	}
-	type entries []*entry
-	data := entries{}
+	return files, nil
+}
+
+func (versions *Versions) RawReleaseTable() (string, error) {
+	data := []*ReleaseEntry{}
 	for _, v := range versions.Data {
-		data = append(data, &entry{
-			Name:      v.Name,
-			Namespace: v.Namespace,
-			Revision:  v.Revision,
+		if v.Removed {
+			continue
+		}
+		data = append(data, &ReleaseEntry{
+			Name:            v.Name,
+			Namespace:       v.Namespace,
+			Revision:        v.Revision,
+			TillerNamespace: v.TillerNamespace,
+			Chart:           v.MetaName,
+			ChartGroup:      v.ChartGroup,
 		})
 	}
 	raw, err := yaml.Marshal(data)
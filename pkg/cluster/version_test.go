@@ -1,8 +1,12 @@
 package cluster
 
 import (
+	"errors"
+	"math/rand"
 	"testing"
 
+	"github.com/charter-oss/barrelman/pkg/cluster/driver"
+	"github.com/golang/protobuf/ptypes/any"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
 	v1 "k8s.io/api/core/v1"
@@ -114,3 +118,68 @@ func TestVersioning(t *testing.T) {
 		TestCoreV1.AssertExpectations(t)
 	})
 }
+
+// deleteFailingDriver is a version store that cannot remove versions
+type deleteFailingDriver struct {
+	driver.VersionDriver
+}
+
+func (d *deleteFailingDriver) Delete(key string) (*release.Release, error) {
+	return nil, errors.New("simulated delete failure")
+}
+
+func TestWriteVersions(t *testing.T) {
+	Convey("writeVersions", t, func() {
+		store := &deleteFailingDriver{driver.NewConfigMaps(newTestClientset().CoreV1().ConfigMaps(DefaultVersionsNamespace))}
+		write := func(revision int32, historyMax int) error {
+			versions := NewVersions("test-manifest")
+			versions.HistoryMax = historyMax
+			versions.AddReleaseVersion(&Version{Name: "app", Namespace: "apps", Revision: revision, Modified: true})
+			return writeVersions(store, nil, versions)
+		}
+		So(write(1, 0), ShouldBeNil)
+		So(write(2, 0), ShouldBeNil)
+
+		Convey("A failed prune does not fail the written version", func() {
+			So(write(3, 1), ShouldBeNil)
+			stored, err := store.List(getReleaseFilter("test-manifest"))
+			So(err, ShouldBeNil)
+			So(stored, ShouldHaveLength, 3)
+		})
+	})
+}
+
+func TestCheckVersionSize(t *testing.T) {
+	Convey("checkVersionSize", t, func() {
+		large := make([]byte, maxVersionSize)
+		rand.Read(large)
+		versions := NewVersions("test-manifest")
+		versions.AddReleaseVersion(&Version{
+			Name:     "app",
+			Revision: 2,
+			Chart: &chart.Chart{
+				Metadata: &chart.Metadata{Name: "app"},
+				Files:    []*any.Any{{TypeUrl: "files/blob", Value: large[:maxVersionSize*7/8]}},
+			},
+		})
+		versions.AddReleaseVersion(&Version{Name: "other", Revision: 1, Chart: &chart.Chart{}})
+
+		Convey("Unchanged releases add no chart to the version", func() {
+			So(checkVersionSize(versions, true), ShouldBeNil)
+		})
+
+		versions.Data[0].Modified = true
+		Convey("A version holding the charts of changed releases is limited", func() {
+			err := checkVersionSize(versions, true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "too large")
+		})
+		Convey("Charts kept apart from the version are limited on their own", func() {
+			So(checkVersionSize(versions, false), ShouldBeNil)
+			versions.Data[0].Chart.Files[0].Value = large
+			err := checkVersionSize(versions, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "release source is too large")
+		})
+	})
+}
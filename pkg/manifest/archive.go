@@ -25,12 +25,34 @@ type ArchiveSpec struct {
 	Namespace   string
 	Overrides   []byte
 	InstallWait bool
+	Retry       *ChartDataRetry
+	// TillerNamespace selects the Tiller of the release, set from the chart or its group
+	TillerNamespace string
+	// ChartGroup is the name of the chart group the chart was archived for
+	ChartGroup string
+	// SourceType and Source locate the chart, Commit is the git commit it was archived from
+	SourceType string
+	Source     *chartsync.Source
+	Commit     string
 }
 
 type ArchiveFiles struct {
 	List []*ArchiveSpec
 }
 
+// TillerNamespaces returns the distinct Tiller namespaces set for the archives
+func (af *ArchiveFiles) TillerNamespaces() []string {
+	namespaces := []string{}
+	seen := make(map[string]bool)
+	for _, as := range af.List {
+		if as.TillerNamespace != "" && !seen[as.TillerNamespace] {
+			seen[as.TillerNamespace] = true
+			namespaces = append(namespaces, as.TillerNamespace)
+		}
+	}
+	return namespaces
+}
+
 func Archive(
 	chart *Chart,
 	path string,
@@ -38,12 +60,18 @@ func Archive(
 	archiver chartsync.Archiver) (*ArchiveSpec, error) {
 
 	as := &ArchiveSpec{
-		MetaName:    chart.Metadata.Name,
-		ChartName:   chart.Data.ChartName,
-		ReleaseName: chart.Data.ReleaseName,
-		Namespace:   chart.Data.Namespace,
-		Overrides:   chart.Data.Overrides,
-		InstallWait: chart.Data.InstallWait,
+		MetaName:        chart.Metadata.Name,
+		ChartName:       chart.Data.ChartName,
+		ReleaseName:     chart.Data.ReleaseName,
+		Namespace:       chart.Data.Namespace,
+		Overrides:       chart.Data.Overrides,
+		InstallWait:     chart.Data.InstallWait,
+		Retry:           chart.Data.Retry,
+		TillerNamespace: chart.Data.TillerNamespace,
+		Source:          chart.Data.SyncSource,
+	}
+	if chart.Data.Source != nil {
+		as.SourceType = chart.Data.Source.Type
 	}
 	var err error
 
@@ -62,6 +90,16 @@ func Archive(
 		Path:         path,
 		DependCharts: dependCharts,
 	})
+	if err == nil && as.SourceType == "git" {
+		// the chart is recorded without its commit rather than failing the archive
+		if as.Commit, err = chartsync.HeadCommit(path); err != nil {
+			log.WithFields(log.Fields{
+				"Name":  as.MetaName,
+				"Error": err.Error(),
+			}).Warn("Failed to resolve the commit of chart")
+			err = nil
+		}
+	}
 
 	return as, err
 }
@@ -190,14 +228,15 @@ func (as *ArchiveSpec) ShortReport() map[string]interface{} {
 }
 func (as *ArchiveSpec) DetailedReport() map[string]interface{} {
 	return map[string]interface{}{
-		"MetaName":    as.MetaName,
-		"ChartName":   as.ChartName,
-		"DataDir":     as.DataDir,
-		"NameSpace":   as.Namespace,
-		"Path":        as.Path,
-		"InstallWait": as.InstallWait,
-		"ReleaseName": as.ReleaseName,
-		"Overrides":   as.Overrides,
+		"MetaName":        as.MetaName,
+		"ChartName":       as.ChartName,
+		"DataDir":         as.DataDir,
+		"NameSpace":       as.Namespace,
+		"Path":            as.Path,
+		"InstallWait":     as.InstallWait,
+		"ReleaseName":     as.ReleaseName,
+		"Overrides":       as.Overrides,
+		"TillerNamespace": as.TillerNamespace,
 	}
 }
 
@@ -1,6 +1,7 @@
 package chartsync
 
 import (
+	"context"
 	"io"
 	"os"
 
@@ -37,7 +38,7 @@ func (r *dirControl) Reset() {
 	return
 }
 
-func (r *dirControl) Sync(cs *ChartSync, acc AccountTable) error {
+func (r *dirControl) Sync(ctx context.Context, cs *ChartSync, acc AccountTable) error {
 	return nil
 }
 
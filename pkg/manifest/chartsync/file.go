@@ -1,6 +1,7 @@
 package chartsync
 
 import (
+	"context"
 	"io"
 	"os"
 
@@ -34,7 +35,7 @@ func (fh *fileHandler) Reset() {
 	return
 }
 
-func (fh *fileHandler) Sync(cs *ChartSync, acc AccountTable) error {
+func (fh *fileHandler) Sync(ctx context.Context, cs *ChartSync, acc AccountTable) error {
 	return nil
 }
 
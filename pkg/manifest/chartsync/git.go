@@ -1,6 +1,7 @@
 package chartsync
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -62,7 +63,7 @@ func (r *gitRepoList) Reset() {
 	return
 }
 
-func (r *gitRepoList) Sync(cs *ChartSync, acc AccountTable) error {
+func (r *gitRepoList) Sync(ctx context.Context, cs *ChartSync, acc AccountTable) error {
 	r.Lock()
 	defer func() {
 		r.Unlock()
@@ -71,7 +72,7 @@ func (r *gitRepoList) Sync(cs *ChartSync, acc AccountTable) error {
 		log.Debug("syncing git repo ", k)
 		// Ensure that the repo is on master before attempting to sync
 
-		if err := r.Download(cs, acc, k); err != nil {
+		if err := r.Download(ctx, cs, acc, k); err != nil {
 			return errors.WithFields(errors.Fields{
 				"URI": k,
 			}).Wrap(err, "Git download failed")
@@ -102,7 +103,8 @@ func (g *SyncGit) GetPath() (string, error) {
 	return target, nil
 }
 
-func (r *gitRepoList) Download(cs *ChartSync, acc AccountTable, location string) error {
+// Download clones or pulls the repository at location, the transfer is abandoned once ctx is done
+func (r *gitRepoList) Download(ctx context.Context, cs *ChartSync, acc AccountTable, location string) error {
 	u, err := url.Parse(location)
 	if err != nil {
 		return err
@@ -138,7 +140,7 @@ func (r *gitRepoList) Download(cs *ChartSync, acc AccountTable, location string)
 	}
 
 	if _, err := os.Stat(target); os.IsNotExist(err) {
-		_, err = git.PlainClone(target, false, cloneOptions)
+		_, err = git.PlainCloneContext(ctx, target, false, cloneOptions)
 		if err != nil {
 			if cloneOptions.Auth != nil {
 				return errors.WithFields(errors.Fields{
@@ -177,7 +179,7 @@ func (r *gitRepoList) Download(cs *ChartSync, acc AccountTable, location string)
 			return errors.Wrap(err, "could not create working tree")
 		}
 
-		err = wt.Pull(pullOptions)
+		err = wt.PullContext(ctx, pullOptions)
 		if err != nil {
 			if err != git.NoErrAlreadyUpToDate {
 				if cloneOptions.Auth != nil {
@@ -271,6 +273,19 @@ func getRepo(path string) (*git.Repository, error) {
 	return repo, nil
 }
 
+// HeadCommit returns the hash of the commit checked out in the repository holding path
+func HeadCommit(path string) (string, error) {
+	repo, err := getRepo(path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get git repository")
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", errors.Wrap(err, "could not get git head")
+	}
+	return head.Hash().String(), nil
+}
+
 func ReturnToMaster(path string) error {
 
 	log.Debug("returning ", path, " to master branch")
@@ -2,6 +2,7 @@
 package chartsync
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -61,7 +62,7 @@ type Controller interface {
 }
 
 type Syncer interface {
-	Sync(*ChartSync, AccountTable) error
+	Sync(context.Context, *ChartSync, AccountTable) error
 }
 
 type Reseter interface {
@@ -86,9 +87,9 @@ func New(d string, acc AccountTable) *ChartSync {
 	}
 }
 
-func (cs *ChartSync) Sync(acc AccountTable) error {
+func (cs *ChartSync) Sync(ctx context.Context, acc AccountTable) error {
 	for _, control := range registry.AllControllers() {
-		if err := control.Sync(cs, acc); err != nil {
+		if err := control.Sync(ctx, cs, acc); err != nil {
 			return errors.Wrap(err, "failed to perform Sync()")
 		}
 	}
@@ -1,6 +1,8 @@
 package manifest
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"os"
@@ -52,8 +54,28 @@ type Manifest struct {
 }
 
 type ManifestData struct {
-	ReleasePrefix string   `json:"release_prefix" yaml:"release_prefix"`
-	ChartGroups   []string `json:"chart_groups" yaml:"chart_groups"`
+	ReleasePrefix string      `json:"release_prefix" yaml:"release_prefix"`
+	ChartGroups   []string    `json:"chart_groups" yaml:"chart_groups"`
+	Diff          *DiffConfig `json:"diff" yaml:"diff"`
+	// Cluster declares the identity of the cluster the manifest is meant for
+	Cluster *ClusterGuard `json:"cluster" yaml:"cluster"`
+	// HistoryMax limits the manifest versions kept in the cluster, zero keeps them all
+	HistoryMax int `json:"history_max" yaml:"history_max"`
+}
+
+// ClusterGuard is the identity expected of the cluster, checked before any release is planned
+type ClusterGuard struct {
+	KubeSystemUID string            `json:"kube_system_uid" yaml:"kube_system_uid"`
+	APIServer     string            `json:"api_server" yaml:"api_server"`
+	Labels        map[string]string `json:"labels" yaml:"labels"`
+}
+
+// DiffConfig lists resource kinds, field paths and value keys treated as sensitive in diffs
+type DiffConfig struct {
+	SuppressedKinds []string `json:"suppressed_kinds" yaml:"suppressed_kinds"`
+	RedactedKinds   []string `json:"redacted_kinds" yaml:"redacted_kinds"`
+	RedactedPaths   []string `json:"redacted_paths" yaml:"redacted_paths"`
+	RedactedKeys    []string `json:"redacted_keys" yaml:"redacted_keys"`
 }
 
 type ChartGroup struct {
@@ -66,6 +88,8 @@ type ChartGroupData struct {
 	Description string
 	Sequenced   bool
 	ChartGroup  []string `json:"chart_group" yaml:"chart_group"`
+	// TillerNamespace selects the Tiller of the charts in the group which set none
+	TillerNamespace string `json:"tiller_namespace" yaml:"tiller_namespace"`
 }
 
 type Chart struct {
@@ -87,9 +111,12 @@ type ChartData struct {
 	Install      *ChartDataInstall
 	InstallWait  bool
 	Upgrade      *ChartDataUpgrade
+	Retry        *ChartDataRetry
 	Source       *ChartSource
 	Dependencies []string
 	Values       map[string]interface{}
+	// TillerNamespace selects the Tiller of the release, the primary Tiller when neither it nor the group set one
+	TillerNamespace string `json:"tiller_namespace" yaml:"tiller_namespace"`
 }
 
 type ChartSource struct {
@@ -113,6 +140,14 @@ type ChartDataUpgrade struct {
 	//Investigate usage in HELM API
 }
 
+// ChartDataRetry limits the retries of transient errors while changing the release
+// intervals are in seconds, unset limits keep the defaults
+type ChartDataRetry struct {
+	Attempts        int
+	InitialInterval float64 `json:"initial_interval" yaml:"initial_interval"`
+	MaxInterval     float64 `json:"max_interval" yaml:"max_interval"`
+}
+
 type RemoteAccount struct {
 	Type   string
 	Name   string
@@ -243,6 +278,40 @@ func (m *Manifest) GetChartsByName(charts []string) ([]*Chart, error) {
 	return ret, nil
 }
 
+// TillerNamespace returns the Tiller namespace of the chart within group, the chart setting wins over the group
+func (c *Chart) TillerNamespace(group *ChartGroup) string {
+	if c.Data.TillerNamespace != "" {
+		return c.Data.TillerNamespace
+	}
+	if group != nil && group.Data != nil {
+		return group.Data.TillerNamespace
+	}
+	return ""
+}
+
+// TillerNamespaces returns the distinct Tiller namespaces set by the chart groups and charts of the manifest
+func (m *Manifest) TillerNamespaces() ([]string, error) {
+	namespaces := []string{}
+	seen := make(map[string]bool)
+	groups, err := m.GetChartGroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "error resolving chart groups")
+	}
+	for _, cg := range groups {
+		charts, err := m.GetChartsByChartName(cg.Data.ChartGroup)
+		if err != nil {
+			return nil, errors.Wrap(err, "error resolving charts")
+		}
+		for _, chart := range charts {
+			if ns := chart.TillerNamespace(cg); ns != "" && !seen[ns] {
+				seen[ns] = true
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	return namespaces, nil
+}
+
 func NewChartGroup() *ChartGroup {
 	chartGroup := &ChartGroup{}
 	chartGroup.Data = &ChartGroupData{}
@@ -271,7 +340,7 @@ func NewChart() *Chart {
 }
 
 //Sync updates local copies of remote repositories configured in a manifest
-func (m *Manifest) Sync() error {
+func (m *Manifest) Sync(ctx context.Context) error {
 	for _, c := range m.AllCharts() {
 		//Add each chart to repo to download/update all charts
 		if err := m.ChartSync.Add(&chartsync.ChartMeta{
@@ -288,7 +357,7 @@ func (m *Manifest) Sync() error {
 		}
 	}
 
-	if err := m.ChartSync.Sync(m.Config.AccountTable); err != nil {
+	if err := m.ChartSync.Sync(ctx, m.Config.AccountTable); err != nil {
 		return errors.Wrap(err, "error while downloading charts")
 	}
 	return nil
@@ -426,6 +495,44 @@ func (m *Manifest) GetChartSpec(c *Chart) (string, []*chartsync.ChartSpec, error
 	return path, dependCharts, nil
 }
 
+// Source returns the manifest YAML as loaded, its sections joined by document separators
+func (m *Manifest) Source() []byte {
+	buf := &bytes.Buffer{}
+	for _, section := range m.YamlSec {
+		buf.WriteString("---\n")
+		buf.Write(bytes.TrimSpace(section.Bytes))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// GuardFromSource returns the cluster guard declared by the manifest YAML source, as returned by Source
+// only the manifest section is read, so no chart is synced. The guard is nil when none is declared.
+func GuardFromSource(source []byte) (*ClusterGuard, error) {
+	yp := yamlpack.New()
+	if err := yp.Import("source", bytes.NewReader(source)); err != nil {
+		return nil, errors.Wrap(err, "error importing manifest source")
+	}
+	for _, k := range yp.AllSections() {
+		schem, err := parseSchema(k.GetString("schema"))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse schema")
+		}
+		if schem.Type != StringManifest {
+			continue
+		}
+		m := &Manifest{}
+		if err := yaml.Unmarshal(k.Bytes, m); err != nil {
+			return nil, errors.Wrap(err, "Error loading manifest")
+		}
+		if m.Data == nil {
+			return nil, nil
+		}
+		return m.Data.Cluster, nil
+	}
+	return nil, nil
+}
+
 //CreateArchives creates archives for charts configured in the manifest
 func (m *Manifest) CreateArchives() (*ArchiveFiles, error) {
 	af := &ArchiveFiles{List: []*ArchiveSpec{}}
@@ -451,6 +558,8 @@ func (m *Manifest) CreateArchives() (*ArchiveFiles, error) {
 			if err != nil {
 				return nil, errors.Wrap(err, "Got err while running Archive")
 			}
+			as.TillerNamespace = chart.TillerNamespace(cg)
+			as.ChartGroup = cg.Metadata.Name
 			af.List = append(af.List, as)
 		}
 	}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"path"
 	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/ghodss/yaml"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/charter-oss/barrelman/pkg/manifest/chartsync"
@@ -20,8 +22,13 @@ func TestNewManifest(t *testing.T) {
 				ManifestFile: getTestDataDir() + "/unit-test-manifest.yaml",
 				AccountTable: make(chartsync.AccountTable),
 			}
-			_, err := New(config)
+			m, err := New(config)
 			So(err, ShouldBeNil)
+
+			source := string(m.Source())
+			So(source, ShouldStartWith, "---\n")
+			So(strings.Count(source, "---\n"), ShouldEqual, len(m.YamlSec))
+			So(source, ShouldContainSubstring, "name: "+m.Name)
 		})
 		Convey("New can fail to open file", func() {
 			_, err := New(&Config{
@@ -50,6 +57,40 @@ func TestNewManifest(t *testing.T) {
 	})
 }
 
+func TestGuardFromSource(t *testing.T) {
+	Convey("GuardFromSource", t, func() {
+		Convey("Reads the cluster guard of the manifest section", func() {
+			guard, err := GuardFromSource([]byte(`---
+schema: barrelman/Manifest/v1
+metadata:
+  schema: metadata/Document/v1
+  name: lamp-stack
+data:
+  chart_groups: []
+  cluster:
+    kube_system_uid: 6ad4-kube-system
+    labels:
+      env: prod
+`))
+			So(err, ShouldBeNil)
+			So(guard, ShouldResemble, &ClusterGuard{
+				KubeSystemUID: "6ad4-kube-system",
+				Labels:        map[string]string{"env": "prod"},
+			})
+		})
+		Convey("Returns nil without a guard", func() {
+			m, err := New(&Config{
+				ManifestFile: getTestDataDir() + "/unit-test-manifest.yaml",
+				AccountTable: make(chartsync.AccountTable),
+			})
+			So(err, ShouldBeNil)
+			guard, err := GuardFromSource(m.Source())
+			So(err, ShouldBeNil)
+			So(guard, ShouldBeNil)
+		})
+	})
+}
+
 func TestManifest(t *testing.T) {
 	m := &Manifest{
 		Lookup: &LookupTable{
@@ -252,6 +293,28 @@ func TestManifest(t *testing.T) {
 				So(chart.Data.InstallWait, ShouldBeFalse)
 			})
 		})
+		Convey("Chart Retry", func() {
+			chart := NewChart()
+			err := yaml.Unmarshal([]byte("data:\n  retry:\n    attempts: 5\n    initial_interval: 0.5\n"), chart)
+			So(err, ShouldBeNil)
+			So(chart.Data.Retry, ShouldNotBeNil)
+			So(chart.Data.Retry.Attempts, ShouldEqual, 5)
+			So(chart.Data.Retry.InitialInterval, ShouldEqual, 0.5)
+			So(chart.Data.Retry.MaxInterval, ShouldEqual, 0)
+		})
+		Convey("Chart and ChartGroup Tiller namespace", func() {
+			group := NewChartGroup()
+			err := yaml.Unmarshal([]byte("data:\n  tiller_namespace: tenant-a\n  chart_group: [ web, db ]\n"), group)
+			So(err, ShouldBeNil)
+			So(group.Data.TillerNamespace, ShouldEqual, "tenant-a")
+			web := NewChart()
+			So(yaml.Unmarshal([]byte("data:\n  release: web\n"), web), ShouldBeNil)
+			db := NewChart()
+			So(yaml.Unmarshal([]byte("data:\n  release: db\n  tiller_namespace: tenant-b\n"), db), ShouldBeNil)
+			So(web.TillerNamespace(group), ShouldEqual, "tenant-a")
+			So(db.TillerNamespace(group), ShouldEqual, "tenant-b")
+			So(web.TillerNamespace(nil), ShouldEqual, "")
+		})
 		Convey("Can process dependencies", func() {
 			m.ChartSync = chartsync.New(getTestDataDir(), make(chartsync.AccountTable))
 			m.AddChart(&Chart{
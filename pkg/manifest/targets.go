@@ -0,0 +1,204 @@
+package manifest
+
+import (
+	"os"
+
+	"github.com/cirrocloud/yamlpack"
+	"github.com/ghodss/yaml"
+
+	"github.com/cirrocloud/structured/errors"
+)
+
+const (
+	StringTargets = "Targets"
+
+	// StrategySequential applies the clusters one after the other and halts on the first failure
+	StrategySequential = "sequential"
+	// StrategyParallel applies every cluster at once
+	StrategyParallel = "parallel"
+)
+
+// Targets lists the clusters a manifest is applied to, read from a barrelman/Targets/v1 document
+type Targets struct {
+	Version  string
+	Metadata *Metadata
+	Data     *TargetsData
+}
+
+type TargetsData struct {
+	Strategy string
+	Targets  []*Target
+}
+
+// Target is one cluster of a multi-cluster apply
+// Values are overlaid on the values of the chart of the same name for this cluster only
+type Target struct {
+	Name        string
+	KubeContext string `json:"kube_context" yaml:"kube_context"`
+	Values      map[string]map[string]interface{}
+	// Cluster replaces the cluster identity declared by the manifest for this target
+	Cluster *ClusterGuard
+}
+
+// NewTargets returns Targets for the given kube contexts, without value overlays
+func NewTargets(kubeContexts []string) *Targets {
+	t := &Targets{
+		Metadata: &Metadata{},
+		Data:     &TargetsData{Targets: []*Target{}},
+	}
+	for _, kc := range kubeContexts {
+		t.Data.Targets = append(t.Data.Targets, &Target{Name: kc, KubeContext: kc})
+	}
+	return t
+}
+
+// LoadTargets reads the Targets document of a manifest file, nil when the manifest has none
+func LoadTargets(file string) (*Targets, error) {
+	fileR, err := os.Open(file)
+	if err != nil {
+		return nil, errors.WithFields(errors.Fields{"file": file}).Wrap(err, "error opening file")
+	}
+	defer fileR.Close()
+	yp := yamlpack.New()
+	if err := yp.Import(file, fileR); err != nil {
+		return nil, errors.WithFields(errors.Fields{"file": file}).Wrap(err, "error importing manifest")
+	}
+	return targetsFromSections(yp.AllSections())
+}
+
+func targetsFromSections(yamlSections []*yamlpack.YamlSection) (*Targets, error) {
+	var targets *Targets
+	for _, k := range yamlSections {
+		schem, err := parseSchema(k.GetString("schema"))
+		if err != nil || schem.Type != StringTargets {
+			continue
+		}
+		if targets != nil {
+			return nil, errors.New("manifest has more than one Targets document")
+		}
+		targets = &Targets{Version: schem.Version}
+		if err := yaml.Unmarshal(k.Bytes, targets); err != nil {
+			return nil, errors.Wrap(err, "Error loading targets")
+		}
+		if err := targets.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+func (t *Targets) validate() error {
+	if t.Data == nil || len(t.Data.Targets) == 0 {
+		return errors.New("Targets document lists no targets")
+	}
+	switch t.Data.Strategy {
+	case "", StrategySequential, StrategyParallel:
+	default:
+		return errors.WithFields(errors.Fields{
+			"Strategy": t.Data.Strategy,
+		}).New("unknown strategy, expected one of [ sequential | parallel ]")
+	}
+	seen := make(map[string]bool)
+	for _, target := range t.Data.Targets {
+		if target.Name == "" {
+			target.Name = target.KubeContext
+		}
+		if target.Name == "" {
+			return errors.New("target requires a name or kube_context")
+		}
+		if seen[target.Name] {
+			return errors.WithFields(errors.Fields{
+				"Target": target.Name,
+			}).New("target name already exists")
+		}
+		seen[target.Name] = true
+	}
+	return nil
+}
+
+// Strategy returns the rollout strategy, sequential unless set
+func (t *Targets) Strategy() string {
+	if t == nil || t.Data == nil || t.Data.Strategy == "" {
+		return StrategySequential
+	}
+	return t.Data.Strategy
+}
+
+// Select returns the targets matching the given kube contexts by name or kube context, in the given order
+// contexts without a target of their own are added without value overlays
+func (t *Targets) Select(kubeContexts []string) *Targets {
+	selected := NewTargets(nil)
+	if t != nil {
+		selected.Version = t.Version
+		selected.Metadata = t.Metadata
+		selected.Data.Strategy = t.Data.Strategy
+	}
+	for _, kc := range kubeContexts {
+		target := &Target{Name: kc, KubeContext: kc}
+		if t != nil {
+			for _, v := range t.Data.Targets {
+				if v.Name == kc || v.KubeContext == kc {
+					target = v
+					break
+				}
+			}
+		}
+		selected.Data.Targets = append(selected.Data.Targets, target)
+	}
+	return selected
+}
+
+// Overlay merges the values set for each chart of the target over the overrides of its archive
+func (t *Target) Overlay(af *ArchiveFiles) error {
+	for _, as := range af.List {
+		values, ok := t.Values[as.MetaName]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		overrides := map[string]interface{}{}
+		if err := yaml.Unmarshal(as.Overrides, &overrides); err != nil {
+			return errors.WithFields(errors.Fields{
+				"Target": t.Name,
+				"Name":   as.MetaName,
+			}).Wrap(err, "Failed to read Override Values")
+		}
+		if overrides == nil {
+			overrides = map[string]interface{}{}
+		}
+		merged, err := yaml.Marshal(mergeValues(overrides, values))
+		if err != nil {
+			return errors.WithFields(errors.Fields{
+				"Target": t.Name,
+				"Name":   as.MetaName,
+			}).Wrap(err, "Failed to marshal Override Values")
+		}
+		as.Overrides = merged
+	}
+	return nil
+}
+
+// mergeValues deep merges src into dst, values of src win
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// CreateTargetArchives creates archives for the charts of the manifest with the values of target overlaid
+func (m *Manifest) CreateTargetArchives(target *Target) (*ArchiveFiles, error) {
+	af, err := m.CreateArchives()
+	if err != nil {
+		return nil, err
+	}
+	if err := target.Overlay(af); err != nil {
+		return nil, errors.Wrap(err, "failed to overlay target values")
+	}
+	return af, nil
+}
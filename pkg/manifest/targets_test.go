@@ -0,0 +1,82 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cirrocloud/yamlpack"
+	"github.com/ghodss/yaml"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func loadTestTargets(doc string) (*Targets, error) {
+	yp := yamlpack.New()
+	if err := yp.Import("targets", strings.NewReader(doc)); err != nil {
+		return nil, err
+	}
+	return targetsFromSections(yp.AllSections())
+}
+
+func TestTargets(t *testing.T) {
+	Convey("Targets", t, func() {
+		doc := `---
+schema: barrelman/Targets/v1
+metadata:
+  name: regions
+data:
+  strategy: parallel
+  targets:
+    - name: east
+      kube_context: us-east
+      values:
+        web:
+          replicas: 3
+          image:
+            tag: v2
+    - kube_context: us-west
+`
+		Convey("Are read from the Targets document", func() {
+			targets, err := loadTestTargets(doc)
+			So(err, ShouldBeNil)
+			So(targets.Strategy(), ShouldEqual, StrategyParallel)
+			So(targets.Data.Targets, ShouldHaveLength, 2)
+			So(targets.Data.Targets[0].KubeContext, ShouldEqual, "us-east")
+			So(targets.Data.Targets[1].Name, ShouldEqual, "us-west")
+		})
+		Convey("Are nil without a Targets document", func() {
+			targets, err := loadTestTargets("---\nschema: barrelman/Manifest/v1\nmetadata:\n  name: m\n")
+			So(err, ShouldBeNil)
+			So(targets, ShouldBeNil)
+			So(targets.Strategy(), ShouldEqual, StrategySequential)
+		})
+		Convey("Reject an unknown strategy", func() {
+			_, err := loadTestTargets(strings.Replace(doc, "parallel", "random", 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown strategy")
+		})
+		Convey("Select by name or kube context", func() {
+			targets, err := loadTestTargets(doc)
+			So(err, ShouldBeNil)
+			selected := targets.Select([]string{"us-west", "east", "eu"})
+			So(selected.Data.Targets, ShouldHaveLength, 3)
+			So(selected.Data.Targets[0].Name, ShouldEqual, "us-west")
+			So(selected.Data.Targets[1].Values, ShouldContainKey, "web")
+			So(selected.Data.Targets[2].KubeContext, ShouldEqual, "eu")
+			So(selected.Strategy(), ShouldEqual, StrategyParallel)
+		})
+		Convey("Overlay the values of the chart", func() {
+			targets, err := loadTestTargets(doc)
+			So(err, ShouldBeNil)
+			af := &ArchiveFiles{List: []*ArchiveSpec{
+				{MetaName: "web", Overrides: []byte("replicas: 1\nimage:\n  repository: web\n  tag: v1\n")},
+				{MetaName: "db", Overrides: []byte("replicas: 1\n")},
+			}}
+			So(targets.Data.Targets[0].Overlay(af), ShouldBeNil)
+			values := map[string]interface{}{}
+			So(yaml.Unmarshal(af.List[0].Overrides, &values), ShouldBeNil)
+			So(values["replicas"], ShouldEqual, 3)
+			So(values["image"], ShouldResemble, map[string]interface{}{"repository": "web", "tag": "v2"})
+			So(string(af.List[1].Overrides), ShouldEqual, "replicas: 1\n")
+		})
+	})
+}